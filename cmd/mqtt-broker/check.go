@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	c "github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/config"
+	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/database"
+)
+
+// checkUsage check 子命令的用法说明
+const checkUsage = "usage: mqtt-broker check [--repair]"
+
+// runCheck 执行 check 子命令，校验持久会话与订阅树是否一致，指定 --repair 时修复不一致
+func runCheck(config c.Config, args []string) error {
+	repair := false
+	for _, arg := range args {
+		if arg != "--repair" {
+			return errors.New(checkUsage)
+		}
+		repair = true
+	}
+
+	backend, err := database.OpenBackend(config)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = backend.Close(context.Background()) }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+	report, err := database.CheckConsistency(ctx, backend, repair)
+	if report != nil {
+		if err := printConsistencyReport(report); err != nil {
+			return err
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if !repair && len(report.Drifts) > 0 {
+		return fmt.Errorf("%d inconsistencies found, run with --repair to fix them", len(report.Drifts))
+	}
+	return nil
+}
+
+// printConsistencyReport 输出一致性校验结果
+func printConsistencyReport(report *database.ConsistencyReport) error {
+	fmt.Printf("Checked %d sessions and %d subscriptions, found %d inconsistencies, repaired %d\n",
+		report.Sessions, report.Subscriptions, len(report.Drifts), report.Repaired)
+	if len(report.Drifts) == 0 {
+		return nil
+	}
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(writer, "KIND\tCLIENT\tTOPIC\tSESSION QOS\tTREE QOS")
+	for _, drift := range report.Drifts {
+		sessionQoS, treeQoS := fmt.Sprint(drift.SessionQoS), fmt.Sprint(drift.TreeQoS)
+		switch drift.Kind {
+		case database.DriftOrphan:
+			sessionQoS = "-"
+		case database.DriftMissing:
+			treeQoS = "-"
+		}
+		_, _ = fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\n", drift.Kind, drift.ClientID, drift.TopicName, sessionQoS, treeQoS)
+	}
+	return writer.Flush()
+}
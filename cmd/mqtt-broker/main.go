@@ -1,16 +1,25 @@
 package main
 
 import (
+	"fmt"
 	c "github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/config"
 	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/database"
 	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/event"
 	__ "github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/grpc"
+	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/health"
 	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/logger"
+	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/metrics"
 	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/server"
+	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/sys"
+	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/tracing"
+	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/utils"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/reflection"
 	"log"
 	"net"
+	"os"
 	"strconv"
 )
 
@@ -20,29 +29,82 @@ func main() {
 		logger.FatalF("Error occured while reading config %v", err)
 		return
 	}
+	if len(os.Args) > 1 {
+		var err error
+		switch os.Args[1] {
+		case "migrate":
+			err = runMigrate(config, os.Args[2:])
+		case "check":
+			err = runCheck(config, os.Args[2:])
+		default:
+			err = fmt.Errorf("unknown command %q", os.Args[1])
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
 	loggerCallback := logger.Init()
 	logger.Info("Application initializing...")
 	cleaner := event.NewCleaner()
 	cleaner.Init(loggerCallback)
 	defer cleaner.Clean()
-	err = database.ConnectDatabase()
+	event.ListenReload()
+	tracer, err := tracing.Init(config)
+	if err != nil {
+		logger.FatalF("Error occured while initializing tracing, details: %v", err)
+		return
+	}
+	if tracer != nil {
+		cleaner.Add(tracer)
+	}
+	mqttServer := server.NewServer(config.AppPort)
+	checks := health.Default()
+	checks.AddLiveness("mqtt", mqttServer.Alive)
+	checks.AddReadiness("mqtt", mqttServer.Ready)
+	checks.AddReadiness("storage", database.Ready)
+	cleaner.AddPhase(event.PhaseStopAccept, utils.ParseStringTime(config.Shutdown.StopAcceptTimeout), checks)
+	if config.HttpPort > 0 {
+		httpServer := metrics.NewServer(config.HttpPort)
+		httpServer.Handle("/healthz", checks.LivenessHandler())
+		httpServer.Handle("/readyz", checks.ReadinessHandler())
+		if err := httpServer.Start(); err != nil {
+			logger.FatalF("Fail to open http port: %v", err)
+			return
+		}
+		cleaner.Add(httpServer)
+	}
+	err = database.Open()
 	if err != nil {
 		logger.FatalF("Error occured while initializing database, details: %v", err)
 		return
 	}
+	if interval := utils.ParseStringTime(config.Sys.Interval); interval > 0 {
+		publisher := sys.NewPublisher(interval)
+		publisher.Start()
+		cleaner.AddPhase(event.PhaseStopAccept, utils.ParseStringTime(config.Shutdown.StopAcceptTimeout), publisher)
+	}
 	listener, err := net.Listen("tcp", ":"+strconv.Itoa(config.GrpcPort))
 	if err != nil {
 		logger.FatalF("Fail to open grpc port: %v", err)
 		return
 	}
-	grpcServer := grpc.NewServer()
+	grpcServer := grpc.NewServer(grpc.StatsHandler(otelgrpc.NewServerHandler()))
 	__.RegisterDeviceServer(grpcServer, &__.GRPCService{})
+	__.RegisterAdminServer(grpcServer, &__.AdminService{})
+	healthpb.RegisterHealthServer(grpcServer, checks.GRPCServer())
 	reflection.Register(grpcServer)
+	cleaner.AddPhase(event.PhaseStopGrpc, utils.ParseStringTime(config.Shutdown.GrpcTimeout), __.NewStopCallback(grpcServer))
 	go func() {
 		err := grpcServer.Serve(listener)
 		if err != nil {
 			log.Fatalf("failed to serve: %v", err)
 		}
 	}()
-	server.StartServer(config.AppPort)
+	checks.Start()
+	if err := mqttServer.Start(); err != nil {
+		logger.FatalF("MQTT Server Start error: %v", err)
+		return
+	}
 }
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	c "github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/config"
+	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/database"
+)
+
+// migrateUsage migrate 子命令的用法说明
+const migrateUsage = "usage: mqtt-broker migrate [up|status]"
+
+// runMigrate 执行 migrate 子命令，在不启动服务器的情况下迁移MongoDB数据库结构或查看迁移状态
+func runMigrate(config c.Config, args []string) error {
+	action := "up"
+	if len(args) > 0 {
+		action = args[0]
+	}
+	if action != "up" && action != "status" || len(args) > 1 {
+		return errors.New(migrateUsage)
+	}
+	if config.Storage.Backend != c.StorageMongo {
+		return fmt.Errorf("migrations only apply to the %s storage backend, current backend is %s", c.StorageMongo, config.Storage.Backend)
+	}
+
+	if err := database.ConnectDatabase(); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+	defer func() { _ = database.Client.Disconnect(context.Background()) }()
+
+	if action == "up" {
+		applied, err := database.Migrate(ctx, database.Database)
+		fmt.Printf("Applied %d migrations\n", applied)
+		if err != nil {
+			return err
+		}
+	}
+	return printMigrationStatus(ctx)
+}
+
+// printMigrationStatus 输出数据库结构版本与每个迁移的状态
+func printMigrationStatus(ctx context.Context) error {
+	version, status, err := database.SchemaStatus(ctx, database.Database)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Schema version: %d (latest %d)\n", version, database.LatestSchemaVersion())
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(writer, "VERSION\tSTATE\tAPPLIED AT\tNAME")
+	for _, migration := range status {
+		state, appliedAt := "pending", "-"
+		if migration.Applied {
+			state = "applied"
+			if !migration.AppliedAt.IsZero() {
+				appliedAt = migration.AppliedAt.Local().Format(time.DateTime)
+			}
+		}
+		_, _ = fmt.Fprintf(writer, "%d\t%s\t%s\t%s\n", migration.Version, state, appliedAt, migration.Name)
+	}
+	return writer.Flush()
+}
@@ -4,66 +4,448 @@ package config
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"os"
+	"path"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
 )
 
 // Config 定义了MQTT服务器的配置结构
+// 带有 reload:"restart" 标签的字段无法在运行时重新加载，修改后需要重启服务器
 type Config struct {
 	Database struct {
-		Host               string `json:"host"`                 // 数据库主机地址
-		Port               uint64 `json:"port"`                 // 数据库端口
-		Username           string `json:"username"`             // 数据库用户名
-		Password           string `json:"password"`             // 数据库密码
-		Database           string `json:"database"`             // 数据库名称
-		UseTLS             bool   `json:"use_tls"`              // 是否使用TLS
-		ConnectTimeout     string `json:"connect_timeout"`      // 连接超时时间
-		SocketTimeout      string `json:"socket_timeout"`       // Socket超时时间
-		ConnectIdleTimeout string `json:"connect_idle_timeout"` // 连接空闲超时时间
-		OperationTimeout   string `json:"operation_timeout"`    // 操作超时时间
-		Heartbeat          string `json:"heartbeat"`            // 心跳间隔
-		MinPoolSize        uint64 `json:"min_pool_size"`        // 最小连接池大小
-		MaxPoolSize        uint64 `json:"max_pool_size"`        // 最大连接池大小
-	} `json:"database"`
-	DebugMode bool   `json:"debug_mode"` // 是否启用调试模式
-	AppName   string `json:"app_name"`   // 应用名称
-	AppPort   int    `json:"app_port"`   // 应用端口
-	GrpcPort  int    `json:"grpc_port"`  // grpc端口
+		URI                 string `json:"uri"`                   // 完整的连接字符串，支持 mongodb+srv 与副本集，设置后忽略host与port
+		Host                string `json:"host"`                  // 数据库主机地址
+		Port                uint64 `json:"port"`                  // 数据库端口
+		Username            string `json:"username"`              // 数据库用户名
+		Password            string `json:"password"`              // 数据库密码
+		UsernameEnv         string `json:"username_env"`          // 读取用户名的环境变量名，变量存在时覆盖username
+		PasswordEnv         string `json:"password_env"`          // 读取密码的环境变量名，变量存在时覆盖password与password_file
+		PasswordFile        string `json:"password_file"`         // 读取密码的文件路径，存在时覆盖password
+		AuthSource          string `json:"auth_source"`           // 认证数据库，为空时使用连接字符串中的设置或admin
+		ReplicaSet          string `json:"replica_set"`           // 副本集名称
+		Database            string `json:"database"`              // 数据库名称
+		UseTLS              bool   `json:"use_tls"`               // 是否使用TLS
+		CAFile              string `json:"ca_file"`               // TLS使用的CA证书文件，为空时使用系统根证书
+		ReadConcern         string `json:"read_concern"`          // 读关注：local、majority、available、linearizable、snapshot
+		ReadPreference      string `json:"read_preference"`       // 读偏好：primary、primaryPreferred、secondary、secondaryPreferred、nearest
+		WriteConcern        string `json:"write_concern"`         // 默认写关注：majority、节点数或标签名
+		Journal             bool   `json:"journal"`               // 写入是否需要等待日志落盘
+		SessionWriteConcern string `json:"session_write_concern"` // 会话写入的写关注，为空时使用write_concern
+		QueueWriteConcern   string `json:"queue_write_concern"`   // 离线队列写入的写关注，为空时使用write_concern
+		ConnectTimeout      string `json:"connect_timeout"`       // 连接超时时间
+		SocketTimeout       string `json:"socket_timeout"`        // Socket超时时间
+		ConnectIdleTimeout  string `json:"connect_idle_timeout"`  // 连接空闲超时时间
+		OperationTimeout    string `json:"operation_timeout"`     // 操作超时时间
+		Heartbeat           string `json:"heartbeat"`             // 心跳间隔
+		MinPoolSize         uint64 `json:"min_pool_size"`         // 最小连接池大小
+		MaxPoolSize         uint64 `json:"max_pool_size"`         // 最大连接池大小
+		AutoMigrate         bool   `json:"auto_migrate"`          // 启动时是否自动执行数据库结构迁移
+	} `json:"database" reload:"restart"`
+	Storage struct {
+		Backend string `json:"backend"` // 存储后端：mongo、bolt、memory
+		Path    string `json:"path"`    // 嵌入式存储（bolt）的数据文件路径
+		// 存储不可用时的容错配置
+		BreakerThreshold int    `json:"breaker_threshold"` // 连续失败多少次后断路器打开
+		BreakerCooldown  string `json:"breaker_cooldown"`  // 断路器打开后多久尝试恢复
+		WriteBufferSize  int    `json:"write_buffer_size"` // 存储不可用时缓冲的最大写操作数，超出时丢弃最早的写操作
+		// 会话字段级变更（待确认消息）的持久化配置
+		SessionDurability    string `json:"session_durability"`     // 持久化模式：sync、periodic、async
+		SessionFlushInterval string `json:"session_flush_interval"` // periodic模式下的写入间隔
+		SessionBatchSize     int    `json:"session_batch_size"`     // 单次批量写入的最大会话数
+		ReconcileInterval    string `json:"reconcile_interval"`     // 会话与订阅树一致性校验的间隔，0s表示不校验
+		CompactInterval      string `json:"compact_interval"`       // 主题树空节点压缩的间隔，0s表示不压缩
+	} `json:"storage" reload:"restart"`
+	Routing struct {
+		IndexShards    int `json:"index_shards"`     // 订阅索引按主题第一层划分的分片数
+		MatchCacheSize int `json:"match_cache_size"` // 匹配结果缓存的最大主题数，0表示不缓存
+	} `json:"routing" reload:"restart"`
+	Sys struct {
+		Interval       string   `json:"interval" reload:"restart"` // $SYS 统计主题的发布间隔，0s表示不发布
+		AllowedClients []string `json:"allowed_clients"`           // 允许订阅 $SYS 主题的客户端ID，支持 * 与 ? 通配符
+	} `json:"sys"`
+	Log struct {
+		Format  string            `json:"format" reload:"restart"` // 输出格式：text、json
+		Level   string            `json:"level"`                   // 默认日志级别：debug、info、warn、error、fatal，debug_mode为true时为debug
+		Modules map[string]string `json:"modules"`                 // 各模块的日志级别，模块为包名，如 database、server、packet
+		// 日志文件的轮转与保留
+		Dir            string `json:"dir" reload:"restart"`             // 日志文件目录
+		MaxSize        int    `json:"max_size" reload:"restart"`        // 单个日志文件的最大大小（MB），超过后轮转，0表示只按天轮转
+		RetentionDays  int    `json:"retention_days" reload:"restart"`  // 日志文件的保留天数，0表示不删除
+		Compress       bool   `json:"compress" reload:"restart"`        // 是否使用gzip压缩轮转后的日志文件
+		OverflowPolicy string `json:"overflow_policy" reload:"restart"` // 写入队列已满时的处理方式：block、drop
+	} `json:"log"`
+	Tracing struct {
+		Enabled     bool    `json:"enabled"`      // 是否启用OpenTelemetry追踪
+		Exporter    string  `json:"exporter"`     // 导出方式：otlp、file
+		Endpoint    string  `json:"endpoint"`     // OTLP gRPC接收端地址，如 127.0.0.1:4317
+		Insecure    bool    `json:"insecure"`     // 连接OTLP接收端时不使用TLS
+		File        string  `json:"file"`         // file导出方式下写入的文件，每行一个JSON格式的Span
+		SampleRatio float64 `json:"sample_ratio"` // 没有上游追踪上下文时的采样比例，0~1
+	} `json:"tracing" reload:"restart"`
+	Shutdown struct {
+		DrainBatchSize     int    `json:"drain_batch_size"`                     // 每批断开的客户端连接数
+		DrainBatchInterval string `json:"drain_batch_interval"`                 // 两批断开之间的间隔
+		StopAcceptTimeout  string `json:"stop_accept_timeout" reload:"restart"` // 停止接受新连接的超时时间
+		DrainTimeout       string `json:"drain_timeout" reload:"restart"`       // 断开客户端连接的超时时间
+		FlushTimeout       string `json:"flush_timeout" reload:"restart"`       // 会话数据落盘的超时时间
+		GrpcTimeout        string `json:"grpc_timeout" reload:"restart"`        // gRPC优雅停止的超时时间
+		DatabaseTimeout    string `json:"database_timeout" reload:"restart"`    // 关闭数据库连接的超时时间
+	} `json:"shutdown"`
+	Connection struct {
+		WriteQueueSize int    `json:"write_queue_size"` // 每个连接出站队列的最大报文数，对新连接生效
+		MaxQueueBytes  int    `json:"max_queue_bytes"`  // 每个连接出站队列的最大字节数，0表示不限制，对新连接生效
+		OverflowPolicy string `json:"overflow_policy"`  // 出站队列溢出策略：drop_qos0、drop_oldest、disconnect，对新连接生效
+		WriteTimeout   string `json:"write_timeout"`    // 单次写入的超时时间，对新连接生效
+	} `json:"connection"`
+	DebugMode bool   `json:"debug_mode"`                 // 是否启用调试模式
+	AppName   string `json:"app_name" reload:"restart"`  // 应用名称
+	AppPort   int    `json:"app_port" reload:"restart"`  // 应用端口
+	GrpcPort  int    `json:"grpc_port" reload:"restart"` // grpc端口
+	HttpPort  int    `json:"http_port" reload:"restart"` // HTTP端口，提供 /metrics 接口，0表示不启用
 }
 
+// 出站队列溢出策略
+const (
+	OverflowDropQoS0   = "drop_qos0"   // 丢弃QoS 0消息
+	OverflowDropOldest = "drop_oldest" // 丢弃队列中最早的QoS 0消息
+	OverflowDisconnect = "disconnect"  // 断开客户端连接
+)
+
+// 日志输出格式
+const (
+	LogFormatText = "text" // 彩色文本
+	LogFormatJSON = "json" // 每行一个JSON对象
+)
+
+// 日志写入队列已满时的处理方式
+const (
+	LogOverflowBlock = "block" // 阻塞调用方直到队列有空位
+	LogOverflowDrop  = "drop"  // 丢弃日志并计数
+)
+
+// 追踪导出方式
+const (
+	TracingExporterOTLP = "otlp" // 通过gRPC导出到OTLP接收端
+	TracingExporterFile = "file" // 写入本地文件，无需接收端
+)
+
+// 存储后端
+const (
+	StorageMongo  = "mongo"  // MongoDB
+	StorageBolt   = "bolt"   // 嵌入式bbolt数据文件，无需外部数据库
+	StorageMemory = "memory" // 内存存储，进程退出后数据丢失，用于开发与测试
+)
+
+// 会话变更的持久化模式
+const (
+	DurabilitySync     = "sync"     // 每次变更同步写入后才继续处理，延迟最高，不会丢失
+	DurabilityPeriodic = "periodic" // 按固定间隔或攒满一批后批量写入，最多丢失一个间隔内的变更
+	DurabilityAsync    = "async"    // 变更立即交给后台批量写入，不等待写入完成
+)
+
+// ReloadResult 描述了一次配置重新加载的结果
+type ReloadResult struct {
+	Applied         []string // 已在运行时生效的字段
+	RestartRequired []string // 已修改但需要重启才能生效的字段
+}
+
+// ReloadHook 配置重新加载后的回调，old为重新加载前的配置，new为当前生效的配置
+type ReloadHook func(old Config, new Config)
+
 var (
-	config      Config
+	mu          sync.RWMutex
+	config      = defaultConfig()
 	initialized = false
+	hooks       []ReloadHook
+	// durationPattern 匹配 utils.ParseStringTime 支持的时间格式
+	durationPattern = regexp.MustCompile(`^[0-9]+[smhdSMHD]$`)
 )
 
-// ReadConfig 从配置文件读取配置
-func ReadConfig() (Config, error) {
+// Default 返回填充了默认值的配置，不读取配置文件
+func Default() Config {
+	return defaultConfig()
+}
+
+// defaultConfig 返回填充了默认值的配置
+func defaultConfig() Config {
+	result := Config{}
+	result.Database.OperationTimeout = "5s"
+	result.Database.AutoMigrate = true
+	result.Storage.Backend = StorageMongo
+	result.Storage.Path = "data/broker.db"
+	result.Storage.BreakerThreshold = 5
+	result.Storage.BreakerCooldown = "10s"
+	result.Storage.WriteBufferSize = 10000
+	result.Storage.SessionDurability = DurabilityPeriodic
+	result.Storage.SessionFlushInterval = "1s"
+	result.Storage.SessionBatchSize = 500
+	result.Storage.ReconcileInterval = "10m"
+	result.Storage.CompactInterval = "1h"
+	result.Routing.IndexShards = 16
+	result.Routing.MatchCacheSize = 10000
+	result.Sys.Interval = "10s"
+	result.HttpPort = 9273
+	result.Log.Format = LogFormatText
+	result.Log.Level = "info"
+	result.Log.Dir = "logs"
+	result.Log.MaxSize = 100
+	result.Log.RetentionDays = 30
+	result.Log.OverflowPolicy = LogOverflowBlock
+	result.Tracing.Exporter = TracingExporterOTLP
+	result.Tracing.Endpoint = "127.0.0.1:4317"
+	result.Tracing.File = "logs/traces.json"
+	result.Tracing.SampleRatio = 1
+	result.Shutdown.DrainBatchSize = 500
+	result.Shutdown.DrainBatchInterval = "1s"
+	result.Shutdown.StopAcceptTimeout = "5s"
+	result.Shutdown.DrainTimeout = "30s"
+	result.Shutdown.FlushTimeout = "15s"
+	result.Shutdown.GrpcTimeout = "10s"
+	result.Shutdown.DatabaseTimeout = "10s"
+	result.Connection.WriteQueueSize = 256
+	result.Connection.MaxQueueBytes = 4 * 1024 * 1024
+	result.Connection.OverflowPolicy = OverflowDropQoS0
+	result.Connection.WriteTimeout = "10s"
+	return result
+}
+
+// readConfigFile 读取并校验配置文件
+func readConfigFile() (Config, error) {
+	result := defaultConfig()
+
 	// 读取配置文件
 	bytes, err := os.ReadFile("config.json")
 
 	if err != nil {
 		// 如果配置文件不存在，创建默认配置
 		writer, _ := os.OpenFile("config.json", os.O_RDONLY|os.O_CREATE, 0777)
-		data, _ := json.MarshalIndent(config, "", "\t")
+		data, _ := json.MarshalIndent(result, "", "\t")
 		_, _ = writer.Write(data)
 		_ = writer.Close()
-		return config, errors.New("the configuration file does not exist and has been created. Please try again after editing the configuration file")
+		return result, errors.New("the configuration file does not exist and has been created. Please try again after editing the configuration file")
 	}
 
 	// 解析JSON配置
-	err = json.Unmarshal(bytes, &config)
+	err = json.Unmarshal(bytes, &result)
+
+	if err != nil {
+		return result, errors.New("the configuration file does not contain valid JSON")
+	}
+
+	if err := validate(&result); err != nil {
+		return result, fmt.Errorf("the configuration file is invalid: %w", err)
+	}
+
+	return result, nil
+}
+
+// validate 校验配置内容
+func validate(conf *Config) error {
+	durations := map[string]string{
+		"shutdown.drain_batch_interval":  conf.Shutdown.DrainBatchInterval,
+		"shutdown.stop_accept_timeout":   conf.Shutdown.StopAcceptTimeout,
+		"shutdown.drain_timeout":         conf.Shutdown.DrainTimeout,
+		"shutdown.flush_timeout":         conf.Shutdown.FlushTimeout,
+		"shutdown.grpc_timeout":          conf.Shutdown.GrpcTimeout,
+		"shutdown.database_timeout":      conf.Shutdown.DatabaseTimeout,
+		"connection.write_timeout":       conf.Connection.WriteTimeout,
+		"storage.breaker_cooldown":       conf.Storage.BreakerCooldown,
+		"storage.session_flush_interval": conf.Storage.SessionFlushInterval,
+		"storage.reconcile_interval":     conf.Storage.ReconcileInterval,
+		"storage.compact_interval":       conf.Storage.CompactInterval,
+		"sys.interval":                   conf.Sys.Interval,
+	}
+	for name, value := range durations {
+		if !durationPattern.MatchString(value) {
+			return fmt.Errorf("%s has invalid duration %q", name, value)
+		}
+	}
+	if conf.Shutdown.DrainBatchSize < 0 {
+		return fmt.Errorf("shutdown.drain_batch_size must not be negative")
+	}
+	if conf.Connection.WriteQueueSize <= 0 {
+		return fmt.Errorf("connection.write_queue_size must be positive")
+	}
+	if conf.Connection.MaxQueueBytes < 0 {
+		return fmt.Errorf("connection.max_queue_bytes must not be negative")
+	}
+	switch conf.Connection.OverflowPolicy {
+	case OverflowDropQoS0, OverflowDropOldest, OverflowDisconnect:
+	default:
+		return fmt.Errorf("connection.overflow_policy %q is not supported", conf.Connection.OverflowPolicy)
+	}
+	if conf.Storage.BreakerThreshold <= 0 {
+		return fmt.Errorf("storage.breaker_threshold must be positive")
+	}
+	if conf.Storage.WriteBufferSize <= 0 {
+		return fmt.Errorf("storage.write_buffer_size must be positive")
+	}
+	if conf.Storage.SessionBatchSize <= 0 {
+		return fmt.Errorf("storage.session_batch_size must be positive")
+	}
+	switch conf.Storage.SessionDurability {
+	case DurabilitySync, DurabilityPeriodic, DurabilityAsync:
+	default:
+		return fmt.Errorf("storage.session_durability %q is not supported", conf.Storage.SessionDurability)
+	}
+	for _, pattern := range conf.Sys.AllowedClients {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("sys.allowed_clients has invalid pattern %q", pattern)
+		}
+	}
+	if conf.Routing.IndexShards <= 0 {
+		return fmt.Errorf("routing.index_shards must be positive")
+	}
+	if conf.Routing.MatchCacheSize < 0 {
+		return fmt.Errorf("routing.match_cache_size must not be negative")
+	}
+	switch conf.Log.Format {
+	case LogFormatText, LogFormatJSON:
+	default:
+		return fmt.Errorf("log.format %q is not supported", conf.Log.Format)
+	}
+	if !validLogLevel(conf.Log.Level) {
+		return fmt.Errorf("log.level %q is not supported", conf.Log.Level)
+	}
+	for module, level := range conf.Log.Modules {
+		if !validLogLevel(level) {
+			return fmt.Errorf("log.modules.%s has unsupported level %q", module, level)
+		}
+	}
+	if conf.Log.Dir == "" {
+		return fmt.Errorf("log.dir must not be empty")
+	}
+	if conf.Log.MaxSize < 0 {
+		return fmt.Errorf("log.max_size must not be negative")
+	}
+	if conf.Log.RetentionDays < 0 {
+		return fmt.Errorf("log.retention_days must not be negative")
+	}
+	switch conf.Log.OverflowPolicy {
+	case LogOverflowBlock, LogOverflowDrop:
+	default:
+		return fmt.Errorf("log.overflow_policy %q is not supported", conf.Log.OverflowPolicy)
+	}
+	if conf.Tracing.SampleRatio < 0 || conf.Tracing.SampleRatio > 1 {
+		return fmt.Errorf("tracing.sample_ratio must be between 0 and 1")
+	}
+	switch conf.Tracing.Exporter {
+	case TracingExporterOTLP, TracingExporterFile:
+	default:
+		return fmt.Errorf("tracing.exporter %q is not supported", conf.Tracing.Exporter)
+	}
+	switch conf.Storage.Backend {
+	case StorageMongo, StorageMemory:
+	case StorageBolt:
+		if conf.Storage.Path == "" {
+			return fmt.Errorf("storage.path must not be empty when using the bolt backend")
+		}
+	default:
+		return fmt.Errorf("storage.backend %q is not supported", conf.Storage.Backend)
+	}
+	if conf.AppPort < 0 || conf.AppPort > 65535 {
+		return fmt.Errorf("app_port %d out of range", conf.AppPort)
+	}
+	if conf.GrpcPort < 0 || conf.GrpcPort > 65535 {
+		return fmt.Errorf("grpc_port %d out of range", conf.GrpcPort)
+	}
+	if conf.HttpPort < 0 || conf.HttpPort > 65535 {
+		return fmt.Errorf("http_port %d out of range", conf.HttpPort)
+	}
+	return nil
+}
 
+// validLogLevel 返回日志级别名称是否有效
+func validLogLevel(name string) bool {
+	switch strings.ToLower(name) {
+	case "debug", "info", "warn", "error", "fatal":
+		return true
+	}
+	return false
+}
+
+// ReadConfig 从配置文件读取配置
+func ReadConfig() (Config, error) {
+	result, err := readConfigFile()
 	if err != nil {
-		return config, errors.New("the configuration file does not contain valid JSON")
+		return result, err
 	}
 
+	mu.Lock()
+	defer mu.Unlock()
+	config = result
 	initialized = true
 	return config, nil
 }
 
 // GetConfig 获取配置，如果未初始化则先读取配置
 func GetConfig() (Config, error) {
+	mu.RLock()
 	if initialized {
+		defer mu.RUnlock()
 		return config, nil
 	}
+	mu.RUnlock()
 	return ReadConfig()
 }
+
+// OnReload 注册配置重新加载后的回调
+func OnReload(hook ReloadHook) {
+	mu.Lock()
+	defer mu.Unlock()
+	hooks = append(hooks, hook)
+}
+
+// Reload 重新读取并校验配置文件，应用可在运行时修改的字段
+// 需要重启才能生效的字段保持原值，并在结果中列出
+func Reload() (*ReloadResult, error) {
+	next, err := readConfigFile()
+	if err != nil {
+		return nil, err
+	}
+
+	mu.Lock()
+	old := config
+	result := &ReloadResult{}
+	diffConfig(reflect.ValueOf(&old).Elem(), reflect.ValueOf(&next).Elem(), "", false, result)
+	config = next
+	initialized = true
+	currentHooks := make([]ReloadHook, len(hooks))
+	copy(currentHooks, hooks)
+	mu.Unlock()
+
+	for _, hook := range currentHooks {
+		hook(old, next)
+	}
+	return result, nil
+}
+
+// diffConfig 递归比较新旧配置，需要重启的字段会被还原为旧值
+func diffConfig(old reflect.Value, next reflect.Value, prefix string, restart bool, result *ReloadResult) {
+	for i := 0; i < old.NumField(); i++ {
+		field := old.Type().Field(i)
+		name := field.Tag.Get("json")
+		if prefix != "" {
+			name = prefix + "." + name
+		}
+		fieldRestart := restart || field.Tag.Get("reload") == "restart"
+
+		if field.Type.Kind() == reflect.Struct {
+			diffConfig(old.Field(i), next.Field(i), name, fieldRestart, result)
+			continue
+		}
+		if reflect.DeepEqual(old.Field(i).Interface(), next.Field(i).Interface()) {
+			continue
+		}
+		if fieldRestart {
+			next.Field(i).Set(old.Field(i))
+			result.RestartRequired = append(result.RestartRequired, name)
+			continue
+		}
+		result.Applied = append(result.Applied, name)
+	}
+}
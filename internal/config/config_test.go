@@ -1,7 +1,10 @@
 package config
 
 import (
+	"encoding/json"
 	"fmt"
+	"os"
+	"reflect"
 	"testing"
 )
 
@@ -12,3 +15,67 @@ func TestReadConfig(t *testing.T) {
 	}
 	fmt.Printf("%+v\n", config)
 }
+
+func TestReload(t *testing.T) {
+	// 测试会修改全局配置并注册回调，结束后恢复原有状态，避免影响其他测试
+	mu.RLock()
+	previousConfig, previousInitialized, previousHooks := config, initialized, hooks
+	mu.RUnlock()
+	t.Cleanup(func() {
+		mu.Lock()
+		defer mu.Unlock()
+		config, initialized, hooks = previousConfig, previousInitialized, previousHooks
+	})
+
+	wd, _ := os.Getwd()
+	defer func() { _ = os.Chdir(wd) }()
+	_ = os.Chdir(t.TempDir())
+
+	write := func(conf Config) {
+		data, _ := json.Marshal(conf)
+		if err := os.WriteFile("config.json", data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	conf := defaultConfig()
+	conf.AppPort = 1883
+	write(conf)
+	if _, err := ReadConfig(); err != nil {
+		t.Fatalf("ReadConfig() error: %v", err)
+	}
+
+	var hookCalled bool
+	OnReload(func(old Config, new Config) {
+		hookCalled = old.DebugMode != new.DebugMode
+	})
+
+	conf.DebugMode = true
+	conf.AppPort = 1884
+	conf.Shutdown.DrainBatchSize = 10
+	write(conf)
+	result, err := Reload()
+	if err != nil {
+		t.Fatalf("Reload() error: %v", err)
+	}
+	if !reflect.DeepEqual(result.Applied, []string{"shutdown.drain_batch_size", "debug_mode"}) {
+		t.Errorf("Reload() applied got: %v", result.Applied)
+	}
+	if !reflect.DeepEqual(result.RestartRequired, []string{"app_port"}) {
+		t.Errorf("Reload() restart required got: %v", result.RestartRequired)
+	}
+	if !hookCalled {
+		t.Error("reload hook was not called")
+	}
+
+	current, _ := GetConfig()
+	if current.AppPort != 1883 || !current.DebugMode || current.Shutdown.DrainBatchSize != 10 {
+		t.Errorf("unexpected config after reload: %+v", current)
+	}
+
+	conf.Shutdown.DrainTimeout = "soon"
+	write(conf)
+	if _, err := Reload(); err == nil {
+		t.Error("Reload() should reject invalid duration")
+	}
+}
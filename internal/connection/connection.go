@@ -0,0 +1,316 @@
+// Package connection 实现了MQTT服务器的连接管理功能
+package connection
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/config"
+	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/logger"
+	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/utils"
+)
+
+var (
+	ErrConnectionClosed = errors.New("connection is closed")
+	ErrQueueFull        = errors.New("outbound queue is full")
+	ErrSlowConsumer     = errors.New("client disconnected as slow consumer")
+)
+
+// controlQoS 控制报文（CONNACK、SUBACK、PINGRESP等）在出站队列中的优先级，不会被 drop_qos0 策略丢弃
+const controlQoS byte = 0xFF
+
+// outbound 出站队列中的报文
+type outbound struct {
+	data net.Buffers // 报文分段，写入时使用向量化写入
+	size int         // 报文总字节数
+	qos  byte
+}
+
+// newOutbound 创建出站报文
+func newOutbound(data net.Buffers, qos byte) outbound {
+	size := 0
+	for _, segment := range data {
+		size += len(segment)
+	}
+	return outbound{data: data, size: size, qos: qos}
+}
+
+// Connection 表示一个客户端连接
+// 所有出站报文先进入有界队列，再由唯一的写协程顺序写入网络连接，避免并发写入导致报文交错
+type Connection struct {
+	Conn         net.Conn
+	ConnID       string
+	clientID     atomic.Value  // 客户端ID，CONNECT处理完成后设置
+	mu           sync.Mutex    // 保护出站队列
+	queue        []outbound    // 出站报文队列
+	queueBytes   int           // 出站队列中的字节数
+	maxDepth     int           // 出站队列最大报文数
+	maxBytes     int           // 出站队列最大字节数，0表示不限制
+	policy       string        // 溢出策略
+	notify       chan struct{} // 有新报文入队时通知写协程
+	closed       chan struct{} // 连接关闭信号
+	done         chan struct{} // 写协程退出信号
+	closeOnce    sync.Once
+	writeTimeout time.Duration // 单次写入超时
+
+	slow         bool      // 是否处于慢消费者状态，由mu保护
+	dropped      uint64    // 因溢出丢弃的报文数，由mu保护
+	lastOverflow time.Time // 最近一次溢出时间，由mu保护
+}
+
+// NewConnection 创建新的客户端连接并启动写协程
+func NewConnection(conn net.Conn, connID string) *Connection {
+	conf, _ := config.GetConfig()
+	c := &Connection{
+		Conn:         conn,
+		ConnID:       connID,
+		maxDepth:     conf.Connection.WriteQueueSize,
+		maxBytes:     conf.Connection.MaxQueueBytes,
+		policy:       conf.Connection.OverflowPolicy,
+		notify:       make(chan struct{}, 1),
+		closed:       make(chan struct{}),
+		done:         make(chan struct{}),
+		writeTimeout: utils.ParseStringTime(conf.Connection.WriteTimeout),
+	}
+	go c.writeLoop()
+	return c
+}
+
+// SetClientID 设置连接对应的客户端ID
+func (c *Connection) SetClientID(clientID string) {
+	c.clientID.Store(clientID)
+}
+
+// ClientID 返回连接对应的客户端ID，CONNECT处理完成前为空
+func (c *Connection) ClientID() string {
+	clientID, _ := c.clientID.Load().(string)
+	return clientID
+}
+
+// Send 将控制报文放入出站队列，不会阻塞调用方
+func (c *Connection) Send(data []byte) error {
+	return c.enqueue(newOutbound(net.Buffers{data}, controlQoS))
+}
+
+// SendPublish 将QoS等级为qos的PUBLISH报文放入出站队列，不会阻塞调用方
+// data中的分段可能被多个连接共享，入队后调用方不能再修改
+func (c *Connection) SendPublish(data net.Buffers, qos byte) error {
+	return c.enqueue(newOutbound(data, qos))
+}
+
+// enqueue 将报文放入出站队列，队列已满时按溢出策略处理
+func (c *Connection) enqueue(item outbound) error {
+	// 在mu保护下检查连接是否关闭，写协程关闭前最后一次取出报文同样持有mu，入队成功的报文不会被遗漏
+	c.mu.Lock()
+	select {
+	case <-c.closed:
+		c.mu.Unlock()
+		return ErrConnectionClosed
+	default:
+	}
+	if !c.fits(item.size) {
+		if err := c.overflow(item); err != nil {
+			c.mu.Unlock()
+			if errors.Is(err, ErrSlowConsumer) {
+				c.Close()
+			}
+			return err
+		}
+	}
+	c.queue = append(c.queue, item)
+	c.queueBytes += item.size
+	c.mu.Unlock()
+
+	select {
+	case c.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// fits 判断新报文是否能放入出站队列，调用方需持有mu
+func (c *Connection) fits(size int) bool {
+	if len(c.queue) >= c.maxDepth {
+		return false
+	}
+	return c.maxBytes <= 0 || c.queueBytes+size <= c.maxBytes
+}
+
+// overflow 按溢出策略为新报文腾出空间，返回非空错误表示新报文被丢弃，调用方需持有mu
+func (c *Connection) overflow(item outbound) error {
+	c.markSlow()
+	switch c.policy {
+	case config.OverflowDisconnect:
+		logger.Warn("Slow consumer disconnected", logger.KeyConnID, c.ConnID, logger.KeyClientID, c.ClientID(),
+			"queue_depth", len(c.queue), "queue_bytes", c.queueBytes)
+		stats.slowDisconnects.Add(1)
+		return ErrSlowConsumer
+	case config.OverflowDropOldest:
+		c.dropOldestQoS0(item.size)
+	default:
+		if item.qos == 0 {
+			c.dropItem()
+			return ErrQueueFull
+		}
+		c.dropOldestQoS0(item.size)
+	}
+	if !c.fits(item.size) {
+		c.dropItem()
+		return ErrQueueFull
+	}
+	return nil
+}
+
+// dropOldestQoS0 从最早的报文开始丢弃QoS 0消息，直到能放入size字节的新报文
+// 控制报文与QoS 1/2消息不会被丢弃，调用方需持有mu
+func (c *Connection) dropOldestQoS0(size int) {
+	for i := 0; i < len(c.queue) && !c.fits(size); {
+		if c.queue[i].qos == 0 {
+			c.dropAt(i)
+			continue
+		}
+		i++
+	}
+}
+
+// dropAt 丢弃出站队列中第i个报文，调用方需持有mu
+func (c *Connection) dropAt(i int) {
+	c.queueBytes -= c.queue[i].size
+	c.queue = append(c.queue[:i], c.queue[i+1:]...)
+	c.dropItem()
+}
+
+// dropItem 记录一次报文丢弃，调用方需持有mu
+func (c *Connection) dropItem() {
+	c.dropped++
+	stats.droppedMessages.Add(1)
+}
+
+// markSlow 记录一次队列溢出，首次溢出时记录慢消费者日志，调用方需持有mu
+func (c *Connection) markSlow() {
+	c.lastOverflow = time.Now()
+	if c.slow {
+		return
+	}
+	c.slow = true
+	stats.slowConsumers.Add(1)
+	logger.Warn("Client is a slow consumer", logger.KeyConnID, c.ConnID, logger.KeyClientID, c.ClientID(),
+		"queue_depth", len(c.queue), "queue_bytes", c.queueBytes, "policy", c.policy)
+}
+
+// dequeue 取出出站队列中的第一个报文，队列为空时返回false
+func (c *Connection) dequeue() (outbound, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.queue) == 0 {
+		if c.slow {
+			// 队列已清空，客户端恢复正常
+			c.slow = false
+			stats.slowConsumers.Add(-1)
+			logger.Info("Client recovered from slow consumer", logger.KeyConnID, c.ConnID, logger.KeyClientID, c.ClientID(), "dropped", c.dropped)
+		}
+		return outbound{}, false
+	}
+	item := c.queue[0]
+	c.queue[0] = outbound{}
+	c.queue = c.queue[1:]
+	c.queueBytes -= item.size
+	return item, true
+}
+
+// Close 停止接收新的出站报文，写协程会写完队列中剩余的报文后关闭网络连接
+func (c *Connection) Close() {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+	})
+}
+
+// Done 返回写协程退出信号，写协程退出时网络连接已关闭
+func (c *Connection) Done() <-chan struct{} {
+	return c.done
+}
+
+// writeLoop 写协程，顺序写入出站队列中的报文
+func (c *Connection) writeLoop() {
+	defer func() {
+		if err := c.Conn.Close(); err != nil && !IsNetClosedError(err) {
+			logger.Warn("Error occured while closing connection", logger.KeyConnID, c.ConnID, "error", err)
+		}
+		c.mu.Lock()
+		if c.slow {
+			c.slow = false
+			stats.slowConsumers.Add(-1)
+		}
+		c.mu.Unlock()
+		close(c.done)
+	}()
+	for {
+		// 写完队列中当前所有的报文
+		for {
+			item, ok := c.dequeue()
+			if !ok {
+				break
+			}
+			if err := c.write(item); err != nil {
+				c.Close()
+				return
+			}
+		}
+		select {
+		case <-c.notify:
+		case <-c.closed:
+			// 写完关闭前已入队的报文
+			for {
+				item, ok := c.dequeue()
+				if !ok {
+					return
+				}
+				if err := c.write(item); err != nil {
+					return
+				}
+			}
+		}
+	}
+}
+
+// write 带写入超时地将报文完整写入网络连接
+func (c *Connection) write(item outbound) error {
+	if c.writeTimeout > 0 {
+		_ = c.Conn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+	}
+	if err := writeBuffers(c.Conn, item.data, c.ConnID); err != nil {
+		return err
+	}
+	recordSent(item)
+	c.traceSent(item.data)
+	return nil
+}
+
+// QueueStatus 描述了连接出站队列的状态
+type QueueStatus struct {
+	ClientID        string
+	ConnID          string
+	QueueDepth      int
+	QueueBytes      int
+	DroppedMessages uint64
+	Slow            bool
+	LastOverflow    time.Time
+}
+
+// Status 返回出站队列的当前状态
+func (c *Connection) Status() QueueStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return QueueStatus{
+		ClientID:        c.ClientID(),
+		ConnID:          c.ConnID,
+		QueueDepth:      len(c.queue),
+		QueueBytes:      c.queueBytes,
+		DroppedMessages: c.dropped,
+		Slow:            c.slow,
+		LastOverflow:    c.lastOverflow,
+	}
+}
@@ -11,12 +11,6 @@ import (
 	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/logger"
 )
 
-// Connection 表示一个客户端连接
-type Connection struct {
-	Conn   net.Conn
-	ConnID string
-}
-
 // ConnectionManager 连接管理器
 type ConnectionManager struct {
 	connections sync.Map
@@ -35,16 +29,28 @@ func GetConnectionManager() *ConnectionManager {
 	return instance
 }
 
-// AddConnection 添加连接
-func (cm *ConnectionManager) AddConnection(clientID string, conn *Connection) {
-	cm.connections.Store(clientID, conn)
-	logger.InfoF("Client %s connected", clientID)
+// AddConnection 添加连接，返回被接管的同一客户端ID的旧连接
+func (cm *ConnectionManager) AddConnection(clientID string, conn *Connection) *Connection {
+	previous, loaded := cm.connections.Swap(clientID, conn)
+	if !loaded {
+		stats.connectedClients.Add(1)
+	}
+	stats.totalClients.Add(1)
+	logger.Info("Client connected", logger.KeyClientID, clientID)
+	if !loaded {
+		return nil
+	}
+	return previous.(*Connection)
 }
 
-// RemoveConnection 移除连接
-func (cm *ConnectionManager) RemoveConnection(clientID string) {
-	cm.connections.Delete(clientID)
-	logger.InfoF("Client %s disconnected", clientID)
+// RemoveConnection 移除连接，连接已被同一客户端ID的新连接接管时不做任何操作并返回false
+func (cm *ConnectionManager) RemoveConnection(clientID string, conn *Connection) bool {
+	if !cm.connections.CompareAndDelete(clientID, conn) {
+		return false
+	}
+	stats.connectedClients.Add(-1)
+	logger.Info("Client disconnected", logger.KeyClientID, clientID)
+	return true
 }
 
 // GetConnection 获取连接
@@ -67,10 +73,23 @@ func IsNetClosedError(err error) bool {
 func HandleReadError(connID string, err error) {
 	switch {
 	case errors.Is(err, io.EOF):
-		logger.InfoF("[%s] Client close connection", connID)
+		logger.Info("Client close connection", logger.KeyConnID, connID)
 	case os.IsTimeout(err):
-		logger.WarnF("[%s] Reading timeout", connID)
+		logger.Warn("Reading timeout", logger.KeyConnID, connID)
 	default:
-		logger.ErrorF("[%s] Error occured while reading packet, details: %v", connID, err)
+		logger.Error("Error occured while reading packet", logger.KeyConnID, connID, "error", err)
 	}
 }
+
+// SlowConsumers 返回所有当前处于慢消费者状态或曾经因溢出丢弃过报文的连接状态
+func (cm *ConnectionManager) SlowConsumers() []QueueStatus {
+	var result []QueueStatus
+	cm.connections.Range(func(_, value any) bool {
+		status := value.(*Connection).Status()
+		if status.Slow || status.DroppedMessages > 0 {
+			result = append(result, status)
+		}
+		return true
+	})
+	return result
+}
@@ -0,0 +1,219 @@
+package connection
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/config"
+)
+
+func newTestConnection(t *testing.T, queueSize int) (*Connection, net.Conn) {
+	return newPolicyConnection(t, queueSize, 0, config.OverflowDropQoS0, true)
+}
+
+// newPolicyConnection 创建测试连接，start为false时不启动写协程，便于观察队列内容
+func newPolicyConnection(t *testing.T, maxDepth int, maxBytes int, policy string, start bool) (*Connection, net.Conn) {
+	server, client := net.Pipe()
+	c := &Connection{
+		Conn:         server,
+		ConnID:       "test",
+		maxDepth:     maxDepth,
+		maxBytes:     maxBytes,
+		policy:       policy,
+		notify:       make(chan struct{}, 1),
+		closed:       make(chan struct{}),
+		done:         make(chan struct{}),
+		writeTimeout: time.Second,
+	}
+	if start {
+		go c.writeLoop()
+	}
+	t.Cleanup(func() {
+		_ = client.Close()
+		_ = server.Close()
+	})
+	return c, client
+}
+
+// queued 返回出站队列中所有报文的第一个字节
+func queued(c *Connection) []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var result []byte
+	for _, item := range c.queue {
+		result = append(result, item.data[0][0])
+	}
+	return result
+}
+
+func TestConnectionConcurrentSend(t *testing.T) {
+	c, client := newTestConnection(t, 1024)
+
+	const senders, packets = 8, 50
+	var wg sync.WaitGroup
+	for i := 0; i < senders; i++ {
+		wg.Add(1)
+		go func(id byte) {
+			defer wg.Done()
+			for j := 0; j < packets; j++ {
+				if err := c.Send(bytes.Repeat([]byte{id}, 64)); err != nil {
+					t.Errorf("Send() error: %v", err)
+				}
+			}
+		}(byte(i + 1))
+	}
+
+	received := make(chan []byte)
+	go func() {
+		data, _ := io.ReadAll(client)
+		received <- data
+	}()
+	wg.Wait()
+	c.Close()
+	<-c.Done()
+
+	data := <-received
+	if len(data) != senders*packets*64 {
+		t.Fatalf("received %d bytes, want %d", len(data), senders*packets*64)
+	}
+	// 每个报文必须连续写入，不能与其他报文交错
+	for i := 0; i < len(data); i += 64 {
+		if !bytes.Equal(data[i:i+64], bytes.Repeat(data[i:i+1], 64)) {
+			t.Fatalf("packet at offset %d is interleaved", i)
+		}
+	}
+}
+
+func TestConnectionCloseFlushesQueue(t *testing.T) {
+	c, client := newTestConnection(t, 4)
+
+	for _, data := range [][]byte{{0x01}, {0x02}, {0x03}} {
+		if err := c.Send(data); err != nil {
+			t.Fatalf("Send() error: %v", err)
+		}
+	}
+	c.Close()
+
+	data, _ := io.ReadAll(client)
+	if !bytes.Equal(data, []byte{0x01, 0x02, 0x03}) {
+		t.Errorf("received %v, want [1 2 3]", data)
+	}
+	<-c.Done()
+
+	if err := c.Send([]byte{0x04}); !errors.Is(err, ErrConnectionClosed) {
+		t.Errorf("Send() after Close got %v, want %v", err, ErrConnectionClosed)
+	}
+}
+
+func TestSendDuringClose(t *testing.T) {
+	for round := 0; round < 50; round++ {
+		c, client := newPolicyConnection(t, 1024, 0, config.OverflowDropQoS0, true)
+		received := make(chan int)
+		go func() {
+			data, _ := io.ReadAll(client)
+			received <- len(data)
+		}()
+
+		// 与Close并发的发送要么返回错误，要么报文在连接关闭前写出
+		var sent atomic.Int32
+		var wg sync.WaitGroup
+		start := make(chan struct{})
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				<-start
+				for j := 0; j < 16; j++ {
+					if c.Send([]byte{byte(j)}) == nil {
+						sent.Add(1)
+					}
+				}
+			}()
+		}
+		close(start)
+		c.Close()
+		wg.Wait()
+		<-c.Done()
+		if got := <-received; got != int(sent.Load()) {
+			t.Fatalf("round %d: received %d bytes, but %d sends succeeded", round, got, sent.Load())
+		}
+	}
+}
+
+func TestOverflowDropQoS0(t *testing.T) {
+	c, _ := newPolicyConnection(t, 3, 0, config.OverflowDropQoS0, false)
+
+	_ = c.SendPublish(net.Buffers{[]byte{1}}, 0)
+	_ = c.SendPublish(net.Buffers{[]byte{2}}, 1)
+	_ = c.SendPublish(net.Buffers{[]byte{3}}, 0)
+
+	// 新的QoS 0报文直接被丢弃
+	if err := c.SendPublish(net.Buffers{[]byte{4}}, 0); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("SendPublish() got %v, want %v", err, ErrQueueFull)
+	}
+	// 新的QoS 1报文挤掉最早的QoS 0报文
+	if err := c.SendPublish(net.Buffers{[]byte{5}}, 1); err != nil {
+		t.Errorf("SendPublish() error: %v", err)
+	}
+	// 控制报文同样可以挤掉QoS 0报文
+	if err := c.Send([]byte{6}); err != nil {
+		t.Errorf("Send() error: %v", err)
+	}
+	// 队列中已经没有QoS 0报文
+	if err := c.SendPublish(net.Buffers{[]byte{7}}, 2); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("SendPublish() got %v, want %v", err, ErrQueueFull)
+	}
+
+	if got := queued(c); !bytes.Equal(got, []byte{2, 5, 6}) {
+		t.Errorf("queue got %v, want [2 5 6]", got)
+	}
+	status := c.Status()
+	if !status.Slow || status.DroppedMessages != 4 {
+		t.Errorf("unexpected status %+v", status)
+	}
+}
+
+func TestOverflowDropOldest(t *testing.T) {
+	c, _ := newPolicyConnection(t, 10, 6, config.OverflowDropOldest, false)
+
+	_ = c.SendPublish(net.Buffers{[]byte{1, 1}}, 0)
+	_ = c.Send([]byte{2, 2})
+	_ = c.SendPublish(net.Buffers{[]byte{3, 3}}, 0)
+
+	// 超出字节限制时丢弃最早的QoS 0消息，跳过控制报文
+	if err := c.SendPublish(net.Buffers{[]byte{4, 4, 4}}, 1); err != nil {
+		t.Errorf("SendPublish() error: %v", err)
+	}
+	if got := queued(c); !bytes.Equal(got, []byte{2, 4}) {
+		t.Errorf("queue got %v, want [2 4]", got)
+	}
+	// 控制报文与QoS 1/2消息不会被丢弃，新消息无法入队
+	if err := c.SendPublish(net.Buffers{[]byte{5, 5}}, 0); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("SendPublish() got %v, want %v", err, ErrQueueFull)
+	}
+	// 单个报文超过字节限制时无法入队
+	if err := c.SendPublish(net.Buffers{make([]byte, 7)}, 1); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("SendPublish() got %v, want %v", err, ErrQueueFull)
+	}
+	if got := queued(c); !bytes.Equal(got, []byte{2, 4}) {
+		t.Errorf("queue got %v, want [2 4]", got)
+	}
+}
+
+func TestOverflowDisconnect(t *testing.T) {
+	c, _ := newPolicyConnection(t, 1, 0, config.OverflowDisconnect, false)
+
+	_ = c.SendPublish(net.Buffers{[]byte{1}}, 1)
+	if err := c.SendPublish(net.Buffers{[]byte{2}}, 0); !errors.Is(err, ErrSlowConsumer) {
+		t.Errorf("SendPublish() got %v, want %v", err, ErrSlowConsumer)
+	}
+	if err := c.Send([]byte{3}); !errors.Is(err, ErrConnectionClosed) {
+		t.Errorf("Send() got %v, want %v", err, ErrConnectionClosed)
+	}
+}
@@ -8,7 +8,7 @@ import (
 
 // MessageSender 消息发送器接口
 type MessageSender interface {
-	SendMessage(clientID string, data []byte) error
+	SendMessage(clientID string, data net.Buffers, qos byte) error
 }
 
 // DefaultMessageSender 默认的消息发送器实现
@@ -19,27 +19,24 @@ func NewMessageSender() MessageSender {
 	return &DefaultMessageSender{}
 }
 
-// SendMessage 发送消息到指定客户端
-func (s *DefaultMessageSender) SendMessage(clientID string, data []byte) error {
+// SendMessage 发送QoS等级为qos的PUBLISH报文到指定客户端
+func (s *DefaultMessageSender) SendMessage(clientID string, data net.Buffers, qos byte) error {
 	connManager := GetConnectionManager()
 	conn, ok := connManager.GetConnection(clientID)
 	if !ok {
 		return nil
 	}
-	return Send(conn.Conn, data, conn.ConnID)
+	return conn.SendPublish(data, qos)
 }
 
-// Send 发送数据到客户端
-func Send(conn net.Conn, data []byte, connID string) error {
-	total := 0
-	for total < len(data) {
-		n, err := conn.Write(data[total:])
-		if err != nil {
-			logger.ErrorF("[%s] Fail to send data, details: %v", connID, err)
-			return err
-		}
-		total += n
+// writeBuffers 使用向量化写入将报文完整写入网络连接，只能由连接的写协程调用
+func writeBuffers(conn net.Conn, data net.Buffers, connID string) error {
+	// WriteTo 会消耗分段切片，拷贝切片头以免修改共享的分段
+	buffers := make(net.Buffers, len(data))
+	copy(buffers, data)
+	if _, err := buffers.WriteTo(conn); err != nil {
+		logger.Error("Fail to send data", logger.KeyConnID, connID, "error", err)
+		return err
 	}
-	logger.DebugF("[%s] Send %d bytes to client, data %v", connID, total, data)
 	return nil
 }
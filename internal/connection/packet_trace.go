@@ -0,0 +1,262 @@
+// Package connection 实现了MQTT服务器的报文跟踪功能
+package connection
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/database"
+	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/logger"
+	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/mqtt"
+)
+
+const (
+	DefaultTraceTTL = 5 * time.Minute // 未指定时报文跟踪的持续时间
+	MaxTraceTTL     = time.Hour       // 报文跟踪的最长持续时间
+	maxTraces       = 16              // 同时开启的报文跟踪数上限
+)
+
+var (
+	ErrEmptyTraceFilter = errors.New("packet trace requires at least one client ID, network or topic filter")
+	ErrTooManyTraces    = errors.New("too many packet traces")
+)
+
+// TraceFilter 报文跟踪的匹配条件
+// 不同类型的条件需要同时满足，同一类型的条件满足其一即可，某一类型的条件为空表示不限制
+type TraceFilter struct {
+	ClientIDs []string       // 客户端ID，支持 * 与 ? 通配符，CONNECT报文按其中的客户端ID匹配
+	Networks  []netip.Prefix // 客户端IP地址所在的网段
+	Topics    []string       // 主题过滤器，设置后只跟踪主题匹配的PUBLISH报文
+}
+
+// ParseNetwork 解析CIDR格式的网段，单个IP地址视为只包含该地址的网段
+func ParseNetwork(network string) (netip.Prefix, error) {
+	if !strings.Contains(network, "/") {
+		addr, err := netip.ParseAddr(network)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		addr = addr.Unmap()
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+	prefix, err := netip.ParsePrefix(network)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	if prefix.Addr().Is4In6() {
+		prefix = netip.PrefixFrom(prefix.Addr().Unmap(), max(prefix.Bits()-96, 0))
+	}
+	return prefix.Masked(), nil
+}
+
+// PacketTrace 一次报文跟踪，到期后自动停止
+type PacketTrace struct {
+	ID      string
+	Filter  TraceFilter
+	Created time.Time
+	Expires time.Time
+	packets atomic.Uint64 // 已输出的报文数
+	timer   *time.Timer
+}
+
+// Packets 返回已输出的报文数
+func (t *PacketTrace) Packets() uint64 {
+	return t.packets.Load()
+}
+
+// matchesPeer 返回IP地址为addr的客户端clientID收发的报文是否满足客户端与网段条件，不需要解码报文
+func (t *PacketTrace) matchesPeer(clientID string, addr netip.Addr) bool {
+	filter := t.Filter
+	if len(filter.ClientIDs) > 0 && !slices.ContainsFunc(filter.ClientIDs, func(pattern string) bool {
+		matched, _ := path.Match(pattern, clientID)
+		return matched
+	}) {
+		return false
+	}
+	if len(filter.Networks) > 0 && !slices.ContainsFunc(filter.Networks, func(network netip.Prefix) bool {
+		return addr.IsValid() && network.Contains(addr)
+	}) {
+		return false
+	}
+	return time.Now().Before(t.Expires)
+}
+
+// matchesTopic 返回报文是否满足主题条件，设置了主题条件时只有PUBLISH报文需要解码
+func (t *PacketTrace) matchesTopic(packetType mqtt.PacketType, info func() *mqtt.PacketInfo) bool {
+	if len(t.Filter.Topics) == 0 {
+		return true
+	}
+	return packetType == mqtt.PUBLISH && slices.ContainsFunc(t.Filter.Topics, func(topic string) bool {
+		return database.TopicMatchesFilter(topic, info().Topic)
+	})
+}
+
+// traceRegistry 当前开启的报文跟踪
+// 读取不加锁，修改时替换整个列表，没有开启跟踪时收发报文只需读取一次原子指针
+type traceRegistry struct {
+	mu     sync.Mutex // 串行化修改
+	nextID uint64
+	active atomic.Pointer[[]*PacketTrace] // 没有开启跟踪时为nil
+}
+
+var traces = &traceRegistry{}
+
+// update 在mu保护下修改跟踪列表
+func (r *traceRegistry) update(change func(list []*PacketTrace) []*PacketTrace) {
+	var list []*PacketTrace
+	if current := r.active.Load(); current != nil {
+		list = slices.Clone(*current)
+	}
+	if list = change(list); len(list) == 0 {
+		r.active.Store(nil)
+		return
+	}
+	r.active.Store(&list)
+}
+
+// StartTrace 开启一次报文跟踪，ttl为0时使用 DefaultTraceTTL
+// 匹配的客户端收发的每个报文都会解码后以 TRACE 级别输出，不受日志级别限制
+func StartTrace(filter TraceFilter, ttl time.Duration) (*PacketTrace, error) {
+	if len(filter.ClientIDs) == 0 && len(filter.Networks) == 0 && len(filter.Topics) == 0 {
+		return nil, ErrEmptyTraceFilter
+	}
+	for _, pattern := range filter.ClientIDs {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid client ID pattern %q", pattern)
+		}
+	}
+	for _, topic := range filter.Topics {
+		if err := database.ValidateTopicFilter(topic); err != nil {
+			return nil, err
+		}
+	}
+	if ttl <= 0 {
+		ttl = DefaultTraceTTL
+	}
+	if ttl > MaxTraceTTL {
+		return nil, fmt.Errorf("packet trace ttl must not exceed %v", MaxTraceTTL)
+	}
+
+	traces.mu.Lock()
+	defer traces.mu.Unlock()
+	if current := traces.active.Load(); current != nil && len(*current) >= maxTraces {
+		return nil, ErrTooManyTraces
+	}
+	traces.nextID++
+	now := time.Now()
+	trace := &PacketTrace{
+		ID:      strconv.FormatUint(traces.nextID, 10),
+		Filter:  filter,
+		Created: now,
+		Expires: now.Add(ttl),
+	}
+	traces.update(func(list []*PacketTrace) []*PacketTrace { return append(list, trace) })
+	trace.timer = time.AfterFunc(ttl, func() {
+		if removeTrace(trace.ID) != nil {
+			logger.Info("Packet trace expired", "trace_id", trace.ID, "packets", trace.Packets())
+		}
+	})
+	logger.Info("Packet trace started", "trace_id", trace.ID, "client_ids", filter.ClientIDs,
+		"networks", filter.Networks, "topics", filter.Topics, "ttl", ttl)
+	return trace, nil
+}
+
+// StopTrace 停止报文跟踪，跟踪不存在或已到期时返回false
+func StopTrace(id string) bool {
+	trace := removeTrace(id)
+	if trace == nil {
+		return false
+	}
+	trace.timer.Stop()
+	logger.Info("Packet trace stopped", "trace_id", trace.ID, "packets", trace.Packets())
+	return true
+}
+
+// removeTrace 从跟踪列表中删除报文跟踪，返回被删除的跟踪
+func removeTrace(id string) *PacketTrace {
+	traces.mu.Lock()
+	defer traces.mu.Unlock()
+	var removed *PacketTrace
+	traces.update(func(list []*PacketTrace) []*PacketTrace {
+		return slices.DeleteFunc(list, func(trace *PacketTrace) bool {
+			if trace.ID == id {
+				removed = trace
+				return true
+			}
+			return false
+		})
+	})
+	return removed
+}
+
+// Traces 返回当前开启的报文跟踪
+func Traces() []*PacketTrace {
+	if current := traces.active.Load(); current != nil {
+		return slices.Clone(*current)
+	}
+	return nil
+}
+
+// TraceReceived 在开启了匹配的报文跟踪时输出收到的报文，需要在报文被释放前调用
+func (c *Connection) TraceReceived(packet *mqtt.Packet) {
+	if active := traces.active.Load(); active != nil {
+		c.trace(*active, "in", packet.Header.Type, packet.Describe)
+	}
+}
+
+// traceSent 在开启了匹配的报文跟踪时输出写入网络连接的报文
+func (c *Connection) traceSent(data net.Buffers) {
+	if active := traces.active.Load(); active != nil && len(data) > 0 && len(data[0]) > 0 {
+		c.trace(*active, "out", mqtt.PacketType(data[0][0]>>4), func() mqtt.PacketInfo { return mqtt.DescribeSegments(data) })
+	}
+}
+
+// trace 输出与任意一个跟踪匹配的报文，多个跟踪匹配同一个报文时只输出一次
+// 先按客户端ID与网段筛选，只有需要匹配主题或确定输出时才解码报文
+func (c *Connection) trace(active []*PacketTrace, direction string, packetType mqtt.PacketType, describe func() mqtt.PacketInfo) {
+	var info *mqtt.PacketInfo
+	decode := func() *mqtt.PacketInfo {
+		if info == nil {
+			decoded := describe()
+			info = &decoded
+		}
+		return info
+	}
+	clientID := c.ClientID()
+	if clientID == "" && packetType == mqtt.CONNECT {
+		clientID = decode().ClientID
+	}
+	addr := remoteAddr(c.Conn)
+	var ids []string
+	for _, trace := range active {
+		if trace.matchesPeer(clientID, addr) && trace.matchesTopic(packetType, decode) {
+			trace.packets.Add(1)
+			ids = append(ids, trace.ID)
+		}
+	}
+	if len(ids) > 0 {
+		logger.Trace("Packet trace", "trace_id", strings.Join(ids, ","), "direction", direction,
+			logger.KeyConnID, c.ConnID, logger.KeyClientID, clientID, "packet", *decode())
+	}
+}
+
+// remoteAddr 返回网络连接对端的IP地址，无法解析时返回无效地址
+func remoteAddr(conn net.Conn) netip.Addr {
+	if tcpAddr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		return tcpAddr.AddrPort().Addr().Unmap()
+	}
+	addrPort, err := netip.ParseAddrPort(conn.RemoteAddr().String())
+	if err != nil {
+		return netip.Addr{}
+	}
+	return addrPort.Addr().Unmap()
+}
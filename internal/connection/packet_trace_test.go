@@ -0,0 +1,251 @@
+package connection
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/config"
+	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/mqtt"
+)
+
+// addrConn 使用指定对端地址的网络连接
+type addrConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (c *addrConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// lockedBuffer 可以被并发写入的缓冲区
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// records 返回写入的所有JSON日志
+func (b *lockedBuffer) records(t *testing.T) []map[string]any {
+	t.Helper()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var records []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(b.buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var record map[string]any
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("invalid JSON %q: %v", line, err)
+		}
+		if record["msg"] == "Packet trace" {
+			records = append(records, record)
+		}
+	}
+	b.buf.Reset()
+	return records
+}
+
+// captureLogs 在测试期间把默认Logger的输出写入返回的缓冲区
+func captureLogs(t *testing.T) *lockedBuffer {
+	buffer := &lockedBuffer{}
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(buffer, nil)))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+	return buffer
+}
+
+// newTraceConnection 创建对端地址为remote的测试连接
+func newTraceConnection(t *testing.T, remote string, clientID string) *Connection {
+	c, _ := newPolicyConnection(t, 16, 0, config.OverflowDropQoS0, false)
+	c.Conn = &addrConn{Conn: c.Conn, remote: net.TCPAddrFromAddrPort(netip.MustParseAddrPort(remote))}
+	if clientID != "" {
+		c.SetClientID(clientID)
+	}
+	return c
+}
+
+// encodeTestString 编码MQTT字符串
+func encodeTestString(s string) []byte {
+	return append([]byte{byte(len(s) >> 8), byte(len(s))}, s...)
+}
+
+// rawPacket 编码固定头部为first的报文
+func rawPacket(first byte, body ...[]byte) []byte {
+	joined := bytes.Join(body, nil)
+	return append(append([]byte{first}, mqtt.EncodeRemainingLength(len(joined))...), joined...)
+}
+
+// readTestPacket 解析完整的报文
+func readTestPacket(t *testing.T, data []byte) *mqtt.Packet {
+	t.Helper()
+	packet, err := mqtt.ReadPacket(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("ReadPacket: %v", err)
+	}
+	return packet
+}
+
+func TestStartTraceValidation(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter TraceFilter
+		ttl    time.Duration
+	}{
+		{"empty filter", TraceFilter{}, 0},
+		{"invalid pattern", TraceFilter{ClientIDs: []string{"[sensor"}}, 0},
+		{"invalid topic filter", TraceFilter{Topics: []string{"a/#/b"}}, 0},
+		{"ttl too long", TraceFilter{ClientIDs: []string{"sensor"}}, 2 * MaxTraceTTL},
+	}
+	for _, tt := range tests {
+		if trace, err := StartTrace(tt.filter, tt.ttl); err == nil {
+			StopTrace(trace.ID)
+			t.Errorf("%s: StartTrace succeeded", tt.name)
+		}
+	}
+	if len(Traces()) != 0 {
+		t.Fatalf("invalid traces were registered: %d", len(Traces()))
+	}
+}
+
+func TestParseNetwork(t *testing.T) {
+	tests := []struct {
+		network string
+		want    string
+		err     bool
+	}{
+		{"10.1.2.3", "10.1.2.3/32", false},
+		{"10.1.2.3/8", "10.0.0.0/8", false},
+		{"::ffff:10.1.2.3/120", "10.1.2.0/24", false},
+		{"2001:db8::1", "2001:db8::1/128", false},
+		{"10.1.2", "", true},
+		{"10.0.0.0/40", "", true},
+	}
+	for _, tt := range tests {
+		prefix, err := ParseNetwork(tt.network)
+		if (err != nil) != tt.err || (!tt.err && prefix.String() != tt.want) {
+			t.Errorf("ParseNetwork(%q) = %v, %v, want %s", tt.network, prefix, err, tt.want)
+		}
+	}
+}
+
+func TestTraceReceivedMatching(t *testing.T) {
+	logs := captureLogs(t)
+	publish := rawPacket(0x32, encodeTestString("a/b"), []byte{0x00, 0x07}, []byte("hello"))
+	subscribe := rawPacket(0x82, []byte{0x00, 0x01}, encodeTestString("a/+"), []byte{0x01})
+	connect := rawPacket(0x10, encodeTestString("MQTT"), []byte{0x04, 0x02, 0x00, 0x3C}, encodeTestString("sensor-9"))
+
+	tests := []struct {
+		name     string
+		filter   TraceFilter
+		remote   string
+		clientID string
+		packet   []byte
+		traced   bool
+	}{
+		{"client pattern", TraceFilter{ClientIDs: []string{"sensor-*"}}, "10.1.2.3:5000", "sensor-1", publish, true},
+		{"other client", TraceFilter{ClientIDs: []string{"sensor-*"}}, "10.1.2.3:5000", "gateway", publish, false},
+		{"client ID from CONNECT", TraceFilter{ClientIDs: []string{"sensor-9"}}, "10.1.2.3:5000", "", connect, true},
+		{"network", TraceFilter{Networks: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}}, "10.1.2.3:5000", "gateway", subscribe, true},
+		{"other network", TraceFilter{Networks: []netip.Prefix{netip.MustParsePrefix("192.168.0.0/16")}}, "10.1.2.3:5000", "gateway", publish, false},
+		{"topic filter", TraceFilter{Topics: []string{"a/+"}}, "10.1.2.3:5000", "gateway", publish, true},
+		{"topic filter skips other packets", TraceFilter{Topics: []string{"a/+"}}, "10.1.2.3:5000", "gateway", subscribe, false},
+		{"all conditions", TraceFilter{ClientIDs: []string{"sensor-1"}, Topics: []string{"b/#"}}, "10.1.2.3:5000", "sensor-1", publish, false},
+	}
+	for _, tt := range tests {
+		trace, err := StartTrace(tt.filter, time.Minute)
+		if err != nil {
+			t.Fatalf("%s: StartTrace: %v", tt.name, err)
+		}
+		c := newTraceConnection(t, tt.remote, tt.clientID)
+		c.TraceReceived(readTestPacket(t, tt.packet))
+		StopTrace(trace.ID)
+
+		records := logs.records(t)
+		if traced := len(records) == 1; traced != tt.traced || trace.Packets() != uint64(len(records)) {
+			t.Fatalf("%s: traced %d packets (counter %d), want traced=%v", tt.name, len(records), trace.Packets(), tt.traced)
+		}
+		if tt.traced && (records[0]["direction"] != "in" || records[0]["trace_id"] != trace.ID) {
+			t.Fatalf("%s: unexpected trace record %v", tt.name, records[0])
+		}
+	}
+}
+
+func TestTraceSentDecodesPacket(t *testing.T) {
+	logs := captureLogs(t)
+	trace, err := StartTrace(TraceFilter{ClientIDs: []string{"sensor"}}, time.Minute)
+	if err != nil {
+		t.Fatalf("StartTrace: %v", err)
+	}
+	defer StopTrace(trace.ID)
+
+	c := newTraceConnection(t, "[2001:db8::1]:5000", "sensor")
+	data := rawPacket(0x3B, encodeTestString("a/b"), []byte{0x12, 0x34}, []byte("hello"))
+	// 报文头与有效载荷位于不同的分段
+	c.traceSent(net.Buffers{data[:7], data[7:]})
+
+	records := logs.records(t)
+	if len(records) != 1 {
+		t.Fatalf("traced %d packets, want 1", len(records))
+	}
+	packet, _ := records[0]["packet"].(map[string]any)
+	want := map[string]any{"type": "PUBLISH", "flags": "1011", "qos": 1.0, "dup": true, "retain": true,
+		"packet_id": float64(0x1234), "topic": "a/b", "payload": "hello", "payload_size": 5.0}
+	for key, value := range want {
+		if packet[key] != value {
+			t.Errorf("packet.%s = %v, want %v", key, packet[key], value)
+		}
+	}
+	if records[0]["direction"] != "out" || records[0]["client_id"] != "sensor" {
+		t.Fatalf("unexpected trace record %v", records[0])
+	}
+}
+
+func TestTraceSkipsOtherClientsWithoutDecoding(t *testing.T) {
+	trace, err := StartTrace(TraceFilter{ClientIDs: []string{"sensor"}}, time.Minute)
+	if err != nil {
+		t.Fatalf("StartTrace: %v", err)
+	}
+	defer StopTrace(trace.ID)
+
+	// 其他客户端的报文不会被解码或合并，跟踪开启时也不影响整个服务器的转发
+	c := newTraceConnection(t, "10.1.2.3:5000", "gateway")
+	data := rawPacket(0x30, encodeTestString("a/b"), []byte("hello"))
+	segments := net.Buffers{data[:7], data[7:]}
+	if allocs := testing.AllocsPerRun(100, func() { c.traceSent(segments) }); allocs != 0 {
+		t.Fatalf("traceSent allocated %v times for an untraced client", allocs)
+	}
+	if trace.Packets() != 0 {
+		t.Fatalf("traced %d packets of another client", trace.Packets())
+	}
+}
+
+func TestTraceExpires(t *testing.T) {
+	trace, err := StartTrace(TraceFilter{ClientIDs: []string{"sensor"}}, 20*time.Millisecond)
+	if err != nil {
+		t.Fatalf("StartTrace: %v", err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for len(Traces()) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("trace did not expire")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if StopTrace(trace.ID) {
+		t.Fatal("StopTrace succeeded for an expired trace")
+	}
+}
@@ -0,0 +1,120 @@
+package connection
+
+import (
+	"sync/atomic"
+
+	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/metrics"
+	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/mqtt"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// connectionStats 连接层的全局统计
+type connectionStats struct {
+	slowConsumers    atomic.Int64  // 当前处于慢消费者状态的连接数
+	slowDisconnects  atomic.Uint64 // 因慢消费者被断开的连接数
+	droppedMessages  atomic.Uint64 // 因出站队列溢出丢弃的报文数
+	connectedClients atomic.Int64  // 当前在线的客户端数
+	totalClients     atomic.Uint64 // 启动以来完成CONNECT的客户端数
+	messagesReceived atomic.Uint64 // 收到的PUBLISH报文数
+	messagesSent     atomic.Uint64 // 发送的PUBLISH报文数
+	bytesReceived    atomic.Uint64 // 收到的字节数，包括所有类型的报文
+	bytesSent        atomic.Uint64 // 发送的字节数，包括所有类型的报文
+}
+
+var stats = &connectionStats{}
+
+// Stats 连接层统计数据快照
+type Stats struct {
+	SlowConsumers    int64
+	SlowDisconnects  uint64
+	DroppedMessages  uint64
+	ConnectedClients int64
+	TotalClients     uint64
+	MessagesReceived uint64
+	MessagesSent     uint64
+	BytesReceived    uint64
+	BytesSent        uint64
+}
+
+// GetStats 返回连接层统计数据快照
+func GetStats() Stats {
+	return Stats{
+		SlowConsumers:    stats.slowConsumers.Load(),
+		SlowDisconnects:  stats.slowDisconnects.Load(),
+		DroppedMessages:  stats.droppedMessages.Load(),
+		ConnectedClients: stats.connectedClients.Load(),
+		TotalClients:     stats.totalClients.Load(),
+		MessagesReceived: stats.messagesReceived.Load(),
+		MessagesSent:     stats.messagesSent.Load(),
+		BytesReceived:    stats.bytesReceived.Load(),
+		BytesSent:        stats.bytesSent.Load(),
+	}
+}
+
+// RecordReceived 记录收到的一个报文，size为报文总字节数
+func RecordReceived(packetType mqtt.PacketType, size int) {
+	stats.bytesReceived.Add(uint64(size))
+	if packetType == mqtt.PUBLISH {
+		stats.messagesReceived.Add(1)
+	}
+	metrics.PacketsReceived.WithLabelValues(packetTypeLabel(packetType)).Inc()
+}
+
+// recordSent 记录写入网络连接的一个报文
+func recordSent(item outbound) {
+	stats.bytesSent.Add(uint64(item.size))
+	if item.qos != controlQoS {
+		stats.messagesSent.Add(1)
+	}
+	if len(item.data) > 0 && len(item.data[0]) > 0 {
+		metrics.PacketsSent.WithLabelValues(packetTypeLabel(mqtt.PacketType(item.data[0][0] >> 4))).Inc()
+	}
+}
+
+// packetTypeLabel 返回报文类型的指标标签
+func packetTypeLabel(packetType mqtt.PacketType) string {
+	if name := packetType.String(); name != "" {
+		return name
+	}
+	return "UNKNOWN"
+}
+
+// queueTotals 返回所有连接出站队列的报文数与字节数之和
+func queueTotals() (depth int, bytes int) {
+	GetConnectionManager().connections.Range(func(_, value any) bool {
+		status := value.(*Connection).Status()
+		depth += status.QueueDepth
+		bytes += status.QueueBytes
+		return true
+	})
+	return depth, bytes
+}
+
+func init() {
+	metrics.Registry.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metrics.Namespace, Name: "clients_connected",
+			Help: "Current number of connected clients.",
+		}, func() float64 { return float64(stats.connectedClients.Load()) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metrics.Namespace, Name: "outbound_queue_depth",
+			Help: "Total number of packets waiting in outbound queues.",
+		}, func() float64 { depth, _ := queueTotals(); return float64(depth) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metrics.Namespace, Name: "outbound_queue_bytes",
+			Help: "Total number of bytes waiting in outbound queues.",
+		}, func() float64 { _, bytes := queueTotals(); return float64(bytes) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metrics.Namespace, Name: "slow_consumers",
+			Help: "Current number of connections marked as slow consumers.",
+		}, func() float64 { return float64(stats.slowConsumers.Load()) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: metrics.Namespace, Name: "dropped_messages_total",
+			Help: "Total number of packets dropped because an outbound queue overflowed.",
+		}, func() float64 { return float64(stats.droppedMessages.Load()) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: metrics.Namespace, Name: "slow_consumer_disconnects_total",
+			Help: "Total number of connections closed as slow consumers.",
+		}, func() float64 { return float64(stats.slowDisconnects.Load()) }),
+	)
+}
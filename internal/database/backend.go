@@ -0,0 +1,92 @@
+// Package database 实现了MQTT服务器的数据存储功能
+package database
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrNotFound 存储后端中不存在请求的数据
+var ErrNotFound = errors.New("document does not exist")
+
+// SubscriptionChange 一次订阅变更
+type SubscriptionChange struct {
+	Subscription Subscription
+	Remove       bool // 为true时删除订阅
+}
+
+// SessionCommit 会话及其订阅变更，存储后端将其作为一个整体写入，保证会话与订阅树一致
+type SessionCommit struct {
+	ClientID string
+	Session  *SessionData         // 会话快照，为nil时只写入订阅变更
+	Delete   bool                 // 删除会话
+	Changes  []SubscriptionChange // 订阅树变更
+}
+
+// SessionBackend 持久会话的存储
+type SessionBackend interface {
+	LoadSessions(ctx context.Context) ([]*SessionData, error)
+	LoadSession(ctx context.Context, clientID string) (*SessionData, error)
+	SaveSession(ctx context.Context, session *SessionData) error
+	DeleteSession(ctx context.Context, clientID string) error
+	// UpdateSessions 批量写入会话的字段级变更，跳过不存在的会话
+	UpdateSessions(ctx context.Context, updates []*SessionUpdate) error
+	// CommitSession 写入会话及其订阅变更
+	CommitSession(ctx context.Context, commit *SessionCommit) error
+}
+
+// SubscriptionBackend 订阅的存储，路由只使用内存订阅树，存储后端仅用于启动时恢复
+type SubscriptionBackend interface {
+	LoadSubscriptions(ctx context.Context) ([]Subscription, error)
+	SaveSubscription(ctx context.Context, subscription Subscription) error
+	DeleteSubscription(ctx context.Context, subscription Subscription) error
+}
+
+// WillMessageBackend 遗嘱消息的存储
+type WillMessageBackend interface {
+	LoadWillMessage(ctx context.Context, clientID string) (*WillMessage, error)
+	SaveWillMessage(ctx context.Context, willMessage *WillMessage) error
+	DeleteWillMessage(ctx context.Context, clientID string) error
+}
+
+// RetainedMessageBackend 保留消息的存储，每个主题最多保存一条
+type RetainedMessageBackend interface {
+	LoadRetainedMessages(ctx context.Context) ([]*RetainedMessage, error)
+	SaveRetainedMessage(ctx context.Context, message *RetainedMessage) error
+	DeleteRetainedMessage(ctx context.Context, topic string) error
+	CountRetainedMessages(ctx context.Context) (int64, error)
+}
+
+// QueueBackend 离线客户端消息队列的存储，消息按入队顺序返回
+type QueueBackend interface {
+	EnqueueMessage(ctx context.Context, message *QueuedMessage) error
+	LoadQueuedMessages(ctx context.Context, clientID string) ([]*QueuedMessage, error)
+	ClearQueuedMessages(ctx context.Context, clientID string) error
+}
+
+// Backend 存储后端，实现需要支持并发调用
+type Backend interface {
+	SessionBackend
+	SubscriptionBackend
+	WillMessageBackend
+	RetainedMessageBackend
+	QueueBackend
+	// Name 返回后端名称，用于日志
+	Name() string
+	// Ping 检查存储后端是否可用，断路器打开后用于探测恢复
+	Ping(ctx context.Context) error
+	// Atomic 返回 CommitSession 是否为原子操作，不是时需要定期校验会话与订阅树的一致性
+	Atomic() bool
+	// Close 关闭存储后端
+	Close(ctx context.Context) error
+}
+
+// BackendCloseCallback 在关闭阶段关闭存储后端
+type BackendCloseCallback struct {
+	backend Backend
+}
+
+// Invoke 执行存储后端关闭操作
+func (cb *BackendCloseCallback) Invoke(ctx context.Context) error {
+	return cb.backend.Close(ctx)
+}
@@ -0,0 +1,200 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	c "github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/config"
+)
+
+// openTestBolt 在临时目录中打开bbolt存储后端
+func openTestBolt(t *testing.T, path string) *boltBackend {
+	t.Helper()
+	backend, err := openBoltBackend(path)
+	if err != nil {
+		t.Fatalf("openBoltBackend: %v", err)
+	}
+	return backend
+}
+
+// forEachBackend 对每种嵌入式存储后端运行同一组测试
+func forEachBackend(t *testing.T, test func(t *testing.T, backend Backend)) {
+	backends := map[string]func(t *testing.T) Backend{
+		"bolt": func(t *testing.T) Backend {
+			return openTestBolt(t, filepath.Join(t.TempDir(), "broker.db"))
+		},
+		"memory": func(t *testing.T) Backend {
+			return NewMemoryBackend()
+		},
+	}
+	for name, open := range backends {
+		t.Run(name, func(t *testing.T) {
+			backend := open(t)
+			defer backend.Close(context.Background())
+			test(t, backend)
+		})
+	}
+}
+
+func TestBackendSessions(t *testing.T) {
+	forEachBackend(t, testBackendSessions)
+}
+
+func testBackendSessions(t *testing.T, backend Backend) {
+	ctx := context.Background()
+
+	session := NewSessionData("client")
+	session.Subscriptions["a/b"] = 1
+	session.PendingPublish[7] = "a/b"
+	if err := backend.SaveSession(ctx, session); err != nil {
+		t.Fatalf("SaveSession: %v", err)
+	}
+
+	loaded, err := backend.LoadSession(ctx, "client")
+	if err != nil {
+		t.Fatalf("LoadSession: %v", err)
+	}
+	if loaded.Subscriptions["a/b"] != 1 || loaded.PendingPublish[7] != "a/b" {
+		t.Fatalf("loaded session = %+v", loaded)
+	}
+	if sessions, err := backend.LoadSessions(ctx); err != nil || len(sessions) != 1 {
+		t.Fatalf("LoadSessions = %v, %v", sessions, err)
+	}
+	// 已保存的数据不受之后对会话修改的影响
+	session.Subscriptions["c/d"] = 0
+	if loaded, _ := backend.LoadSession(ctx, "client"); len(loaded.Subscriptions) != 1 {
+		t.Fatalf("stored session changed with caller: %+v", loaded.Subscriptions)
+	}
+
+	// 字段级变更只修改待确认消息，跳过不存在的会话
+	update := newSessionUpdate("client")
+	update.setPendingPublish(8, "c/d")
+	update.unsetPendingPublish(7)
+	missing := newSessionUpdate("missing")
+	missing.setPendingPublish(1, "a/b")
+	if err := backend.UpdateSessions(ctx, []*SessionUpdate{update, missing}); err != nil {
+		t.Fatalf("UpdateSessions: %v", err)
+	}
+	loaded, _ = backend.LoadSession(ctx, "client")
+	if len(loaded.PendingPublish) != 1 || loaded.PendingPublish[8] != "c/d" || loaded.Subscriptions["a/b"] != 1 {
+		t.Fatalf("session after update = %+v", loaded)
+	}
+	if _, err := backend.LoadSession(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("UpdateSessions created a missing session: %v", err)
+	}
+
+	if err := backend.DeleteSession(ctx, "client"); err != nil {
+		t.Fatalf("DeleteSession: %v", err)
+	}
+	if _, err := backend.LoadSession(ctx, "client"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("LoadSession after delete error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestBackendMessages(t *testing.T) {
+	forEachBackend(t, testBackendMessages)
+}
+
+func testBackendMessages(t *testing.T, backend Backend) {
+	ctx := context.Background()
+
+	// 遗嘱消息
+	will := NewWillMessage("client", []byte("will/topic"), []byte("bye"), 1, false)
+	if err := backend.SaveWillMessage(ctx, will); err != nil {
+		t.Fatalf("SaveWillMessage: %v", err)
+	}
+	if loaded, err := backend.LoadWillMessage(ctx, "client"); err != nil || string(loaded.Content) != "bye" {
+		t.Fatalf("LoadWillMessage = %+v, %v", loaded, err)
+	}
+	if err := backend.DeleteWillMessage(ctx, "client"); err != nil {
+		t.Fatalf("DeleteWillMessage: %v", err)
+	}
+	if _, err := backend.LoadWillMessage(ctx, "client"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("LoadWillMessage after delete error = %v", err)
+	}
+
+	// 保留消息，同一主题只保留最后一条
+	for _, payload := range []string{"old", "new"} {
+		if err := backend.SaveRetainedMessage(ctx, &RetainedMessage{Topic: "status", Payload: []byte(payload)}); err != nil {
+			t.Fatalf("SaveRetainedMessage: %v", err)
+		}
+	}
+	retained, err := backend.LoadRetainedMessages(ctx)
+	if err != nil || len(retained) != 1 || string(retained[0].Payload) != "new" {
+		t.Fatalf("LoadRetainedMessages = %v, %v", retained, err)
+	}
+	if err := backend.DeleteRetainedMessage(ctx, "status"); err != nil {
+		t.Fatalf("DeleteRetainedMessage: %v", err)
+	}
+
+	// 离线队列按入队顺序返回
+	for _, payload := range []string{"1", "2", "3"} {
+		if err := backend.EnqueueMessage(ctx, &QueuedMessage{ClientID: "client", Topic: "queue", Payload: []byte(payload)}); err != nil {
+			t.Fatalf("EnqueueMessage: %v", err)
+		}
+	}
+	queued, err := backend.LoadQueuedMessages(ctx, "client")
+	if err != nil {
+		t.Fatalf("LoadQueuedMessages: %v", err)
+	}
+	var payloads []string
+	for _, message := range queued {
+		payloads = append(payloads, string(message.Payload))
+	}
+	if !slices.Equal(payloads, []string{"1", "2", "3"}) {
+		t.Fatalf("queued payloads = %v", payloads)
+	}
+	if err := backend.ClearQueuedMessages(ctx, "client"); err != nil {
+		t.Fatalf("ClearQueuedMessages: %v", err)
+	}
+	if err := backend.ClearQueuedMessages(ctx, "missing"); err != nil {
+		t.Fatalf("ClearQueuedMessages on empty queue: %v", err)
+	}
+	if queued, _ := backend.LoadQueuedMessages(ctx, "client"); len(queued) != 0 {
+		t.Fatalf("queue not cleared: %v", queued)
+	}
+}
+
+func TestBoltBackendSubscriptionsSurviveRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "broker.db")
+	ctx := context.Background()
+
+	backend := openTestBolt(t, path)
+	ds := useStore(t, newDBStore(backend, testConfig(c.DurabilitySync)))
+	for _, subscription := range []Subscription{
+		{ClientID: "a", TopicName: "sensor/+/temp", QoSLevel: 1},
+		{ClientID: "b", TopicName: "sensor/#"},
+		{ClientID: "c", TopicName: "sensor/1/temp"},
+	} {
+		session := NewSessionData(subscription.ClientID)
+		session.AddSubscription(&subscription)
+		if !ds.SaveSession(session) {
+			t.Fatalf("SaveSession(%s) failed", subscription.ClientID)
+		}
+	}
+	session, err := ds.GetSession("c")
+	if err != nil {
+		t.Fatalf("GetSession: %v", err)
+	}
+	session.RemoveSubscription(&Subscription{TopicName: "sensor/1/temp"})
+	if !session.Save() {
+		t.Fatal("Save failed")
+	}
+	if err := backend.Close(ctx); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	// 重新打开后从数据文件恢复内存订阅树
+	reopened := newDBStore(openTestBolt(t, path), testConfig(c.DurabilitySync))
+	defer reopened.backend.Close(ctx)
+	if err := reopened.LoadSubscriptions(); err != nil {
+		t.Fatalf("LoadSubscriptions: %v", err)
+	}
+	got := matchedClients(reopened.index.Match("sensor/1/temp"))
+	if !slices.Equal(got, []string{"a", "b"}) {
+		t.Fatalf("Match after restart = %v, want [a b]", got)
+	}
+}
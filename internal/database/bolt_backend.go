@@ -0,0 +1,337 @@
+// Package database 实现了MQTT服务器的数据存储功能
+package database
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/logger"
+	"go.etcd.io/bbolt"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// bbolt中各类数据所在的桶
+var (
+	sessionBucket      = []byte(SessionCollectionName)
+	subscriptionBucket = []byte(SubscriptionCollectionName)
+	willMessageBucket  = []byte(WillMessageCollectionName)
+	retainedBucket     = []byte(RetainedCollectionName)
+	queueBucket        = []byte(QueueCollectionName) // 每个客户端一个子桶，键为递增序号
+)
+
+// boltBackend 基于bbolt数据文件的嵌入式存储后端，无需外部数据库
+// 数据使用BSON编码，与MongoDB后端的文档结构一致
+type boltBackend struct {
+	db *bbolt.DB
+}
+
+// openBoltBackend 打开或创建path处的数据文件
+func openBoltBackend(path string) (*boltBackend, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("error occured while creating storage directory: %v", err)
+	}
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("error occured while opening storage file %s: %v", path, err)
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		for _, bucket := range [][]byte{sessionBucket, subscriptionBucket, willMessageBucket, retainedBucket, queueBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("error occured while creating storage buckets: %v", err)
+	}
+	logger.DebugF("Storage file %s opened", path)
+	return &boltBackend{db: db}, nil
+}
+
+// Name 返回后端名称
+func (b *boltBackend) Name() string {
+	return "bolt"
+}
+
+// Ping 嵌入式数据文件与进程同生命周期，始终可用
+func (b *boltBackend) Ping(ctx context.Context) error {
+	return nil
+}
+
+// Atomic bbolt在一个事务中写入会话与订阅
+func (b *boltBackend) Atomic() bool {
+	return true
+}
+
+// Close 关闭数据文件
+func (b *boltBackend) Close(ctx context.Context) error {
+	logger.InfoF("Closing storage file %s", b.db.Path())
+	return b.db.Close()
+}
+
+// put 将value编码后写入桶中
+func (b *boltBackend) put(bucket []byte, key string, value any) error {
+	data, err := bson.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return b.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucket).Put([]byte(key), data)
+	})
+}
+
+// get 读取桶中的值并解码到value，不存在时返回 ErrNotFound
+func (b *boltBackend) get(bucket []byte, key string, value any) error {
+	return b.db.View(func(tx *bbolt.Tx) error {
+		data := tx.Bucket(bucket).Get([]byte(key))
+		if data == nil {
+			return ErrNotFound
+		}
+		return bson.Unmarshal(data, value)
+	})
+}
+
+// remove 删除桶中的键
+func (b *boltBackend) remove(bucket []byte, key string) error {
+	return b.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucket).Delete([]byte(key))
+	})
+}
+
+// loadAll 解码桶中的所有值
+func loadAll[T any](b *boltBackend, bucket []byte) ([]*T, error) {
+	var result []*T
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucket).ForEach(func(_, data []byte) error {
+			value := new(T)
+			if err := bson.Unmarshal(data, value); err != nil {
+				return err
+			}
+			result = append(result, value)
+			return nil
+		})
+	})
+	return result, err
+}
+
+// subscriptionKey 订阅的键，MQTT字符串中不允许出现U+0000，可作为分隔符
+func subscriptionKey(subscription Subscription) string {
+	return subscription.ClientID + "\x00" + subscription.TopicName
+}
+
+// LoadSessions 获取所有持久会话
+func (b *boltBackend) LoadSessions(ctx context.Context) ([]*SessionData, error) {
+	return loadAll[SessionData](b, sessionBucket)
+}
+
+// LoadSession 获取客户端会话数据
+func (b *boltBackend) LoadSession(ctx context.Context, clientID string) (*SessionData, error) {
+	session := &SessionData{}
+	if err := b.get(sessionBucket, clientID, session); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// SaveSession 保存客户端会话数据
+func (b *boltBackend) SaveSession(ctx context.Context, session *SessionData) error {
+	return b.put(sessionBucket, session.ClientID, session)
+}
+
+// DeleteSession 删除客户端会话数据
+func (b *boltBackend) DeleteSession(ctx context.Context, clientID string) error {
+	return b.remove(sessionBucket, clientID)
+}
+
+// UpdateSessions 在一个事务中读取、修改并写回会话
+func (b *boltBackend) UpdateSessions(ctx context.Context, updates []*SessionUpdate) error {
+	return b.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(sessionBucket)
+		for _, update := range updates {
+			data := bucket.Get([]byte(update.ClientID))
+			if data == nil {
+				continue
+			}
+			session := &SessionData{}
+			if err := bson.Unmarshal(data, session); err != nil {
+				return err
+			}
+			update.apply(session)
+			data, err := bson.Marshal(session)
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put([]byte(update.ClientID), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// CommitSession 在一个事务中写入会话与订阅变更
+func (b *boltBackend) CommitSession(ctx context.Context, commit *SessionCommit) error {
+	var session []byte
+	if commit.Session != nil && !commit.Delete {
+		data, err := bson.Marshal(commit.Session)
+		if err != nil {
+			return err
+		}
+		session = data
+	}
+	return b.db.Update(func(tx *bbolt.Tx) error {
+		sessions := tx.Bucket(sessionBucket)
+		if commit.Delete {
+			if err := sessions.Delete([]byte(commit.ClientID)); err != nil {
+				return err
+			}
+		} else if session != nil {
+			if err := sessions.Put([]byte(commit.ClientID), session); err != nil {
+				return err
+			}
+		}
+		subscriptions := tx.Bucket(subscriptionBucket)
+		for _, change := range commit.Changes {
+			key := []byte(subscriptionKey(change.Subscription))
+			if change.Remove {
+				if err := subscriptions.Delete(key); err != nil {
+					return err
+				}
+				continue
+			}
+			data, err := bson.Marshal(change.Subscription)
+			if err != nil {
+				return err
+			}
+			if err := subscriptions.Put(key, data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// LoadSubscriptions 获取所有订阅
+func (b *boltBackend) LoadSubscriptions(ctx context.Context) ([]Subscription, error) {
+	subscriptions, err := loadAll[Subscription](b, subscriptionBucket)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]Subscription, len(subscriptions))
+	for i, subscription := range subscriptions {
+		result[i] = *subscription
+	}
+	return result, nil
+}
+
+// SaveSubscription 保存或更新订阅
+func (b *boltBackend) SaveSubscription(ctx context.Context, subscription Subscription) error {
+	return b.put(subscriptionBucket, subscriptionKey(subscription), subscription)
+}
+
+// DeleteSubscription 删除订阅
+func (b *boltBackend) DeleteSubscription(ctx context.Context, subscription Subscription) error {
+	return b.remove(subscriptionBucket, subscriptionKey(subscription))
+}
+
+// LoadWillMessage 获取遗嘱消息
+func (b *boltBackend) LoadWillMessage(ctx context.Context, clientID string) (*WillMessage, error) {
+	message := &WillMessage{}
+	if err := b.get(willMessageBucket, clientID, message); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+// SaveWillMessage 保存遗嘱消息
+func (b *boltBackend) SaveWillMessage(ctx context.Context, willMessage *WillMessage) error {
+	return b.put(willMessageBucket, willMessage.ClientID, willMessage)
+}
+
+// DeleteWillMessage 删除遗嘱消息
+func (b *boltBackend) DeleteWillMessage(ctx context.Context, clientID string) error {
+	return b.remove(willMessageBucket, clientID)
+}
+
+// LoadRetainedMessages 获取所有保留消息
+func (b *boltBackend) LoadRetainedMessages(ctx context.Context) ([]*RetainedMessage, error) {
+	return loadAll[RetainedMessage](b, retainedBucket)
+}
+
+// SaveRetainedMessage 保存主题的保留消息，替换该主题上原有的保留消息
+func (b *boltBackend) SaveRetainedMessage(ctx context.Context, message *RetainedMessage) error {
+	return b.put(retainedBucket, message.Topic, message)
+}
+
+// DeleteRetainedMessage 删除主题的保留消息
+func (b *boltBackend) DeleteRetainedMessage(ctx context.Context, topic string) error {
+	return b.remove(retainedBucket, topic)
+}
+
+// CountRetainedMessages 返回保留消息数
+func (b *boltBackend) CountRetainedMessages(ctx context.Context) (int64, error) {
+	var count int64
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		count = int64(tx.Bucket(retainedBucket).Stats().KeyN)
+		return nil
+	})
+	return count, err
+}
+
+// EnqueueMessage 将消息追加到客户端的离线队列
+func (b *boltBackend) EnqueueMessage(ctx context.Context, message *QueuedMessage) error {
+	data, err := bson.Marshal(message)
+	if err != nil {
+		return err
+	}
+	return b.db.Update(func(tx *bbolt.Tx) error {
+		queue, err := tx.Bucket(queueBucket).CreateBucketIfNotExists([]byte(message.ClientID))
+		if err != nil {
+			return err
+		}
+		seq, err := queue.NextSequence()
+		if err != nil {
+			return err
+		}
+		// 大端序编码保证按键遍历即为入队顺序
+		return queue.Put(binary.BigEndian.AppendUint64(nil, seq), data)
+	})
+}
+
+// LoadQueuedMessages 按入队顺序获取客户端离线队列中的消息
+func (b *boltBackend) LoadQueuedMessages(ctx context.Context, clientID string) ([]*QueuedMessage, error) {
+	var messages []*QueuedMessage
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		queue := tx.Bucket(queueBucket).Bucket([]byte(clientID))
+		if queue == nil {
+			return nil
+		}
+		return queue.ForEach(func(_, data []byte) error {
+			message := &QueuedMessage{}
+			if err := bson.Unmarshal(data, message); err != nil {
+				return err
+			}
+			messages = append(messages, message)
+			return nil
+		})
+	})
+	return messages, err
+}
+
+// ClearQueuedMessages 清空客户端的离线队列
+func (b *boltBackend) ClearQueuedMessages(ctx context.Context, clientID string) error {
+	return b.db.Update(func(tx *bbolt.Tx) error {
+		err := tx.Bucket(queueBucket).DeleteBucket([]byte(clientID))
+		if err == nil || errors.Is(err, bbolt.ErrBucketNotFound) {
+			return nil
+		}
+		return err
+	})
+}
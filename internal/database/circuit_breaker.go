@@ -0,0 +1,130 @@
+// Package database 实现了MQTT服务器的数据存储功能
+package database
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/logger"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// BreakerState 断路器状态
+type BreakerState int
+
+const (
+	BreakerClosed   BreakerState = iota // 存储正常，操作直接发往存储后端
+	BreakerOpen                         // 存储不可用，写操作进入缓冲区，读操作只使用内存数据
+	BreakerHalfOpen                     // 冷却结束，允许一次探测操作
+)
+
+// String 返回断路器状态名称
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// circuitBreaker 存储后端断路器
+// 连续失败达到阈值后打开，冷却时间过后放行一次探测，探测成功则关闭
+type circuitBreaker struct {
+	mu        sync.Mutex
+	state     BreakerState
+	failures  int           // 连续失败次数
+	threshold int           // 打开断路器的连续失败次数
+	cooldown  time.Duration // 打开后到下一次探测的时间
+	changedAt time.Time     // 最近一次状态变化时间
+	lastError error         // 最近一次导致失败的错误
+}
+
+// newCircuitBreaker 创建处于关闭状态的断路器
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	if threshold <= 0 {
+		threshold = 1
+	}
+	return &circuitBreaker{threshold: threshold, cooldown: cooldown, changedAt: time.Now()}
+}
+
+// allow 返回当前是否可以访问存储后端
+// 打开状态下冷却时间结束后转为半开并放行一次探测，探测结束前其余操作不放行
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BreakerClosed:
+		return true
+	case BreakerOpen:
+		if time.Since(b.changedAt) < b.cooldown {
+			return false
+		}
+		b.setState(BreakerHalfOpen)
+		return true
+	default:
+		return false
+	}
+}
+
+// success 记录一次成功的存储操作并关闭断路器
+func (b *circuitBreaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	if b.state != BreakerClosed {
+		b.setState(BreakerClosed)
+		logger.InfoF("Storage backend recovered, circuit breaker closed")
+	}
+}
+
+// failure 记录一次因存储不可用导致的失败，达到阈值或探测失败时打开断路器
+func (b *circuitBreaker) failure(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	b.lastError = err
+	if b.state == BreakerHalfOpen || (b.state == BreakerClosed && b.failures >= b.threshold) {
+		if b.state == BreakerClosed {
+			logger.ErrorF("Storage backend unavailable after %d consecutive failures, entering degraded mode: %v", b.failures, err)
+		}
+		b.setState(BreakerOpen)
+	}
+}
+
+// setState 切换状态，调用方需持有锁
+func (b *circuitBreaker) setState(state BreakerState) {
+	b.state = state
+	b.changedAt = time.Now()
+}
+
+// status 返回当前状态、状态持续起点与最近一次错误
+func (b *circuitBreaker) status() (BreakerState, time.Time, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state, b.changedAt, b.lastError
+}
+
+// isOutage 判断错误是否表示存储不可用
+// 记录不存在、唯一键冲突等由存储正常返回的错误不计入断路器
+func isOutage(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, ErrNotFound) || errors.Is(err, mongo.ErrNoDocuments) || errors.Is(err, ClientIdEmptyError) {
+		return false
+	}
+	if mongo.IsDuplicateKeyError(err) {
+		return false
+	}
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,146 @@
+// Package database 实现了MQTT服务器的数据存储功能
+package database
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/logger"
+)
+
+// DriftKind 会话与订阅树不一致的类型
+type DriftKind string
+
+const (
+	DriftOrphan  DriftKind = "orphan"  // 订阅树中有但会话中没有的订阅，会把消息路由给未完成订阅的客户端
+	DriftMissing DriftKind = "missing" // 会话中有但订阅树中没有的订阅，客户端收不到消息
+	DriftQoS     DriftKind = "qos"     // 会话与订阅树中的QoS不同
+)
+
+// Drift 一处会话与订阅树的不一致
+type Drift struct {
+	Kind       DriftKind
+	ClientID   string
+	TopicName  string
+	SessionQoS byte // 会话中的QoS，orphan时无意义
+	TreeQoS    byte // 订阅树中的QoS，missing时无意义
+}
+
+// ConsistencyReport 一次一致性校验的结果
+type ConsistencyReport struct {
+	Sessions      int     // 校验的持久会话数
+	Subscriptions int     // 订阅树中的订阅数
+	Drifts        []Drift // 发现的不一致，按客户端ID与主题排序
+	Repaired      int     // 已修复的不一致数
+}
+
+// CheckConsistency 以持久会话为准校验订阅树，repair为true时修复不一致
+// 会话只有在SUBSCRIBE完成后才会保存，因此订阅树中多余的订阅会被删除，缺少的订阅会按会话补齐
+func CheckConsistency(ctx context.Context, backend Backend, repair bool) (*ConsistencyReport, error) {
+	sessions, err := backend.LoadSessions(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error occured while loading sessions: %v", err)
+	}
+	subscriptions, err := backend.LoadSubscriptions(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error occured while loading subscriptions: %v", err)
+	}
+
+	expected := make(map[string]Subscription)
+	for _, session := range sessions {
+		for _, subscription := range session.subscriptionList() {
+			expected[subscriptionKey(subscription)] = subscription
+		}
+	}
+	report := &ConsistencyReport{Sessions: len(sessions), Subscriptions: len(subscriptions)}
+	for _, subscription := range subscriptions {
+		key := subscriptionKey(subscription)
+		want, ok := expected[key]
+		delete(expected, key)
+		switch {
+		case !ok:
+			report.Drifts = append(report.Drifts, Drift{Kind: DriftOrphan, ClientID: subscription.ClientID,
+				TopicName: subscription.TopicName, TreeQoS: subscription.QoSLevel})
+		case want.QoSLevel != subscription.QoSLevel:
+			report.Drifts = append(report.Drifts, Drift{Kind: DriftQoS, ClientID: subscription.ClientID,
+				TopicName: subscription.TopicName, SessionQoS: want.QoSLevel, TreeQoS: subscription.QoSLevel})
+		}
+	}
+	for _, subscription := range expected {
+		report.Drifts = append(report.Drifts, Drift{Kind: DriftMissing, ClientID: subscription.ClientID,
+			TopicName: subscription.TopicName, SessionQoS: subscription.QoSLevel})
+	}
+	slices.SortFunc(report.Drifts, func(a, b Drift) int {
+		return cmp.Or(cmp.Compare(a.ClientID, b.ClientID), cmp.Compare(a.TopicName, b.TopicName))
+	})
+
+	if !repair {
+		return report, nil
+	}
+	for _, drift := range report.Drifts {
+		subscription := Subscription{ClientID: drift.ClientID, TopicName: drift.TopicName, QoSLevel: drift.SessionQoS}
+		change := SubscriptionChange{Subscription: subscription, Remove: drift.Kind == DriftOrphan}
+		if err := backend.CommitSession(ctx, &SessionCommit{ClientID: drift.ClientID, Changes: []SubscriptionChange{change}}); err != nil {
+			return report, fmt.Errorf("error occured while repairing %s subscription %s of client %s: %v", drift.Kind, drift.TopicName, drift.ClientID, err)
+		}
+		report.Repaired++
+	}
+	return report, nil
+}
+
+// reconcile 校验并修复存储后端中的会话与订阅树，同时修正内存订阅树
+// 校验期间暂停会话提交，否则在读取会话与读取订阅树之间完成的SUBSCRIBE会被当作多余的订阅删除
+func (ds *DBStore) reconcile(ctx context.Context) {
+	ds.commitMu.Lock()
+	defer ds.commitMu.Unlock()
+	// 缓冲区中的写操作尚未落盘，等重放完成后再校验
+	if ds.buffer.Len() > 0 {
+		ds.drifted.Store(true)
+		return
+	}
+
+	startTime := time.Now()
+	report, err := CheckConsistency(ctx, ds.backend, true)
+	if err != nil {
+		logger.ErrorF("Consistency check failed: %v", err)
+		ds.drifted.Store(true)
+		return
+	}
+	for _, drift := range report.Drifts {
+		subscription := Subscription{ClientID: drift.ClientID, TopicName: drift.TopicName, QoSLevel: drift.SessionQoS}
+		if drift.Kind == DriftOrphan {
+			ds.index.Remove(subscription)
+		} else if _, err := ds.index.Insert(subscription); err != nil {
+			logger.ErrorF("Unable to route repaired subscription %s of client %s: %v", drift.TopicName, drift.ClientID, err)
+		}
+		logger.WarnF("Repaired %s subscription %s of client %s", drift.Kind, drift.TopicName, drift.ClientID)
+	}
+	logger.InfoF("Consistency check finished: sessions=%d, subscriptions=%d, repaired=%d, cost=%v",
+		report.Sessions, report.Subscriptions, report.Repaired, time.Since(startTime))
+}
+
+// runReconciliation 周期性校验会话与订阅树的一致性，直到stop关闭
+// 存储后端能原子提交时只在降级重放等可能产生偏差的操作之后校验；降级期间不校验，避免把尚未落盘的会话当作不存在
+func (ds *DBStore) runReconciliation(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if ds.Health().Degraded {
+				continue
+			}
+			if ds.backend.Atomic() && !ds.drifted.Swap(false) {
+				continue
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 10*OperationTimeout)
+			ds.reconcile(ctx)
+			cancel()
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	c "github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/config"
+)
+
+func TestBackendCommitSession(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, backend Backend) {
+		ctx := context.Background()
+		session := NewSessionData("client")
+		session.Subscriptions["a/b"] = 1
+		subscription := Subscription{ClientID: "client", TopicName: "a/b", QoSLevel: 1}
+		err := backend.CommitSession(ctx, &SessionCommit{
+			ClientID: "client",
+			Session:  session,
+			Changes:  []SubscriptionChange{{Subscription: subscription}},
+		})
+		if err != nil {
+			t.Fatalf("CommitSession: %v", err)
+		}
+		if report, err := CheckConsistency(ctx, backend, false); err != nil || len(report.Drifts) != 0 || report.Subscriptions != 1 {
+			t.Fatalf("CheckConsistency after commit = %+v, %v", report, err)
+		}
+
+		err = backend.CommitSession(ctx, &SessionCommit{
+			ClientID: "client",
+			Delete:   true,
+			Changes:  []SubscriptionChange{{Subscription: subscription, Remove: true}},
+		})
+		if err != nil {
+			t.Fatalf("CommitSession delete: %v", err)
+		}
+		sessions, _ := backend.LoadSessions(ctx)
+		subscriptions, _ := backend.LoadSubscriptions(ctx)
+		if len(sessions) != 0 || len(subscriptions) != 0 {
+			t.Fatalf("after delete sessions = %v, subscriptions = %v", sessions, subscriptions)
+		}
+	})
+}
+
+func TestCheckConsistency(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, backend Backend) {
+		ctx := context.Background()
+		session := NewSessionData("a")
+		session.Subscriptions["x"] = 1
+		session.Subscriptions["y"] = 0
+		if err := backend.SaveSession(ctx, session); err != nil {
+			t.Fatal(err)
+		}
+		for _, subscription := range []Subscription{
+			{ClientID: "a", TopicName: "x", QoSLevel: 0},
+			{ClientID: "b", TopicName: "z", QoSLevel: 2},
+		} {
+			if err := backend.SaveSubscription(ctx, subscription); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		want := []Drift{
+			{Kind: DriftQoS, ClientID: "a", TopicName: "x", SessionQoS: 1, TreeQoS: 0},
+			{Kind: DriftMissing, ClientID: "a", TopicName: "y", SessionQoS: 0},
+			{Kind: DriftOrphan, ClientID: "b", TopicName: "z", TreeQoS: 2},
+		}
+		report, err := CheckConsistency(ctx, backend, false)
+		if err != nil {
+			t.Fatalf("CheckConsistency: %v", err)
+		}
+		if len(report.Drifts) != len(want) || report.Repaired != 0 {
+			t.Fatalf("report = %+v, want drifts %+v", report, want)
+		}
+		for i := range want {
+			if report.Drifts[i] != want[i] {
+				t.Fatalf("drift %d = %+v, want %+v", i, report.Drifts[i], want[i])
+			}
+		}
+
+		if report, err = CheckConsistency(ctx, backend, true); err != nil || report.Repaired != len(want) {
+			t.Fatalf("repair = %+v, %v", report, err)
+		}
+		if report, err = CheckConsistency(ctx, backend, false); err != nil || len(report.Drifts) != 0 {
+			t.Fatalf("drifts after repair = %+v, %v", report, err)
+		}
+	})
+}
+
+func TestDeleteSessionRemovesSubscriptions(t *testing.T) {
+	ds := useMemoryStore(t)
+	session := NewSessionData("client")
+	session.AddSubscription(&Subscription{TopicName: "a/+", QoSLevel: 1})
+	if !ds.SaveSession(session) {
+		t.Fatal("SaveSession failed")
+	}
+	ds.sessionCache.Purge()
+
+	// 以清除会话的方式重新连接时删除旧会话，订阅不能继续路由
+	if !ds.DeleteSession("client") {
+		t.Fatal("DeleteSession failed")
+	}
+	if matched := ds.index.Match("a/b"); len(matched) != 0 {
+		t.Fatalf("deleted session still matches: %v", matched)
+	}
+	if subscriptions, _ := ds.backend.LoadSubscriptions(context.Background()); len(subscriptions) != 0 {
+		t.Fatalf("deleted session still has persisted subscriptions: %v", subscriptions)
+	}
+}
+
+func TestReconcileRemovesOrphanRoutes(t *testing.T) {
+	ds := useMemoryStore(t)
+	orphan := Subscription{ClientID: "gone", TopicName: "a/b"}
+	if err := ds.backend.SaveSubscription(context.Background(), orphan); err != nil {
+		t.Fatal(err)
+	}
+	if err := ds.LoadSubscriptions(); err != nil {
+		t.Fatal(err)
+	}
+
+	ds.reconcile(context.Background())
+	if matched := ds.index.Match("a/b"); len(matched) != 0 {
+		t.Fatalf("orphan still routed: %v", matched)
+	}
+	if report, _ := CheckConsistency(context.Background(), ds.backend, false); len(report.Drifts) != 0 {
+		t.Fatalf("drifts after reconcile: %+v", report.Drifts)
+	}
+}
+
+func TestReconcileRepairsRoutes(t *testing.T) {
+	ds := useMemoryStore(t)
+	ctx := context.Background()
+	session := NewSessionData("client")
+	session.Subscriptions["a/b"] = 1
+	session.Subscriptions["c/d"] = 1
+	if err := ds.backend.SaveSession(ctx, session); err != nil {
+		t.Fatal(err)
+	}
+	if err := ds.backend.SaveSubscription(ctx, Subscription{ClientID: "client", TopicName: "a/b"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ds.LoadSubscriptions(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 缺少的订阅与QoS不同的订阅按会话修正内存订阅树
+	ds.reconcile(ctx)
+	for _, topic := range []string{"a/b", "c/d"} {
+		matched := ds.index.Match(topic)
+		if len(matched) != 1 || matched[0].QoSLevel != 1 {
+			t.Fatalf("Match(%s) after reconcile = %v, want QoS 1 route", topic, matched)
+		}
+	}
+}
+
+// slowSubscriptionsBackend 读取订阅树前等待，放大校验读取会话与读取订阅树之间的时间窗口
+type slowSubscriptionsBackend struct {
+	*MemoryBackend
+}
+
+func (b *slowSubscriptionsBackend) LoadSubscriptions(ctx context.Context) ([]Subscription, error) {
+	time.Sleep(time.Millisecond)
+	return b.MemoryBackend.LoadSubscriptions(ctx)
+}
+
+func TestReconcileConcurrentWithCommits(t *testing.T) {
+	ds := useStore(t, newDBStore(&slowSubscriptionsBackend{NewMemoryBackend()}, testConfig(c.DurabilitySync)))
+
+	// 校验多次的同时不断有客户端完成订阅
+	reconciled := make(chan struct{})
+	go func() {
+		defer close(reconciled)
+		for i := 0; i < 5; i++ {
+			ds.reconcile(context.Background())
+		}
+	}()
+	clients := 0
+	for running := true; running; clients++ {
+		select {
+		case <-reconciled:
+			running = false
+		default:
+		}
+		session := NewSessionData(fmt.Sprintf("client-%d", clients))
+		session.AddSubscription(&Subscription{TopicName: fmt.Sprintf("device/%d", clients)})
+		if !session.Save() {
+			t.Fatalf("Save(client-%d) failed", clients)
+		}
+	}
+
+	// 校验期间完成的订阅既不能从存储后端删除，也不能从内存订阅树删除
+	for i := 0; i < clients; i++ {
+		if matched := ds.index.Match(fmt.Sprintf("device/%d", i)); len(matched) != 1 {
+			t.Fatalf("client-%d lost its route: %v", i, matched)
+		}
+	}
+	report, err := CheckConsistency(context.Background(), ds.backend, false)
+	if err != nil || len(report.Drifts) != 0 || report.Subscriptions != clients {
+		t.Fatalf("CheckConsistency = %+v, %v", report, err)
+	}
+}
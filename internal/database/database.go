@@ -3,17 +3,16 @@ package database
 
 import (
 	"context"
-	"crypto/tls"
 	"fmt"
 	c "github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/config"
 	event2 "github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/event"
 	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/logger"
+	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/metrics"
 	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/utils"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/event"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
-	"net/url"
 	"slices"
 	"time"
 )
@@ -24,24 +23,86 @@ var (
 	Database         *mongo.Database
 	Sessions         *mongo.Collection
 	Subscriptions    *mongo.Collection
-	OperationTimeout time.Duration
+	OperationTimeout = 5 * time.Second
 )
 
-// DBCloseCallback 实现了数据库关闭回调接口
-type DBCloseCallback struct {
+// Open 按配置打开存储后端，初始化全局存储实例并加载订阅
+func Open() error {
+	config, err := c.GetConfig()
+	if err != nil {
+		return fmt.Errorf("error occured while opening storage: %v", err)
+	}
+
+	backend, err := OpenBackend(config)
+	if err != nil {
+		return err
+	}
+	if err := UseBackend(backend, config); err != nil {
+		return err
+	}
+
+	// 注册关闭回调，先等待异步写入完成再关闭存储后端
+	timeout := utils.ParseStringTime(config.Shutdown.DatabaseTimeout)
+	ds := store.Load()
+	cleaner := event2.NewCleaner()
+	cleaner.AddPhase(event2.PhaseFlushStorage, timeout, ds.writer)
+	cleaner.AddPhase(event2.PhaseFlushStorage, timeout, &WriteBufferFlushCallback{store: ds})
+	cleaner.AddPhase(event2.PhaseCloseDatabase, timeout, &BackendCloseCallback{backend: backend})
+	return nil
 }
 
-// NewDBCloseCallback 创建新的数据库关闭回调实例
-func NewDBCloseCallback() *DBCloseCallback {
-	return &DBCloseCallback{}
+// OpenBackend 按配置打开存储后端，不初始化全局存储实例
+func OpenBackend(config c.Config) (Backend, error) {
+	OperationTimeout = utils.ParseStringTime(config.Database.OperationTimeout)
+
+	var backend Backend
+	var err error
+	switch config.Storage.Backend {
+	case c.StorageBolt:
+		backend, err = openBoltBackend(config.Storage.Path)
+	case c.StorageMemory:
+		logger.Warn("Using memory storage backend, all data will be lost on exit")
+		backend = NewMemoryBackend()
+	default:
+		if err = ConnectDatabase(); err == nil {
+			err = prepareSchema(config.Database.AutoMigrate)
+		}
+		if err == nil {
+			backend, err = newMongoBackend(config)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	logger.InfoF("Using %s storage backend", backend.Name())
+	return backend, nil
 }
 
-// Invoke 执行数据库关闭操作
-func (dc *DBCloseCallback) Invoke(ctx context.Context) error {
-	logger.InfoF("Closing database connection")
-	ctx, cancel := context.WithTimeout(context.Background(), OperationTimeout)
-	defer cancel()
-	return Client.Disconnect(ctx)
+// UseBackend 使用指定的存储后端与配置初始化全局存储实例，加载订阅到内存订阅树后启动后台协程
+// 已有的全局存储实例会被停止，但不关闭其存储后端；测试中可以直接注入 NewMemoryBackend 创建的内存后端
+func UseBackend(backend Backend, config c.Config) error {
+	ds := newDBStore(backend, config)
+
+	// 不能原子提交会话与订阅的存储后端可能因崩溃留下不一致，加载订阅前先修复
+	if !backend.Atomic() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*OperationTimeout)
+		ds.reconcile(ctx)
+		cancel()
+	}
+	if err := ds.LoadSubscriptions(); err != nil {
+		return err
+	}
+	ds.start()
+
+	previous := store.Swap(ds)
+	if previous != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*OperationTimeout)
+		defer cancel()
+		if err := previous.Close(ctx); err != nil {
+			logger.WarnF("Error occured while stopping previous storage: %v", err)
+		}
+	}
+	return nil
 }
 
 // ConnectDatabase 连接到MongoDB数据库
@@ -52,38 +113,34 @@ func ConnectDatabase() error {
 		return fmt.Errorf("error occured while connecting to database: %v", err)
 	}
 
-	OperationTimeout = utils.ParseStringTime(config.Database.OperationTimeout)
-
-	// 编码特殊字符
-	encodedUser := url.QueryEscape(config.Database.Username)
-	encodedPass := url.QueryEscape(config.Database.Password)
-	databaseUrl := fmt.Sprintf("mongodb://%s:%s@%s:%d/?authSource=admin",
-		encodedUser, encodedPass,
-		config.Database.Host,
-		config.Database.Port,
-	)
-
 	// 配置MongoDB客户端选项
-	clientOptions := options.Client().ApplyURI(databaseUrl).SetAppName(config.AppName)
-	// 连接池配置
-	clientOptions.SetMinPoolSize(config.Database.MinPoolSize) // 最小连接数
-	clientOptions.SetMaxPoolSize(config.Database.MaxPoolSize) // 最大连接数
-	clientOptions.SetMaxConnIdleTime(utils.ParseStringTime(config.Database.ConnectIdleTimeout))
-	// 超时限制
-	clientOptions.SetConnectTimeout(utils.ParseStringTime(config.Database.ConnectTimeout))
-	clientOptions.SetSocketTimeout(utils.ParseStringTime(config.Database.SocketTimeout))
-	// 心跳包
-	clientOptions.SetHeartbeatInterval(utils.ParseStringTime(config.Database.Heartbeat))
-	// TLS配置
-	if config.Database.UseTLS {
-		tlsConfig := &tls.Config{
-			InsecureSkipVerify: false,
-		}
-		clientOptions.SetTLSConfig(tlsConfig)
+	clientOptions := options.Client().SetAppName(config.AppName)
+	if err := applyConnectionOptions(clientOptions, config); err != nil {
+		return fmt.Errorf("invalid database configuration: %v", err)
+	}
+	// 连接池、超时与心跳配置，未配置的项使用连接字符串中的设置或驱动默认值
+	if config.Database.MinPoolSize > 0 {
+		clientOptions.SetMinPoolSize(config.Database.MinPoolSize) // 最小连接数
+	}
+	if config.Database.MaxPoolSize > 0 {
+		clientOptions.SetMaxPoolSize(config.Database.MaxPoolSize) // 最大连接数
+	}
+	if d := utils.ParseStringTime(config.Database.ConnectIdleTimeout); d > 0 {
+		clientOptions.SetMaxConnIdleTime(d)
+	}
+	if d := utils.ParseStringTime(config.Database.ConnectTimeout); d > 0 {
+		clientOptions.SetConnectTimeout(d)
+	}
+	if d := utils.ParseStringTime(config.Database.SocketTimeout); d > 0 {
+		clientOptions.SetSocketTimeout(d)
+	}
+	if d := utils.ParseStringTime(config.Database.Heartbeat); d > 0 {
+		clientOptions.SetHeartbeatInterval(d)
 	}
 	// 连接池监控
 	clientOptions.SetPoolMonitor(&event.PoolMonitor{
 		Event: func(evt *event.PoolEvent) {
+			metrics.MongoPoolEvents.WithLabelValues(evt.Type).Inc()
 			switch evt.Type {
 			case event.ConnectionCreated:
 				logger.DebugF("Database connection created: %+v", evt)
@@ -92,6 +149,15 @@ func ConnectDatabase() error {
 			}
 		},
 	})
+	// 命令耗时监控
+	clientOptions.SetMonitor(&event.CommandMonitor{
+		Succeeded: func(_ context.Context, evt *event.CommandSucceededEvent) {
+			metrics.MongoCommandDuration.WithLabelValues(evt.CommandName, "ok").Observe(evt.Duration.Seconds())
+		},
+		Failed: func(_ context.Context, evt *event.CommandFailedEvent) {
+			metrics.MongoCommandDuration.WithLabelValues(evt.CommandName, "error").Observe(evt.Duration.Seconds())
+		},
+	})
 
 	// 创建客户端
 	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
@@ -129,45 +195,26 @@ func ConnectDatabase() error {
 	Sessions = Database.Collection(SessionCollectionName)
 	Subscriptions = Database.Collection(SubscriptionCollectionName)
 
-	// 删除现有索引
-	_, err = Sessions.Indexes().DropAll(context.Background())
-	if err != nil {
-		return fmt.Errorf("error occured while dropping database indexes: %v", err)
-	}
+	return nil
+}
 
-	_, err = Subscriptions.Indexes().DropAll(context.Background())
-	if err != nil {
-		return fmt.Errorf("error occured while dropping database indexes: %v", err)
+// prepareSchema 确保数据库结构为最新版本
+// autoMigrate为false时只检查版本，版本不一致时需要先使用 migrate 命令执行迁移
+func prepareSchema(autoMigrate bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+	if autoMigrate {
+		if _, err := Migrate(ctx, Database); err != nil {
+			return fmt.Errorf("error occured while migrating database: %v", err)
+		}
+		return nil
 	}
-
-	// 创建会话集合索引
-	_, err = Sessions.Indexes().CreateOne(
-		context.Background(),
-		mongo.IndexModel{
-			Keys:    bson.D{{Key: "client_id", Value: 1}},
-			Options: options.Index().SetUnique(true).SetName("sessions_client_id_unique"),
-		},
-	)
-
-	// 创建订阅集合索引
-	_, err = Subscriptions.Indexes().CreateMany(
-		context.Background(), []mongo.IndexModel{
-			{
-				Keys:    bson.D{{Key: "path", Value: 1}},
-				Options: options.Index().SetUnique(true).SetName("subscriptions_path_unique"),
-			},
-			{
-				Keys:    bson.D{{Key: "_id", Value: 1}},
-				Options: options.Index().SetName("subscriptions_id_unique"),
-			},
-		},
-	)
-
+	version, _, err := SchemaStatus(ctx, Database)
 	if err != nil {
-		return fmt.Errorf("error occured while creating database indexes: %v", err)
+		return err
+	}
+	if version != LatestSchemaVersion() {
+		return fmt.Errorf("database schema version %d does not match required version %d, run the migrate command first", version, LatestSchemaVersion())
 	}
-
-	// 注册数据库关闭回调
-	event2.NewCleaner().Add(NewDBCloseCallback())
 	return nil
 }
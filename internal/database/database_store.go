@@ -5,41 +5,118 @@ import (
 	"context"
 	"errors"
 	"github.com/hashicorp/golang-lru/v2/expirable"
+	c "github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/config"
 	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/logger"
-	"go.mongodb.org/mongo-driver/bson"
+	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/utils"
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
-// DBStore 实现了数据库存储接口
+// DBStore 实现了数据库存储接口，可被多个连接协程并发使用
+// 临时会话与订阅路由只保存在内存中，其余数据通过存储后端持久化
 type DBStore struct {
-	client       *mongo.Client                          // MongoDB客户端
-	db           *mongo.Database                        // 数据库实例
-	sessions     map[string]*SessionData                // 内存中的会话数据
-	willMessages map[string]*WillMessage                // 遗嘱消息
-	sessionCache *expirable.LRU[string, *SessionData]   // 会话缓存
-	topicCache   *expirable.LRU[string, *TopicTreeNode] // 主题树缓存
+	backend           Backend                              // 存储后端
+	mu                sync.RWMutex                         // 保护sessions与willMessages
+	sessions          map[string]*SessionData              // 内存中的会话数据
+	willMessages      map[string]*WillMessage              // 遗嘱消息
+	sessionCache      *expirable.LRU[string, *SessionData] // 会话缓存
+	index             *SubscriptionIndex                   // 内存订阅索引，用于路由
+	writer            *sessionWriter                       // 会话字段级变更写入器
+	breaker           *circuitBreaker                      // 存储后端断路器
+	buffer            *writeBuffer                         // 存储不可用期间的写缓冲区
+	commitMu          sync.RWMutex                         // 会话提交持有读锁，一致性校验持有写锁，校验期间的提交不会被误判为不一致
+	reconcileInterval time.Duration                        // 一致性校验的间隔，0表示不校验
+	compactInterval   time.Duration                        // 主题树压缩的间隔，0表示不压缩
+	stop              chan struct{}                        // 关闭后停止恢复检查、一致性校验与主题树压缩
+	stopOnce          sync.Once
+	wg                sync.WaitGroup // 等待后台协程退出
+	drifted           atomic.Bool    // 会话与订阅树可能不一致，需要在下一次校验时修复
 }
 
 var (
-	store              *DBStore
+	store              atomic.Pointer[DBStore] // 全局存储实例，完全初始化后才会发布
 	ClientIdEmptyError = errors.New("client_id is empty")
 )
 
-// NewDatabaseStore 创建新的数据库存储实例
+// NewDatabaseStore 返回全局存储实例，需先调用 Open 打开存储后端
 func NewDatabaseStore() *DBStore {
-	if store == nil {
-		store = &DBStore{
-			client:       Client,
-			db:           Database,
-			sessions:     make(map[string]*SessionData),
-			willMessages: make(map[string]*WillMessage),
-			sessionCache: expirable.NewLRU[string, *SessionData](256, nil, time.Hour),
-			topicCache:   expirable.NewLRU[string, *TopicTreeNode](256, nil, time.Hour),
-		}
+	return store.Load()
+}
+
+// newDBStore 按配置创建使用指定存储后端的存储实例，调用start后才会启动后台协程
+func newDBStore(backend Backend, config c.Config) *DBStore {
+	ds := &DBStore{
+		backend:           backend,
+		sessions:          make(map[string]*SessionData),
+		willMessages:      make(map[string]*WillMessage),
+		sessionCache:      expirable.NewLRU[string, *SessionData](256, nil, time.Hour),
+		index:             NewSubscriptionIndex(config.Routing.IndexShards, config.Routing.MatchCacheSize),
+		breaker:           newCircuitBreaker(config.Storage.BreakerThreshold, utils.ParseStringTime(config.Storage.BreakerCooldown)),
+		buffer:            newWriteBuffer(config.Storage.WriteBufferSize),
+		reconcileInterval: utils.ParseStringTime(config.Storage.ReconcileInterval),
+		compactInterval:   utils.ParseStringTime(config.Storage.CompactInterval),
+		stop:              make(chan struct{}),
+	}
+	ds.writer = newSessionWriter(ds, config.Storage.SessionDurability,
+		utils.ParseStringTime(config.Storage.SessionFlushInterval), config.Storage.SessionBatchSize)
+	return ds
+}
+
+// start 启动会话写入器、降级恢复检查以及周期性的一致性校验与主题树压缩，只能调用一次
+func (ds *DBStore) start() {
+	ds.writer.start()
+	ds.background(func() { ds.runRecovery(ds.stop) })
+	if ds.reconcileInterval > 0 {
+		ds.background(func() { ds.runReconciliation(ds.reconcileInterval, ds.stop) })
+	}
+	if ds.compactInterval > 0 {
+		ds.background(func() { ds.runCompaction(ds.compactInterval, ds.stop) })
+	}
+}
+
+// background 启动一个在stop关闭后退出的后台协程
+func (ds *DBStore) background(run func()) {
+	ds.wg.Add(1)
+	go func() {
+		defer ds.wg.Done()
+		run()
+	}()
+}
+
+// stopRecovery 停止降级模式下的恢复检查、一致性校验与主题树压缩
+func (ds *DBStore) stopRecovery() {
+	ds.stopOnce.Do(func() { close(ds.stop) })
+}
+
+// Close 停止start启动的后台协程，并写入会话写入器中尚未写入的变更，不关闭存储后端
+func (ds *DBStore) Close(ctx context.Context) error {
+	if err := ds.writer.Invoke(ctx); err != nil {
+		return err
+	}
+	return ds.stopBackground(ctx)
+}
+
+// stopBackground 停止恢复检查、一致性校验与主题树压缩，并等待正在进行的操作完成
+func (ds *DBStore) stopBackground(ctx context.Context) error {
+	ds.stopRecovery()
+	done := make(chan struct{})
+	go func() {
+		ds.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
-	return store
+}
+
+// operationContext 返回单次存储操作使用的上下文
+func operationContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), OperationTimeout)
 }
 
 // handleErr 处理数据库操作错误
@@ -48,7 +125,7 @@ func handleErr(err error) {
 		logger.ErrorF("unique key conflicts: %s", err.Error())
 		return
 	}
-	if errors.Is(err, mongo.ErrNoDocuments) {
+	if errors.Is(err, ErrNotFound) || errors.Is(err, mongo.ErrNoDocuments) {
 		logger.ErrorF("document does not exist: %s", err.Error())
 		return
 	}
@@ -59,212 +136,217 @@ func handleErr(err error) {
 	logger.ErrorF("database operation failed: %s", err.Error())
 }
 
-func (ds *DBStore) GetAllSession() []SessionData {
-	sessions := make([]SessionData, len(ds.sessions))
-	i := 0
+// GetAllSession 获取内存中与存储后端中的所有会话，存储不可用时只返回内存中的会话
+func (ds *DBStore) GetAllSession() []*SessionData {
+	ds.mu.RLock()
+	sessions := make([]*SessionData, 0, len(ds.sessions))
 	for _, value := range ds.sessions {
-		sessions[i] = *value
-		i++
+		sessions = append(sessions, value)
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), OperationTimeout)
-	defer cancel()
-	cursor, _ := Database.Collection(SessionCollectionName).Find(ctx, bson.M{})
-	defer cursor.Close(ctx)
-	count := 0
-	for cursor.Next(ctx) {
-		var session SessionData
-		if err := cursor.Decode(&session); err != nil {
-			continue
-		}
-		count++
-		sessions = append(sessions, session)
+	ds.mu.RUnlock()
+
+	var persisted []*SessionData
+	err := ds.load(func(ctx context.Context) (err error) {
+		persisted, err = ds.backend.LoadSessions(ctx)
+		return err
+	})
+	if err != nil {
+		handleErr(err)
 	}
-	return sessions
+	return append(sessions, persisted...)
 }
 
 // GetSession 获取客户端会话数据
-func (ds *DBStore) GetSession(clientID string) *SessionData {
+// 会话不存在时返回nil；存储不可用且内存中没有该会话时返回 ErrStorageUnavailable，调用方不能以新会话代替
+func (ds *DBStore) GetSession(clientID string) (*SessionData, error) {
+	session, err := ds.lookupSession(clientID)
+	switch {
+	case err == nil:
+		return session, nil
+	case errors.Is(err, ErrNotFound):
+		return nil, nil
+	case errors.Is(err, ErrStorageUnavailable):
+		logger.WarnF("[%s] Storage is degraded, session can not be loaded", clientID)
+	default:
+		handleErr(err)
+	}
+	return nil, err
+}
+
+// lookupSession 依次从内存、缓存、写缓冲区与存储后端查找会话
+func (ds *DBStore) lookupSession(clientID string) (*SessionData, error) {
 	// 首先检查内存中的会话
-	if session, ok := ds.sessions[clientID]; ok {
-		return session
+	ds.mu.RLock()
+	session, ok := ds.sessions[clientID]
+	ds.mu.RUnlock()
+	if ok {
+		return session, nil
 	}
 	// 然后检查缓存
 	if session, ok := ds.sessionCache.Get(clientID); ok {
-		return session
+		return session, nil
 	}
-	// 最后从数据库查询
-	ctx, cancel := context.WithTimeout(context.Background(), OperationTimeout)
-	defer cancel()
-
 	if clientID == "" {
-		handleErr(ClientIdEmptyError)
-		return nil
+		return nil, ClientIdEmptyError
+	}
+	// 再检查尚未写入存储后端的会话，等待删除的会话视为不存在
+	if write, ok := ds.buffer.pending(sessionWriteKey(clientID)); ok {
+		if session, _ := write.value.(*SessionData); session != nil {
+			return session, nil
+		}
+		return nil, ErrNotFound
 	}
 
-	filter := bson.D{{"client_id", clientID}}
-	var session SessionData
-
-	startTime := time.Now()
-	err := Database.Collection(SessionCollectionName).FindOne(ctx, filter).Decode(&session)
-	logger.DebugF("session query cost: %v", time.Since(startTime))
-
+	// 最后从存储后端查询
+	err := ds.load(func(ctx context.Context) (err error) {
+		session, err = ds.backend.LoadSession(ctx, clientID)
+		return err
+	})
 	if err != nil {
-		handleErr(err)
-		return nil
+		return nil, err
 	}
-
-	ds.sessionCache.Add(clientID, &session)
-	return &session
+	ds.sessionCache.Add(clientID, session)
+	return session, nil
 }
 
-// SaveSession 保存客户端会话数据
+// SaveSession 保存客户端会话数据及上次保存以来的订阅变更，存储不可用时写入缓冲区，恢复后重放
 func (ds *DBStore) SaveSession(sessionData *SessionData) bool {
-	// 临时会话只保存在内存中
+	// 临时会话与其订阅只保存在内存中
 	if sessionData.TempSession {
-		delete(ds.sessions, sessionData.ClientID)
+		sessionData.takeChanges()
+		ds.mu.Lock()
 		ds.sessions[sessionData.ClientID] = sessionData
+		ds.mu.Unlock()
 		return true
 	}
 
 	// 从缓存中移除
 	ds.sessionCache.Remove(sessionData.ClientID)
-	ctx, cancel := context.WithTimeout(context.Background(), OperationTimeout)
-	defer cancel()
 
 	if sessionData.ClientID == "" {
 		handleErr(ClientIdEmptyError)
 		return false
 	}
 
-	// 保存到数据库
-	filter := bson.D{{"client_id", sessionData.ClientID}}
-	opts := options.Replace().SetUpsert(true)
-
-	result, err := Database.Collection(SessionCollectionName).ReplaceOne(ctx, filter, sessionData, opts)
-
+	// 保存快照，避免序列化过程中会话数据被其他协程修改
+	snapshot, changes := sessionData.commitSnapshot()
+	err := ds.commit(&SessionCommit{ClientID: sessionData.ClientID, Session: snapshot, Changes: changes}, sessionData)
 	if err != nil {
 		handleErr(err)
 		return false
 	}
 
-	logger.DebugF("Session saved: client_id=%s, matched=%d, modified=%d, upserted=%v",
-		sessionData.ClientID,
-		result.MatchedCount,
-		result.ModifiedCount,
-		result.UpsertedID != nil,
-	)
-
 	ds.sessionCache.Add(sessionData.ClientID, sessionData)
 	return true
 }
 
-// DeleteSession 删除客户端会话数据
+// DeleteSession 删除客户端会话数据，会话的订阅一并从订阅树中删除
 func (ds *DBStore) DeleteSession(clientID string) bool {
-	// 从内存中删除
-	if _, ok := ds.sessions[clientID]; ok {
-		delete(ds.sessions, clientID)
+	// 从内存中删除，临时会话的订阅只保存在订阅树中
+	ds.mu.Lock()
+	temp, ok := ds.sessions[clientID]
+	delete(ds.sessions, clientID)
+	ds.mu.Unlock()
+	if ok {
+		for _, subscription := range temp.subscriptionList() {
+			ds.index.Remove(subscription)
+		}
 		return true
 	}
 
-	// 从缓存中删除
-	ds.sessionCache.Remove(clientID)
-
-	ctx, cancel := context.WithTimeout(context.Background(), OperationTimeout)
-	defer cancel()
-
 	if clientID == "" {
 		handleErr(ClientIdEmptyError)
 		return false
 	}
 
-	// 从数据库中删除
-	filter := bson.D{{"client_id", clientID}}
-	result, err := Database.Collection(SessionCollectionName).DeleteOne(ctx, filter)
+	commit := &SessionCommit{ClientID: clientID, Delete: true}
+	session, err := ds.lookupSession(clientID)
+	switch {
+	case err == nil:
+		for _, subscription := range session.subscriptionList() {
+			ds.index.Remove(subscription)
+			commit.Changes = append(commit.Changes, SubscriptionChange{Subscription: subscription, Remove: true})
+		}
+	case !errors.Is(err, ErrNotFound):
+		// 无法得知会话的订阅，订阅树中可能留下多余的订阅，由下一次一致性校验清理
+		logger.WarnF("[%s] Unable to load session before deleting it, subscriptions will be reconciled later: %v", clientID, err)
+		ds.drifted.Store(true)
+	}
+
+	// 从缓存中删除，并丢弃尚未写入的字段级变更
+	ds.sessionCache.Remove(clientID)
+	ds.writer.discard(clientID)
 
-	if err != nil {
+	if err := ds.commit(commit, nil); err != nil {
 		handleErr(err)
 		return false
 	}
-
-	logger.DebugF("Session deleted: client_id=%s, deleted=%d", clientID, result.DeletedCount)
-
 	return true
 }
 
 // GetWillMessage 获取遗嘱消息
 func (ds *DBStore) GetWillMessage(clientID string) *WillMessage {
-	ctx, cancel := context.WithTimeout(context.Background(), OperationTimeout)
-	defer cancel()
-
 	if clientID == "" {
 		handleErr(ClientIdEmptyError)
 		return nil
 	}
+	if write, ok := ds.buffer.pending(willWriteKey(clientID)); ok {
+		message, _ := write.value.(*WillMessage)
+		return message
+	}
 
-	filter := bson.D{{"client_id", clientID}}
-	var message WillMessage
-
-	startTime := time.Now()
-	err := Database.Collection(WillMessageCollectionName).FindOne(ctx, filter).Decode(&message)
-	logger.DebugF("will message query cost: %v", time.Since(startTime))
-
+	var message *WillMessage
+	err := ds.load(func(ctx context.Context) (err error) {
+		message, err = ds.backend.LoadWillMessage(ctx, clientID)
+		return err
+	})
 	if err != nil {
 		handleErr(err)
 		return nil
 	}
-
-	return &message
+	return message
 }
 
 // SaveWillMessage 保存遗嘱消息
 func (ds *DBStore) SaveWillMessage(willMessage *WillMessage) bool {
-	ctx, cancel := context.WithTimeout(context.Background(), OperationTimeout)
-	defer cancel()
-
 	if willMessage.ClientID == "" {
 		handleErr(ClientIdEmptyError)
 		return false
 	}
 
-	filter := bson.D{{"client_id", willMessage.ClientID}}
-	opts := options.Replace().SetUpsert(true)
-
-	result, err := Database.Collection(WillMessageCollectionName).ReplaceOne(ctx, filter, willMessage, opts)
-
+	err := ds.persist(willWriteKey(willMessage.ClientID), willMessage, func(ctx context.Context) error {
+		return ds.backend.SaveWillMessage(ctx, willMessage)
+	})
 	if err != nil {
 		handleErr(err)
 		return false
 	}
-
-	logger.DebugF("Session saved: client_id=%s, matched=%d, modified=%d, upserted=%v",
-		willMessage.ClientID,
-		result.MatchedCount,
-		result.ModifiedCount,
-		result.UpsertedID != nil,
-	)
-
 	return true
 }
 
 // DeleteWillMessage 删除遗嘱消息
 func (ds *DBStore) DeleteWillMessage(clientID string) bool {
-	ctx, cancel := context.WithTimeout(context.Background(), OperationTimeout)
-	defer cancel()
-
 	if clientID == "" {
 		handleErr(ClientIdEmptyError)
 		return false
 	}
 
-	filter := bson.D{{"client_id", clientID}}
-	result, err := Database.Collection(WillMessageCollectionName).DeleteOne(ctx, filter)
-
+	err := ds.persist(willWriteKey(clientID), nil, func(ctx context.Context) error {
+		return ds.backend.DeleteWillMessage(ctx, clientID)
+	})
 	if err != nil {
 		handleErr(err)
 		return false
 	}
-
-	logger.DebugF("Session deleted: client_id=%s, deleted=%d", clientID, result.DeletedCount)
-
 	return true
 }
+
+// RetainedMessageCount 返回存储后端中的保留消息数
+func (ds *DBStore) RetainedMessageCount() (int64, error) {
+	var count int64
+	err := ds.load(func(ctx context.Context) (err error) {
+		count, err = ds.backend.CountRetainedMessages(ctx)
+		return err
+	})
+	return count, err
+}
@@ -0,0 +1,140 @@
+package database
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	c "github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/config"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// testConfig 返回使用指定持久化模式的默认配置，定时写入间隔足够长以免测试期间自动写入
+func testConfig(durability string) c.Config {
+	config := c.Default()
+	config.Storage.SessionDurability = durability
+	config.Storage.SessionFlushInterval = "1h"
+	return config
+}
+
+// useStore 使用ds替换全局存储，测试结束后恢复
+func useStore(t *testing.T, ds *DBStore) *DBStore {
+	t.Helper()
+	previous := store.Swap(ds)
+	t.Cleanup(func() { store.Store(previous) })
+	return ds
+}
+
+// useMemoryStore 使用内存存储后端替换全局存储，测试结束后恢复
+func useMemoryStore(t *testing.T) *DBStore {
+	t.Helper()
+	return useStore(t, newDBStore(NewMemoryBackend(), testConfig(c.DurabilitySync)))
+}
+
+func TestDBStoreConcurrentClients(t *testing.T) {
+	ds := useMemoryStore(t)
+
+	const clients = 64
+	const topics = 16
+	var wg sync.WaitGroup
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			clientID := fmt.Sprintf("client-%d", i)
+			session := NewSessionData(clientID)
+			session.TempSession = true
+			if !ds.SaveSession(session) {
+				t.Errorf("SaveSession(%s) failed", clientID)
+				return
+			}
+			for j := 0; j < topics; j++ {
+				session.AddSubscription(&Subscription{TopicName: fmt.Sprintf("device/%d/status", j), QoSLevel: 1})
+				session.AddPendingPublish(uint16(j+1), fmt.Sprintf("device/%d/status", j))
+				if _, err := ds.MatchTopic(fmt.Sprintf("device/%d/status", j)); err != nil {
+					t.Errorf("MatchTopic failed: %v", err)
+				}
+				if got, err := ds.GetSession(clientID); err != nil || got != session {
+					t.Errorf("GetSession(%s) returned another session", clientID)
+				}
+			}
+			// 偶数客户端取消一半订阅后断开
+			if i%2 == 0 {
+				for j := 0; j < topics/2; j++ {
+					session.RemoveSubscription(&Subscription{TopicName: fmt.Sprintf("device/%d/status", j)})
+				}
+				session.RemoveAllSubscriptions()
+				ds.DeleteSession(clientID)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if got, want := ds.index.Count(), int64(clients/2*topics); got != want {
+		t.Fatalf("subscription count = %d, want %d", got, want)
+	}
+	if got := len(ds.index.Match("device/0/status")); got != clients/2 {
+		t.Fatalf("matched %d subscribers, want %d", got, clients/2)
+	}
+	for i := 0; i < clients; i++ {
+		session, err := ds.GetSession(fmt.Sprintf("client-%d", i))
+		if err != nil {
+			t.Fatalf("GetSession(client-%d): %v", i, err)
+		}
+		if (session != nil) != (i%2 == 1) {
+			t.Fatalf("unexpected session state for client-%d: %v", i, session)
+		}
+		if session != nil && session.SubscriptionCount() != topics {
+			t.Fatalf("client-%d has %d subscriptions, want %d", i, session.SubscriptionCount(), topics)
+		}
+	}
+}
+
+func TestSessionSnapshotWhileModified(t *testing.T) {
+	useMemoryStore(t)
+	session := NewSessionData("client")
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for j := 0; j < 500; j++ {
+			session.AddSubscription(&Subscription{TopicName: fmt.Sprintf("topic/%d", j)})
+			session.AddPendingPublish(uint16(j+1), fmt.Sprintf("topic/%d", j))
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for j := 0; j < 100; j++ {
+			if _, err := bson.Marshal(session.snapshot()); err != nil {
+				t.Errorf("marshal snapshot: %v", err)
+				return
+			}
+		}
+	}()
+	wg.Wait()
+
+	if session.SubscriptionCount() != 500 {
+		t.Fatalf("SubscriptionCount() = %d, want 500", session.SubscriptionCount())
+	}
+}
+
+func TestPacketIDManagerConcurrent(t *testing.T) {
+	managers := make([]*PacketIDManager, 32)
+	var wg sync.WaitGroup
+	for i := range managers {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			managers[i] = NewPacketIDManager()
+			id := managers[i].NextID()
+			managers[i].ReleaseID(id)
+		}(i)
+	}
+	wg.Wait()
+	for _, mgr := range managers {
+		if mgr != managers[0] {
+			t.Fatal("NewPacketIDManager returned different instances")
+		}
+	}
+}
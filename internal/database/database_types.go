@@ -4,9 +4,11 @@ const (
 	SessionCollectionName      = "sessions"
 	WillMessageCollectionName  = "will_messages"
 	SubscriptionCollectionName = "subscriptions"
+	RetainedCollectionName     = "retained_messages"
+	QueueCollectionName        = "queued_messages"
 )
 
-var collectionsList = []string{SessionCollectionName, WillMessageCollectionName, SubscriptionCollectionName}
+var collectionsList = []string{SessionCollectionName, WillMessageCollectionName, SubscriptionCollectionName, RetainedCollectionName, QueueCollectionName}
 
 type Subscription struct {
 	ClientID  string `bson:"client_id"`
@@ -17,18 +19,35 @@ type Subscription struct {
 type WillMessage struct {
 	ClientID string `bson:"client_id"`
 	Topic    []byte `bson:"topic"`
-	QoS      byte   `bson:"qo_s"`
+	QoS      byte   `bson:"qos"`
 	Content  []byte `bson:"content"`
 	Retained bool   `bson:"retained"`
 }
 
+// RetainedMessage 主题上的保留消息
+type RetainedMessage struct {
+	Topic   string `bson:"topic"`
+	Payload []byte `bson:"payload"`
+	QoS     byte   `bson:"qos"`
+}
+
+// QueuedMessage 等待投递给离线客户端的消息
+type QueuedMessage struct {
+	ClientID string `bson:"client_id"`
+	Topic    string `bson:"topic"`
+	Payload  []byte `bson:"payload"`
+	QoS      byte   `bson:"qos"`
+}
+
 type SessionStore interface {
-	GetAllSession() []SessionData
-	GetSession(clientID string) *SessionData
+	GetAllSession() []*SessionData
+	GetSession(clientID string) (*SessionData, error)
 	SaveSession(session *SessionData) bool
 	DeleteSession(clientID string) bool
 }
 
+var _ SessionStore = (*DBStore)(nil)
+
 type WillMessageStore interface {
 	GetWillMessage(clientID string) *WillMessage
 	SaveWillMessage(willMessage *WillMessage) bool
@@ -0,0 +1,244 @@
+// Package database 实现了MQTT服务器的数据存储功能
+package database
+
+import (
+	"context"
+	"sync"
+)
+
+// MemoryBackend 内存存储后端，进程退出后数据丢失
+// 用于开发调试，以及在测试中替代MongoDB
+type MemoryBackend struct {
+	mu            sync.RWMutex
+	sessions      map[string]*SessionData
+	subscriptions map[string]Subscription // key=subscriptionKey
+	willMessages  map[string]*WillMessage
+	retained      map[string]*RetainedMessage
+	queues        map[string][]*QueuedMessage
+}
+
+// NewMemoryBackend 创建内存存储后端
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{
+		sessions:      make(map[string]*SessionData),
+		subscriptions: make(map[string]Subscription),
+		willMessages:  make(map[string]*WillMessage),
+		retained:      make(map[string]*RetainedMessage),
+		queues:        make(map[string][]*QueuedMessage),
+	}
+}
+
+// Name 返回后端名称
+func (b *MemoryBackend) Name() string {
+	return "memory"
+}
+
+// Ping 内存后端始终可用
+func (b *MemoryBackend) Ping(ctx context.Context) error {
+	return nil
+}
+
+// Atomic 内存后端在同一把锁内写入会话与订阅
+func (b *MemoryBackend) Atomic() bool {
+	return true
+}
+
+// Close 内存后端无需关闭
+func (b *MemoryBackend) Close(ctx context.Context) error {
+	return nil
+}
+
+// LoadSessions 获取所有持久会话
+func (b *MemoryBackend) LoadSessions(ctx context.Context) ([]*SessionData, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	sessions := make([]*SessionData, 0, len(b.sessions))
+	for _, session := range b.sessions {
+		sessions = append(sessions, session.snapshot())
+	}
+	return sessions, nil
+}
+
+// LoadSession 获取客户端会话数据，返回副本以模拟持久化存储
+func (b *MemoryBackend) LoadSession(ctx context.Context, clientID string) (*SessionData, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	session, ok := b.sessions[clientID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return session.snapshot(), nil
+}
+
+// SaveSession 保存客户端会话数据的副本
+func (b *MemoryBackend) SaveSession(ctx context.Context, session *SessionData) error {
+	snapshot := session.snapshot()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.sessions[session.ClientID] = snapshot
+	return nil
+}
+
+// DeleteSession 删除客户端会话数据
+func (b *MemoryBackend) DeleteSession(ctx context.Context, clientID string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.sessions, clientID)
+	return nil
+}
+
+// UpdateSessions 将字段级变更应用到保存的会话副本
+func (b *MemoryBackend) UpdateSessions(ctx context.Context, updates []*SessionUpdate) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, update := range updates {
+		if session, ok := b.sessions[update.ClientID]; ok {
+			update.apply(session)
+		}
+	}
+	return nil
+}
+
+// CommitSession 在同一把锁内写入会话与订阅变更
+func (b *MemoryBackend) CommitSession(ctx context.Context, commit *SessionCommit) error {
+	var snapshot *SessionData
+	if commit.Session != nil {
+		snapshot = commit.Session.snapshot()
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if commit.Delete {
+		delete(b.sessions, commit.ClientID)
+	} else if snapshot != nil {
+		b.sessions[commit.ClientID] = snapshot
+	}
+	for _, change := range commit.Changes {
+		if change.Remove {
+			delete(b.subscriptions, subscriptionKey(change.Subscription))
+		} else {
+			b.subscriptions[subscriptionKey(change.Subscription)] = change.Subscription
+		}
+	}
+	return nil
+}
+
+// LoadSubscriptions 获取所有订阅
+func (b *MemoryBackend) LoadSubscriptions(ctx context.Context) ([]Subscription, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	subscriptions := make([]Subscription, 0, len(b.subscriptions))
+	for _, subscription := range b.subscriptions {
+		subscriptions = append(subscriptions, subscription)
+	}
+	return subscriptions, nil
+}
+
+// SaveSubscription 保存或更新订阅
+func (b *MemoryBackend) SaveSubscription(ctx context.Context, subscription Subscription) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subscriptions[subscriptionKey(subscription)] = subscription
+	return nil
+}
+
+// DeleteSubscription 删除订阅
+func (b *MemoryBackend) DeleteSubscription(ctx context.Context, subscription Subscription) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.subscriptions, subscriptionKey(subscription))
+	return nil
+}
+
+// LoadWillMessage 获取遗嘱消息
+func (b *MemoryBackend) LoadWillMessage(ctx context.Context, clientID string) (*WillMessage, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	message, ok := b.willMessages[clientID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	result := *message
+	return &result, nil
+}
+
+// SaveWillMessage 保存遗嘱消息
+func (b *MemoryBackend) SaveWillMessage(ctx context.Context, willMessage *WillMessage) error {
+	message := *willMessage
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.willMessages[willMessage.ClientID] = &message
+	return nil
+}
+
+// DeleteWillMessage 删除遗嘱消息
+func (b *MemoryBackend) DeleteWillMessage(ctx context.Context, clientID string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.willMessages, clientID)
+	return nil
+}
+
+// LoadRetainedMessages 获取所有保留消息
+func (b *MemoryBackend) LoadRetainedMessages(ctx context.Context) ([]*RetainedMessage, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	messages := make([]*RetainedMessage, 0, len(b.retained))
+	for _, message := range b.retained {
+		result := *message
+		messages = append(messages, &result)
+	}
+	return messages, nil
+}
+
+// SaveRetainedMessage 保存主题的保留消息，替换该主题上原有的保留消息
+func (b *MemoryBackend) SaveRetainedMessage(ctx context.Context, message *RetainedMessage) error {
+	result := *message
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.retained[message.Topic] = &result
+	return nil
+}
+
+// DeleteRetainedMessage 删除主题的保留消息
+func (b *MemoryBackend) DeleteRetainedMessage(ctx context.Context, topic string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.retained, topic)
+	return nil
+}
+
+// CountRetainedMessages 返回保留消息数
+func (b *MemoryBackend) CountRetainedMessages(ctx context.Context) (int64, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return int64(len(b.retained)), nil
+}
+
+// EnqueueMessage 将消息追加到客户端的离线队列
+func (b *MemoryBackend) EnqueueMessage(ctx context.Context, message *QueuedMessage) error {
+	result := *message
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.queues[message.ClientID] = append(b.queues[message.ClientID], &result)
+	return nil
+}
+
+// LoadQueuedMessages 按入队顺序获取客户端离线队列中的消息
+func (b *MemoryBackend) LoadQueuedMessages(ctx context.Context, clientID string) ([]*QueuedMessage, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	messages := make([]*QueuedMessage, 0, len(b.queues[clientID]))
+	for _, message := range b.queues[clientID] {
+		result := *message
+		messages = append(messages, &result)
+	}
+	return messages, nil
+}
+
+// ClearQueuedMessages 清空客户端的离线队列
+func (b *MemoryBackend) ClearQueuedMessages(ctx context.Context, clientID string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.queues, clientID)
+	return nil
+}
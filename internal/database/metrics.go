@@ -0,0 +1,52 @@
+// Package database 实现了MQTT服务器的数据存储功能
+package database
+
+import (
+	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// storeValue 在全局存储实例已初始化时返回value的结果，否则返回0
+func storeValue(value func(ds *DBStore) float64) func() float64 {
+	return func() float64 {
+		ds := store.Load()
+		if ds == nil {
+			return 0
+		}
+		return value(ds)
+	}
+}
+
+func init() {
+	metrics.Registry.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metrics.Namespace, Name: "storage_degraded",
+			Help: "Whether the storage backend is in degraded mode (1) or healthy (0).",
+		}, storeValue(func(ds *DBStore) float64 {
+			if ds.Health().Degraded {
+				return 1
+			}
+			return 0
+		})),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metrics.Namespace, Name: "storage_pending_writes",
+			Help: "Number of buffered storage writes waiting to be replayed.",
+		}, storeValue(func(ds *DBStore) float64 { return float64(ds.buffer.Len()) })),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: metrics.Namespace, Name: "storage_dropped_writes_total",
+			Help: "Total number of buffered storage writes dropped because the buffer was full.",
+		}, storeValue(func(ds *DBStore) float64 { return float64(ds.buffer.dropped.Load()) })),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metrics.Namespace, Name: "subscriptions",
+			Help: "Current number of subscriptions.",
+		}, storeValue(func(ds *DBStore) float64 { return float64(ds.index.Count()) })),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: metrics.Namespace, Name: "match_cache_hits_total",
+			Help: "Total number of topic match results served from cache.",
+		}, storeValue(func(ds *DBStore) float64 { hits, _ := ds.index.CacheStats(); return float64(hits) })),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: metrics.Namespace, Name: "match_cache_misses_total",
+			Help: "Total number of topic matches not found in cache.",
+		}, storeValue(func(ds *DBStore) float64 { _, misses := ds.index.CacheStats(); return float64(misses) })),
+	)
+}
@@ -0,0 +1,202 @@
+// Package database 实现了MQTT服务器的数据存储功能
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/logger"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// SchemaCollectionName 保存数据库结构版本的集合
+const SchemaCollectionName = "schema_version"
+
+// schemaDocumentID 结构版本文档的ID
+const schemaDocumentID = "schema"
+
+// migration 一次数据库结构迁移，迁移需要可以重复执行
+type migration struct {
+	version int
+	name    string
+	up      func(ctx context.Context, db *mongo.Database) error
+}
+
+// migrations 按版本号顺序排列的全部迁移，只能在末尾追加
+var migrations = []migration{
+	{1, "create session and subscription indexes", migrateCoreIndexes},
+	{2, "create retained message and queue indexes", migrateMessageIndexes},
+	{3, "rename will message qo_s to qos", migrateWillQoS},
+	{4, "backfill session maps", migrateSessionMaps},
+}
+
+// LatestSchemaVersion 返回当前代码对应的数据库结构版本
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// appliedMigration 已执行的迁移记录
+type appliedMigration struct {
+	Version   int       `bson:"version"`
+	Name      string    `bson:"name"`
+	AppliedAt time.Time `bson:"applied_at"`
+}
+
+// schemaDocument 数据库结构版本文档
+type schemaDocument struct {
+	ID      string             `bson:"_id"`
+	Version int                `bson:"version"`
+	History []appliedMigration `bson:"history"`
+}
+
+// MigrationStatus 描述一次迁移的执行状态
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedAt time.Time // 未执行或执行时间未知时为零值
+}
+
+// readSchema 读取结构版本文档，不存在时返回版本0
+func readSchema(ctx context.Context, db *mongo.Database) (*schemaDocument, error) {
+	schema := &schemaDocument{ID: schemaDocumentID}
+	err := db.Collection(SchemaCollectionName).FindOne(ctx, bson.D{{Key: "_id", Value: schemaDocumentID}}).Decode(schema)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, fmt.Errorf("error occured while reading schema version: %v", err)
+	}
+	return schema, nil
+}
+
+// SchemaStatus 返回当前结构版本与每个迁移的执行状态
+func SchemaStatus(ctx context.Context, db *mongo.Database) (int, []MigrationStatus, error) {
+	schema, err := readSchema(ctx, db)
+	if err != nil {
+		return 0, nil, err
+	}
+	appliedAt := make(map[int]time.Time, len(schema.History))
+	for _, record := range schema.History {
+		appliedAt[record.Version] = record.AppliedAt
+	}
+	result := make([]MigrationStatus, len(migrations))
+	for i, m := range migrations {
+		result[i] = MigrationStatus{
+			Version:   m.version,
+			Name:      m.name,
+			Applied:   m.version <= schema.Version,
+			AppliedAt: appliedAt[m.version],
+		}
+	}
+	return schema.Version, result, nil
+}
+
+// Migrate 按顺序执行所有未执行的迁移，返回执行的迁移数量
+// 每个迁移完成后立即记录版本号，中断后再次执行会从下一个未完成的迁移继续
+func Migrate(ctx context.Context, db *mongo.Database) (int, error) {
+	schema, err := readSchema(ctx, db)
+	if err != nil {
+		return 0, err
+	}
+	if schema.Version > LatestSchemaVersion() {
+		return 0, fmt.Errorf("database schema version %d is newer than supported version %d", schema.Version, LatestSchemaVersion())
+	}
+
+	applied := 0
+	for _, m := range migrations {
+		if m.version <= schema.Version {
+			continue
+		}
+		logger.InfoF("Applying database migration %d: %s", m.version, m.name)
+		startTime := time.Now()
+		if err := m.up(ctx, db); err != nil {
+			return applied, fmt.Errorf("migration %d (%s) failed: %v", m.version, m.name, err)
+		}
+		if err := recordMigration(ctx, db, m); err != nil {
+			return applied, err
+		}
+		schema.Version = m.version
+		applied++
+		logger.InfoF("Database migration %d applied, cost: %v", m.version, time.Since(startTime))
+	}
+	return applied, nil
+}
+
+// recordMigration 记录迁移已完成
+func recordMigration(ctx context.Context, db *mongo.Database, m migration) error {
+	update := bson.D{
+		{Key: "$max", Value: bson.D{{Key: "version", Value: m.version}}},
+		{Key: "$push", Value: bson.D{{Key: "history", Value: appliedMigration{
+			Version:   m.version,
+			Name:      m.name,
+			AppliedAt: time.Now(),
+		}}}},
+	}
+	filter := bson.D{{Key: "_id", Value: schemaDocumentID}}
+	_, err := db.Collection(SchemaCollectionName).UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if err != nil {
+		return fmt.Errorf("error occured while recording migration %d: %v", m.version, err)
+	}
+	return nil
+}
+
+// migrateCoreIndexes 创建会话与订阅集合的唯一索引
+// 索引已存在时创建操作不会修改索引，也不会出现没有唯一索引的时间窗口
+func migrateCoreIndexes(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection(SessionCollectionName).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "client_id", Value: 1}},
+		Options: options.Index().SetUnique(true).SetName("sessions_client_id_unique"),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = db.Collection(SubscriptionCollectionName).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "path", Value: 1}},
+		Options: options.Index().SetUnique(true).SetName("subscriptions_path_unique"),
+	})
+	return err
+}
+
+// migrateMessageIndexes 创建保留消息与离线队列集合的索引
+func migrateMessageIndexes(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection(RetainedCollectionName).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "topic", Value: 1}},
+		Options: options.Index().SetUnique(true).SetName("retained_topic_unique"),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = db.Collection(QueueCollectionName).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "client_id", Value: 1}, {Key: "_id", Value: 1}},
+		Options: options.Index().SetName("queue_client_id"),
+	})
+	return err
+}
+
+// migrateWillQoS 将遗嘱消息中由默认命名规则生成的 qo_s 字段重命名为 qos
+func migrateWillQoS(ctx context.Context, db *mongo.Database) error {
+	filter := bson.D{{Key: "qo_s", Value: bson.D{{Key: "$exists", Value: true}}}}
+	update := bson.D{{Key: "$rename", Value: bson.D{{Key: "qo_s", Value: "qos"}}}}
+	result, err := db.Collection(WillMessageCollectionName).UpdateMany(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	logger.InfoF("Renamed qo_s field of %d will messages", result.ModifiedCount)
+	return nil
+}
+
+// migrateSessionMaps 为旧版本写入的会话补全缺失的map字段，避免解码后得到nil map
+func migrateSessionMaps(ctx context.Context, db *mongo.Database) error {
+	for _, field := range []string{"subscriptions", "pending_publish", "pending_pubrel", "inflight_qos2"} {
+		filter := bson.D{{Key: field, Value: bson.D{{Key: "$in", Value: bson.A{nil}}}}}
+		update := bson.D{{Key: "$set", Value: bson.D{{Key: field, Value: bson.D{}}}}}
+		result, err := db.Collection(SessionCollectionName).UpdateMany(ctx, filter, update)
+		if err != nil {
+			return err
+		}
+		logger.InfoF("Backfilled %s of %d sessions", field, result.ModifiedCount)
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package database
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestMigrationsOrdered(t *testing.T) {
+	for i, m := range migrations {
+		if m.version != i+1 {
+			t.Fatalf("migration #%d has version %d, versions must start at 1 and increase by 1", i, m.version)
+		}
+		if m.name == "" || m.up == nil {
+			t.Fatalf("migration %d is incomplete", m.version)
+		}
+	}
+	if LatestSchemaVersion() != len(migrations) {
+		t.Fatalf("LatestSchemaVersion() = %d, want %d", LatestSchemaVersion(), len(migrations))
+	}
+}
+
+func TestWillMessageQoSKey(t *testing.T) {
+	data, err := bson.Marshal(NewWillMessage("client", []byte("topic"), []byte("bye"), 1, false))
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	raw := bson.Raw(data)
+	if _, err := raw.LookupErr("qos"); err != nil {
+		t.Fatalf("will message has no qos key: %v", raw)
+	}
+	if _, err := raw.LookupErr("qo_s"); err == nil {
+		t.Fatalf("will message still uses qo_s key: %v", raw)
+	}
+}
@@ -0,0 +1,347 @@
+// Package database 实现了MQTT服务器的数据存储功能
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/golang-lru/v2/expirable"
+	c "github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/config"
+	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/logger"
+	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/metrics"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// mongoBackend 基于MongoDB的存储后端
+type mongoBackend struct {
+	sessions     *mongo.Collection                      // 使用会话写关注的会话集合
+	queue        *mongo.Collection                      // 使用离线队列写关注的队列集合
+	topicCache   *expirable.LRU[string, *TopicTreeNode] // 主题树缓存
+	transactions bool                                   // 部署是否支持多文档事务（副本集或分片集群）
+	treeMu       sync.Mutex                             // 串行化主题树节点的读取-修改-写入，避免并发写入丢失子节点引用
+}
+
+// newMongoBackend 创建MongoDB存储后端，调用前需先调用 ConnectDatabase
+func newMongoBackend(conf c.Config) (*mongoBackend, error) {
+	sessionOptions, err := collectionOptions(conf.Database.SessionWriteConcern, conf.Database.Journal)
+	if err != nil {
+		return nil, fmt.Errorf("invalid session write concern: %v", err)
+	}
+	queueOptions, err := collectionOptions(conf.Database.QueueWriteConcern, conf.Database.Journal)
+	if err != nil {
+		return nil, fmt.Errorf("invalid queue write concern: %v", err)
+	}
+	backend := &mongoBackend{
+		sessions:   Database.Collection(SessionCollectionName, sessionOptions),
+		queue:      Database.Collection(QueueCollectionName, queueOptions),
+		topicCache: expirable.NewLRU[string, *TopicTreeNode](256, nil, time.Hour),
+	}
+	ctx, cancel := operationContext()
+	defer cancel()
+	backend.transactions, err = supportsTransactions(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error occured while detecting deployment type: %v", err)
+	}
+	if backend.transactions {
+		logger.InfoF("MongoDB deployment supports transactions, sessions and subscriptions are committed atomically")
+	} else {
+		logger.WarnF("MongoDB deployment is standalone, sessions and subscriptions are reconciled periodically")
+	}
+	return backend, nil
+}
+
+// supportsTransactions 通过hello命令判断部署是否为副本集或分片集群
+func supportsTransactions(ctx context.Context) (bool, error) {
+	var hello bson.M
+	if err := Database.RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello); err != nil {
+		return false, err
+	}
+	if _, ok := hello["setName"]; ok {
+		return true, nil
+	}
+	return hello["msg"] == "isdbgrid", nil
+}
+
+// Name 返回后端名称
+func (b *mongoBackend) Name() string {
+	return "mongo"
+}
+
+// Ping 向主节点发送ping命令
+func (b *mongoBackend) Ping(ctx context.Context) error {
+	return Client.Ping(ctx, nil)
+}
+
+// Atomic 只有支持事务的部署才能原子地写入会话与订阅树
+func (b *mongoBackend) Atomic() bool {
+	return b.transactions
+}
+
+// Close 关闭数据库连接
+func (b *mongoBackend) Close(ctx context.Context) error {
+	logger.InfoF("Closing database connection")
+	return Client.Disconnect(ctx)
+}
+
+// notFound 将MongoDB的文档不存在错误转换为 ErrNotFound
+func notFound(err error) error {
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// LoadSessions 获取数据库中的所有会话
+func (b *mongoBackend) LoadSessions(ctx context.Context) ([]*SessionData, error) {
+	cursor, err := b.sessions.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	var sessions []*SessionData
+	for cursor.Next(ctx) {
+		session := &SessionData{}
+		if err := cursor.Decode(session); err != nil {
+			continue
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, cursor.Err()
+}
+
+// LoadSession 获取客户端会话数据
+func (b *mongoBackend) LoadSession(ctx context.Context, clientID string) (*SessionData, error) {
+	filter := bson.D{{Key: "client_id", Value: clientID}}
+	session := &SessionData{}
+
+	startTime := time.Now()
+	err := b.sessions.FindOne(ctx, filter).Decode(session)
+	metrics.StorageDuration.WithLabelValues("load_session").Observe(time.Since(startTime).Seconds())
+	logger.DebugF("session query cost: %v", time.Since(startTime))
+
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return session, nil
+}
+
+// SaveSession 保存客户端会话数据
+func (b *mongoBackend) SaveSession(ctx context.Context, session *SessionData) error {
+	filter := bson.D{{Key: "client_id", Value: session.ClientID}}
+	opts := options.Replace().SetUpsert(true)
+
+	result, err := b.sessions.ReplaceOne(ctx, filter, session, opts)
+	if err != nil {
+		return err
+	}
+
+	logger.DebugF("Session saved: client_id=%s, matched=%d, modified=%d, upserted=%v",
+		session.ClientID,
+		result.MatchedCount,
+		result.ModifiedCount,
+		result.UpsertedID != nil,
+	)
+	return nil
+}
+
+// DeleteSession 删除客户端会话数据
+func (b *mongoBackend) DeleteSession(ctx context.Context, clientID string) error {
+	filter := bson.D{{Key: "client_id", Value: clientID}}
+	result, err := b.sessions.DeleteOne(ctx, filter)
+	if err != nil {
+		return err
+	}
+
+	logger.DebugF("Session deleted: client_id=%s, deleted=%d", clientID, result.DeletedCount)
+	return nil
+}
+
+// UpdateSessions 使用BulkWrite批量执行会话的$set/$unset更新
+func (b *mongoBackend) UpdateSessions(ctx context.Context, updates []*SessionUpdate) error {
+	models := make([]mongo.WriteModel, 0, len(updates))
+	for _, update := range updates {
+		if update.empty() {
+			continue
+		}
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.D{{Key: "client_id", Value: update.ClientID}}).
+			SetUpdate(update.document()))
+	}
+	if len(models) == 0 {
+		return nil
+	}
+
+	startTime := time.Now()
+	result, err := b.sessions.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+	metrics.StorageDuration.WithLabelValues("update_sessions").Observe(time.Since(startTime).Seconds())
+	if err != nil {
+		return err
+	}
+	logger.DebugF("Session updates written: sessions=%d, modified=%d, cost=%v", len(models), result.ModifiedCount, time.Since(startTime))
+	return nil
+}
+
+// CommitSession 写入会话及其订阅变更，部署支持事务时在一个事务中完成
+func (b *mongoBackend) CommitSession(ctx context.Context, commit *SessionCommit) error {
+	if !b.transactions || len(commit.Changes) == 0 {
+		return b.commitOrdered(ctx, commit)
+	}
+	session, err := Client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+	_, err = session.WithTransaction(ctx, func(sessionContext mongo.SessionContext) (any, error) {
+		return nil, b.commitOrdered(sessionContext, commit)
+	})
+	if err != nil {
+		// 事务回滚后缓存中的主题树节点可能已被修改
+		b.topicCache.Purge()
+	}
+	return err
+}
+
+// commitOrdered 依次写入订阅删除、会话与订阅新增
+// 不在事务中执行时，中途失败最多留下会话中有但订阅树中缺少的订阅，不会留下把消息路由给未订阅客户端的多余订阅
+func (b *mongoBackend) commitOrdered(ctx context.Context, commit *SessionCommit) error {
+	for _, change := range commit.Changes {
+		if !change.Remove {
+			continue
+		}
+		if err := b.DeleteSubscription(ctx, change.Subscription); err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+	}
+	if commit.Delete {
+		if err := b.DeleteSession(ctx, commit.ClientID); err != nil {
+			return err
+		}
+	} else if commit.Session != nil {
+		if err := b.SaveSession(ctx, commit.Session); err != nil {
+			return err
+		}
+	}
+	for _, change := range commit.Changes {
+		if change.Remove {
+			continue
+		}
+		if err := b.SaveSubscription(ctx, change.Subscription); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LoadWillMessage 获取遗嘱消息
+func (b *mongoBackend) LoadWillMessage(ctx context.Context, clientID string) (*WillMessage, error) {
+	filter := bson.D{{Key: "client_id", Value: clientID}}
+	var message WillMessage
+
+	startTime := time.Now()
+	err := Database.Collection(WillMessageCollectionName).FindOne(ctx, filter).Decode(&message)
+	metrics.StorageDuration.WithLabelValues("load_will_message").Observe(time.Since(startTime).Seconds())
+	logger.DebugF("will message query cost: %v", time.Since(startTime))
+
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &message, nil
+}
+
+// SaveWillMessage 保存遗嘱消息
+func (b *mongoBackend) SaveWillMessage(ctx context.Context, willMessage *WillMessage) error {
+	filter := bson.D{{Key: "client_id", Value: willMessage.ClientID}}
+	opts := options.Replace().SetUpsert(true)
+
+	result, err := Database.Collection(WillMessageCollectionName).ReplaceOne(ctx, filter, willMessage, opts)
+	if err != nil {
+		return err
+	}
+
+	logger.DebugF("Will message saved: client_id=%s, matched=%d, modified=%d, upserted=%v",
+		willMessage.ClientID,
+		result.MatchedCount,
+		result.ModifiedCount,
+		result.UpsertedID != nil,
+	)
+	return nil
+}
+
+// DeleteWillMessage 删除遗嘱消息
+func (b *mongoBackend) DeleteWillMessage(ctx context.Context, clientID string) error {
+	filter := bson.D{{Key: "client_id", Value: clientID}}
+	result, err := Database.Collection(WillMessageCollectionName).DeleteOne(ctx, filter)
+	if err != nil {
+		return err
+	}
+
+	logger.DebugF("Will message deleted: client_id=%s, deleted=%d", clientID, result.DeletedCount)
+	return nil
+}
+
+// LoadRetainedMessages 获取所有保留消息
+func (b *mongoBackend) LoadRetainedMessages(ctx context.Context) ([]*RetainedMessage, error) {
+	cursor, err := Database.Collection(RetainedCollectionName).Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	var messages []*RetainedMessage
+	if err := cursor.All(ctx, &messages); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// SaveRetainedMessage 保存主题的保留消息，替换该主题上原有的保留消息
+func (b *mongoBackend) SaveRetainedMessage(ctx context.Context, message *RetainedMessage) error {
+	filter := bson.D{{Key: "topic", Value: message.Topic}}
+	opts := options.Replace().SetUpsert(true)
+	_, err := Database.Collection(RetainedCollectionName).ReplaceOne(ctx, filter, message, opts)
+	return err
+}
+
+// DeleteRetainedMessage 删除主题的保留消息
+func (b *mongoBackend) DeleteRetainedMessage(ctx context.Context, topic string) error {
+	filter := bson.D{{Key: "topic", Value: topic}}
+	_, err := Database.Collection(RetainedCollectionName).DeleteOne(ctx, filter)
+	return err
+}
+
+// CountRetainedMessages 返回保留消息数，使用集合元数据中的估计值，不扫描文档
+func (b *mongoBackend) CountRetainedMessages(ctx context.Context) (int64, error) {
+	return Database.Collection(RetainedCollectionName).EstimatedDocumentCount(ctx)
+}
+
+// EnqueueMessage 将消息追加到客户端的离线队列
+func (b *mongoBackend) EnqueueMessage(ctx context.Context, message *QueuedMessage) error {
+	_, err := b.queue.InsertOne(ctx, message)
+	return err
+}
+
+// LoadQueuedMessages 按入队顺序获取客户端离线队列中的消息
+func (b *mongoBackend) LoadQueuedMessages(ctx context.Context, clientID string) ([]*QueuedMessage, error) {
+	filter := bson.D{{Key: "client_id", Value: clientID}}
+	// ObjectID 按创建时间递增，按_id排序即为入队顺序
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	cursor, err := b.queue.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	var messages []*QueuedMessage
+	if err := cursor.All(ctx, &messages); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// ClearQueuedMessages 清空客户端的离线队列
+func (b *mongoBackend) ClearQueuedMessages(ctx context.Context, clientID string) error {
+	filter := bson.D{{Key: "client_id", Value: clientID}}
+	_, err := b.queue.DeleteMany(ctx, filter)
+	return err
+}
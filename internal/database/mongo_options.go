@@ -0,0 +1,205 @@
+// Package database 实现了MQTT服务器的数据存储功能
+package database
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	c "github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/config"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+// defaultAuthSource 未指定认证数据库时使用的认证数据库
+const defaultAuthSource = "admin"
+
+// connectionURI 返回连接字符串
+// 配置了uri时直接使用，支持 mongodb+srv 与副本集等完整的连接字符串；否则由host与port拼接
+func connectionURI(conf c.Config) string {
+	if conf.Database.URI != "" {
+		return conf.Database.URI
+	}
+	host := conf.Database.Host
+	if conf.Database.Port != 0 {
+		host = fmt.Sprintf("%s:%d", host, conf.Database.Port)
+	}
+	return (&url.URL{Scheme: "mongodb", Host: host, Path: "/"}).String()
+}
+
+// loadCredentials 读取数据库用户名与密码
+// 优先级：环境变量 > 密码文件 > 配置文件中的明文
+func loadCredentials(conf c.Config) (string, string, error) {
+	username := conf.Database.Username
+	if conf.Database.UsernameEnv != "" {
+		if value, ok := os.LookupEnv(conf.Database.UsernameEnv); ok {
+			username = value
+		}
+	}
+
+	password := conf.Database.Password
+	if conf.Database.PasswordFile != "" {
+		data, err := os.ReadFile(conf.Database.PasswordFile)
+		if err != nil {
+			return "", "", fmt.Errorf("error occured while reading database password file: %v", err)
+		}
+		password = strings.TrimRight(string(data), "\r\n")
+	}
+	if conf.Database.PasswordEnv != "" {
+		if value, ok := os.LookupEnv(conf.Database.PasswordEnv); ok {
+			password = value
+		}
+	}
+	return username, password, nil
+}
+
+// parseWriteConcern 解析写关注，支持 majority、非负整数与副本集标签名，空字符串表示使用默认值
+func parseWriteConcern(value string, journal bool) (*writeconcern.WriteConcern, error) {
+	var wc *writeconcern.WriteConcern
+	switch {
+	case value == "":
+		if !journal {
+			return nil, nil
+		}
+		wc = &writeconcern.WriteConcern{}
+	case value == "majority":
+		wc = writeconcern.Majority()
+	default:
+		if w, err := strconv.Atoi(value); err == nil {
+			if w < 0 {
+				return nil, fmt.Errorf("write concern %q must not be negative", value)
+			}
+			wc = &writeconcern.WriteConcern{W: w}
+		} else {
+			wc = writeconcern.Custom(value)
+		}
+	}
+	if journal {
+		wc.Journal = &journal
+	}
+	return wc, nil
+}
+
+// parseReadConcern 解析读关注级别，空字符串表示使用默认值
+func parseReadConcern(level string) (*readconcern.ReadConcern, error) {
+	switch level {
+	case "":
+		return nil, nil
+	case "local", "majority", "available", "linearizable", "snapshot":
+		return &readconcern.ReadConcern{Level: level}, nil
+	default:
+		return nil, fmt.Errorf("read concern %q is not supported", level)
+	}
+}
+
+// parseReadPreference 解析读偏好，空字符串表示使用默认值
+func parseReadPreference(mode string) (*readpref.ReadPref, error) {
+	if mode == "" {
+		return nil, nil
+	}
+	parsed, err := readpref.ModeFromString(mode)
+	if err != nil {
+		return nil, err
+	}
+	return readpref.New(parsed)
+}
+
+// loadTLSConfig 创建TLS配置，指定了CA文件时只信任该文件中的证书，否则使用系统根证书
+func loadTLSConfig(caFile string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: false,
+	}
+	if caFile == "" {
+		return tlsConfig, nil
+	}
+	data, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("error occured while reading database CA file: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("database CA file %s contains no PEM certificates", caFile)
+	}
+	tlsConfig.RootCAs = pool
+	return tlsConfig, nil
+}
+
+// applyConnectionOptions 根据配置设置连接字符串、认证、副本集、读写关注与TLS
+func applyConnectionOptions(clientOptions *options.ClientOptions, conf c.Config) error {
+	clientOptions.ApplyURI(connectionURI(conf))
+
+	// 单独配置的用户名与密码覆盖连接字符串中的认证信息
+	username, password, err := loadCredentials(conf)
+	if err != nil {
+		return err
+	}
+	if username != "" {
+		credential := options.Credential{}
+		if clientOptions.Auth != nil {
+			credential = *clientOptions.Auth
+		}
+		credential.Username = username
+		credential.Password = password
+		credential.PasswordSet = password != ""
+		if conf.Database.AuthSource != "" {
+			credential.AuthSource = conf.Database.AuthSource
+		} else if credential.AuthSource == "" {
+			credential.AuthSource = defaultAuthSource
+		}
+		clientOptions.SetAuth(credential)
+	}
+
+	if conf.Database.ReplicaSet != "" {
+		clientOptions.SetReplicaSet(conf.Database.ReplicaSet)
+	}
+
+	readConcern, err := parseReadConcern(conf.Database.ReadConcern)
+	if err != nil {
+		return err
+	}
+	if readConcern != nil {
+		clientOptions.SetReadConcern(readConcern)
+	}
+	readPreference, err := parseReadPreference(conf.Database.ReadPreference)
+	if err != nil {
+		return err
+	}
+	if readPreference != nil {
+		clientOptions.SetReadPreference(readPreference)
+	}
+	writeConcern, err := parseWriteConcern(conf.Database.WriteConcern, conf.Database.Journal)
+	if err != nil {
+		return err
+	}
+	if writeConcern != nil {
+		clientOptions.SetWriteConcern(writeConcern)
+	}
+
+	if conf.Database.UseTLS {
+		tlsConfig, err := loadTLSConfig(conf.Database.CAFile)
+		if err != nil {
+			return err
+		}
+		clientOptions.SetTLSConfig(tlsConfig)
+	}
+	return clientOptions.Validate()
+}
+
+// collectionOptions 返回使用指定写关注的集合选项，写关注为空时继承客户端设置
+func collectionOptions(value string, journal bool) (*options.CollectionOptions, error) {
+	writeConcern, err := parseWriteConcern(value, journal)
+	if err != nil {
+		return nil, err
+	}
+	collection := options.Collection()
+	if writeConcern != nil {
+		collection.SetWriteConcern(writeConcern)
+	}
+	return collection, nil
+}
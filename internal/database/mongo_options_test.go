@@ -0,0 +1,155 @@
+package database
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	c "github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/config"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestConnectionURI(t *testing.T) {
+	conf := c.Config{}
+	conf.Database.Host = "127.0.0.1"
+	conf.Database.Port = 27017
+	if got := connectionURI(conf); got != "mongodb://127.0.0.1:27017/" {
+		t.Fatalf("connectionURI() = %q", got)
+	}
+	conf.Database.URI = "mongodb+srv://cluster.example.com/?retryWrites=true"
+	if got := connectionURI(conf); got != conf.Database.URI {
+		t.Fatalf("connectionURI() = %q, want configured uri", got)
+	}
+}
+
+func TestLoadCredentials(t *testing.T) {
+	passwordFile := filepath.Join(t.TempDir(), "password")
+	if err := os.WriteFile(passwordFile, []byte("from-file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	conf := c.Config{}
+	conf.Database.Username = "inline-user"
+	conf.Database.Password = "inline-password"
+	if username, password, _ := loadCredentials(conf); username != "inline-user" || password != "inline-password" {
+		t.Fatalf("inline credentials = %q/%q", username, password)
+	}
+
+	conf.Database.PasswordFile = passwordFile
+	if _, password, _ := loadCredentials(conf); password != "from-file" {
+		t.Fatalf("password from file = %q", password)
+	}
+
+	conf.Database.UsernameEnv = "TEST_BROKER_DB_USER"
+	conf.Database.PasswordEnv = "TEST_BROKER_DB_PASSWORD"
+	t.Setenv("TEST_BROKER_DB_USER", "env-user")
+	t.Setenv("TEST_BROKER_DB_PASSWORD", "env-password")
+	if username, password, _ := loadCredentials(conf); username != "env-user" || password != "env-password" {
+		t.Fatalf("env credentials = %q/%q", username, password)
+	}
+
+	conf.Database.PasswordFile = filepath.Join(t.TempDir(), "missing")
+	if _, _, err := loadCredentials(conf); err == nil {
+		t.Fatal("missing password file should fail")
+	}
+}
+
+func TestParseWriteConcern(t *testing.T) {
+	tests := []struct {
+		value   string
+		journal bool
+		w       any
+		wantNil bool
+		wantErr bool
+	}{
+		{value: "", wantNil: true},
+		{value: "", journal: true, w: nil},
+		{value: "majority", w: "majority"},
+		{value: "2", w: 2},
+		{value: "dc-east", w: "dc-east"},
+		{value: "-1", wantErr: true},
+	}
+	for _, tt := range tests {
+		wc, err := parseWriteConcern(tt.value, tt.journal)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("parseWriteConcern(%q) error = %v", tt.value, err)
+		}
+		if tt.wantErr {
+			continue
+		}
+		if (wc == nil) != tt.wantNil {
+			t.Fatalf("parseWriteConcern(%q) = %v", tt.value, wc)
+		}
+		if wc == nil {
+			continue
+		}
+		if wc.W != tt.w || wc.GetJ() != tt.journal {
+			t.Fatalf("parseWriteConcern(%q, %v) = w:%v j:%v", tt.value, tt.journal, wc.W, wc.GetJ())
+		}
+	}
+}
+
+func TestParseReadSettings(t *testing.T) {
+	if rc, err := parseReadConcern("majority"); err != nil || rc.Level != "majority" {
+		t.Fatalf("parseReadConcern(majority) = %v, %v", rc, err)
+	}
+	if _, err := parseReadConcern("strong"); err == nil {
+		t.Fatal("unknown read concern should fail")
+	}
+	if rp, err := parseReadPreference("secondaryPreferred"); err != nil || rp.Mode().String() != "secondaryPreferred" {
+		t.Fatalf("parseReadPreference(secondaryPreferred) = %v, %v", rp, err)
+	}
+	if _, err := parseReadPreference("fastest"); err == nil {
+		t.Fatal("unknown read preference should fail")
+	}
+}
+
+func TestApplyConnectionOptions(t *testing.T) {
+	conf := c.Config{}
+	conf.Database.URI = "mongodb://uri-user:uri-password@h1:27017,h2:27017/?replicaSet=rs0&authSource=users"
+	conf.Database.ReadPreference = "nearest"
+	conf.Database.WriteConcern = "majority"
+
+	clientOptions := options.Client()
+	if err := applyConnectionOptions(clientOptions, conf); err != nil {
+		t.Fatalf("applyConnectionOptions: %v", err)
+	}
+	if len(clientOptions.Hosts) != 2 || *clientOptions.ReplicaSet != "rs0" {
+		t.Fatalf("hosts = %v, replica set = %v", clientOptions.Hosts, clientOptions.ReplicaSet)
+	}
+	if clientOptions.Auth.Username != "uri-user" || clientOptions.Auth.AuthSource != "users" {
+		t.Fatalf("auth from uri = %+v", clientOptions.Auth)
+	}
+
+	// 单独配置的用户名密码覆盖连接字符串，但保留其中的认证数据库
+	conf.Database.Username = "config-user"
+	conf.Database.Password = "config-password"
+	clientOptions = options.Client()
+	if err := applyConnectionOptions(clientOptions, conf); err != nil {
+		t.Fatalf("applyConnectionOptions: %v", err)
+	}
+	if clientOptions.Auth.Username != "config-user" || clientOptions.Auth.Password != "config-password" || clientOptions.Auth.AuthSource != "users" {
+		t.Fatalf("auth override = %+v", clientOptions.Auth)
+	}
+
+	conf.Database.ReadConcern = "strong"
+	if err := applyConnectionOptions(options.Client(), conf); err == nil {
+		t.Fatal("invalid read concern should fail")
+	}
+}
+
+func TestLoadTLSConfig(t *testing.T) {
+	if tlsConfig, err := loadTLSConfig(""); err != nil || tlsConfig.RootCAs != nil {
+		t.Fatalf("loadTLSConfig without CA = %v, %v", tlsConfig, err)
+	}
+	invalid := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(invalid, []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadTLSConfig(invalid); err == nil {
+		t.Fatal("CA file without certificates should fail")
+	}
+	if _, err := loadTLSConfig(filepath.Join(t.TempDir(), "missing.pem")); err == nil {
+		t.Fatal("missing CA file should fail")
+	}
+}
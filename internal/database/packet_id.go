@@ -8,15 +8,18 @@ type PacketIDManager struct {
 	released  map[uint16]struct{}
 }
 
-var PacketManager *PacketIDManager
+var (
+	PacketManager     *PacketIDManager
+	packetManagerOnce sync.Once
+)
 
 func NewPacketIDManager() *PacketIDManager {
-	if PacketManager == nil {
+	packetManagerOnce.Do(func() {
 		PacketManager = &PacketIDManager{
 			currentID: 1, // 起始值为1
 			released:  make(map[uint16]struct{}),
 		}
-	}
+	})
 	return PacketManager
 }
 
@@ -1,16 +1,24 @@
 // Package database 实现了MQTT服务器的数据存储功能
 package database
 
-import "github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/logger"
+import (
+	"maps"
+	"sync"
+
+	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/logger"
+)
 
 // SessionData 表示MQTT客户端的会话数据
+// 同一会话可能被多个连接协程同时访问，修改字段需通过方法进行
 type SessionData struct {
-	ClientID       string              `bson:"client_id"`       // 客户端ID
-	TempSession    bool                `bson:"temp_session"`    // 是否为临时会话
-	Subscriptions  map[string]byte     `bson:"subscriptions"`   // 订阅的主题和QoS级别
-	PendingPublish map[uint16]string   `bson:"pending_publish"` // 待确认的QoS 1/2消息
-	PendingPubrel  map[uint16]struct{} `bson:"pending_pubrel"`  // 等待PUBREL的QoS 2消息
-	InflightQoS2   map[uint16]string   `bson:"inflight_qos2"`   // 已发送但未完成的QoS 2消息
+	mu             sync.Mutex           // 保护以下map字段与changes
+	changes        []SubscriptionChange // 上次保存以来的订阅变更，与会话一起提交
+	ClientID       string               `bson:"client_id"`       // 客户端ID
+	TempSession    bool                 `bson:"temp_session"`    // 是否为临时会话
+	Subscriptions  map[string]byte      `bson:"subscriptions"`   // 订阅的主题和QoS级别
+	PendingPublish map[uint16]string    `bson:"pending_publish"` // 待确认的QoS 2消息
+	PendingPubrel  map[uint16]struct{}  `bson:"pending_pubrel"`  // 等待PUBREL的QoS 2消息
+	InflightQoS2   map[uint16]string    `bson:"inflight_qos2"`   // 已发送但未完成的QoS 2消息
 }
 
 // NewSessionData 创建新的会话数据实例
@@ -25,35 +33,133 @@ func NewSessionData(clientID string) *SessionData {
 	}
 }
 
+// snapshot 返回会话数据的副本，用于序列化
+func (session *SessionData) snapshot() *SessionData {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	return &SessionData{
+		ClientID:       session.ClientID,
+		TempSession:    session.TempSession,
+		Subscriptions:  maps.Clone(session.Subscriptions),
+		PendingPublish: maps.Clone(session.PendingPublish),
+		PendingPubrel:  maps.Clone(session.PendingPubrel),
+		InflightQoS2:   maps.Clone(session.InflightQoS2),
+	}
+}
+
+// commitSnapshot 返回会话数据的副本与上次保存以来的订阅变更，并清空变更
+func (session *SessionData) commitSnapshot() (*SessionData, []SubscriptionChange) {
+	snapshot := session.snapshot()
+	return snapshot, session.takeChanges()
+}
+
+// takeChanges 取出并清空上次保存以来的订阅变更
+func (session *SessionData) takeChanges() []SubscriptionChange {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	changes := session.changes
+	session.changes = nil
+	return changes
+}
+
+// subscriptionList 返回会话的全部订阅
+func (session *SessionData) subscriptionList() []Subscription {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	subscriptions := make([]Subscription, 0, len(session.Subscriptions))
+	for topicName, qos := range session.Subscriptions {
+		subscriptions = append(subscriptions, Subscription{ClientID: session.ClientID, TopicName: topicName, QoSLevel: qos})
+	}
+	return subscriptions
+}
+
 // Save 保存会话数据到数据库
 func (session *SessionData) Save() bool {
-	return store.SaveSession(session)
+	return store.Load().SaveSession(session)
 }
 
 // AddSubscription 添加主题订阅
+// 内存订阅树立即生效，存储后端中的订阅树在下次保存会话时与会话一起写入
 func (session *SessionData) AddSubscription(subscription *Subscription) {
 	subscription.ClientID = session.ClientID
-	err := store.InsertSubscription(subscription)
+	err := store.Load().InsertSubscription(subscription)
 	if err != nil {
 		logger.ErrorF("Error while inserting subscription %v", err)
+		return
+	}
+	session.mu.Lock()
+	if session.Subscriptions == nil {
+		session.Subscriptions = make(map[string]byte)
 	}
 	session.Subscriptions[subscription.TopicName] = subscription.QoSLevel
+	session.changes = append(session.changes, SubscriptionChange{Subscription: *subscription})
+	session.mu.Unlock()
 }
 
 // RemoveSubscription 移除主题订阅
+// 内存订阅树立即生效，存储后端中的订阅树在下次保存会话时与会话一起写入
 func (session *SessionData) RemoveSubscription(subscription *Subscription) {
 	subscription.ClientID = session.ClientID
-	store.DeleteSubscription(subscription)
+	store.Load().DeleteSubscription(subscription)
+	session.mu.Lock()
 	delete(session.Subscriptions, subscription.TopicName)
+	session.changes = append(session.changes, SubscriptionChange{Subscription: *subscription, Remove: true})
+	session.mu.Unlock()
 }
 
-// RemoveAllSubscriptions 移除所有主题订阅
+// RemoveAllSubscriptions 从内存订阅树中移除所有主题订阅
 func (session *SessionData) RemoveAllSubscriptions() {
-	for k, v := range session.Subscriptions {
-		store.DeleteSubscription(&Subscription{
-			ClientID:  session.ClientID,
-			TopicName: k,
-			QoSLevel:  v,
+	for _, subscription := range session.subscriptionList() {
+		store.Load().DeleteSubscription(&subscription)
+	}
+}
+
+// AddPendingPublish 记录一条待确认的QoS 2消息
+// 持久会话只写入这一字段的变更，按配置的持久化模式落盘
+func (session *SessionData) AddPendingPublish(packetID uint16, topicName string) {
+	session.mu.Lock()
+	if session.PendingPublish == nil {
+		session.PendingPublish = make(map[uint16]string)
+	}
+	session.PendingPublish[packetID] = topicName
+	session.mu.Unlock()
+	if !session.TempSession {
+		store.Load().writer.update(session, func(update *SessionUpdate) {
+			update.setPendingPublish(packetID, topicName)
+		})
+	}
+}
+
+// RemovePendingPublish 移除一条已确认的QoS 2消息
+func (session *SessionData) RemovePendingPublish(packetID uint16) {
+	session.mu.Lock()
+	delete(session.PendingPublish, packetID)
+	session.mu.Unlock()
+	if !session.TempSession {
+		store.Load().writer.update(session, func(update *SessionUpdate) {
+			update.unsetPendingPublish(packetID)
 		})
 	}
 }
+
+// SubscriptionCount 返回会话的订阅数量
+func (session *SessionData) SubscriptionCount() int {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	return len(session.Subscriptions)
+}
+
+// HasPendingPublish 返回报文ID对应的QoS 2消息是否仍在等待PUBREL
+func (session *SessionData) HasPendingPublish(packetID uint16) bool {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	_, ok := session.PendingPublish[packetID]
+	return ok
+}
+
+// PendingPublishCount 返回会话中待确认的QoS 2消息数量
+func (session *SessionData) PendingPublishCount() int {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	return len(session.PendingPublish)
+}
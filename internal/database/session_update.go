@@ -0,0 +1,80 @@
+// Package database 实现了MQTT服务器的数据存储功能
+package database
+
+import (
+	"strconv"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// SessionUpdate 会话的字段级变更，同一会话的多次变更合并后批量写入
+// 只修改变更涉及的字段，不存在的会话不会被创建
+type SessionUpdate struct {
+	ClientID            string
+	SetPendingPublish   map[uint16]string   // 新增的待确认消息
+	UnsetPendingPublish map[uint16]struct{} // 已确认的待确认消息
+}
+
+// newSessionUpdate 创建空的会话变更
+func newSessionUpdate(clientID string) *SessionUpdate {
+	return &SessionUpdate{
+		ClientID:            clientID,
+		SetPendingPublish:   make(map[uint16]string),
+		UnsetPendingPublish: make(map[uint16]struct{}),
+	}
+}
+
+// setPendingPublish 记录新增的待确认消息，覆盖之前对同一报文ID的删除
+func (u *SessionUpdate) setPendingPublish(packetID uint16, topicName string) {
+	delete(u.UnsetPendingPublish, packetID)
+	u.SetPendingPublish[packetID] = topicName
+}
+
+// unsetPendingPublish 记录删除的待确认消息，覆盖之前对同一报文ID的新增
+func (u *SessionUpdate) unsetPendingPublish(packetID uint16) {
+	delete(u.SetPendingPublish, packetID)
+	u.UnsetPendingPublish[packetID] = struct{}{}
+}
+
+// empty 返回变更是否为空
+func (u *SessionUpdate) empty() bool {
+	return len(u.SetPendingPublish) == 0 && len(u.UnsetPendingPublish) == 0
+}
+
+// apply 将变更应用到会话数据，供不支持字段级更新的存储后端使用
+func (u *SessionUpdate) apply(session *SessionData) {
+	if session.PendingPublish == nil {
+		session.PendingPublish = make(map[uint16]string)
+	}
+	for packetID, topicName := range u.SetPendingPublish {
+		session.PendingPublish[packetID] = topicName
+	}
+	for packetID := range u.UnsetPendingPublish {
+		delete(session.PendingPublish, packetID)
+	}
+}
+
+// document 返回MongoDB的$set/$unset更新文档
+func (u *SessionUpdate) document() bson.D {
+	var update bson.D
+	if len(u.SetPendingPublish) > 0 {
+		set := make(bson.D, 0, len(u.SetPendingPublish))
+		for packetID, topicName := range u.SetPendingPublish {
+			set = append(set, bson.E{Key: pendingPublishField(packetID), Value: topicName})
+		}
+		update = append(update, bson.E{Key: "$set", Value: set})
+	}
+	if len(u.UnsetPendingPublish) > 0 {
+		unset := make(bson.D, 0, len(u.UnsetPendingPublish))
+		for packetID := range u.UnsetPendingPublish {
+			unset = append(unset, bson.E{Key: pendingPublishField(packetID), Value: ""})
+		}
+		update = append(update, bson.E{Key: "$unset", Value: unset})
+	}
+	return update
+}
+
+// pendingPublishField 返回待确认消息在会话文档中的字段路径
+func pendingPublishField(packetID uint16) string {
+	return "pending_publish." + strconv.Itoa(int(packetID))
+}
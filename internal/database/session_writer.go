@@ -0,0 +1,179 @@
+// Package database 实现了MQTT服务器的数据存储功能
+package database
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	c "github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/config"
+	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/logger"
+)
+
+// pendingUpdate 等待写入的会话变更
+type pendingUpdate struct {
+	session *SessionData
+	update  *SessionUpdate
+}
+
+// sessionWriter 合并会话的字段级变更，按持久化模式批量写入存储后端
+// 代替每条QoS 2消息一次的整文档替换
+type sessionWriter struct {
+	store     *DBStore
+	mode      string        // 持久化模式
+	interval  time.Duration // periodic模式下的写入间隔
+	batchSize int           // 单次批量写入的最大会话数
+	mu        sync.Mutex    // 保护pending
+	pending   map[string]*pendingUpdate
+	notify    chan struct{} // 通知写入协程立即写入
+	stop      chan struct{}
+	done      chan struct{}
+	stopOnce  sync.Once
+}
+
+// newSessionWriter 创建会话写入器，sync以外的模式需要调用start启动写入协程
+func newSessionWriter(store *DBStore, mode string, interval time.Duration, batchSize int) *sessionWriter {
+	if interval <= 0 {
+		interval = time.Second
+	}
+	if batchSize <= 0 {
+		batchSize = 1
+	}
+	return &sessionWriter{
+		store:     store,
+		mode:      mode,
+		interval:  interval,
+		batchSize: batchSize,
+		pending:   make(map[string]*pendingUpdate),
+		notify:    make(chan struct{}, 1),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+// start 启动写入协程
+func (w *sessionWriter) start() {
+	if w.mode == c.DurabilitySync {
+		close(w.done)
+		return
+	}
+	go w.run()
+}
+
+// update 记录会话的一次变更
+// sync模式下同步写入，其余模式合并到同一会话的待写入变更中
+func (w *sessionWriter) update(session *SessionData, change func(update *SessionUpdate)) {
+	if w.mode == c.DurabilitySync {
+		update := newSessionUpdate(session.ClientID)
+		change(update)
+		w.store.persistUpdates([]*pendingUpdate{{session: session, update: update}})
+		return
+	}
+
+	w.mu.Lock()
+	pending, ok := w.pending[session.ClientID]
+	if !ok {
+		pending = &pendingUpdate{session: session, update: newSessionUpdate(session.ClientID)}
+		w.pending[session.ClientID] = pending
+	}
+	change(pending.update)
+	size := len(w.pending)
+	w.mu.Unlock()
+
+	if w.mode == c.DurabilityAsync || size >= w.batchSize {
+		select {
+		case w.notify <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// discard 丢弃会话尚未写入的变更，会话被删除时调用
+func (w *sessionWriter) discard(clientID string) {
+	w.mu.Lock()
+	delete(w.pending, clientID)
+	w.mu.Unlock()
+}
+
+// run 写入协程，定时或收到通知时写入所有待写入的变更
+func (w *sessionWriter) run() {
+	defer close(w.done)
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stop:
+			w.flush()
+			return
+		case <-ticker.C:
+			w.flush()
+		case <-w.notify:
+			w.flush()
+		}
+	}
+}
+
+// flush 取出所有待写入的变更并分批写入
+func (w *sessionWriter) flush() {
+	w.mu.Lock()
+	if len(w.pending) == 0 {
+		w.mu.Unlock()
+		return
+	}
+	pending := w.pending
+	w.pending = make(map[string]*pendingUpdate, len(pending))
+	w.mu.Unlock()
+
+	batch := make([]*pendingUpdate, 0, min(len(pending), w.batchSize))
+	for _, update := range pending {
+		batch = append(batch, update)
+		if len(batch) == w.batchSize {
+			w.store.persistUpdates(batch)
+			batch = batch[:0:0]
+		}
+	}
+	if len(batch) > 0 {
+		w.store.persistUpdates(batch)
+	}
+}
+
+// Invoke 停止写入协程并写入剩余的变更
+func (w *sessionWriter) Invoke(ctx context.Context) error {
+	w.stopOnce.Do(func() { close(w.stop) })
+	select {
+	case <-w.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// persistUpdates 批量写入会话的字段级变更
+// 存储不可用或缓冲区中仍有未重放的写操作时，改为缓冲会话的完整快照，快照已包含这些变更
+func (ds *DBStore) persistUpdates(batch []*pendingUpdate) {
+	if ds.buffer.Len() == 0 && ds.breaker.allow() {
+		updates := make([]*SessionUpdate, len(batch))
+		for i, pending := range batch {
+			updates[i] = pending.update
+		}
+		ctx, cancel := operationContext()
+		err := ds.backend.UpdateSessions(ctx, updates)
+		cancel()
+		if !isOutage(err) {
+			ds.breaker.success()
+			if err != nil {
+				handleErr(err)
+			}
+			return
+		}
+		ds.breaker.failure(err)
+		logger.WarnF("Writing %d session updates failed, buffering until storage recovers: %v", len(batch), err)
+	}
+	for _, pending := range batch {
+		session := pending.session
+		snapshot := session.snapshot()
+		ds.buffer.add(sessionWriteKey(session.ClientID), session, func(ctx context.Context) error {
+			return ds.backend.SaveSession(ctx, snapshot)
+		})
+	}
+}
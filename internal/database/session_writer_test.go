@@ -0,0 +1,131 @@
+package database
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	c "github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/config"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// countingBackend 统计整个会话提交与批量更新次数的内存后端
+type countingBackend struct {
+	*MemoryBackend
+	commits atomic.Int32
+	batches atomic.Int32
+}
+
+func (b *countingBackend) CommitSession(ctx context.Context, commit *SessionCommit) error {
+	b.commits.Add(1)
+	return b.MemoryBackend.CommitSession(ctx, commit)
+}
+
+func (b *countingBackend) UpdateSessions(ctx context.Context, updates []*SessionUpdate) error {
+	b.batches.Add(1)
+	return b.MemoryBackend.UpdateSessions(ctx, updates)
+}
+
+func TestSessionUpdateCoalesces(t *testing.T) {
+	update := newSessionUpdate("client")
+	update.setPendingPublish(1, "a")
+	update.setPendingPublish(2, "b")
+	update.unsetPendingPublish(1)
+	update.unsetPendingPublish(3)
+	update.setPendingPublish(3, "c")
+
+	// map遍历顺序不确定，只比较各操作符下的字段
+	got := update.document()
+	if len(got) != 2 || got[0].Key != "$set" || got[1].Key != "$unset" {
+		t.Fatalf("document() = %v, want $set and $unset", got)
+	}
+	if set := got[0].Value.(bson.D); len(set) != 2 {
+		t.Fatalf("$set = %v, want pending_publish.2 and pending_publish.3", set)
+	}
+	if unset := got[1].Value.(bson.D); len(unset) != 1 || unset[0].Key != "pending_publish.1" {
+		t.Fatalf("$unset = %v, want pending_publish.1", unset)
+	}
+}
+
+func TestSessionWriterDurabilityModes(t *testing.T) {
+	const messages = 100
+	tests := []struct {
+		mode        string
+		beforeFlush int32 // 调用Invoke之前的批量写入次数
+		afterFlush  int32 // 调用Invoke之后的批量写入次数
+	}{
+		// 同步模式下每次变更写入一次，包括删除
+		{c.DurabilitySync, messages + 1, messages + 1},
+		// 定时模式下所有变更合并为一次写入
+		{c.DurabilityPeriodic, 0, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			backend := &countingBackend{MemoryBackend: NewMemoryBackend()}
+			ds := useStore(t, newDBStore(backend, testConfig(tt.mode)))
+			ds.writer.start()
+
+			session := NewSessionData("client")
+			ds.SaveSession(session)
+			for i := 1; i <= messages; i++ {
+				session.AddPendingPublish(uint16(i), "a/b")
+			}
+			session.RemovePendingPublish(1)
+
+			if batches := backend.batches.Load(); batches != tt.beforeFlush {
+				t.Fatalf("batches before flush = %d, want %d", batches, tt.beforeFlush)
+			}
+			if err := ds.writer.Invoke(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if batches := backend.batches.Load(); batches != tt.afterFlush {
+				t.Fatalf("batches after flush = %d, want %d", batches, tt.afterFlush)
+			}
+			if commits := backend.commits.Load(); commits != 1 {
+				t.Fatalf("whole session committed %d times, want 1", commits)
+			}
+			persisted, err := backend.LoadSession(context.Background(), "client")
+			if err != nil || len(persisted.PendingPublish) != messages-1 || persisted.PendingPublish[1] != "" {
+				t.Fatalf("persisted session = %+v, %v", persisted, err)
+			}
+		})
+	}
+}
+
+func TestSessionWriterAsyncFlushesWithoutInterval(t *testing.T) {
+	backend := &countingBackend{MemoryBackend: NewMemoryBackend()}
+	ds := useStore(t, newDBStore(backend, testConfig(c.DurabilityAsync)))
+	ds.writer.start()
+	defer ds.writer.Invoke(context.Background())
+
+	session := NewSessionData("client")
+	ds.SaveSession(session)
+	session.AddPendingPublish(1, "a/b")
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if persisted, _ := backend.LoadSession(context.Background(), "client"); persisted.PendingPublish[1] == "a/b" {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("async update was not written before the flush interval")
+}
+
+func TestSessionWriterDiscardsDeletedSession(t *testing.T) {
+	backend := &countingBackend{MemoryBackend: NewMemoryBackend()}
+	ds := useStore(t, newDBStore(backend, testConfig(c.DurabilityPeriodic)))
+	ds.writer.start()
+
+	session := NewSessionData("client")
+	ds.SaveSession(session)
+	session.AddPendingPublish(1, "a/b")
+	ds.DeleteSession("client")
+	if err := ds.writer.Invoke(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if batches := backend.batches.Load(); batches != 0 {
+		t.Fatalf("updates of a deleted session were written %d times", batches)
+	}
+}
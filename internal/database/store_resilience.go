@@ -0,0 +1,236 @@
+// Package database 实现了MQTT服务器的数据存储功能
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/logger"
+)
+
+// recoveryInterval 降级模式下检查断路器并重放缓冲写操作的间隔
+const recoveryInterval = time.Second
+
+var (
+	// ErrStorageUnavailable 断路器打开时访问存储后端返回的错误
+	ErrStorageUnavailable = errors.New("storage backend unavailable")
+	// ErrStorageNotOpen 存储尚未打开时就绪检查返回的错误
+	ErrStorageNotOpen = errors.New("storage is not open")
+)
+
+// StorageHealth 描述了存储后端的健康状态
+type StorageHealth struct {
+	Backend       string       // 存储后端名称
+	Degraded      bool         // 是否处于降级模式，断路器未关闭或仍有未重放的写操作
+	State         BreakerState // 断路器状态
+	Since         time.Time    // 当前断路器状态的开始时间
+	PendingWrites int          // 等待重放的写操作数
+	DroppedWrites uint64       // 因缓冲区已满丢弃的写操作数
+	LastError     string       // 最近一次导致失败的存储错误
+}
+
+// 缓冲区中各类写操作的键
+func sessionWriteKey(clientID string) string { return "session/" + clientID }
+func willWriteKey(clientID string) string    { return "will/" + clientID }
+func subscriptionWriteKey(subscription Subscription) string {
+	return "subscription/" + subscriptionKey(subscription)
+}
+
+// Health 返回存储后端的健康状态
+func (ds *DBStore) Health() StorageHealth {
+	state, since, lastError := ds.breaker.status()
+	health := StorageHealth{
+		Backend:       ds.backend.Name(),
+		State:         state,
+		Since:         since,
+		PendingWrites: ds.buffer.Len(),
+		DroppedWrites: ds.buffer.dropped.Load(),
+	}
+	health.Degraded = state != BreakerClosed || health.PendingWrites > 0
+	if lastError != nil {
+		health.LastError = lastError.Error()
+	}
+	return health
+}
+
+// Ping 通过断路器探测存储后端是否可用，断路器打开时直接返回 ErrStorageUnavailable
+func (ds *DBStore) Ping() error {
+	return ds.load(ds.backend.Ping)
+}
+
+// Ready 存储的就绪检查，全局存储实例已打开并且存储后端可用时返回nil
+func Ready(context.Context) error {
+	ds := store.Load()
+	if ds == nil {
+		return ErrStorageNotOpen
+	}
+	return ds.Ping()
+}
+
+// persist 通过断路器执行一次写操作
+// 断路器打开、写入因存储不可用失败或缓冲区中仍有未重放的写操作时，写操作进入缓冲区并视为成功，
+// 保证同一对象的写操作按发生顺序落盘
+func (ds *DBStore) persist(key string, value any, apply func(ctx context.Context) error) error {
+	if ds.buffer.Len() == 0 && ds.breaker.allow() {
+		ctx, cancel := operationContext()
+		err := apply(ctx)
+		cancel()
+		if !isOutage(err) {
+			ds.breaker.success()
+			return err
+		}
+		ds.breaker.failure(err)
+		logger.WarnF("Storage write of %s failed, buffering until storage recovers: %v", key, err)
+	}
+	ds.buffer.add(key, value, apply)
+	return nil
+}
+
+// commit 通过断路器写入会话提交
+// 存储不可用时会话与每个订阅变更分别进入缓冲区，重放不再是原子的，恢复后由一致性校验修复可能的偏差
+func (ds *DBStore) commit(commit *SessionCommit, value any) error {
+	ds.commitMu.RLock()
+	defer ds.commitMu.RUnlock()
+	if ds.buffer.Len() == 0 && ds.breaker.allow() {
+		ctx, cancel := operationContext()
+		err := ds.backend.CommitSession(ctx, commit)
+		cancel()
+		if !isOutage(err) {
+			ds.breaker.success()
+			return err
+		}
+		ds.breaker.failure(err)
+		logger.WarnF("Storage commit of session %s failed, buffering until storage recovers: %v", commit.ClientID, err)
+	}
+
+	// 删除订阅先于会话写入，新增订阅晚于会话写入，与存储后端的写入顺序一致
+	bufferChange := func(change SubscriptionChange) {
+		ds.buffer.add(subscriptionWriteKey(change.Subscription), nil, func(ctx context.Context) error {
+			return ds.backend.CommitSession(ctx, &SessionCommit{ClientID: commit.ClientID, Changes: []SubscriptionChange{change}})
+		})
+	}
+	for _, change := range commit.Changes {
+		if change.Remove {
+			bufferChange(change)
+		}
+	}
+	sessionOnly := &SessionCommit{ClientID: commit.ClientID, Session: commit.Session, Delete: commit.Delete}
+	ds.buffer.add(sessionWriteKey(commit.ClientID), value, func(ctx context.Context) error {
+		return ds.backend.CommitSession(ctx, sessionOnly)
+	})
+	for _, change := range commit.Changes {
+		if !change.Remove {
+			bufferChange(change)
+		}
+	}
+	return nil
+}
+
+// load 通过断路器执行一次读操作，断路器打开时直接返回 ErrStorageUnavailable
+func (ds *DBStore) load(fetch func(ctx context.Context) error) error {
+	if !ds.breaker.allow() {
+		return ErrStorageUnavailable
+	}
+	ctx, cancel := operationContext()
+	defer cancel()
+	err := fetch(ctx)
+	if isOutage(err) {
+		ds.breaker.failure(err)
+		return err
+	}
+	ds.breaker.success()
+	return err
+}
+
+// replay 按顺序重放缓冲的写操作，遇到存储不可用时停止并打开断路器
+func (ds *DBStore) replay(ctx context.Context) error {
+	replayed := 0
+	for {
+		write, ok := ds.buffer.front()
+		if !ok {
+			break
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		opCtx, cancel := context.WithTimeout(ctx, OperationTimeout)
+		ds.commitMu.RLock()
+		err := write.apply(opCtx)
+		ds.commitMu.RUnlock()
+		cancel()
+		if isOutage(err) {
+			ds.breaker.failure(err)
+			return err
+		}
+		ds.breaker.success()
+		if err != nil {
+			logger.ErrorF("Dropping buffered write of %s: %v", write.key, err)
+		}
+		ds.buffer.done(write)
+		replayed++
+	}
+	if replayed > 0 {
+		logger.InfoF("Replayed %d buffered storage writes", replayed)
+		ds.drifted.Store(true)
+	}
+	return nil
+}
+
+// recover 在降级模式下探测存储后端，可用时重放缓冲的写操作
+func (ds *DBStore) recover() {
+	state, _, _ := ds.breaker.status()
+	if state == BreakerClosed && ds.buffer.Len() == 0 {
+		return
+	}
+	if !ds.breaker.allow() {
+		return
+	}
+	if ds.buffer.Len() == 0 {
+		ctx, cancel := operationContext()
+		err := ds.backend.Ping(ctx)
+		cancel()
+		if err != nil {
+			ds.breaker.failure(err)
+			return
+		}
+		ds.breaker.success()
+		return
+	}
+	_ = ds.replay(context.Background())
+}
+
+// runRecovery 周期性执行恢复检查，直到stop关闭
+func (ds *DBStore) runRecovery(stop <-chan struct{}) {
+	ticker := time.NewTicker(recoveryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			ds.recover()
+		}
+	}
+}
+
+// WriteBufferFlushCallback 在关闭阶段停止后台协程并尝试写入缓冲区中剩余的写操作
+type WriteBufferFlushCallback struct {
+	store *DBStore
+}
+
+// Invoke 执行缓冲区写入，存储仍不可用时返回丢失的写操作数
+func (cb *WriteBufferFlushCallback) Invoke(ctx context.Context) error {
+	if err := cb.store.stopBackground(ctx); err != nil {
+		return err
+	}
+	if cb.store.buffer.Len() == 0 {
+		return nil
+	}
+	logger.InfoF("Flushing %d buffered storage writes", cb.store.buffer.Len())
+	if err := cb.store.replay(ctx); err != nil {
+		return fmt.Errorf("%d buffered storage writes lost: %v", cb.store.buffer.Len(), err)
+	}
+	return nil
+}
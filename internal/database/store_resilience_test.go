@@ -0,0 +1,237 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	c "github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/config"
+)
+
+var errBackendDown = errors.New("connection refused")
+
+// flakyBackend 可以模拟存储不可用的内存后端
+type flakyBackend struct {
+	*MemoryBackend
+	down atomic.Bool
+}
+
+func (b *flakyBackend) check() error {
+	if b.down.Load() {
+		return errBackendDown
+	}
+	return nil
+}
+
+func (b *flakyBackend) Ping(ctx context.Context) error {
+	return b.check()
+}
+
+func (b *flakyBackend) LoadSession(ctx context.Context, clientID string) (*SessionData, error) {
+	if err := b.check(); err != nil {
+		return nil, err
+	}
+	return b.MemoryBackend.LoadSession(ctx, clientID)
+}
+
+func (b *flakyBackend) SaveSession(ctx context.Context, session *SessionData) error {
+	if err := b.check(); err != nil {
+		return err
+	}
+	return b.MemoryBackend.SaveSession(ctx, session)
+}
+
+func (b *flakyBackend) DeleteSession(ctx context.Context, clientID string) error {
+	if err := b.check(); err != nil {
+		return err
+	}
+	return b.MemoryBackend.DeleteSession(ctx, clientID)
+}
+
+func (b *flakyBackend) CommitSession(ctx context.Context, commit *SessionCommit) error {
+	if err := b.check(); err != nil {
+		return err
+	}
+	return b.MemoryBackend.CommitSession(ctx, commit)
+}
+
+func (b *flakyBackend) SaveSubscription(ctx context.Context, subscription Subscription) error {
+	if err := b.check(); err != nil {
+		return err
+	}
+	return b.MemoryBackend.SaveSubscription(ctx, subscription)
+}
+
+func (b *flakyBackend) SaveWillMessage(ctx context.Context, message *WillMessage) error {
+	if err := b.check(); err != nil {
+		return err
+	}
+	return b.MemoryBackend.SaveWillMessage(ctx, message)
+}
+
+// useFlakyStore 使用可模拟故障的后端替换全局存储，断路器在一次失败后打开且无冷却时间
+func useFlakyStore(t *testing.T) (*DBStore, *flakyBackend) {
+	t.Helper()
+	backend := &flakyBackend{MemoryBackend: NewMemoryBackend()}
+	ds := useStore(t, newDBStore(backend, testConfig(c.DurabilitySync)))
+	ds.breaker = newCircuitBreaker(1, 0)
+	return ds, backend
+}
+
+func TestDegradedWritesAreBufferedAndReplayed(t *testing.T) {
+	ds, backend := useFlakyStore(t)
+	backend.down.Store(true)
+
+	session := NewSessionData("client")
+	session.AddSubscription(&Subscription{TopicName: "a/b", QoSLevel: 1})
+	if !ds.SaveSession(session) {
+		t.Fatal("SaveSession failed while storage is down")
+	}
+	if !ds.SaveWillMessage(&WillMessage{ClientID: "client", Topic: []byte("will"), QoS: 1}) {
+		t.Fatal("SaveWillMessage failed while storage is down")
+	}
+
+	health := ds.Health()
+	if !health.Degraded || health.State != BreakerOpen || health.PendingWrites != 3 {
+		t.Fatalf("Health() = %+v, want degraded with 3 pending writes", health)
+	}
+	// 路由只依赖内存订阅树，存储不可用时仍然可用
+	if subscriptions, _ := ds.MatchTopic("a/b"); len(subscriptions) != 1 {
+		t.Fatalf("MatchTopic returned %d subscriptions while degraded", len(subscriptions))
+	}
+	// 缓存淘汰后仍能从缓冲区取回会话
+	ds.sessionCache.Purge()
+	if got, err := ds.GetSession("client"); err != nil || got != session {
+		t.Fatalf("GetSession() = %v, %v, want the buffered session", got, err)
+	}
+	// 无法确认会话是否存在，不能当作新客户端
+	if got, err := ds.GetSession("unknown"); err == nil {
+		t.Fatalf("GetSession() = %v while degraded, want an error", got)
+	}
+
+	backend.down.Store(false)
+	ds.recover()
+
+	health = ds.Health()
+	if health.Degraded || health.PendingWrites != 0 {
+		t.Fatalf("Health() = %+v after recovery, want healthy", health)
+	}
+	persisted, err := backend.LoadSession(context.Background(), "client")
+	if err != nil || persisted.Subscriptions["a/b"] != 1 {
+		t.Fatalf("session not replayed: %+v, %v", persisted, err)
+	}
+	if will, err := backend.LoadWillMessage(context.Background(), "client"); err != nil || string(will.Topic) != "will" {
+		t.Fatalf("will message not replayed: %+v, %v", will, err)
+	}
+	subscriptions, err := backend.LoadSubscriptions(context.Background())
+	if err != nil || len(subscriptions) != 1 {
+		t.Fatalf("subscription not replayed: %+v, %v", subscriptions, err)
+	}
+}
+
+func TestDegradedWritesAreCoalesced(t *testing.T) {
+	ds, backend := useFlakyStore(t)
+	if !ds.SaveSession(NewSessionData("client")) {
+		t.Fatal("SaveSession failed")
+	}
+	backend.down.Store(true)
+
+	session := NewSessionData("client")
+	session.AddPendingPublish(1, "a/b")
+	ds.SaveSession(session)
+	ds.DeleteSession("client")
+	if pending := ds.Health().PendingWrites; pending != 1 {
+		t.Fatalf("PendingWrites = %d, want 1", pending)
+	}
+	// 等待删除的会话视为不存在
+	if got, err := ds.GetSession("client"); got != nil || err != nil {
+		t.Fatalf("GetSession() = %v, %v for a session pending deletion", got, err)
+	}
+
+	backend.down.Store(false)
+	ds.recover()
+	if _, err := backend.LoadSession(context.Background(), "client"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("LoadSession after replay = %v, want ErrNotFound", err)
+	}
+}
+
+func TestWriteBufferDropsOldest(t *testing.T) {
+	buffer := newWriteBuffer(2)
+	noop := func(ctx context.Context) error { return nil }
+	buffer.add("a", nil, noop)
+	buffer.add("b", nil, noop)
+	buffer.add("a", 1, noop)
+	buffer.add("c", nil, noop)
+
+	if buffer.Len() != 2 || buffer.dropped.Load() != 1 {
+		t.Fatalf("Len() = %d, dropped = %d, want 2 and 1", buffer.Len(), buffer.dropped.Load())
+	}
+	if _, ok := buffer.pending("b"); ok {
+		t.Fatal("oldest write was not dropped")
+	}
+	if write, _ := buffer.front(); write.key != "a" || write.value != 1 {
+		t.Fatalf("front() = %+v, want latest write of a", write)
+	}
+}
+
+func TestCircuitBreakerTransitions(t *testing.T) {
+	breaker := newCircuitBreaker(2, time.Hour)
+	steps := []struct {
+		name  string
+		apply func()
+		state BreakerState
+		allow bool
+	}{
+		{"first failure", func() { breaker.failure(errBackendDown) }, BreakerClosed, true},
+		{"threshold reached", func() { breaker.failure(errBackendDown) }, BreakerOpen, false},
+		{"cooldown elapsed", func() { breaker.cooldown = 0 }, BreakerOpen, true},
+		{"probe in flight", func() {}, BreakerHalfOpen, false},
+		{"probe failed", func() { breaker.failure(errBackendDown) }, BreakerOpen, true},
+		{"probe succeeded", func() { breaker.success() }, BreakerClosed, true},
+	}
+	for _, step := range steps {
+		step.apply()
+		if state, _, _ := breaker.status(); state != step.state {
+			t.Fatalf("%s: state = %s, want %s", step.name, state, step.state)
+		}
+		if allow := breaker.allow(); allow != step.allow {
+			t.Fatalf("%s: allow() = %v, want %v", step.name, allow, step.allow)
+		}
+	}
+}
+
+func TestIsOutage(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{ErrNotFound, false},
+		{ClientIdEmptyError, false},
+		{context.DeadlineExceeded, true},
+		{errBackendDown, true},
+	}
+	for _, tt := range tests {
+		if got := isOutage(tt.err); got != tt.want {
+			t.Errorf("isOutage(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestReady(t *testing.T) {
+	ds, backend := useFlakyStore(t)
+	ds.breaker = newCircuitBreaker(1, time.Hour)
+	if err := Ready(context.Background()); err != nil {
+		t.Fatalf("Ready() = %v", err)
+	}
+	backend.down.Store(true)
+	if err := Ready(context.Background()); !errors.Is(err, errBackendDown) {
+		t.Fatalf("Ready() during outage = %v, want %v", err, errBackendDown)
+	}
+	// 断路器打开后不再访问存储后端
+	if err := Ready(context.Background()); !errors.Is(err, ErrStorageUnavailable) {
+		t.Fatalf("Ready() with open breaker = %v, want %v", err, ErrStorageUnavailable)
+	}
+}
@@ -0,0 +1,206 @@
+// Package database 实现了MQTT服务器的数据存储功能
+package database
+
+import (
+	"hash/fnv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+)
+
+const (
+	defaultIndexShards    = 16    // 默认订阅索引分片数
+	defaultMatchCacheSize = 10000 // 默认匹配结果缓存的主题数
+)
+
+// cachedMatch 缓存的匹配结果及计算时分片与通配符分片的世代
+type cachedMatch struct {
+	results    []Subscription
+	generation uint64
+	wildcard   uint64
+}
+
+// indexShard 订阅索引的一个分片，包含一棵订阅树与第一层落在此分片的主题的匹配结果缓存
+type indexShard struct {
+	trie       *TopicTrie
+	version    atomic.Uint64                   // 订阅树每次变更后递增，用于丢弃变更期间计算的匹配结果
+	generation atomic.Uint64                   // 含通配符的过滤器变更后递增，之前缓存的结果全部失效
+	mu         sync.Mutex                      // 串行化缓存写入与失效
+	cache      *lru.Cache[string, cachedMatch] // key=发布主题, value=匹配结果，为nil时不缓存
+}
+
+// SubscriptionIndex 按主题第一层分片的内存订阅索引
+// 第一层为通配符的订阅保存在单独的分片中，匹配时与主题所在分片的结果合并；
+// 不同分片的写入与缓存互不影响，热点主题的重复匹配直接返回缓存结果
+type SubscriptionIndex struct {
+	shards   []*indexShard
+	wildcard *indexShard // 第一层为 "+" 或 "#" 的订阅
+	hits     atomic.Uint64
+	misses   atomic.Uint64
+}
+
+// NewSubscriptionIndex 创建订阅索引，cacheSize为所有分片缓存的主题总数，0表示不缓存
+func NewSubscriptionIndex(shards int, cacheSize int) *SubscriptionIndex {
+	if shards <= 0 {
+		shards = defaultIndexShards
+	}
+	newShard := func(size int) *indexShard {
+		shard := &indexShard{trie: NewTopicTrie()}
+		if size > 0 {
+			shard.cache, _ = lru.New[string, cachedMatch](size)
+		}
+		return shard
+	}
+	index := &SubscriptionIndex{shards: make([]*indexShard, shards)}
+	perShard := 0
+	if cacheSize > 0 {
+		perShard = max(cacheSize/shards, 1)
+	}
+	for i := range index.shards {
+		index.shards[i] = newShard(perShard)
+	}
+	index.wildcard = newShard(0)
+	return index
+}
+
+// firstLevel 返回主题或过滤器的第一层
+func firstLevel(topic string) string {
+	if index := strings.IndexByte(topic, '/'); index >= 0 {
+		return topic[:index]
+	}
+	return topic
+}
+
+// shardOf 返回第一层为level的主题所在的分片
+func (idx *SubscriptionIndex) shardOf(level string) *indexShard {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(level))
+	return idx.shards[hash.Sum32()%uint32(len(idx.shards))]
+}
+
+// filterShard 返回保存过滤器的分片
+func (idx *SubscriptionIndex) filterShard(filter string) *indexShard {
+	if level := firstLevel(filter); level != "+" && level != "#" {
+		return idx.shardOf(level)
+	}
+	return idx.wildcard
+}
+
+// Insert 插入或更新订阅，返回是否为新增订阅
+func (idx *SubscriptionIndex) Insert(subscription Subscription) (bool, error) {
+	shard := idx.filterShard(subscription.TopicName)
+	added, err := shard.trie.Insert(subscription)
+	if err != nil {
+		return false, err
+	}
+	idx.invalidate(shard, subscription.TopicName)
+	return added, nil
+}
+
+// Remove 删除订阅，返回订阅是否存在
+func (idx *SubscriptionIndex) Remove(subscription Subscription) bool {
+	shard := idx.filterShard(subscription.TopicName)
+	if !shard.trie.Remove(subscription) {
+		return false
+	}
+	idx.invalidate(shard, subscription.TopicName)
+	return true
+}
+
+// invalidate 在shard中的过滤器变更后使受影响的缓存结果失效，不需要遍历缓存
+// 不含通配符的过滤器只匹配与其相同的主题，直接删除该主题的缓存；
+// 含通配符的过滤器递增分片的世代，第一层为通配符时递增通配符分片的世代，使所有分片的缓存失效
+func (idx *SubscriptionIndex) invalidate(shard *indexShard, filter string) {
+	shard.version.Add(1)
+	if strings.ContainsAny(filter, "+#") {
+		shard.generation.Add(1)
+		return
+	}
+	if shard.cache != nil {
+		shard.mu.Lock()
+		shard.cache.Remove(filter)
+		shard.mu.Unlock()
+	}
+}
+
+// Match 返回与发布主题匹配的所有订阅，返回值可能被缓存共享，不能被修改
+func (idx *SubscriptionIndex) Match(publishTopic string) []Subscription {
+	shard := idx.shardOf(firstLevel(publishTopic))
+	generation, wildcardGeneration := shard.generation.Load(), idx.wildcard.generation.Load()
+	if shard.cache != nil {
+		if cached, ok := shard.cache.Get(publishTopic); ok && cached.generation == generation && cached.wildcard == wildcardGeneration {
+			idx.hits.Add(1)
+			return cached.results
+		}
+		idx.misses.Add(1)
+	}
+
+	// 先读取版本再匹配，匹配期间发生变更时不缓存可能过期的结果
+	version, wildcardVersion := shard.version.Load(), idx.wildcard.version.Load()
+	results := shard.trie.Match(publishTopic)
+	if wildcard := idx.wildcard.trie.Match(publishTopic); len(wildcard) > 0 {
+		results = append(results, wildcard...)
+	}
+
+	if shard.cache != nil {
+		shard.mu.Lock()
+		if shard.version.Load() == version && idx.wildcard.version.Load() == wildcardVersion {
+			shard.cache.Add(publishTopic, cachedMatch{results: results, generation: generation, wildcard: wildcardGeneration})
+		}
+		shard.mu.Unlock()
+	}
+	return results
+}
+
+// Count 返回订阅总数
+func (idx *SubscriptionIndex) Count() int64 {
+	total := idx.wildcard.trie.Count()
+	for _, shard := range idx.shards {
+		total += shard.trie.Count()
+	}
+	return total
+}
+
+// NodeCount 返回所有分片订阅树的节点数
+func (idx *SubscriptionIndex) NodeCount() int {
+	total := idx.wildcard.trie.NodeCount()
+	for _, shard := range idx.shards {
+		total += shard.trie.NodeCount()
+	}
+	return total
+}
+
+// Compact 压缩所有分片的订阅树，返回压缩前后的节点数，压缩不改变匹配结果，不需要使缓存失效
+func (idx *SubscriptionIndex) Compact() (before int, after int) {
+	for _, shard := range append([]*indexShard{idx.wildcard}, idx.shards...) {
+		b, a := shard.trie.Compact()
+		before += b
+		after += a
+	}
+	return before, after
+}
+
+// CacheStats 返回匹配结果缓存的命中与未命中次数
+func (idx *SubscriptionIndex) CacheStats() (hits uint64, misses uint64) {
+	return idx.hits.Load(), idx.misses.Load()
+}
+
+// TopicMatchesFilter 返回发布主题是否匹配订阅过滤器，规则与 TopicTrie.Match 一致
+func TopicMatchesFilter(filter string, topic string) bool {
+	if strings.HasPrefix(topic, "$") && (strings.HasPrefix(filter, "+") || strings.HasPrefix(filter, "#")) {
+		return false
+	}
+	filterLevels := strings.Split(filter, "/")
+	topicLevels := strings.Split(topic, "/")
+	for i, level := range filterLevels {
+		if level == "#" {
+			return true
+		}
+		if i >= len(topicLevels) || (level != "+" && level != topicLevels[i]) {
+			return false
+		}
+	}
+	return len(filterLevels) == len(topicLevels)
+}
@@ -0,0 +1,299 @@
+package database
+
+import (
+	"fmt"
+	"math/rand"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// randomFilter 生成由有限词表组成的随机过滤器，使不同过滤器之间大量重叠
+func randomFilter(r *rand.Rand) string {
+	words := []string{"a", "b", "c", "$SYS", "+"}
+	n := 1 + r.Intn(3)
+	levels := make([]string, n)
+	for i := range levels {
+		levels[i] = words[r.Intn(len(words))]
+		if levels[i] == "$SYS" && i > 0 {
+			levels[i] = "a"
+		}
+	}
+	if r.Intn(4) == 0 {
+		levels = append(levels, "#")
+	}
+	return strings.Join(levels, "/")
+}
+
+// randomTopic 生成与randomFilter使用同一词表的随机发布主题
+func randomTopic(r *rand.Rand) string {
+	words := []string{"a", "b", "c"}
+	n := 1 + r.Intn(4)
+	levels := make([]string, n)
+	for i := range levels {
+		levels[i] = words[r.Intn(len(words))]
+	}
+	if r.Intn(5) == 0 {
+		levels[0] = "$SYS"
+	}
+	return strings.Join(levels, "/")
+}
+
+// expectedClients 逐个比较过滤器得到的匹配结果（已排序）
+func expectedClients(subscriptions map[string]Subscription, topic string) []string {
+	var clients []string
+	for _, subscription := range subscriptions {
+		if TopicMatchesFilter(subscription.TopicName, topic) {
+			clients = append(clients, subscription.ClientID)
+		}
+	}
+	slices.Sort(clients)
+	return clients
+}
+
+func TestSubscriptionIndexMatchesBruteForce(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	index := NewSubscriptionIndex(4, 64)
+	live := make(map[string]Subscription)
+	for i := 0; i < 5000; i++ {
+		subscription := Subscription{ClientID: fmt.Sprintf("client-%d", r.Intn(20)), TopicName: randomFilter(r)}
+		key := subscriptionKey(subscription)
+		// 交替新增与删除，使缓存不断失效
+		if _, ok := live[key]; ok && r.Intn(2) == 0 {
+			index.Remove(subscription)
+			delete(live, key)
+		} else if _, err := index.Insert(subscription); err != nil {
+			t.Fatal(err)
+		} else {
+			live[key] = subscription
+		}
+
+		topic := randomTopic(r)
+		got := matchedClients(index.Match(topic))
+		if want := expectedClients(live, topic); !slices.Equal(got, want) {
+			t.Fatalf("step %d: Match(%q) = %v, want %v", i, topic, got, want)
+		}
+	}
+	if hits, _ := index.CacheStats(); hits == 0 {
+		t.Fatal("match cache was never hit")
+	}
+}
+
+func TestMatchCacheInvalidation(t *testing.T) {
+	index := NewSubscriptionIndex(4, 100)
+	insert := func(clientID string, filter string) {
+		if _, err := index.Insert(Subscription{ClientID: clientID, TopicName: filter}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	match := func(topic string, want ...string) {
+		t.Helper()
+		if got := matchedClients(index.Match(topic)); !slices.Equal(got, want) {
+			t.Fatalf("Match(%q) = %v, want %v", topic, got, want)
+		}
+	}
+	hits := func() uint64 {
+		hits, _ := index.CacheStats()
+		return hits
+	}
+
+	insert("hash", "sport/#")
+	match("sport/tennis", "hash")
+	match("sport/tennis", "hash")
+	if hits() != 1 {
+		t.Fatalf("hits = %d, want 1", hits())
+	}
+
+	// 不影响该主题的变更不会使缓存失效：同一分片中不含通配符的过滤器，以及其他分片中含通配符的过滤器
+	other := "finance"
+	for i := 0; index.shardOf(other) == index.shardOf("sport"); i++ {
+		other = fmt.Sprintf("finance%d", i)
+	}
+	insert("other", "sport/golf")
+	insert("finance", other+"/+")
+	match("sport/tennis", "hash")
+	if hits() != 2 {
+		t.Fatalf("hits = %d after unrelated changes, want 2", hits())
+	}
+
+	// 匹配该主题的变更使缓存失效，包括第一层为通配符的订阅
+	// 含通配符的过滤器变更使同一分片（第一层为通配符时为所有分片）的缓存全部失效
+	insert("plus", "+/tennis")
+	match("sport/tennis", "hash", "plus")
+	insert("exact", "sport/tennis")
+	match("sport/tennis", "exact", "hash", "plus")
+	index.Remove(Subscription{ClientID: "hash", TopicName: "sport/#"})
+	match("sport/tennis", "exact", "plus")
+	if hits() != 2 {
+		t.Fatalf("hits = %d after related changes, want 2", hits())
+	}
+}
+
+func TestSubscriptionIndexConcurrent(t *testing.T) {
+	index := NewSubscriptionIndex(4, 16)
+	topics := []string{"a/b", "a/c", "b/b", "c/a/b"}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(int64(i)))
+			for j := 0; j < 500; j++ {
+				subscription := Subscription{ClientID: fmt.Sprintf("client-%d", i), TopicName: randomFilter(r)}
+				_, _ = index.Insert(subscription)
+				if j%2 == 0 {
+					index.Remove(subscription)
+				}
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 2000; j++ {
+				index.Match(topics[j%len(topics)])
+			}
+		}()
+	}
+	wg.Wait()
+
+	// 并发变更结束后缓存中不能残留过期的结果
+	reference := NewSubscriptionIndex(1, 0)
+	for _, shard := range append(index.shards, index.wildcard) {
+		var collect func(node *trieNode)
+		collect = func(node *trieNode) {
+			for _, subscription := range node.subscriptions() {
+				_, _ = reference.Insert(subscription)
+			}
+			node.children.Range(func(_, value any) bool {
+				collect(value.(*trieNode))
+				return true
+			})
+		}
+		collect(&shard.trie.root)
+	}
+	for _, topic := range topics {
+		got, want := matchedClients(index.Match(topic)), matchedClients(reference.Match(topic))
+		if !slices.Equal(got, want) {
+			t.Fatalf("Match(%q) = %v, want %v", topic, got, want)
+		}
+	}
+}
+
+// benchmarkSubscriptions 构造基准测试使用的订阅：大量设备各自的命令主题，以及少量热点遥测主题的订阅者
+func benchmarkSubscriptions() []Subscription {
+	var subscriptions []Subscription
+	for i := 0; i < 10000; i++ {
+		subscriptions = append(subscriptions, Subscription{ClientID: fmt.Sprintf("device-%d", i), TopicName: fmt.Sprintf("devices/%d/cmd", i)})
+	}
+	for i := 0; i < 100; i++ {
+		subscriptions = append(subscriptions,
+			Subscription{ClientID: fmt.Sprintf("dashboard-%d", i), TopicName: "telemetry/+/temperature"},
+			Subscription{ClientID: fmt.Sprintf("archiver-%d", i), TopicName: "telemetry/#"},
+		)
+	}
+	subscriptions = append(subscriptions, Subscription{ClientID: "auditor", TopicName: "+/+/temperature"})
+	return subscriptions
+}
+
+// benchmarkTopics 热点主题
+var benchmarkTopics = []string{"telemetry/line1/temperature", "telemetry/line2/temperature", "telemetry/line3/humidity", "devices/42/cmd"}
+
+// BenchmarkMatchTopicTrie 当前的 MatchTopic：单棵订阅树，每次发布都完整匹配
+func BenchmarkMatchTopicTrie(b *testing.B) {
+	trie := NewTopicTrie()
+	for _, subscription := range benchmarkSubscriptions() {
+		_, _ = trie.Insert(subscription)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		trie.Match(benchmarkTopics[i%len(benchmarkTopics)])
+	}
+}
+
+// BenchmarkMatchTopicIndex 分片索引与匹配结果缓存
+func BenchmarkMatchTopicIndex(b *testing.B) {
+	for _, cacheSize := range []int{0, defaultMatchCacheSize} {
+		b.Run(fmt.Sprintf("cache=%d", cacheSize), func(b *testing.B) {
+			index := NewSubscriptionIndex(defaultIndexShards, cacheSize)
+			for _, subscription := range benchmarkSubscriptions() {
+				_, _ = index.Insert(subscription)
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				index.Match(benchmarkTopics[i%len(benchmarkTopics)])
+			}
+		})
+	}
+}
+
+// BenchmarkMatchTopicTrieParallel 多个连接协程同时发布到热点主题
+func BenchmarkMatchTopicTrieParallel(b *testing.B) {
+	trie := NewTopicTrie()
+	for _, subscription := range benchmarkSubscriptions() {
+		_, _ = trie.Insert(subscription)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			trie.Match(benchmarkTopics[i%len(benchmarkTopics)])
+		}
+	})
+}
+
+// BenchmarkMatchTopicIndexParallel 多个连接协程同时发布到热点主题，同时有订阅变更
+func BenchmarkMatchTopicIndexParallel(b *testing.B) {
+	index := NewSubscriptionIndex(defaultIndexShards, defaultMatchCacheSize)
+	for _, subscription := range benchmarkSubscriptions() {
+		_, _ = index.Insert(subscription)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			// 每1000次发布有一次与热点主题无关的订阅变更
+			if i%1000 == 0 {
+				subscription := Subscription{ClientID: "churn", TopicName: fmt.Sprintf("devices/%d/cmd", i)}
+				_, _ = index.Insert(subscription)
+				index.Remove(subscription)
+			}
+			index.Match(benchmarkTopics[i%len(benchmarkTopics)])
+		}
+	})
+}
+
+// BenchmarkMatchTopicIndexChurn 缓存已满时每次发布前都有一次订阅与取消订阅
+// exact为不含通配符的设备命令主题，wildcard为第一层为通配符、会影响所有分片的过滤器
+func BenchmarkMatchTopicIndexChurn(b *testing.B) {
+	for _, tt := range []struct {
+		name   string
+		filter string
+	}{
+		{"exact", "devices/%d/cmd"},
+		{"wildcard", "+/%d/cmd"},
+	} {
+		b.Run(tt.name, func(b *testing.B) {
+			index := NewSubscriptionIndex(defaultIndexShards, defaultMatchCacheSize)
+			for _, subscription := range benchmarkSubscriptions() {
+				_, _ = index.Insert(subscription)
+			}
+			for i := 0; i < defaultMatchCacheSize; i++ {
+				index.Match(fmt.Sprintf("devices/%d/cmd", i))
+			}
+			churn := make([]Subscription, 1024)
+			for i := range churn {
+				churn[i] = Subscription{ClientID: "churn", TopicName: fmt.Sprintf(tt.filter, i)}
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				subscription := churn[i%len(churn)]
+				_, _ = index.Insert(subscription)
+				index.Remove(subscription)
+				index.Match(benchmarkTopics[i%len(benchmarkTopics)])
+			}
+		})
+	}
+}
@@ -3,8 +3,10 @@ package database
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/logger"
+	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/metrics"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -32,7 +34,7 @@ type TopicTreeNode struct {
 }
 
 // createNode 创建新的主题树节点
-func createNode(path string, level string) *TopicTreeNode {
+func (b *mongoBackend) createNode(ctx context.Context, path string, level string) (*TopicTreeNode, error) {
 	result := &TopicTreeNode{
 		ID:           primitive.NewObjectID(),
 		Path:         path,
@@ -41,84 +43,54 @@ func createNode(path string, level string) *TopicTreeNode {
 		Terminals:    []Subscription{},
 		WildcardHash: []Subscription{},
 	}
-	result.save()
-	return result
+	return result, b.saveNode(ctx, result)
 }
 
 // getOrCreateNode 获取或创建主题树节点
-func (ds *DBStore) getOrCreateNode(path string, level string) *TopicTreeNode {
-	result := ds.getNodeByPath(path)
-	if result != nil {
-		return result
+func (b *mongoBackend) getOrCreateNode(ctx context.Context, path string, level string) (*TopicTreeNode, error) {
+	result, err := b.getNodeByPath(ctx, path)
+	if err == nil {
+		return result, nil
 	}
-	return createNode(path, level)
-}
-
-// getNodeByPath 根据路径获取主题树节点
-func (ds *DBStore) getNodeByPath(path string) *TopicTreeNode {
-	// 首先检查缓存
-	if node, ok := ds.topicCache.Get(path); ok {
-		return node
-	}
-	ctx, cancel := context.WithTimeout(context.Background(), OperationTimeout)
-	defer cancel()
-
-	filter := bson.D{{"path", path}}
-	var topicNode TopicTreeNode
-
-	startTime := time.Now()
-	err := Subscriptions.FindOne(ctx, filter).Decode(&topicNode)
-	logger.DebugF("query topic tree node cost: %v", time.Since(startTime))
-
-	if err != nil {
-		handleErr(err)
-		return nil
+	if !errors.Is(err, ErrNotFound) {
+		return nil, err
 	}
-
-	ds.topicCache.Add(path, &topicNode)
-	return &topicNode
+	return b.createNode(ctx, path, level)
 }
 
-// getNodeByID 根据ID获取主题树节点
-func (ds *DBStore) getNodeByID(id primitive.ObjectID) *TopicTreeNode {
+// getNodeByPath 根据路径获取主题树节点
+func (b *mongoBackend) getNodeByPath(ctx context.Context, path string) (*TopicTreeNode, error) {
 	// 首先检查缓存
-	if node, ok := ds.topicCache.Get(id.String()); ok {
-		return node
+	if node, ok := b.topicCache.Get(path); ok {
+		return node, nil
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), OperationTimeout)
-	defer cancel()
 
-	filter := bson.D{{"_id", id}}
+	filter := bson.D{{Key: "path", Value: path}}
 	var topicNode TopicTreeNode
 
 	startTime := time.Now()
 	err := Subscriptions.FindOne(ctx, filter).Decode(&topicNode)
+	metrics.StorageDuration.WithLabelValues("load_topic_node").Observe(time.Since(startTime).Seconds())
 	logger.DebugF("query topic tree node cost: %v", time.Since(startTime))
 
 	if err != nil {
-		handleErr(err)
-		return nil
+		return nil, notFound(err)
 	}
 
-	ds.topicCache.Add(id.String(), &topicNode)
-	return &topicNode
+	b.topicCache.Add(path, &topicNode)
+	return &topicNode, nil
 }
 
-// save 保存主题树节点到数据库
-func (topic *TopicTreeNode) save() {
-	store.topicCache.Remove(topic.Path)
+// saveNode 保存主题树节点到数据库
+func (b *mongoBackend) saveNode(ctx context.Context, topic *TopicTreeNode) error {
+	b.topicCache.Remove(topic.Path)
 
-	ctx, cancel := context.WithTimeout(context.Background(), OperationTimeout)
-	defer cancel()
-
-	filter := bson.D{{"_id", topic.ID}}
+	filter := bson.D{{Key: "_id", Value: topic.ID}}
 	opts := options.Replace().SetUpsert(true)
 
-	result, err := Database.Collection(SubscriptionCollectionName).ReplaceOne(ctx, filter, topic, opts)
-
+	result, err := Subscriptions.ReplaceOne(ctx, filter, topic, opts)
 	if err != nil {
-		handleErr(err)
-		return
+		return err
 	}
 
 	logger.DebugF("Topic saved successfully, path=%s, level=%s, matched=%d, modified=%d, upserted=%v",
@@ -128,6 +100,7 @@ func (topic *TopicTreeNode) save() {
 		result.ModifiedCount,
 		result.UpsertedID != nil,
 	)
+	return nil
 }
 
 // equal 比较两个订阅是否相等
@@ -145,128 +118,209 @@ func (ds *DBStore) ClearSubscription(session *SessionData) {
 
 }
 
-// DeleteSubscription 删除订阅
+// DeleteSubscription 从内存订阅树删除订阅
+// 存储后端中的订阅随会话一起提交，见 SessionData.RemoveSubscription
 func (ds *DBStore) DeleteSubscription(subscription *Subscription) bool {
-	levels := strings.Split(subscription.TopicName, "/")
+	return ds.index.Remove(*subscription)
+}
+
+// InsertSubscription 向内存订阅树插入订阅
+// 存储后端中的订阅随会话一起提交，见 SessionData.AddSubscription
+func (ds *DBStore) InsertSubscription(subscription *Subscription) error {
+	_, err := ds.index.Insert(*subscription)
+	return err
+}
 
-	// 处理通配符订阅
-	if slices.Contains(levels, "#") {
-		path := strings.Join(levels[:len(levels)-1], "/")
-		node := ds.getNodeByPath(path)
-		if node != nil {
-			node.WildcardHash = slices.DeleteFunc(node.WildcardHash, subscription.equal)
-			node.save()
-			return true
+// MatchTopic 匹配主题订阅，只访问内存订阅树，不会访问数据库
+func (ds *DBStore) MatchTopic(publishTopic string) ([]Subscription, error) {
+	startTime := time.Now()
+	subscriptions := ds.index.Match(publishTopic)
+	metrics.MatchDuration.Observe(time.Since(startTime).Seconds())
+	return subscriptions, nil
+}
+
+// SubscriptionCount 返回内存订阅索引中的订阅总数
+func (ds *DBStore) SubscriptionCount() int64 {
+	return ds.index.Count()
+}
+
+// LoadSubscriptions 从存储后端加载全部订阅到内存订阅树
+func (ds *DBStore) LoadSubscriptions() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*OperationTimeout)
+	defer cancel()
+
+	startTime := time.Now()
+	subscriptions, err := ds.backend.LoadSubscriptions(ctx)
+	if err != nil {
+		return fmt.Errorf("error occured while loading subscriptions: %v", err)
+	}
+	for _, subscription := range subscriptions {
+		if _, err := ds.index.Insert(subscription); err != nil {
+			logger.WarnF("Skipping invalid subscription %s of client %s: %v", subscription.TopicName, subscription.ClientID, err)
 		}
-		return false
 	}
 
-	// 处理普通订阅
-	node := ds.getNodeByPath(subscription.TopicName)
-	if node != nil {
+	logger.InfoF("Loaded %d subscriptions into memory, cost: %v", ds.index.Count(), time.Since(startTime))
+	return nil
+}
+
+// LoadSubscriptions 读取主题树中的全部订阅
+func (b *mongoBackend) LoadSubscriptions(ctx context.Context) ([]Subscription, error) {
+	cursor, err := Subscriptions.Find(ctx, bson.D{})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var subscriptions []Subscription
+	for cursor.Next(ctx) {
+		var node TopicTreeNode
+		if err := cursor.Decode(&node); err != nil {
+			return nil, fmt.Errorf("error occured while decoding topic tree node: %v", err)
+		}
+		subscriptions = append(subscriptions, node.Terminals...)
+		subscriptions = append(subscriptions, node.WildcardHash...)
+	}
+	return subscriptions, cursor.Err()
+}
+
+// DeleteSubscription 从主题树删除订阅，并剪除因此变为空的分支
+func (b *mongoBackend) DeleteSubscription(ctx context.Context, subscription Subscription) error {
+	b.treeMu.Lock()
+	defer b.treeMu.Unlock()
+
+	path, hash := subscriptionNodePath(subscription.TopicName)
+	node, err := b.getNodeByPath(ctx, path)
+	if err != nil {
+		return err
+	}
+	if hash {
+		node.WildcardHash = slices.DeleteFunc(node.WildcardHash, subscription.equal)
+	} else {
 		node.Terminals = slices.DeleteFunc(node.Terminals, subscription.equal)
-		node.save()
-		return true
 	}
-	return false
+	return b.pruneNode(ctx, node)
 }
 
-// InsertSubscription 插入新的订阅
-func (ds *DBStore) InsertSubscription(subscription *Subscription) error {
+// subscriptionNodePath 返回保存订阅的主题树节点路径
+// 以 "/#" 结尾的订阅保存在父节点的WildcardHash中，其余订阅（包括 "+" 与单独的 "#"）保存在过滤器路径对应节点的Terminals中
+func subscriptionNodePath(filter string) (path string, hash bool) {
+	if parent, found := strings.CutSuffix(filter, "/#"); found {
+		return parent, true
+	}
+	return filter, false
+}
+
+// empty 返回节点是否既没有订阅也没有子节点
+func (node *TopicTreeNode) empty() bool {
+	return len(node.Terminals) == 0 && len(node.WildcardHash) == 0 && len(node.Children) == 0 && node.WildcardPlus.IsZero()
+}
+
+// pruneNode 保存修改后的节点，节点为空时删除它并移除父节点中的引用，依次向上处理
+func (b *mongoBackend) pruneNode(ctx context.Context, node *TopicTreeNode) error {
+	for node.empty() {
+		b.topicCache.Remove(node.Path)
+		if _, err := Subscriptions.DeleteOne(ctx, bson.D{{Key: "_id", Value: node.ID}}); err != nil {
+			return err
+		}
+		logger.DebugF("Empty topic tree node removed, path=%s", node.Path)
+
+		// 第一层节点没有父节点
+		parentPath, _, found := cutLastLevel(node.Path)
+		if !found {
+			return nil
+		}
+		parent, err := b.getNodeByPath(ctx, parentPath)
+		if errors.Is(err, ErrNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if node.Level == "+" && parent.WildcardPlus == node.ID {
+			parent.WildcardPlus = primitive.NilObjectID
+		} else if parent.Children[node.Level] == node.ID {
+			delete(parent.Children, node.Level)
+		}
+		node = parent
+	}
+	return b.saveNode(ctx, node)
+}
+
+// cutLastLevel 将路径拆分为父路径与最后一层
+func cutLastLevel(path string) (parent string, level string, found bool) {
+	index := strings.LastIndex(path, "/")
+	if index < 0 {
+		return "", path, false
+	}
+	return path[:index], path[index+1:], true
+}
+
+// SaveSubscription 将订阅写入主题树
+func (b *mongoBackend) SaveSubscription(ctx context.Context, subscription Subscription) error {
+	b.treeMu.Lock()
+	defer b.treeMu.Unlock()
+
 	levels := strings.Split(subscription.TopicName, "/")
 
 	var currentNode *TopicTreeNode = nil
 	var parentNode *TopicTreeNode = nil
+	var err error
 
 	for i, level := range levels {
+		// 处理多层通配符，订阅保存在父节点上，不创建 "#" 节点
+		if level == "#" && parentNode != nil {
+			if i != len(levels)-1 {
+				return fmt.Errorf("'#' must be the last level, topic: %s", subscription.TopicName)
+			}
+			if index := slices.IndexFunc(parentNode.WildcardHash, subscription.equal); index >= 0 {
+				parentNode.WildcardHash[index] = subscription
+			} else {
+				parentNode.WildcardHash = append(parentNode.WildcardHash, subscription)
+			}
+			return b.saveNode(ctx, parentNode)
+		}
+
 		path := strings.Join(levels[:i+1], "/")
-		currentNode = ds.getOrCreateNode(path, level)
+		currentNode, err = b.getOrCreateNode(ctx, path, level)
+		if err != nil {
+			return err
+		}
 
 		if parentNode == nil {
 			// 根节点处理
 		} else if level == "+" {
 			// 处理单层通配符
-			parentNode.WildcardPlus = currentNode.ID
-			parentNode.save()
-		} else if level == "#" {
-			// 处理多层通配符
-			if i != len(levels)-1 {
-				return fmt.Errorf("'#' must be the last level, topic: %s", subscription.TopicName)
+			if parentNode.WildcardPlus != currentNode.ID {
+				parentNode.WildcardPlus = currentNode.ID
+				if err := b.saveNode(ctx, parentNode); err != nil {
+					return err
+				}
 			}
-			parentNode.WildcardHash = append(parentNode.WildcardHash, *subscription)
-			parentNode.save()
-			return nil
 		} else {
 			// 处理普通层级
 			if _, ok := parentNode.Children[level]; !ok {
 				parentNode.Children[level] = currentNode.ID
-				parentNode.save()
+				if err := b.saveNode(ctx, parentNode); err != nil {
+					return err
+				}
 			}
 		}
 
-		// 如果是最后一个层级，添加终端订阅
-		if i == len(levels)-1 && !slices.ContainsFunc(currentNode.Terminals, subscription.equal) {
-			currentNode.Terminals = append(currentNode.Terminals, *subscription)
-			currentNode.save()
-		}
-
-		parentNode = currentNode
-	}
-
-	return nil
-}
-
-// MatchTopic 匹配主题订阅
-func (ds *DBStore) MatchTopic(publishTopic string) ([]Subscription, error) {
-	// 拆分发布主题为层级数组
-	levels := strings.Split(publishTopic, "/")
-	var results []Subscription
-
-	// 初始化队列：从根节点开始
-	rootNode := ds.getNodeByPath(levels[0])
-	rootPlus := ds.getNodeByPath("+")
-	var queue []*TopicTreeNode
-	if rootPlus != nil {
-		queue = append(queue, rootPlus)
-	}
-	if rootNode != nil {
-		queue = append(queue, rootNode)
-	}
-
-	for _, currentLevel := range levels[1:] {
-		var nextQueue []*TopicTreeNode
-
-		// 遍历当前层所有可能匹配的节点
-		for _, node := range queue {
-			// 1. 收集当前节点的 # 通配符订阅
-			results = append(results, node.WildcardHash...)
-
-			// 2. 精确匹配子节点
-			if childID, ok := node.Children[currentLevel]; ok {
-				childNode := ds.getNodeByID(childID)
-				nextQueue = append(nextQueue, childNode)
+		// 如果是最后一个层级，添加或更新终端订阅
+		if i == len(levels)-1 {
+			if index := slices.IndexFunc(currentNode.Terminals, subscription.equal); index >= 0 {
+				currentNode.Terminals[index] = subscription
+			} else {
+				currentNode.Terminals = append(currentNode.Terminals, subscription)
 			}
-
-			// 3. 处理 + 通配符子节点
-			if !node.WildcardPlus.IsZero() {
-				plusNode := ds.getNodeByID(node.WildcardPlus)
-				nextQueue = append(nextQueue, plusNode)
+			if err := b.saveNode(ctx, currentNode); err != nil {
+				return err
 			}
 		}
 
-		// 4. 更新队列为下一层节点
-		queue = nextQueue
-
-		// 提前终止：队列为空时无需继续
-		if len(queue) == 0 {
-			break
-		}
-	}
-
-	// 5. 收集终端节点的精确订阅
-	for _, node := range queue {
-		results = append(results, node.Terminals...)
+		parentNode = currentNode
 	}
 
-	return results, nil
+	return nil
 }
@@ -0,0 +1,148 @@
+// Package database 实现了MQTT服务器的数据存储功能
+package database
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/logger"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// topicTreeCompactor 持久化主题树的存储后端实现此接口以支持压缩
+// 只保存订阅列表的存储后端没有空节点，不需要实现
+type topicTreeCompactor interface {
+	// CompactTopicTree 删除主题树中的空节点，返回压缩前后的节点数
+	CompactTopicTree(ctx context.Context) (before int, after int, err error)
+}
+
+// CompactionReport 一次主题树压缩的结果
+type CompactionReport struct {
+	MemoryNodesBefore  int // 压缩前内存订阅树的节点数
+	MemoryNodesAfter   int // 压缩后内存订阅树的节点数
+	StorageNodesBefore int // 压缩前存储后端主题树的节点数，存储后端不保存主题树时为0
+	StorageNodesAfter  int // 压缩后存储后端主题树的节点数
+}
+
+// planTreeCompaction 计算主题树压缩需要删除的空节点与需要更新子节点引用的节点
+// 节点按深度从深到浅处理，整条空分支在一次遍历中删除
+func planTreeCompaction(nodes []*TopicTreeNode) (removed []primitive.ObjectID, updated []*TopicTreeNode) {
+	alive := make(map[primitive.ObjectID]bool, len(nodes))
+	for _, node := range nodes {
+		alive[node.ID] = true
+	}
+	sorted := slices.Clone(nodes)
+	slices.SortStableFunc(sorted, func(a, b *TopicTreeNode) int {
+		return cmp.Compare(strings.Count(b.Path, "/"), strings.Count(a.Path, "/"))
+	})
+
+	for _, node := range sorted {
+		changed := false
+		for level, id := range node.Children {
+			if !alive[id] {
+				delete(node.Children, level)
+				changed = true
+			}
+		}
+		if !node.WildcardPlus.IsZero() && !alive[node.WildcardPlus] {
+			node.WildcardPlus = primitive.NilObjectID
+			changed = true
+		}
+		if node.empty() {
+			alive[node.ID] = false
+			removed = append(removed, node.ID)
+			continue
+		}
+		if changed {
+			updated = append(updated, node)
+		}
+	}
+	return removed, updated
+}
+
+// CompactTopicTree 删除主题树中的空节点，返回压缩前后的节点数
+// 先更新父节点的引用再删除子节点，中途失败时只会留下可在下次压缩中删除的空节点
+func (b *mongoBackend) CompactTopicTree(ctx context.Context) (int, int, error) {
+	b.treeMu.Lock()
+	defer b.treeMu.Unlock()
+
+	cursor, err := Subscriptions.Find(ctx, bson.D{})
+	if err != nil {
+		return 0, 0, err
+	}
+	var nodes []*TopicTreeNode
+	if err := cursor.All(ctx, &nodes); err != nil {
+		return 0, 0, err
+	}
+
+	removed, updated := planTreeCompaction(nodes)
+	defer b.topicCache.Purge()
+	for _, node := range updated {
+		if err := b.saveNode(ctx, node); err != nil {
+			return len(nodes), len(nodes), err
+		}
+	}
+	if len(removed) > 0 {
+		result, err := Subscriptions.DeleteMany(ctx, bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: removed}}}})
+		if err != nil {
+			return len(nodes), len(nodes), err
+		}
+		return len(nodes), len(nodes) - int(result.DeletedCount), nil
+	}
+	return len(nodes), len(nodes), nil
+}
+
+// CompactTopicTree 压缩内存订阅树与存储后端中的主题树，删除没有订阅也没有子节点的空分支
+func (ds *DBStore) CompactTopicTree(ctx context.Context) (*CompactionReport, error) {
+	report := &CompactionReport{}
+	report.MemoryNodesBefore, report.MemoryNodesAfter = ds.index.Compact()
+
+	compactor, ok := ds.backend.(topicTreeCompactor)
+	if !ok {
+		return report, nil
+	}
+	if !ds.breaker.allow() {
+		return report, ErrStorageUnavailable
+	}
+	var err error
+	report.StorageNodesBefore, report.StorageNodesAfter, err = compactor.CompactTopicTree(ctx)
+	if isOutage(err) {
+		ds.breaker.failure(err)
+	} else {
+		ds.breaker.success()
+	}
+	if err != nil {
+		return report, fmt.Errorf("error occured while compacting topic tree: %v", err)
+	}
+	return report, nil
+}
+
+// runCompaction 周期性压缩主题树，直到stop关闭，降级期间不压缩
+func (ds *DBStore) runCompaction(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if ds.Health().Degraded {
+				continue
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 10*OperationTimeout)
+			report, err := ds.CompactTopicTree(ctx)
+			cancel()
+			if err != nil {
+				logger.ErrorF("%v", err)
+				continue
+			}
+			logger.InfoF("Topic tree compacted: memory nodes %d -> %d, storage nodes %d -> %d",
+				report.MemoryNodesBefore, report.MemoryNodesAfter, report.StorageNodesBefore, report.StorageNodesAfter)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package database
+
+import (
+	"slices"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestPlanTreeCompaction(t *testing.T) {
+	root := &TopicTreeNode{ID: primitive.NewObjectID(), Level: "devices", Path: "devices", Children: map[string]primitive.ObjectID{}}
+	plus := &TopicTreeNode{ID: primitive.NewObjectID(), Level: "+", Path: "devices/+", Children: map[string]primitive.ObjectID{}}
+	status := &TopicTreeNode{ID: primitive.NewObjectID(), Level: "status", Path: "devices/+/status",
+		Terminals: []Subscription{{ClientID: "watcher", TopicName: "devices/+/status"}}}
+	device := &TopicTreeNode{ID: primitive.NewObjectID(), Level: "1", Path: "devices/1", Children: map[string]primitive.ObjectID{}}
+	cmd := &TopicTreeNode{ID: primitive.NewObjectID(), Level: "cmd", Path: "devices/1/cmd"}
+	empty := &TopicTreeNode{ID: primitive.NewObjectID(), Level: "empty", Path: "empty"}
+	root.WildcardPlus = plus.ID
+	root.Children["1"] = device.ID
+	root.Children["gone"] = primitive.NewObjectID() // 指向不存在的节点
+	plus.Children["status"] = status.ID
+	device.Children["cmd"] = cmd.ID
+
+	removed, updated := planTreeCompaction([]*TopicTreeNode{root, plus, status, device, cmd, empty})
+
+	wantRemoved := []primitive.ObjectID{cmd.ID, device.ID, empty.ID}
+	if len(removed) != len(wantRemoved) {
+		t.Fatalf("removed %d nodes, want %d", len(removed), len(wantRemoved))
+	}
+	for _, id := range wantRemoved {
+		if !slices.Contains(removed, id) {
+			t.Fatalf("node %s was not removed", id.Hex())
+		}
+	}
+	if len(updated) != 1 || updated[0] != root {
+		t.Fatalf("updated = %v, want only the root node", updated)
+	}
+	if len(root.Children) != 0 || root.WildcardPlus != plus.ID {
+		t.Fatalf("root references = %v, %s, want only the '+' child", root.Children, root.WildcardPlus.Hex())
+	}
+}
+
+func TestPlanTreeCompactionRemovesWholeBranch(t *testing.T) {
+	a := &TopicTreeNode{ID: primitive.NewObjectID(), Level: "a", Path: "a", Children: map[string]primitive.ObjectID{}}
+	b := &TopicTreeNode{ID: primitive.NewObjectID(), Level: "b", Path: "a/b", Children: map[string]primitive.ObjectID{}}
+	c := &TopicTreeNode{ID: primitive.NewObjectID(), Level: "c", Path: "a/b/c"}
+	a.Children["b"] = b.ID
+	b.Children["c"] = c.ID
+
+	// 输入顺序与深度无关
+	removed, updated := planTreeCompaction([]*TopicTreeNode{a, b, c})
+	if len(removed) != 3 || len(updated) != 0 {
+		t.Fatalf("removed %d nodes and updated %d, want 3 and 0", len(removed), len(updated))
+	}
+}
@@ -0,0 +1,245 @@
+// Package database 实现了MQTT服务器的数据存储功能
+package database
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// trieNode 内存订阅树节点
+// 子节点保存在 sync.Map 中，订阅列表以写时复制的方式整体替换，读取时无需加锁
+type trieNode struct {
+	children  sync.Map                       // key=层级名称（包括 "+" 与 "#"）, value=*trieNode
+	terminals atomic.Pointer[[]Subscription] // 过滤器恰好结束于此节点的订阅
+}
+
+// child 返回名为level的子节点，不存在时返回nil
+func (n *trieNode) child(level string) *trieNode {
+	if value, ok := n.children.Load(level); ok {
+		return value.(*trieNode)
+	}
+	return nil
+}
+
+// hasChildren 返回节点是否有子节点
+func (n *trieNode) hasChildren() bool {
+	found := false
+	n.children.Range(func(_, _ any) bool {
+		found = true
+		return false
+	})
+	return found
+}
+
+// empty 返回节点是否既没有订阅也没有子节点，可以从树中删除
+func (n *trieNode) empty() bool {
+	return len(n.subscriptions()) == 0 && !n.hasChildren()
+}
+
+// subscriptions 返回此节点上的订阅列表，返回值不能被修改
+func (n *trieNode) subscriptions() []Subscription {
+	if list := n.terminals.Load(); list != nil {
+		return *list
+	}
+	return nil
+}
+
+// TopicTrie 内存中的主题订阅树
+// 读取（匹配）完全无锁，写入之间通过互斥锁串行化
+type TopicTrie struct {
+	root  trieNode
+	mu    sync.Mutex   // 串行化写入
+	count atomic.Int64 // 订阅总数
+}
+
+// NewTopicTrie 创建新的内存订阅树
+func NewTopicTrie() *TopicTrie {
+	return &TopicTrie{}
+}
+
+// ValidateTopicFilter 校验订阅主题过滤器
+func ValidateTopicFilter(filter string) error {
+	if filter == "" {
+		return fmt.Errorf("topic filter must not be empty")
+	}
+	levels := strings.Split(filter, "/")
+	for i, level := range levels {
+		if strings.Contains(level, "#") && (level != "#" || i != len(levels)-1) {
+			return fmt.Errorf("'#' must be the last level, topic: %s", filter)
+		}
+		if strings.Contains(level, "+") && level != "+" {
+			return fmt.Errorf("'+' must occupy an entire level, topic: %s", filter)
+		}
+	}
+	return nil
+}
+
+// ValidateTopicName 校验发布主题名，主题名不能为空也不能包含通配符
+func ValidateTopicName(topicName string) error {
+	if topicName == "" {
+		return fmt.Errorf("topic name must not be empty")
+	}
+	if strings.ContainsAny(topicName, "+#") {
+		return fmt.Errorf("topic name must not contain wildcards, topic: %s", topicName)
+	}
+	return nil
+}
+
+// Insert 插入或更新订阅，返回是否为新增订阅
+func (t *TopicTrie) Insert(subscription Subscription) (bool, error) {
+	if err := ValidateTopicFilter(subscription.TopicName); err != nil {
+		return false, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	node := &t.root
+	for _, level := range strings.Split(subscription.TopicName, "/") {
+		next := node.child(level)
+		if next == nil {
+			next = &trieNode{}
+			node.children.Store(level, next)
+		}
+		node = next
+	}
+
+	current := node.subscriptions()
+	index := slices.IndexFunc(current, subscription.equal)
+	updated := make([]Subscription, len(current), len(current)+1)
+	copy(updated, current)
+	if index >= 0 {
+		updated[index] = subscription
+	} else {
+		updated = append(updated, subscription)
+		t.count.Add(1)
+	}
+	node.terminals.Store(&updated)
+	return index < 0, nil
+}
+
+// Remove 删除订阅，返回订阅是否存在
+// 删除后沿路径向上剪除没有订阅也没有子节点的节点，避免频繁变化的主题使树无限增长
+func (t *TopicTrie) Remove(subscription Subscription) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	levels := strings.Split(subscription.TopicName, "/")
+	path := make([]*trieNode, 0, len(levels)+1)
+	node := &t.root
+	path = append(path, node)
+	for _, level := range levels {
+		if node = node.child(level); node == nil {
+			return false
+		}
+		path = append(path, node)
+	}
+
+	current := node.subscriptions()
+	if !slices.ContainsFunc(current, subscription.equal) {
+		return false
+	}
+	updated := slices.DeleteFunc(slices.Clone(current), subscription.equal)
+	node.terminals.Store(&updated)
+	t.count.Add(-1)
+
+	// path[i+1]是path[i]名为levels[i]的子节点
+	for i := len(levels) - 1; i >= 0 && path[i+1].empty(); i-- {
+		path[i].children.Delete(levels[i])
+	}
+	return true
+}
+
+// NodeCount 返回树中除根节点外的节点数
+func (t *TopicTrie) NodeCount() int {
+	var count func(node *trieNode) int
+	count = func(node *trieNode) int {
+		total := 0
+		node.children.Range(func(_, value any) bool {
+			total += 1 + count(value.(*trieNode))
+			return true
+		})
+		return total
+	}
+	return count(&t.root)
+}
+
+// Compact 删除树中所有没有订阅的分支，返回压缩前后的节点数
+// 删除订阅时已经逐条剪枝，压缩用于清理并发写入等情况下遗留的空节点
+func (t *TopicTrie) Compact() (before int, after int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	before = t.NodeCount()
+	var prune func(node *trieNode)
+	prune = func(node *trieNode) {
+		node.children.Range(func(key, value any) bool {
+			child := value.(*trieNode)
+			prune(child)
+			if child.empty() {
+				node.children.Delete(key)
+			}
+			return true
+		})
+	}
+	prune(&t.root)
+	return before, t.NodeCount()
+}
+
+// Count 返回订阅总数
+func (t *TopicTrie) Count() int64 {
+	return t.count.Load()
+}
+
+// Match 返回与发布主题匹配的所有订阅
+// 以 $ 开头的主题（如 $SYS/...）不匹配第一层为通配符的过滤器，见 MQTT 规范 4.7.2
+func (t *TopicTrie) Match(publishTopic string) []Subscription {
+	levels := strings.Split(publishTopic, "/")
+	dollar := strings.HasPrefix(publishTopic, "$")
+	var results []Subscription
+
+	queue := []*trieNode{&t.root}
+	for i, currentLevel := range levels {
+		var nextQueue []*trieNode
+		wildcard := i > 0 || !dollar
+
+		// 遍历当前层所有可能匹配的节点
+		for _, node := range queue {
+			// 1. 收集当前节点的 # 通配符订阅
+			if hash := node.child("#"); hash != nil && wildcard {
+				results = append(results, hash.subscriptions()...)
+			}
+
+			// 2. 精确匹配子节点
+			if child := node.child(currentLevel); child != nil {
+				nextQueue = append(nextQueue, child)
+			}
+
+			// 3. 处理 + 通配符子节点
+			if plus := node.child("+"); plus != nil && wildcard {
+				nextQueue = append(nextQueue, plus)
+			}
+		}
+
+		// 4. 更新队列为下一层节点
+		queue = nextQueue
+
+		// 提前终止：队列为空时无需继续
+		if len(queue) == 0 {
+			break
+		}
+	}
+
+	// 5. 收集终端节点的精确订阅，以及 # 匹配零个层级的订阅（sport/# 匹配 sport）
+	for _, node := range queue {
+		results = append(results, node.subscriptions()...)
+		if hash := node.child("#"); hash != nil {
+			results = append(results, hash.subscriptions()...)
+		}
+	}
+
+	return results
+}
@@ -0,0 +1,306 @@
+package database
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+)
+
+// matchedClients 返回匹配结果中的客户端ID（已排序）
+func matchedClients(subscriptions []Subscription) []string {
+	var clients []string
+	for _, subscription := range subscriptions {
+		clients = append(clients, subscription.ClientID)
+	}
+	slices.Sort(clients)
+	return clients
+}
+
+func TestTopicTrieMatch(t *testing.T) {
+	trie := NewTopicTrie()
+	for _, subscription := range []Subscription{
+		{ClientID: "exact", TopicName: "sport/tennis/player1"},
+		{ClientID: "plus", TopicName: "sport/+/player1"},
+		{ClientID: "hash", TopicName: "sport/#"},
+		{ClientID: "root-plus", TopicName: "+/tennis/+"},
+		{ClientID: "other", TopicName: "finance/stock"},
+	} {
+		if _, err := trie.Insert(subscription); err != nil {
+			t.Fatalf("insert %s: %v", subscription.TopicName, err)
+		}
+	}
+
+	tests := []struct {
+		topic string
+		want  []string
+	}{
+		{"sport/tennis/player1", []string{"exact", "hash", "plus", "root-plus"}},
+		{"sport/golf/player1", []string{"hash", "plus"}},
+		{"sport/tennis/player2", []string{"hash", "root-plus"}},
+		{"sport/tennis/player1/ranking", []string{"hash"}},
+		{"finance/stock", []string{"other"}},
+		{"finance/bond", nil},
+		{"music", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.topic, func(t *testing.T) {
+			got := matchedClients(trie.Match(tt.topic))
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("Match(%q) = %v, want %v", tt.topic, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestTopicTrieMatchSpec 覆盖 MQTT 规范 4.7 节中的主题匹配示例
+func TestTopicTrieMatchSpec(t *testing.T) {
+	tests := []struct {
+		filter string
+		topic  string
+		match  bool
+	}{
+		// 4.7.1.2 多层通配符
+		{"sport/tennis/player1/#", "sport/tennis/player1", true},
+		{"sport/tennis/player1/#", "sport/tennis/player1/ranking", true},
+		{"sport/tennis/player1/#", "sport/tennis/player1/score/wimbledon", true},
+		{"sport/tennis/player1/#", "sport/tennis/player2", false},
+		{"sport/#", "sport", true},
+		{"sport/#", "sport/", true},
+		{"sport/#", "sports", false},
+		{"#", "sport", true},
+		{"#", "sport/tennis/player1", true},
+		{"#", "/", true},
+		{"+/#", "sport", true},
+		{"+/#", "sport/tennis", true},
+		// 4.7.1.3 单层通配符
+		{"sport/tennis/+", "sport/tennis/player1", true},
+		{"sport/tennis/+", "sport/tennis/player2", true},
+		{"sport/tennis/+", "sport/tennis/player1/ranking", false},
+		{"sport/+", "sport", false},
+		{"sport/+", "sport/", true},
+		{"+", "sport", true},
+		{"+", "/finance", false},
+		{"+/+", "/finance", true},
+		{"/+", "/finance", true},
+		{"sport/+/player1", "sport/tennis/player1", true},
+		{"+/tennis/#", "sport/tennis/player1", true},
+		{"+/tennis/#", "sport/tennis", true},
+		// 4.7.2 以 $ 开头的主题
+		{"#", "$SYS/monitor/Clients", false},
+		{"+/monitor/Clients", "$SYS/monitor/Clients", false},
+		{"+", "$SYS", false},
+		{"+/#", "$SYS/monitor", false},
+		{"$SYS/#", "$SYS/monitor/Clients", true},
+		{"$SYS/#", "$SYS", true},
+		{"$SYS/monitor/+", "$SYS/monitor/Clients", true},
+		{"$SYS/+/Clients", "$SYS/monitor/Clients", true},
+		{"sport/#", "sport/$internal", true},
+		// 4.7.3 主题语义，区分大小写，空层级也是层级
+		{"ACCOUNTS", "Accounts", false},
+		{"Accounts payable", "Accounts payable", true},
+		{"/finance", "finance", false},
+		{"finance", "/finance", false},
+		{"sport//tennis", "sport//tennis", true},
+		{"sport/+/tennis", "sport//tennis", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filter+" "+tt.topic, func(t *testing.T) {
+			if err := ValidateTopicFilter(tt.filter); err != nil {
+				t.Fatalf("ValidateTopicFilter(%q) = %v", tt.filter, err)
+			}
+			trie := NewTopicTrie()
+			subscription := Subscription{ClientID: "client", TopicName: tt.filter}
+			if _, err := trie.Insert(subscription); err != nil {
+				t.Fatal(err)
+			}
+			if got := len(trie.Match(tt.topic)) == 1; got != tt.match {
+				t.Fatalf("filter %q matches topic %q = %v, want %v", tt.filter, tt.topic, got, tt.match)
+			}
+			if got := TopicMatchesFilter(tt.filter, tt.topic); got != tt.match {
+				t.Fatalf("TopicMatchesFilter(%q, %q) = %v, want %v", tt.filter, tt.topic, got, tt.match)
+			}
+			// 删除后不再匹配，树中不留节点
+			if !trie.Remove(subscription) || len(trie.Match(tt.topic)) != 0 || trie.NodeCount() != 0 {
+				t.Fatalf("filter %q was not removed cleanly", tt.filter)
+			}
+		})
+	}
+}
+
+func TestSubscriptionNodePath(t *testing.T) {
+	tests := []struct {
+		filter string
+		path   string
+		hash   bool
+	}{
+		{"sport/tennis", "sport/tennis", false},
+		{"sport/+", "sport/+", false},
+		{"+", "+", false},
+		{"+/tennis/+", "+/tennis/+", false},
+		{"sport/#", "sport", true},
+		{"sport/+/#", "sport/+", true},
+		{"+/#", "+", true},
+		{"#", "#", false},
+	}
+	for _, tt := range tests {
+		path, hash := subscriptionNodePath(tt.filter)
+		if path != tt.path || hash != tt.hash {
+			t.Errorf("subscriptionNodePath(%q) = %q, %v, want %q, %v", tt.filter, path, hash, tt.path, tt.hash)
+		}
+	}
+}
+
+func TestValidateTopicName(t *testing.T) {
+	tests := []struct {
+		topic string
+		valid bool
+	}{
+		{"sport/tennis", true},
+		{"/", true},
+		{"$SYS/broker", true},
+		{"", false},
+		{"sport/+", false},
+		{"sport/#", false},
+		{"sport+", false},
+	}
+	for _, tt := range tests {
+		err := ValidateTopicName(tt.topic)
+		if (err == nil) != tt.valid {
+			t.Errorf("ValidateTopicName(%q) error = %v, want valid=%v", tt.topic, err, tt.valid)
+		}
+	}
+}
+
+func TestTopicTrieInsertRemove(t *testing.T) {
+	trie := NewTopicTrie()
+	subscription := Subscription{ClientID: "client", TopicName: "a/b", QoSLevel: 0}
+
+	if added, err := trie.Insert(subscription); err != nil || !added {
+		t.Fatalf("first insert: added=%v err=%v", added, err)
+	}
+	// 重复订阅只更新QoS等级
+	subscription.QoSLevel = 1
+	if added, err := trie.Insert(subscription); err != nil || added {
+		t.Fatalf("second insert: added=%v err=%v", added, err)
+	}
+	if trie.Count() != 1 {
+		t.Fatalf("Count() = %d, want 1", trie.Count())
+	}
+	if got := trie.Match("a/b"); len(got) != 1 || got[0].QoSLevel != 1 {
+		t.Fatalf("Match after update = %v", got)
+	}
+
+	if !trie.Remove(subscription) {
+		t.Fatal("Remove returned false for existing subscription")
+	}
+	if trie.Remove(subscription) {
+		t.Fatal("Remove returned true for missing subscription")
+	}
+	if trie.Count() != 0 || len(trie.Match("a/b")) != 0 {
+		t.Fatalf("subscription still present after remove")
+	}
+}
+
+func TestValidateTopicFilter(t *testing.T) {
+	tests := []struct {
+		filter string
+		valid  bool
+	}{
+		{"a/b/c", true},
+		{"a/+/c", true},
+		{"a/#", true},
+		{"#", true},
+		{"", false},
+		{"a/#/c", false},
+		{"a/b#", false},
+		{"a/b+/c", false},
+		{"sport/tennis#", false},
+		{"sport/tennis/#/ranking", false},
+		{"sport+", false},
+		{"+/tennis/#", true},
+		{"/", true},
+	}
+	for _, tt := range tests {
+		err := ValidateTopicFilter(tt.filter)
+		if (err == nil) != tt.valid {
+			t.Errorf("ValidateTopicFilter(%q) error = %v, want valid=%v", tt.filter, err, tt.valid)
+		}
+	}
+}
+
+func TestTopicTrieConcurrent(t *testing.T) {
+	trie := NewTopicTrie()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				subscription := Subscription{ClientID: fmt.Sprintf("client-%d", i), TopicName: fmt.Sprintf("load/%d/#", j)}
+				_, _ = trie.Insert(subscription)
+				if j%2 == 0 {
+					trie.Remove(subscription)
+				}
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				trie.Match(fmt.Sprintf("load/%d/x", j))
+			}
+		}()
+	}
+	wg.Wait()
+	if trie.Count() != 8*100 {
+		t.Fatalf("Count() = %d, want %d", trie.Count(), 8*100)
+	}
+}
+
+func TestTopicTriePrunesEmptyBranches(t *testing.T) {
+	trie := NewTopicTrie()
+	shared := Subscription{ClientID: "watcher", TopicName: "devices/+/status"}
+	if _, err := trie.Insert(shared); err != nil {
+		t.Fatal(err)
+	}
+	baseline := trie.NodeCount()
+
+	// 模拟设备频繁上下线，每个设备的主题只订阅一次
+	for i := 0; i < 1000; i++ {
+		subscription := Subscription{ClientID: "device", TopicName: fmt.Sprintf("devices/%d/cmd", i)}
+		if _, err := trie.Insert(subscription); err != nil {
+			t.Fatal(err)
+		}
+		if !trie.Remove(subscription) {
+			t.Fatalf("Remove(%s) = false", subscription.TopicName)
+		}
+	}
+	if got := trie.NodeCount(); got != baseline {
+		t.Fatalf("NodeCount() = %d after churn, want %d", got, baseline)
+	}
+
+	trie.Remove(shared)
+	if got := trie.NodeCount(); got != 0 {
+		t.Fatalf("NodeCount() = %d after removing all subscriptions, want 0", got)
+	}
+}
+
+func TestTopicTrieCompact(t *testing.T) {
+	trie := NewTopicTrie()
+	if _, err := trie.Insert(Subscription{ClientID: "a", TopicName: "a/b"}); err != nil {
+		t.Fatal(err)
+	}
+	// 直接挂上没有订阅的分支，模拟遗留的空节点
+	leaf := &trieNode{}
+	branch := &trieNode{}
+	branch.children.Store("leaf", leaf)
+	trie.root.children.Store("stale", branch)
+
+	before, after := trie.Compact()
+	if before != 4 || after != 2 {
+		t.Fatalf("Compact() = %d, %d, want 4, 2", before, after)
+	}
+	if matched := trie.Match("a/b"); len(matched) != 1 {
+		t.Fatalf("Match(a/b) returned %d subscriptions after compaction", len(matched))
+	}
+}
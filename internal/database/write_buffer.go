@@ -0,0 +1,94 @@
+// Package database 实现了MQTT服务器的数据存储功能
+package database
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/logger"
+)
+
+// pendingWrite 存储不可用期间缓冲的一次写操作
+type pendingWrite struct {
+	key   string                          // 写入对象的键，同一键只保留最新的写操作
+	value any                             // 写入的数据，删除操作为nil
+	apply func(ctx context.Context) error // 写入存储后端
+}
+
+// writeBuffer 存储不可用期间的写缓冲区
+// 同一键的写操作合并为最新的一次并移到队尾，恢复后按顺序重放
+type writeBuffer struct {
+	mu      sync.Mutex
+	order   *list.List               // 按写入顺序排列的pendingWrite
+	entries map[string]*list.Element // 键到队列元素的索引
+	limit   int                      // 最大缓冲数量
+	dropped atomic.Uint64            // 因缓冲区已满丢弃的写操作数
+}
+
+// newWriteBuffer 创建写缓冲区
+func newWriteBuffer(limit int) *writeBuffer {
+	return &writeBuffer{
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+		limit:   limit,
+	}
+}
+
+// add 缓冲一次写操作，缓冲区已满时丢弃最早的写操作
+func (b *writeBuffer) add(key string, value any, apply func(ctx context.Context) error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if element, ok := b.entries[key]; ok {
+		b.order.Remove(element)
+	} else if b.limit > 0 && b.order.Len() >= b.limit {
+		oldest := b.order.Front()
+		b.order.Remove(oldest)
+		delete(b.entries, oldest.Value.(*pendingWrite).key)
+		b.dropped.Add(1)
+		logger.WarnF("Storage write buffer is full, dropping buffered write of %s", oldest.Value.(*pendingWrite).key)
+	}
+	b.entries[key] = b.order.PushBack(&pendingWrite{key: key, value: value, apply: apply})
+}
+
+// pending 返回键对应的缓冲写操作
+func (b *writeBuffer) pending(key string) (*pendingWrite, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	element, ok := b.entries[key]
+	if !ok {
+		return nil, false
+	}
+	return element.Value.(*pendingWrite), true
+}
+
+// front 返回最早的缓冲写操作
+func (b *writeBuffer) front() (*pendingWrite, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	element := b.order.Front()
+	if element == nil {
+		return nil, false
+	}
+	return element.Value.(*pendingWrite), true
+}
+
+// done 移除已写入的缓冲写操作，重放期间同一键有了更新的写操作时保留新的写操作
+func (b *writeBuffer) done(write *pendingWrite) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	element, ok := b.entries[write.key]
+	if !ok || element.Value.(*pendingWrite) != write {
+		return
+	}
+	b.order.Remove(element)
+	delete(b.entries, write.key)
+}
+
+// Len 返回缓冲的写操作数量
+func (b *writeBuffer) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.order.Len()
+}
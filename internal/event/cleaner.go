@@ -6,59 +6,123 @@ import (
 	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/logger"
 	"os"
 	"os/signal"
+	"slices"
 	"sync"
 	"syscall"
 	"time"
 )
 
+// Phase 定义了清理回调的执行阶段，数值越小越先执行
+type Phase int
+
+const (
+	PhaseStopAccept       Phase = iota // 停止接受新连接
+	PhaseDrainConnections              // 分批断开客户端连接
+	PhaseFlushSessions                 // 等待连接处理器保存会话数据
+	PhaseFlushStorage                  // 等待异步写入的数据落盘
+	PhaseStopGrpc                      // 停止gRPC服务
+	PhaseCloseDatabase                 // 关闭数据库连接
+	PhaseDefault                       // 未指定阶段的清理回调
+)
+
+// defaultTimeout 未指定超时时间的清理回调使用的默认超时
+const defaultTimeout = 10 * time.Second
+
+var phaseNames = map[Phase]string{
+	PhaseStopAccept:       "stop-accept",
+	PhaseDrainConnections: "drain-connections",
+	PhaseFlushSessions:    "flush-sessions",
+	PhaseFlushStorage:     "flush-storage",
+	PhaseStopGrpc:         "stop-grpc",
+	PhaseCloseDatabase:    "close-database",
+	PhaseDefault:          "default",
+}
+
+// String 返回阶段名称
+func (p Phase) String() string {
+	return phaseNames[p]
+}
+
 type Callable interface {
 	Invoke(ctx context.Context) error
 }
 
+// cleanerEntry 表示一个已注册的清理回调
+type cleanerEntry struct {
+	phase    Phase
+	timeout  time.Duration
+	callable Callable
+}
+
 type Cleaner struct {
-	cleaners       []Callable
+	cleaners       []cleanerEntry
 	mu             sync.Mutex
 	initOnce       sync.Once
+	cleanOnce      sync.Once
 	cleaning       bool
+	done           chan struct{}
 	loggerShutdown Callable
 }
 
-var cleanerInstance = &Cleaner{}
+var cleanerInstance = &Cleaner{done: make(chan struct{})}
 
 func NewCleaner() *Cleaner {
 	return cleanerInstance
 }
 
+// Add 注册一个在默认阶段执行的清理回调
 func (c *Cleaner) Add(callable Callable) {
+	c.AddPhase(PhaseDefault, defaultTimeout, callable)
+}
+
+// AddPhase 注册一个在指定阶段执行的清理回调
+// 同一阶段内的回调按注册顺序执行，timeout<=0 时使用默认超时
+func (c *Cleaner) AddPhase(phase Phase, timeout time.Duration, callable Callable) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	if c.cleaning {
 		logger.Debug("Cleaner is already shutting down, ignoring new cleaner")
 		return
 	}
-	c.cleaners = append(c.cleaners, callable)
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	c.cleaners = append(c.cleaners, cleanerEntry{phase: phase, timeout: timeout, callable: callable})
 }
 
+// Clean 按阶段顺序执行所有清理回调，然后退出进程
+// 重复调用时会等待第一次调用完成
 func (c *Cleaner) Clean() {
+	c.cleanOnce.Do(c.clean)
+	<-c.done
+}
+
+func (c *Cleaner) clean() {
 	c.mu.Lock()
 	c.cleaning = true // 标记为清理中，阻止后续Add操作
-	cleanersCopy := make([]Callable, len(c.cleaners))
+	cleanersCopy := make([]cleanerEntry, len(c.cleaners))
 	copy(cleanersCopy, c.cleaners)
 	c.mu.Unlock()
 
+	slices.SortStableFunc(cleanersCopy, func(a, b cleanerEntry) int {
+		return int(a.phase) - int(b.phase)
+	})
+
 	logger.DebugF("Starting cleanup of %d registered functions", len(cleanersCopy))
 
 	var errs []error
-	for i, callable := range cleanersCopy {
-		func(idx int, c Callable) { // 使用匿名函数确保defer在每次迭代执行
-			logger.DebugF("Invoking cleaner #%d (%T)", idx+1, c)
-			timeoutCtx, cancelFunc := context.WithTimeout(context.Background(), 10*time.Second)
+	for i, entry := range cleanersCopy {
+		func(idx int, e cleanerEntry) { // 使用匿名函数确保defer在每次迭代执行
+			logger.DebugF("Invoking cleaner #%d (%T) in phase %s, timeout %v", idx+1, e.callable, e.phase, e.timeout)
+			timeoutCtx, cancelFunc := context.WithTimeout(context.Background(), e.timeout)
 			defer cancelFunc() // 确保每次调用后取消上下文
-			if err := c.Invoke(timeoutCtx); err != nil {
-				logger.ErrorF("Cleaner #%d (%T) failed: %v", idx+1, c, err) // 记录类型和错误
+			startTime := time.Now()
+			if err := e.callable.Invoke(timeoutCtx); err != nil {
+				logger.ErrorF("Cleaner #%d (%T) failed in phase %s: %v", idx+1, e.callable, e.phase, err) // 记录类型和错误
 				errs = append(errs, err)
 			}
-		}(i, callable)
+			logger.DebugF("Cleaner #%d (%T) finished, cost: %v", idx+1, e.callable, time.Since(startTime))
+		}(i, entry)
 	}
 
 	if len(errs) > 0 {
@@ -76,6 +140,7 @@ func (c *Cleaner) Clean() {
 	if err := c.loggerShutdown.Invoke(shutdownCtx); err != nil {
 		fmt.Fprintf(os.Stderr, "LOGGER SHUTDOWN ERROR: %v\n", err)
 	}
+	close(c.done)
 	syscall.Exit(0)
 }
 
@@ -0,0 +1,40 @@
+package event
+
+import (
+	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/config"
+	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/logger"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+var reloadOnce sync.Once
+
+// ReloadConfig 重新加载配置文件并记录结果
+func ReloadConfig() (*config.ReloadResult, error) {
+	result, err := config.Reload()
+	if err != nil {
+		logger.ErrorF("Fail to reload config, details: %v", err)
+		return nil, err
+	}
+	logger.InfoF("Config reloaded, applied: %v", result.Applied)
+	if len(result.RestartRequired) > 0 {
+		logger.WarnF("Config fields changed but restart required: %v", result.RestartRequired)
+	}
+	return result, nil
+}
+
+// ListenReload 监听SIGHUP信号，收到信号时重新加载配置
+func ListenReload() {
+	reloadOnce.Do(func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGHUP)
+		go func() {
+			for range signals {
+				logger.Info("Received SIGHUP signal, reloading config")
+				_, _ = ReloadConfig()
+			}
+		}()
+	})
+}
@@ -0,0 +1,187 @@
+package __
+
+import (
+	"errors"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/connection"
+	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/database"
+	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/event"
+	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/logger"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// AdminService 实现了服务器管理相关的gRPC接口
+type AdminService struct {
+	UnimplementedAdminServer
+}
+
+// ReloadConfig 重新加载配置文件
+func (*AdminService) ReloadConfig(context.Context, *Empty) (*ReloadConfigResponse, error) {
+	result, err := event.ReloadConfig()
+	if err != nil {
+		return &ReloadConfigResponse{Status: false}, err
+	}
+	return &ReloadConfigResponse{
+		Status:          true,
+		Applied:         result.Applied,
+		RestartRequired: result.RestartRequired,
+	}, nil
+}
+
+// ListSlowConsumers 列出处于慢消费者状态或因出站队列溢出丢弃过报文的客户端
+func (*AdminService) ListSlowConsumers(context.Context, *Empty) (*SlowConsumersResponse, error) {
+	statuses := connection.GetConnectionManager().SlowConsumers()
+	consumers := make([]*SlowConsumer, len(statuses))
+	for i, status := range statuses {
+		var lastOverflow int64
+		if !status.LastOverflow.IsZero() {
+			lastOverflow = status.LastOverflow.UnixMilli()
+		}
+		consumers[i] = &SlowConsumer{
+			ClientId:        status.ClientID,
+			ConnId:          status.ConnID,
+			QueueDepth:      int64(status.QueueDepth),
+			QueueBytes:      int64(status.QueueBytes),
+			DroppedMessages: status.DroppedMessages,
+			Slow:            status.Slow,
+			LastOverflow:    lastOverflow,
+		}
+	}
+	stats := connection.GetStats()
+	return &SlowConsumersResponse{
+		Consumers:       consumers,
+		SlowConsumers:   stats.SlowConsumers,
+		SlowDisconnects: stats.SlowDisconnects,
+		DroppedMessages: stats.DroppedMessages,
+	}, nil
+}
+
+// StorageHealth 返回存储后端健康状态，存储不可用期间degraded为true
+func (*AdminService) StorageHealth(context.Context, *Empty) (*StorageHealthResponse, error) {
+	health := database.NewDatabaseStore().Health()
+	return &StorageHealthResponse{
+		Backend:       health.Backend,
+		Degraded:      health.Degraded,
+		BreakerState:  health.State.String(),
+		Since:         health.Since.UnixMilli(),
+		PendingWrites: int64(health.PendingWrites),
+		DroppedWrites: health.DroppedWrites,
+		LastError:     health.LastError,
+	}, nil
+}
+
+// CompactTopicTree 立即压缩主题树，返回内存订阅树与存储后端主题树压缩前后的节点数
+func (*AdminService) CompactTopicTree(ctx context.Context, _ *Empty) (*CompactTopicTreeResponse, error) {
+	report, err := database.NewDatabaseStore().CompactTopicTree(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &CompactTopicTreeResponse{
+		MemoryNodesBefore:  int64(report.MemoryNodesBefore),
+		MemoryNodesAfter:   int64(report.MemoryNodesAfter),
+		StorageNodesBefore: int64(report.StorageNodesBefore),
+		StorageNodesAfter:  int64(report.StorageNodesAfter),
+	}, nil
+}
+
+// logLevels 返回当前的日志级别设置，模块按名称排序
+func logLevels() *LogLevelsResponse {
+	levels := logger.GetLevels()
+	modules := levels.Modules()
+	response := &LogLevelsResponse{Level: logger.LevelName(levels.Base()), Modules: make([]*LogLevel, 0, len(modules))}
+	for _, module := range slices.Sorted(maps.Keys(modules)) {
+		response.Modules = append(response.Modules, &LogLevel{Module: module, Level: logger.LevelName(modules[module])})
+	}
+	return response
+}
+
+// GetLogLevels 返回默认日志级别与单独设置了级别的模块
+func (*AdminService) GetLogLevels(context.Context, *Empty) (*LogLevelsResponse, error) {
+	return logLevels(), nil
+}
+
+// SetLogLevel 在运行时调整日志级别，module为空时调整默认级别，level为空时删除模块单独设置的级别
+// 调整只在本次运行中有效，重新加载配置文件时会被配置中的级别覆盖
+func (*AdminService) SetLogLevel(_ context.Context, request *SetLogLevelRequest) (*LogLevelsResponse, error) {
+	levels := logger.GetLevels()
+	if request.Level == "" {
+		if request.Module == "" {
+			return nil, status.Error(codes.InvalidArgument, "level must not be empty when setting the default level")
+		}
+		levels.ResetModule(request.Module)
+		logger.Info("Log level reset", logger.KeyModule, request.Module)
+		return logLevels(), nil
+	}
+	level, err := logger.ParseLevel(request.Level)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if request.Module == "" {
+		levels.SetBase(level)
+	} else {
+		levels.SetModule(request.Module, level)
+	}
+	logger.Info("Log level changed", logger.KeyModule, request.Module, "level", logger.LevelName(level))
+	return logLevels(), nil
+}
+
+// packetTrace 将报文跟踪转换为接口返回值
+func packetTrace(trace *connection.PacketTrace) *PacketTrace {
+	networks := make([]string, len(trace.Filter.Networks))
+	for i, network := range trace.Filter.Networks {
+		networks[i] = network.String()
+	}
+	return &PacketTrace{
+		Id:        trace.ID,
+		ClientIds: trace.Filter.ClientIDs,
+		Networks:  networks,
+		Topics:    trace.Filter.Topics,
+		Created:   trace.Created.UnixMilli(),
+		Expires:   trace.Expires.UnixMilli(),
+		Packets:   trace.Packets(),
+	}
+}
+
+// StartPacketTrace 在一段时间内输出匹配的客户端收发的报文，ttlSeconds为0时持续5分钟
+// 不同类型的条件需要同时满足，同一类型的条件满足其一即可，networks支持CIDR与单个IP地址
+func (*AdminService) StartPacketTrace(_ context.Context, request *StartPacketTraceRequest) (*PacketTrace, error) {
+	filter := connection.TraceFilter{ClientIDs: request.ClientIds, Topics: request.Topics}
+	for _, network := range request.Networks {
+		prefix, err := connection.ParseNetwork(network)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid network %q: %v", network, err)
+		}
+		filter.Networks = append(filter.Networks, prefix)
+	}
+	trace, err := connection.StartTrace(filter, time.Duration(request.TtlSeconds)*time.Second)
+	if errors.Is(err, connection.ErrTooManyTraces) {
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return packetTrace(trace), nil
+}
+
+// StopPacketTrace 提前停止报文跟踪
+func (*AdminService) StopPacketTrace(_ context.Context, request *StopPacketTraceRequest) (*Empty, error) {
+	if !connection.StopTrace(request.Id) {
+		return nil, status.Errorf(codes.NotFound, "packet trace %q not found", request.Id)
+	}
+	return &Empty{}, nil
+}
+
+// ListPacketTraces 列出当前开启的报文跟踪
+func (*AdminService) ListPacketTraces(context.Context, *Empty) (*PacketTracesResponse, error) {
+	traces := connection.Traces()
+	response := &PacketTracesResponse{Traces: make([]*PacketTrace, len(traces))}
+	for i, trace := range traces {
+		response.Traces[i] = packetTrace(trace)
+	}
+	return response, nil
+}
@@ -3,8 +3,12 @@ package __
 import (
 	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/connection"
 	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/database"
+	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/logger"
 	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/packet"
+	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/tracing"
 	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"net"
 )
 
 type GRPCService struct{}
@@ -14,9 +18,11 @@ func (s *GRPCService) mustEmbedUnimplementedDeviceServer() {
 	panic("implement me")
 }
 
-func (*GRPCService) GetAllDevices(context.Context, *Empty) (*AllDevicesResponse, error) {
+func (*GRPCService) GetAllDevices(ctx context.Context, _ *Empty) (*AllDevicesResponse, error) {
 	databaseStore := database.NewDatabaseStore()
+	_, span := tracing.Start(ctx, "storage.get_all_sessions")
 	sessions := databaseStore.GetAllSession()
+	span.End()
 	devices := make([]*Devices, len(sessions))
 	i := 0
 	for _, value := range sessions {
@@ -47,9 +53,36 @@ func (s *GRPCService) SetDevicesState(ctx context.Context, device *TargetDevice)
 		publishPacket.Payload = []byte("OFF")
 	}
 	sender := connection.NewMessageSender()
-	err := sender.SendMessage(device.DevicesId, packet.NewPublishPacket(publishPacket))
+	err := packet.Deliver(ctx, sender, device.DevicesId, net.Buffers{packet.NewPublishPacket(publishPacket)}, publishPacket.PacketFlag.QoS, 0)
 	if err != nil {
 		return &ExecuteResponse{Status: false}, err
 	}
 	return &ExecuteResponse{Status: true}, nil
 }
+
+// StopCallback 实现了gRPC服务关闭回调接口
+type StopCallback struct {
+	server *grpc.Server
+}
+
+// NewStopCallback 创建新的gRPC服务关闭回调实例
+func NewStopCallback(server *grpc.Server) *StopCallback {
+	return &StopCallback{server: server}
+}
+
+// Invoke 优雅停止gRPC服务，超时后强制停止
+func (sc *StopCallback) Invoke(ctx context.Context) error {
+	logger.Info("Stopping grpc server")
+	done := make(chan struct{})
+	go func() {
+		sc.server.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		sc.server.Stop()
+		return ctx.Err()
+	}
+}
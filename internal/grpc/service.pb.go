@@ -241,6 +241,790 @@ func (x *ExecuteResponse) GetStatus() bool {
 	return false
 }
 
+type ReloadConfigResponse struct {
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	Status          bool                   `protobuf:"varint,1,opt,name=status,proto3" json:"status,omitempty"`
+	Applied         []string               `protobuf:"bytes,2,rep,name=applied,proto3" json:"applied,omitempty"`
+	RestartRequired []string               `protobuf:"bytes,3,rep,name=restartRequired,proto3" json:"restartRequired,omitempty"`
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *ReloadConfigResponse) Reset() {
+	*x = ReloadConfigResponse{}
+	mi := &file_service_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ReloadConfigResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReloadConfigResponse) ProtoMessage() {}
+
+func (x *ReloadConfigResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_service_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReloadConfigResponse.ProtoReflect.Descriptor instead.
+func (*ReloadConfigResponse) Descriptor() ([]byte, []int) {
+	return file_service_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *ReloadConfigResponse) GetStatus() bool {
+	if x != nil {
+		return x.Status
+	}
+	return false
+}
+
+func (x *ReloadConfigResponse) GetApplied() []string {
+	if x != nil {
+		return x.Applied
+	}
+	return nil
+}
+
+func (x *ReloadConfigResponse) GetRestartRequired() []string {
+	if x != nil {
+		return x.RestartRequired
+	}
+	return nil
+}
+
+type SlowConsumer struct {
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	ClientId        string                 `protobuf:"bytes,1,opt,name=clientId,proto3" json:"clientId,omitempty"`
+	ConnId          string                 `protobuf:"bytes,2,opt,name=connId,proto3" json:"connId,omitempty"`
+	QueueDepth      int64                  `protobuf:"varint,3,opt,name=queueDepth,proto3" json:"queueDepth,omitempty"`
+	QueueBytes      int64                  `protobuf:"varint,4,opt,name=queueBytes,proto3" json:"queueBytes,omitempty"`
+	DroppedMessages uint64                 `protobuf:"varint,5,opt,name=droppedMessages,proto3" json:"droppedMessages,omitempty"`
+	Slow            bool                   `protobuf:"varint,6,opt,name=slow,proto3" json:"slow,omitempty"`
+	LastOverflow    int64                  `protobuf:"varint,7,opt,name=lastOverflow,proto3" json:"lastOverflow,omitempty"`
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *SlowConsumer) Reset() {
+	*x = SlowConsumer{}
+	mi := &file_service_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SlowConsumer) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SlowConsumer) ProtoMessage() {}
+
+func (x *SlowConsumer) ProtoReflect() protoreflect.Message {
+	mi := &file_service_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SlowConsumer.ProtoReflect.Descriptor instead.
+func (*SlowConsumer) Descriptor() ([]byte, []int) {
+	return file_service_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *SlowConsumer) GetClientId() string {
+	if x != nil {
+		return x.ClientId
+	}
+	return ""
+}
+
+func (x *SlowConsumer) GetConnId() string {
+	if x != nil {
+		return x.ConnId
+	}
+	return ""
+}
+
+func (x *SlowConsumer) GetQueueDepth() int64 {
+	if x != nil {
+		return x.QueueDepth
+	}
+	return 0
+}
+
+func (x *SlowConsumer) GetQueueBytes() int64 {
+	if x != nil {
+		return x.QueueBytes
+	}
+	return 0
+}
+
+func (x *SlowConsumer) GetDroppedMessages() uint64 {
+	if x != nil {
+		return x.DroppedMessages
+	}
+	return 0
+}
+
+func (x *SlowConsumer) GetSlow() bool {
+	if x != nil {
+		return x.Slow
+	}
+	return false
+}
+
+func (x *SlowConsumer) GetLastOverflow() int64 {
+	if x != nil {
+		return x.LastOverflow
+	}
+	return 0
+}
+
+type SlowConsumersResponse struct {
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	Consumers       []*SlowConsumer        `protobuf:"bytes,1,rep,name=consumers,proto3" json:"consumers,omitempty"`
+	SlowConsumers   int64                  `protobuf:"varint,2,opt,name=slowConsumers,proto3" json:"slowConsumers,omitempty"`
+	SlowDisconnects uint64                 `protobuf:"varint,3,opt,name=slowDisconnects,proto3" json:"slowDisconnects,omitempty"`
+	DroppedMessages uint64                 `protobuf:"varint,4,opt,name=droppedMessages,proto3" json:"droppedMessages,omitempty"`
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *SlowConsumersResponse) Reset() {
+	*x = SlowConsumersResponse{}
+	mi := &file_service_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SlowConsumersResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SlowConsumersResponse) ProtoMessage() {}
+
+func (x *SlowConsumersResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_service_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SlowConsumersResponse.ProtoReflect.Descriptor instead.
+func (*SlowConsumersResponse) Descriptor() ([]byte, []int) {
+	return file_service_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *SlowConsumersResponse) GetConsumers() []*SlowConsumer {
+	if x != nil {
+		return x.Consumers
+	}
+	return nil
+}
+
+func (x *SlowConsumersResponse) GetSlowConsumers() int64 {
+	if x != nil {
+		return x.SlowConsumers
+	}
+	return 0
+}
+
+func (x *SlowConsumersResponse) GetSlowDisconnects() uint64 {
+	if x != nil {
+		return x.SlowDisconnects
+	}
+	return 0
+}
+
+func (x *SlowConsumersResponse) GetDroppedMessages() uint64 {
+	if x != nil {
+		return x.DroppedMessages
+	}
+	return 0
+}
+
+type StorageHealthResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Backend       string                 `protobuf:"bytes,1,opt,name=backend,proto3" json:"backend,omitempty"`
+	Degraded      bool                   `protobuf:"varint,2,opt,name=degraded,proto3" json:"degraded,omitempty"`
+	BreakerState  string                 `protobuf:"bytes,3,opt,name=breakerState,proto3" json:"breakerState,omitempty"`
+	Since         int64                  `protobuf:"varint,4,opt,name=since,proto3" json:"since,omitempty"`
+	PendingWrites int64                  `protobuf:"varint,5,opt,name=pendingWrites,proto3" json:"pendingWrites,omitempty"`
+	DroppedWrites uint64                 `protobuf:"varint,6,opt,name=droppedWrites,proto3" json:"droppedWrites,omitempty"`
+	LastError     string                 `protobuf:"bytes,7,opt,name=lastError,proto3" json:"lastError,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StorageHealthResponse) Reset() {
+	*x = StorageHealthResponse{}
+	mi := &file_service_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StorageHealthResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StorageHealthResponse) ProtoMessage() {}
+
+func (x *StorageHealthResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_service_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StorageHealthResponse.ProtoReflect.Descriptor instead.
+func (*StorageHealthResponse) Descriptor() ([]byte, []int) {
+	return file_service_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *StorageHealthResponse) GetBackend() string {
+	if x != nil {
+		return x.Backend
+	}
+	return ""
+}
+
+func (x *StorageHealthResponse) GetDegraded() bool {
+	if x != nil {
+		return x.Degraded
+	}
+	return false
+}
+
+func (x *StorageHealthResponse) GetBreakerState() string {
+	if x != nil {
+		return x.BreakerState
+	}
+	return ""
+}
+
+func (x *StorageHealthResponse) GetSince() int64 {
+	if x != nil {
+		return x.Since
+	}
+	return 0
+}
+
+func (x *StorageHealthResponse) GetPendingWrites() int64 {
+	if x != nil {
+		return x.PendingWrites
+	}
+	return 0
+}
+
+func (x *StorageHealthResponse) GetDroppedWrites() uint64 {
+	if x != nil {
+		return x.DroppedWrites
+	}
+	return 0
+}
+
+func (x *StorageHealthResponse) GetLastError() string {
+	if x != nil {
+		return x.LastError
+	}
+	return ""
+}
+
+type CompactTopicTreeResponse struct {
+	state              protoimpl.MessageState `protogen:"open.v1"`
+	MemoryNodesBefore  int64                  `protobuf:"varint,1,opt,name=memoryNodesBefore,proto3" json:"memoryNodesBefore,omitempty"`
+	MemoryNodesAfter   int64                  `protobuf:"varint,2,opt,name=memoryNodesAfter,proto3" json:"memoryNodesAfter,omitempty"`
+	StorageNodesBefore int64                  `protobuf:"varint,3,opt,name=storageNodesBefore,proto3" json:"storageNodesBefore,omitempty"`
+	StorageNodesAfter  int64                  `protobuf:"varint,4,opt,name=storageNodesAfter,proto3" json:"storageNodesAfter,omitempty"`
+	unknownFields      protoimpl.UnknownFields
+	sizeCache          protoimpl.SizeCache
+}
+
+func (x *CompactTopicTreeResponse) Reset() {
+	*x = CompactTopicTreeResponse{}
+	mi := &file_service_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CompactTopicTreeResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CompactTopicTreeResponse) ProtoMessage() {}
+
+func (x *CompactTopicTreeResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_service_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CompactTopicTreeResponse.ProtoReflect.Descriptor instead.
+func (*CompactTopicTreeResponse) Descriptor() ([]byte, []int) {
+	return file_service_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *CompactTopicTreeResponse) GetMemoryNodesBefore() int64 {
+	if x != nil {
+		return x.MemoryNodesBefore
+	}
+	return 0
+}
+
+func (x *CompactTopicTreeResponse) GetMemoryNodesAfter() int64 {
+	if x != nil {
+		return x.MemoryNodesAfter
+	}
+	return 0
+}
+
+func (x *CompactTopicTreeResponse) GetStorageNodesBefore() int64 {
+	if x != nil {
+		return x.StorageNodesBefore
+	}
+	return 0
+}
+
+func (x *CompactTopicTreeResponse) GetStorageNodesAfter() int64 {
+	if x != nil {
+		return x.StorageNodesAfter
+	}
+	return 0
+}
+
+type LogLevel struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Module        string                 `protobuf:"bytes,1,opt,name=module,proto3" json:"module,omitempty"`
+	Level         string                 `protobuf:"bytes,2,opt,name=level,proto3" json:"level,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LogLevel) Reset() {
+	*x = LogLevel{}
+	mi := &file_service_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LogLevel) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LogLevel) ProtoMessage() {}
+
+func (x *LogLevel) ProtoReflect() protoreflect.Message {
+	mi := &file_service_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LogLevel.ProtoReflect.Descriptor instead.
+func (*LogLevel) Descriptor() ([]byte, []int) {
+	return file_service_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *LogLevel) GetModule() string {
+	if x != nil {
+		return x.Module
+	}
+	return ""
+}
+
+func (x *LogLevel) GetLevel() string {
+	if x != nil {
+		return x.Level
+	}
+	return ""
+}
+
+type SetLogLevelRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Module        string                 `protobuf:"bytes,1,opt,name=module,proto3" json:"module,omitempty"`
+	Level         string                 `protobuf:"bytes,2,opt,name=level,proto3" json:"level,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SetLogLevelRequest) Reset() {
+	*x = SetLogLevelRequest{}
+	mi := &file_service_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SetLogLevelRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SetLogLevelRequest) ProtoMessage() {}
+
+func (x *SetLogLevelRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_service_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SetLogLevelRequest.ProtoReflect.Descriptor instead.
+func (*SetLogLevelRequest) Descriptor() ([]byte, []int) {
+	return file_service_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *SetLogLevelRequest) GetModule() string {
+	if x != nil {
+		return x.Module
+	}
+	return ""
+}
+
+func (x *SetLogLevelRequest) GetLevel() string {
+	if x != nil {
+		return x.Level
+	}
+	return ""
+}
+
+type LogLevelsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Level         string                 `protobuf:"bytes,1,opt,name=level,proto3" json:"level,omitempty"`
+	Modules       []*LogLevel            `protobuf:"bytes,2,rep,name=modules,proto3" json:"modules,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LogLevelsResponse) Reset() {
+	*x = LogLevelsResponse{}
+	mi := &file_service_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LogLevelsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LogLevelsResponse) ProtoMessage() {}
+
+func (x *LogLevelsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_service_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LogLevelsResponse.ProtoReflect.Descriptor instead.
+func (*LogLevelsResponse) Descriptor() ([]byte, []int) {
+	return file_service_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *LogLevelsResponse) GetLevel() string {
+	if x != nil {
+		return x.Level
+	}
+	return ""
+}
+
+func (x *LogLevelsResponse) GetModules() []*LogLevel {
+	if x != nil {
+		return x.Modules
+	}
+	return nil
+}
+
+type StartPacketTraceRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ClientIds     []string               `protobuf:"bytes,1,rep,name=clientIds,proto3" json:"clientIds,omitempty"`
+	Networks      []string               `protobuf:"bytes,2,rep,name=networks,proto3" json:"networks,omitempty"`
+	Topics        []string               `protobuf:"bytes,3,rep,name=topics,proto3" json:"topics,omitempty"`
+	TtlSeconds    int64                  `protobuf:"varint,4,opt,name=ttlSeconds,proto3" json:"ttlSeconds,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StartPacketTraceRequest) Reset() {
+	*x = StartPacketTraceRequest{}
+	mi := &file_service_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StartPacketTraceRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StartPacketTraceRequest) ProtoMessage() {}
+
+func (x *StartPacketTraceRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_service_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StartPacketTraceRequest.ProtoReflect.Descriptor instead.
+func (*StartPacketTraceRequest) Descriptor() ([]byte, []int) {
+	return file_service_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *StartPacketTraceRequest) GetClientIds() []string {
+	if x != nil {
+		return x.ClientIds
+	}
+	return nil
+}
+
+func (x *StartPacketTraceRequest) GetNetworks() []string {
+	if x != nil {
+		return x.Networks
+	}
+	return nil
+}
+
+func (x *StartPacketTraceRequest) GetTopics() []string {
+	if x != nil {
+		return x.Topics
+	}
+	return nil
+}
+
+func (x *StartPacketTraceRequest) GetTtlSeconds() int64 {
+	if x != nil {
+		return x.TtlSeconds
+	}
+	return 0
+}
+
+type StopPacketTraceRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StopPacketTraceRequest) Reset() {
+	*x = StopPacketTraceRequest{}
+	mi := &file_service_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StopPacketTraceRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StopPacketTraceRequest) ProtoMessage() {}
+
+func (x *StopPacketTraceRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_service_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StopPacketTraceRequest.ProtoReflect.Descriptor instead.
+func (*StopPacketTraceRequest) Descriptor() ([]byte, []int) {
+	return file_service_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *StopPacketTraceRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+type PacketTrace struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	ClientIds     []string               `protobuf:"bytes,2,rep,name=clientIds,proto3" json:"clientIds,omitempty"`
+	Networks      []string               `protobuf:"bytes,3,rep,name=networks,proto3" json:"networks,omitempty"`
+	Topics        []string               `protobuf:"bytes,4,rep,name=topics,proto3" json:"topics,omitempty"`
+	Created       int64                  `protobuf:"varint,5,opt,name=created,proto3" json:"created,omitempty"`
+	Expires       int64                  `protobuf:"varint,6,opt,name=expires,proto3" json:"expires,omitempty"`
+	Packets       uint64                 `protobuf:"varint,7,opt,name=packets,proto3" json:"packets,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PacketTrace) Reset() {
+	*x = PacketTrace{}
+	mi := &file_service_proto_msgTypes[15]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PacketTrace) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PacketTrace) ProtoMessage() {}
+
+func (x *PacketTrace) ProtoReflect() protoreflect.Message {
+	mi := &file_service_proto_msgTypes[15]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PacketTrace.ProtoReflect.Descriptor instead.
+func (*PacketTrace) Descriptor() ([]byte, []int) {
+	return file_service_proto_rawDescGZIP(), []int{15}
+}
+
+func (x *PacketTrace) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *PacketTrace) GetClientIds() []string {
+	if x != nil {
+		return x.ClientIds
+	}
+	return nil
+}
+
+func (x *PacketTrace) GetNetworks() []string {
+	if x != nil {
+		return x.Networks
+	}
+	return nil
+}
+
+func (x *PacketTrace) GetTopics() []string {
+	if x != nil {
+		return x.Topics
+	}
+	return nil
+}
+
+func (x *PacketTrace) GetCreated() int64 {
+	if x != nil {
+		return x.Created
+	}
+	return 0
+}
+
+func (x *PacketTrace) GetExpires() int64 {
+	if x != nil {
+		return x.Expires
+	}
+	return 0
+}
+
+func (x *PacketTrace) GetPackets() uint64 {
+	if x != nil {
+		return x.Packets
+	}
+	return 0
+}
+
+type PacketTracesResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Traces        []*PacketTrace         `protobuf:"bytes,1,rep,name=traces,proto3" json:"traces,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PacketTracesResponse) Reset() {
+	*x = PacketTracesResponse{}
+	mi := &file_service_proto_msgTypes[16]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PacketTracesResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PacketTracesResponse) ProtoMessage() {}
+
+func (x *PacketTracesResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_service_proto_msgTypes[16]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PacketTracesResponse.ProtoReflect.Descriptor instead.
+func (*PacketTracesResponse) Descriptor() ([]byte, []int) {
+	return file_service_proto_rawDescGZIP(), []int{16}
+}
+
+func (x *PacketTracesResponse) GetTraces() []*PacketTrace {
+	if x != nil {
+		return x.Traces
+	}
+	return nil
+}
+
 var File_service_proto protoreflect.FileDescriptor
 
 const file_service_proto_rawDesc = "" +
@@ -255,10 +1039,82 @@ const file_service_proto_rawDesc = "" +
 	"\tDevicesId\x18\x01 \x01(\tR\tDevicesId\x12\x16\n" +
 	"\x06status\x18\x02 \x01(\bR\x06status\")\n" +
 	"\x0fExecuteResponse\x12\x16\n" +
-	"\x06status\x18\x01 \x01(\bR\x06status2~\n" +
+	"\x06status\x18\x01 \x01(\bR\x06status\"r\n" +
+	"\x14ReloadConfigResponse\x12\x16\n" +
+	"\x06status\x18\x01 \x01(\bR\x06status\x12\x18\n" +
+	"\aapplied\x18\x02 \x03(\tR\aapplied\x12(\n" +
+	"\x0frestartRequired\x18\x03 \x03(\tR\x0frestartRequired\"\xe4\x01\n" +
+	"\fSlowConsumer\x12\x1a\n" +
+	"\bclientId\x18\x01 \x01(\tR\bclientId\x12\x16\n" +
+	"\x06connId\x18\x02 \x01(\tR\x06connId\x12\x1e\n" +
+	"\n" +
+	"queueDepth\x18\x03 \x01(\x03R\n" +
+	"queueDepth\x12\x1e\n" +
+	"\n" +
+	"queueBytes\x18\x04 \x01(\x03R\n" +
+	"queueBytes\x12(\n" +
+	"\x0fdroppedMessages\x18\x05 \x01(\x04R\x0fdroppedMessages\x12\x12\n" +
+	"\x04slow\x18\x06 \x01(\bR\x04slow\x12\"\n" +
+	"\flastOverflow\x18\a \x01(\x03R\flastOverflow\"\xc3\x01\n" +
+	"\x15SlowConsumersResponse\x120\n" +
+	"\tconsumers\x18\x01 \x03(\v2\x12.grpc.SlowConsumerR\tconsumers\x12$\n" +
+	"\rslowConsumers\x18\x02 \x01(\x03R\rslowConsumers\x12(\n" +
+	"\x0fslowDisconnects\x18\x03 \x01(\x04R\x0fslowDisconnects\x12(\n" +
+	"\x0fdroppedMessages\x18\x04 \x01(\x04R\x0fdroppedMessages\"\xf1\x01\n" +
+	"\x15StorageHealthResponse\x12\x18\n" +
+	"\abackend\x18\x01 \x01(\tR\abackend\x12\x1a\n" +
+	"\bdegraded\x18\x02 \x01(\bR\bdegraded\x12\"\n" +
+	"\fbreakerState\x18\x03 \x01(\tR\fbreakerState\x12\x14\n" +
+	"\x05since\x18\x04 \x01(\x03R\x05since\x12$\n" +
+	"\rpendingWrites\x18\x05 \x01(\x03R\rpendingWrites\x12$\n" +
+	"\rdroppedWrites\x18\x06 \x01(\x04R\rdroppedWrites\x12\x1c\n" +
+	"\tlastError\x18\a \x01(\tR\tlastError\"\xd2\x01\n" +
+	"\x18CompactTopicTreeResponse\x12,\n" +
+	"\x11memoryNodesBefore\x18\x01 \x01(\x03R\x11memoryNodesBefore\x12*\n" +
+	"\x10memoryNodesAfter\x18\x02 \x01(\x03R\x10memoryNodesAfter\x12.\n" +
+	"\x12storageNodesBefore\x18\x03 \x01(\x03R\x12storageNodesBefore\x12,\n" +
+	"\x11storageNodesAfter\x18\x04 \x01(\x03R\x11storageNodesAfter\"8\n" +
+	"\bLogLevel\x12\x16\n" +
+	"\x06module\x18\x01 \x01(\tR\x06module\x12\x14\n" +
+	"\x05level\x18\x02 \x01(\tR\x05level\"B\n" +
+	"\x12SetLogLevelRequest\x12\x16\n" +
+	"\x06module\x18\x01 \x01(\tR\x06module\x12\x14\n" +
+	"\x05level\x18\x02 \x01(\tR\x05level\"S\n" +
+	"\x11LogLevelsResponse\x12\x14\n" +
+	"\x05level\x18\x01 \x01(\tR\x05level\x12(\n" +
+	"\amodules\x18\x02 \x03(\v2\x0e.grpc.LogLevelR\amodules\"\x8b\x01\n" +
+	"\x17StartPacketTraceRequest\x12\x1c\n" +
+	"\tclientIds\x18\x01 \x03(\tR\tclientIds\x12\x1a\n" +
+	"\bnetworks\x18\x02 \x03(\tR\bnetworks\x12\x16\n" +
+	"\x06topics\x18\x03 \x03(\tR\x06topics\x12\x1e\n" +
+	"\n" +
+	"ttlSeconds\x18\x04 \x01(\x03R\n" +
+	"ttlSeconds\"(\n" +
+	"\x16StopPacketTraceRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\"\xbd\x01\n" +
+	"\vPacketTrace\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x1c\n" +
+	"\tclientIds\x18\x02 \x03(\tR\tclientIds\x12\x1a\n" +
+	"\bnetworks\x18\x03 \x03(\tR\bnetworks\x12\x16\n" +
+	"\x06topics\x18\x04 \x03(\tR\x06topics\x12\x18\n" +
+	"\acreated\x18\x05 \x01(\x03R\acreated\x12\x18\n" +
+	"\aexpires\x18\x06 \x01(\x03R\aexpires\x12\x18\n" +
+	"\apackets\x18\a \x01(\x04R\apackets\"A\n" +
+	"\x14PacketTracesResponse\x12)\n" +
+	"\x06traces\x18\x01 \x03(\v2\x11.grpc.PacketTraceR\x06traces2~\n" +
 	"\x06Device\x126\n" +
 	"\rGetAllDevices\x12\v.grpc.Empty\x1a\x18.grpc.AllDevicesResponse\x12<\n" +
-	"\x0fSetDevicesState\x12\x12.grpc.TargetDevice\x1a\x15.grpc.ExecuteResponseB\x04Z\x02./b\x06proto3"
+	"\x0fSetDevicesState\x12\x12.grpc.TargetDevice\x1a\x15.grpc.ExecuteResponse2\xb4\x04\n" +
+	"\x05Admin\x127\n" +
+	"\fReloadConfig\x12\v.grpc.Empty\x1a\x1a.grpc.ReloadConfigResponse\x12=\n" +
+	"\x11ListSlowConsumers\x12\v.grpc.Empty\x1a\x1b.grpc.SlowConsumersResponse\x129\n" +
+	"\rStorageHealth\x12\v.grpc.Empty\x1a\x1b.grpc.StorageHealthResponse\x12?\n" +
+	"\x10CompactTopicTree\x12\v.grpc.Empty\x1a\x1e.grpc.CompactTopicTreeResponse\x124\n" +
+	"\fGetLogLevels\x12\v.grpc.Empty\x1a\x17.grpc.LogLevelsResponse\x12@\n" +
+	"\vSetLogLevel\x12\x18.grpc.SetLogLevelRequest\x1a\x17.grpc.LogLevelsResponse\x12D\n" +
+	"\x10StartPacketTrace\x12\x1d.grpc.StartPacketTraceRequest\x1a\x11.grpc.PacketTrace\x12<\n" +
+	"\x0fStopPacketTrace\x12\x1c.grpc.StopPacketTraceRequest\x1a\v.grpc.Empty\x12;\n" +
+	"\x10ListPacketTraces\x12\v.grpc.Empty\x1a\x1a.grpc.PacketTracesResponseB\x04Z\x02./b\x06proto3"
 
 var (
 	file_service_proto_rawDescOnce sync.Once
@@ -272,25 +1128,58 @@ func file_service_proto_rawDescGZIP() []byte {
 	return file_service_proto_rawDescData
 }
 
-var file_service_proto_msgTypes = make([]protoimpl.MessageInfo, 5)
+var file_service_proto_msgTypes = make([]protoimpl.MessageInfo, 17)
 var file_service_proto_goTypes = []any{
-	(*Empty)(nil),              // 0: grpc.Empty
-	(*Devices)(nil),            // 1: grpc.Devices
-	(*AllDevicesResponse)(nil), // 2: grpc.AllDevicesResponse
-	(*TargetDevice)(nil),       // 3: grpc.TargetDevice
-	(*ExecuteResponse)(nil),    // 4: grpc.ExecuteResponse
+	(*Empty)(nil),                    // 0: grpc.Empty
+	(*Devices)(nil),                  // 1: grpc.Devices
+	(*AllDevicesResponse)(nil),       // 2: grpc.AllDevicesResponse
+	(*TargetDevice)(nil),             // 3: grpc.TargetDevice
+	(*ExecuteResponse)(nil),          // 4: grpc.ExecuteResponse
+	(*ReloadConfigResponse)(nil),     // 5: grpc.ReloadConfigResponse
+	(*SlowConsumer)(nil),             // 6: grpc.SlowConsumer
+	(*SlowConsumersResponse)(nil),    // 7: grpc.SlowConsumersResponse
+	(*StorageHealthResponse)(nil),    // 8: grpc.StorageHealthResponse
+	(*CompactTopicTreeResponse)(nil), // 9: grpc.CompactTopicTreeResponse
+	(*LogLevel)(nil),                 // 10: grpc.LogLevel
+	(*SetLogLevelRequest)(nil),       // 11: grpc.SetLogLevelRequest
+	(*LogLevelsResponse)(nil),        // 12: grpc.LogLevelsResponse
+	(*StartPacketTraceRequest)(nil),  // 13: grpc.StartPacketTraceRequest
+	(*StopPacketTraceRequest)(nil),   // 14: grpc.StopPacketTraceRequest
+	(*PacketTrace)(nil),              // 15: grpc.PacketTrace
+	(*PacketTracesResponse)(nil),     // 16: grpc.PacketTracesResponse
 }
 var file_service_proto_depIdxs = []int32{
-	1, // 0: grpc.AllDevicesResponse.devices:type_name -> grpc.Devices
-	0, // 1: grpc.Device.GetAllDevices:input_type -> grpc.Empty
-	3, // 2: grpc.Device.SetDevicesState:input_type -> grpc.TargetDevice
-	2, // 3: grpc.Device.GetAllDevices:output_type -> grpc.AllDevicesResponse
-	4, // 4: grpc.Device.SetDevicesState:output_type -> grpc.ExecuteResponse
-	3, // [3:5] is the sub-list for method output_type
-	1, // [1:3] is the sub-list for method input_type
-	1, // [1:1] is the sub-list for extension type_name
-	1, // [1:1] is the sub-list for extension extendee
-	0, // [0:1] is the sub-list for field type_name
+	1,  // 0: grpc.AllDevicesResponse.devices:type_name -> grpc.Devices
+	6,  // 1: grpc.SlowConsumersResponse.consumers:type_name -> grpc.SlowConsumer
+	10, // 2: grpc.LogLevelsResponse.modules:type_name -> grpc.LogLevel
+	15, // 3: grpc.PacketTracesResponse.traces:type_name -> grpc.PacketTrace
+	0,  // 4: grpc.Device.GetAllDevices:input_type -> grpc.Empty
+	3,  // 5: grpc.Device.SetDevicesState:input_type -> grpc.TargetDevice
+	0,  // 6: grpc.Admin.ReloadConfig:input_type -> grpc.Empty
+	0,  // 7: grpc.Admin.ListSlowConsumers:input_type -> grpc.Empty
+	0,  // 8: grpc.Admin.StorageHealth:input_type -> grpc.Empty
+	0,  // 9: grpc.Admin.CompactTopicTree:input_type -> grpc.Empty
+	0,  // 10: grpc.Admin.GetLogLevels:input_type -> grpc.Empty
+	11, // 11: grpc.Admin.SetLogLevel:input_type -> grpc.SetLogLevelRequest
+	13, // 12: grpc.Admin.StartPacketTrace:input_type -> grpc.StartPacketTraceRequest
+	14, // 13: grpc.Admin.StopPacketTrace:input_type -> grpc.StopPacketTraceRequest
+	0,  // 14: grpc.Admin.ListPacketTraces:input_type -> grpc.Empty
+	2,  // 15: grpc.Device.GetAllDevices:output_type -> grpc.AllDevicesResponse
+	4,  // 16: grpc.Device.SetDevicesState:output_type -> grpc.ExecuteResponse
+	5,  // 17: grpc.Admin.ReloadConfig:output_type -> grpc.ReloadConfigResponse
+	7,  // 18: grpc.Admin.ListSlowConsumers:output_type -> grpc.SlowConsumersResponse
+	8,  // 19: grpc.Admin.StorageHealth:output_type -> grpc.StorageHealthResponse
+	9,  // 20: grpc.Admin.CompactTopicTree:output_type -> grpc.CompactTopicTreeResponse
+	12, // 21: grpc.Admin.GetLogLevels:output_type -> grpc.LogLevelsResponse
+	12, // 22: grpc.Admin.SetLogLevel:output_type -> grpc.LogLevelsResponse
+	15, // 23: grpc.Admin.StartPacketTrace:output_type -> grpc.PacketTrace
+	0,  // 24: grpc.Admin.StopPacketTrace:output_type -> grpc.Empty
+	16, // 25: grpc.Admin.ListPacketTraces:output_type -> grpc.PacketTracesResponse
+	15, // [15:26] is the sub-list for method output_type
+	4,  // [4:15] is the sub-list for method input_type
+	4,  // [4:4] is the sub-list for extension type_name
+	4,  // [4:4] is the sub-list for extension extendee
+	0,  // [0:4] is the sub-list for field type_name
 }
 
 func init() { file_service_proto_init() }
@@ -304,9 +1193,9 @@ func file_service_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_service_proto_rawDesc), len(file_service_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   5,
+			NumMessages:   17,
 			NumExtensions: 0,
-			NumServices:   1,
+			NumServices:   2,
 		},
 		GoTypes:           file_service_proto_goTypes,
 		DependencyIndexes: file_service_proto_depIdxs,
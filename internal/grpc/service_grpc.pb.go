@@ -157,3 +157,409 @@ var Device_ServiceDesc = grpc.ServiceDesc{
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "service.proto",
 }
+
+const (
+	Admin_ReloadConfig_FullMethodName      = "/grpc.Admin/ReloadConfig"
+	Admin_ListSlowConsumers_FullMethodName = "/grpc.Admin/ListSlowConsumers"
+	Admin_StorageHealth_FullMethodName     = "/grpc.Admin/StorageHealth"
+	Admin_CompactTopicTree_FullMethodName  = "/grpc.Admin/CompactTopicTree"
+	Admin_GetLogLevels_FullMethodName      = "/grpc.Admin/GetLogLevels"
+	Admin_SetLogLevel_FullMethodName       = "/grpc.Admin/SetLogLevel"
+	Admin_StartPacketTrace_FullMethodName  = "/grpc.Admin/StartPacketTrace"
+	Admin_StopPacketTrace_FullMethodName   = "/grpc.Admin/StopPacketTrace"
+	Admin_ListPacketTraces_FullMethodName  = "/grpc.Admin/ListPacketTraces"
+)
+
+// AdminClient is the client API for Admin service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type AdminClient interface {
+	ReloadConfig(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*ReloadConfigResponse, error)
+	ListSlowConsumers(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*SlowConsumersResponse, error)
+	StorageHealth(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*StorageHealthResponse, error)
+	CompactTopicTree(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*CompactTopicTreeResponse, error)
+	GetLogLevels(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*LogLevelsResponse, error)
+	SetLogLevel(ctx context.Context, in *SetLogLevelRequest, opts ...grpc.CallOption) (*LogLevelsResponse, error)
+	StartPacketTrace(ctx context.Context, in *StartPacketTraceRequest, opts ...grpc.CallOption) (*PacketTrace, error)
+	StopPacketTrace(ctx context.Context, in *StopPacketTraceRequest, opts ...grpc.CallOption) (*Empty, error)
+	ListPacketTraces(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*PacketTracesResponse, error)
+}
+
+type adminClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewAdminClient(cc grpc.ClientConnInterface) AdminClient {
+	return &adminClient{cc}
+}
+
+func (c *adminClient) ReloadConfig(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*ReloadConfigResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ReloadConfigResponse)
+	err := c.cc.Invoke(ctx, Admin_ReloadConfig_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) ListSlowConsumers(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*SlowConsumersResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SlowConsumersResponse)
+	err := c.cc.Invoke(ctx, Admin_ListSlowConsumers_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) StorageHealth(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*StorageHealthResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(StorageHealthResponse)
+	err := c.cc.Invoke(ctx, Admin_StorageHealth_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) CompactTopicTree(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*CompactTopicTreeResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(CompactTopicTreeResponse)
+	err := c.cc.Invoke(ctx, Admin_CompactTopicTree_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) GetLogLevels(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*LogLevelsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(LogLevelsResponse)
+	err := c.cc.Invoke(ctx, Admin_GetLogLevels_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) SetLogLevel(ctx context.Context, in *SetLogLevelRequest, opts ...grpc.CallOption) (*LogLevelsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(LogLevelsResponse)
+	err := c.cc.Invoke(ctx, Admin_SetLogLevel_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) StartPacketTrace(ctx context.Context, in *StartPacketTraceRequest, opts ...grpc.CallOption) (*PacketTrace, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(PacketTrace)
+	err := c.cc.Invoke(ctx, Admin_StartPacketTrace_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) StopPacketTrace(ctx context.Context, in *StopPacketTraceRequest, opts ...grpc.CallOption) (*Empty, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, Admin_StopPacketTrace_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) ListPacketTraces(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*PacketTracesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(PacketTracesResponse)
+	err := c.cc.Invoke(ctx, Admin_ListPacketTraces_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// AdminServer is the server API for Admin service.
+// All implementations must embed UnimplementedAdminServer
+// for forward compatibility.
+type AdminServer interface {
+	ReloadConfig(context.Context, *Empty) (*ReloadConfigResponse, error)
+	ListSlowConsumers(context.Context, *Empty) (*SlowConsumersResponse, error)
+	StorageHealth(context.Context, *Empty) (*StorageHealthResponse, error)
+	CompactTopicTree(context.Context, *Empty) (*CompactTopicTreeResponse, error)
+	GetLogLevels(context.Context, *Empty) (*LogLevelsResponse, error)
+	SetLogLevel(context.Context, *SetLogLevelRequest) (*LogLevelsResponse, error)
+	StartPacketTrace(context.Context, *StartPacketTraceRequest) (*PacketTrace, error)
+	StopPacketTrace(context.Context, *StopPacketTraceRequest) (*Empty, error)
+	ListPacketTraces(context.Context, *Empty) (*PacketTracesResponse, error)
+	mustEmbedUnimplementedAdminServer()
+}
+
+// UnimplementedAdminServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedAdminServer struct{}
+
+func (UnimplementedAdminServer) ReloadConfig(context.Context, *Empty) (*ReloadConfigResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReloadConfig not implemented")
+}
+func (UnimplementedAdminServer) ListSlowConsumers(context.Context, *Empty) (*SlowConsumersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListSlowConsumers not implemented")
+}
+func (UnimplementedAdminServer) StorageHealth(context.Context, *Empty) (*StorageHealthResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method StorageHealth not implemented")
+}
+func (UnimplementedAdminServer) CompactTopicTree(context.Context, *Empty) (*CompactTopicTreeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CompactTopicTree not implemented")
+}
+func (UnimplementedAdminServer) GetLogLevels(context.Context, *Empty) (*LogLevelsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetLogLevels not implemented")
+}
+func (UnimplementedAdminServer) SetLogLevel(context.Context, *SetLogLevelRequest) (*LogLevelsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetLogLevel not implemented")
+}
+func (UnimplementedAdminServer) StartPacketTrace(context.Context, *StartPacketTraceRequest) (*PacketTrace, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method StartPacketTrace not implemented")
+}
+func (UnimplementedAdminServer) StopPacketTrace(context.Context, *StopPacketTraceRequest) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method StopPacketTrace not implemented")
+}
+func (UnimplementedAdminServer) ListPacketTraces(context.Context, *Empty) (*PacketTracesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListPacketTraces not implemented")
+}
+func (UnimplementedAdminServer) mustEmbedUnimplementedAdminServer() {}
+func (UnimplementedAdminServer) testEmbeddedByValue()               {}
+
+// UnsafeAdminServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to AdminServer will
+// result in compilation errors.
+type UnsafeAdminServer interface {
+	mustEmbedUnimplementedAdminServer()
+}
+
+func RegisterAdminServer(s grpc.ServiceRegistrar, srv AdminServer) {
+	// If the following call pancis, it indicates UnimplementedAdminServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Admin_ServiceDesc, srv)
+}
+
+func _Admin_ReloadConfig_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).ReloadConfig(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_ReloadConfig_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).ReloadConfig(ctx, req.(*Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_ListSlowConsumers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).ListSlowConsumers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_ListSlowConsumers_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).ListSlowConsumers(ctx, req.(*Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_StorageHealth_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).StorageHealth(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_StorageHealth_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).StorageHealth(ctx, req.(*Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_CompactTopicTree_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).CompactTopicTree(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_CompactTopicTree_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).CompactTopicTree(ctx, req.(*Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_GetLogLevels_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).GetLogLevels(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_GetLogLevels_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).GetLogLevels(ctx, req.(*Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_SetLogLevel_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetLogLevelRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).SetLogLevel(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_SetLogLevel_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).SetLogLevel(ctx, req.(*SetLogLevelRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_StartPacketTrace_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StartPacketTraceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).StartPacketTrace(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_StartPacketTrace_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).StartPacketTrace(ctx, req.(*StartPacketTraceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_StopPacketTrace_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StopPacketTraceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).StopPacketTrace(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_StopPacketTrace_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).StopPacketTrace(ctx, req.(*StopPacketTraceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_ListPacketTraces_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).ListPacketTraces(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_ListPacketTraces_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).ListPacketTraces(ctx, req.(*Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Admin_ServiceDesc is the grpc.ServiceDesc for Admin service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Admin_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "grpc.Admin",
+	HandlerType: (*AdminServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ReloadConfig",
+			Handler:    _Admin_ReloadConfig_Handler,
+		},
+		{
+			MethodName: "ListSlowConsumers",
+			Handler:    _Admin_ListSlowConsumers_Handler,
+		},
+		{
+			MethodName: "StorageHealth",
+			Handler:    _Admin_StorageHealth_Handler,
+		},
+		{
+			MethodName: "CompactTopicTree",
+			Handler:    _Admin_CompactTopicTree_Handler,
+		},
+		{
+			MethodName: "GetLogLevels",
+			Handler:    _Admin_GetLogLevels_Handler,
+		},
+		{
+			MethodName: "SetLogLevel",
+			Handler:    _Admin_SetLogLevel_Handler,
+		},
+		{
+			MethodName: "StartPacketTrace",
+			Handler:    _Admin_StartPacketTrace_Handler,
+		},
+		{
+			MethodName: "StopPacketTrace",
+			Handler:    _Admin_StopPacketTrace_Handler,
+		},
+		{
+			MethodName: "ListPacketTraces",
+			Handler:    _Admin_ListPacketTraces_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "service.proto",
+}
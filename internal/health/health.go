@@ -0,0 +1,227 @@
+// Package health 实现了存活与就绪检查，通过HTTP接口 /healthz、/readyz 与标准gRPC健康检查服务提供
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"maps"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/logger"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+const (
+	checkTimeout  = 2 * time.Second // 单次检查的超时时间
+	watchInterval = 5 * time.Second // 更新gRPC健康状态的间隔
+)
+
+// ErrShuttingDown 服务器正在关闭时就绪检查返回的错误
+var ErrShuttingDown = errors.New("server is shutting down")
+
+// Check 一个子系统的检查，返回nil表示正常
+type Check func(ctx context.Context) error
+
+// Registry 各子系统的存活与就绪检查
+type Registry struct {
+	mu         sync.RWMutex
+	liveness   map[string]Check
+	readiness  map[string]Check
+	draining   atomic.Bool
+	grpcHealth *health.Server // 标准gRPC健康检查服务，服务名为子系统名称，空字符串表示整体状态
+	updateMu   sync.Mutex     // 串行化gRPC健康状态的更新
+	last       Result         // 上次更新时的就绪检查结果，用于记录状态变化
+	stop       chan struct{}
+	stopOnce   sync.Once
+}
+
+var registry = NewRegistry()
+
+// NewRegistry 创建新的检查注册表
+// 第一次更新之前gRPC健康检查服务的整体状态为 NOT_SERVING
+func NewRegistry() *Registry {
+	r := &Registry{
+		liveness:   make(map[string]Check),
+		readiness:  make(map[string]Check),
+		grpcHealth: health.NewServer(),
+		stop:       make(chan struct{}),
+	}
+	r.grpcHealth.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	return r
+}
+
+// Default 返回全局检查注册表
+func Default() *Registry {
+	return registry
+}
+
+// AddLiveness 注册子系统的存活检查，失败表示进程需要重启
+func (r *Registry) AddLiveness(name string, check Check) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.liveness[name] = check
+}
+
+// AddReadiness 注册子系统的就绪检查，失败表示暂时不能接收流量
+func (r *Registry) AddReadiness(name string, check Check) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.readiness[name] = check
+	r.grpcHealth.SetServingStatus(name, healthpb.HealthCheckResponse_NOT_SERVING)
+}
+
+// Result 一次检查的结果，key为子系统名称，value为空表示正常，否则为失败原因
+type Result map[string]string
+
+// Healthy 返回是否所有子系统都正常
+func (r Result) Healthy() bool {
+	for _, reason := range r {
+		if reason != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// run 并发执行所有检查
+func run(ctx context.Context, checks map[string]Check) Result {
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	result := make(Result, len(checks))
+	for name, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			reason := ""
+			if err := check(ctx); err != nil {
+				reason = err.Error()
+			}
+			mu.Lock()
+			result[name] = reason
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	return result
+}
+
+// Liveness 执行所有存活检查
+func (r *Registry) Liveness(ctx context.Context) Result {
+	r.mu.RLock()
+	checks := maps.Clone(r.liveness)
+	r.mu.RUnlock()
+	return run(ctx, checks)
+}
+
+// Readiness 执行所有就绪检查，服务器关闭后所有子系统都视为未就绪
+func (r *Registry) Readiness(ctx context.Context) Result {
+	r.mu.RLock()
+	checks := maps.Clone(r.readiness)
+	r.mu.RUnlock()
+	if r.draining.Load() {
+		result := make(Result, len(checks))
+		for name := range checks {
+			result[name] = ErrShuttingDown.Error()
+		}
+		return result
+	}
+	return run(ctx, checks)
+}
+
+// response /healthz 与 /readyz 的响应
+type response struct {
+	Status string `json:"status"`
+	Checks Result `json:"checks"`
+}
+
+// handler 返回执行检查的HTTP处理器，所有子系统正常时返回200，否则返回503
+func handler(check func(ctx context.Context) Result) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		result := check(req.Context())
+		body := response{Status: "ok", Checks: result}
+		status := http.StatusOK
+		if !result.Healthy() {
+			body.Status = "unavailable"
+			status = http.StatusServiceUnavailable
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(body)
+	})
+}
+
+// LivenessHandler 返回 /healthz 接口的处理器
+func (r *Registry) LivenessHandler() http.Handler {
+	return handler(r.Liveness)
+}
+
+// ReadinessHandler 返回 /readyz 接口的处理器
+func (r *Registry) ReadinessHandler() http.Handler {
+	return handler(r.Readiness)
+}
+
+// GRPCServer 返回标准gRPC健康检查服务，需要注册到gRPC服务器
+func (r *Registry) GRPCServer() *health.Server {
+	return r.grpcHealth
+}
+
+// Update 执行就绪检查并更新gRPC健康状态
+func (r *Registry) Update(ctx context.Context) Result {
+	result := r.Readiness(ctx)
+	r.updateMu.Lock()
+	defer r.updateMu.Unlock()
+	for name, reason := range result {
+		if previous, ok := r.last[name]; !ok || previous != reason {
+			if reason == "" {
+				logger.InfoF("Subsystem %s is ready", name)
+			} else {
+				logger.WarnF("Subsystem %s is not ready: %s", name, reason)
+			}
+		}
+		r.grpcHealth.SetServingStatus(name, servingStatus(reason == ""))
+	}
+	r.grpcHealth.SetServingStatus("", servingStatus(result.Healthy()))
+	r.last = result
+	return result
+}
+
+// servingStatus 返回gRPC健康检查服务使用的状态
+func servingStatus(ready bool) healthpb.HealthCheckResponse_ServingStatus {
+	if ready {
+		return healthpb.HealthCheckResponse_SERVING
+	}
+	return healthpb.HealthCheckResponse_NOT_SERVING
+}
+
+// Start 立即更新一次gRPC健康状态，之后周期性更新，直到服务器关闭
+func (r *Registry) Start() {
+	r.Update(context.Background())
+	go func() {
+		ticker := time.NewTicker(watchInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-r.stop:
+				return
+			case <-ticker.C:
+				r.Update(context.Background())
+			}
+		}
+	}()
+}
+
+// Invoke 在关闭开始时把所有子系统标记为未就绪，停止向服务器分配新流量
+func (r *Registry) Invoke(context.Context) error {
+	logger.Info("Marking server as not ready")
+	r.draining.Store(true)
+	r.stopOnce.Do(func() { close(r.stop) })
+	r.grpcHealth.Shutdown()
+	return nil
+}
@@ -0,0 +1,113 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// grpcStatus 返回gRPC健康检查服务中服务的状态
+func grpcStatus(t *testing.T, r *Registry, service string) healthpb.HealthCheckResponse_ServingStatus {
+	t.Helper()
+	resp, err := r.GRPCServer().Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+	if err != nil {
+		t.Fatalf("Check(%q): %v", service, err)
+	}
+	return resp.Status
+}
+
+// get 请求处理器并解析响应
+func get(t *testing.T, handler http.Handler) (int, response) {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+	var body response
+	if err := json.NewDecoder(recorder.Body).Decode(&body); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	return recorder.Code, body
+}
+
+func TestReadinessFollowsChecks(t *testing.T) {
+	r := NewRegistry()
+	var storageDown atomic.Bool
+	r.AddLiveness("mqtt", func(context.Context) error { return nil })
+	r.AddReadiness("mqtt", func(context.Context) error { return nil })
+	r.AddReadiness("storage", func(context.Context) error {
+		if storageDown.Load() {
+			return errors.New("connection refused")
+		}
+		return nil
+	})
+	if status := grpcStatus(t, r, ""); status != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Fatalf("overall status before first update = %s, want NOT_SERVING", status)
+	}
+
+	r.Update(context.Background())
+	if code, body := get(t, r.ReadinessHandler()); code != http.StatusOK || body.Status != "ok" {
+		t.Fatalf("/readyz = %d %+v, want 200 ok", code, body)
+	}
+	for _, service := range []string{"", "mqtt", "storage"} {
+		if status := grpcStatus(t, r, service); status != healthpb.HealthCheckResponse_SERVING {
+			t.Fatalf("status of %q = %s, want SERVING", service, status)
+		}
+	}
+
+	// 存储不可用时只影响存储子系统与整体状态，进程仍然存活
+	storageDown.Store(true)
+	r.Update(context.Background())
+	code, body := get(t, r.ReadinessHandler())
+	if code != http.StatusServiceUnavailable || body.Checks["storage"] != "connection refused" || body.Checks["mqtt"] != "" {
+		t.Fatalf("/readyz = %d %+v, want 503 with storage failure", code, body)
+	}
+	if code, _ := get(t, r.LivenessHandler()); code != http.StatusOK {
+		t.Fatalf("/healthz = %d during storage outage, want 200", code)
+	}
+	want := map[string]healthpb.HealthCheckResponse_ServingStatus{
+		"":        healthpb.HealthCheckResponse_NOT_SERVING,
+		"mqtt":    healthpb.HealthCheckResponse_SERVING,
+		"storage": healthpb.HealthCheckResponse_NOT_SERVING,
+	}
+	for service, status := range want {
+		if got := grpcStatus(t, r, service); got != status {
+			t.Errorf("status of %q = %s, want %s", service, got, status)
+		}
+	}
+
+	storageDown.Store(false)
+	r.Update(context.Background())
+	if status := grpcStatus(t, r, ""); status != healthpb.HealthCheckResponse_SERVING {
+		t.Fatalf("overall status after recovery = %s, want SERVING", status)
+	}
+}
+
+func TestShutdownMarksNotReady(t *testing.T) {
+	r := NewRegistry()
+	r.AddLiveness("mqtt", func(context.Context) error { return nil })
+	r.AddReadiness("mqtt", func(context.Context) error { return nil })
+	r.Start()
+
+	if err := r.Invoke(context.Background()); err != nil {
+		t.Fatalf("Invoke: %v", err)
+	}
+	code, body := get(t, r.ReadinessHandler())
+	if code != http.StatusServiceUnavailable || body.Checks["mqtt"] != ErrShuttingDown.Error() {
+		t.Fatalf("/readyz after shutdown = %d %+v", code, body)
+	}
+	if code, _ := get(t, r.LivenessHandler()); code != http.StatusOK {
+		t.Fatalf("/healthz after shutdown = %d, want 200", code)
+	}
+	// 关闭后即使检查恢复也保持 NOT_SERVING
+	r.Update(context.Background())
+	for _, service := range []string{"", "mqtt"} {
+		if status := grpcStatus(t, r, service); status != healthpb.HealthCheckResponse_NOT_SERVING {
+			t.Errorf("status of %q after shutdown = %s, want NOT_SERVING", service, status)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package logger
+
+import (
+	"fmt"
+	"log/slog"
+	"maps"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// levelTable 某一时刻的日志级别设置，创建后不再修改
+type levelTable struct {
+	base    slog.Level            // 没有单独设置的模块使用的级别
+	modules map[string]slog.Level // 单独设置了级别的模块
+	min     slog.Level            // 所有级别中最低的一个，用于快速跳过不会输出的日志
+}
+
+// Levels 全局与各模块的日志级别，模块为调用方所在的包名，如 database、server、packet
+// 读取时不加锁，修改时替换整张表
+type Levels struct {
+	mu    sync.Mutex // 串行化修改
+	table atomic.Pointer[levelTable]
+}
+
+// NewLevels 创建默认级别为base的日志级别设置
+func NewLevels(base slog.Level) *Levels {
+	l := &Levels{}
+	l.table.Store(&levelTable{base: base, modules: map[string]slog.Level{}, min: base})
+	return l
+}
+
+// levels 全局日志级别设置
+var levels = NewLevels(slog.LevelInfo)
+
+// GetLevels 返回全局日志级别设置
+func GetLevels() *Levels {
+	return levels
+}
+
+// Level 返回模块的日志级别
+func (l *Levels) Level(module string) slog.Level {
+	table := l.table.Load()
+	if level, ok := table.modules[module]; ok {
+		return level
+	}
+	return table.base
+}
+
+// Min 返回所有级别中最低的一个
+func (l *Levels) Min() slog.Level {
+	return l.table.Load().min
+}
+
+// Base 返回默认级别
+func (l *Levels) Base() slog.Level {
+	return l.table.Load().base
+}
+
+// Modules 返回单独设置了级别的模块
+func (l *Levels) Modules() map[string]slog.Level {
+	return maps.Clone(l.table.Load().modules)
+}
+
+// update 拷贝当前设置，修改后替换
+func (l *Levels) update(change func(table *levelTable)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	current := l.table.Load()
+	table := &levelTable{base: current.base, modules: maps.Clone(current.modules)}
+	change(table)
+	table.min = table.base
+	for _, level := range table.modules {
+		table.min = min(table.min, level)
+	}
+	l.table.Store(table)
+}
+
+// SetBase 设置默认级别
+func (l *Levels) SetBase(level slog.Level) {
+	l.update(func(table *levelTable) { table.base = level })
+}
+
+// SetModule 设置模块的级别
+func (l *Levels) SetModule(module string, level slog.Level) {
+	l.update(func(table *levelTable) { table.modules[module] = level })
+}
+
+// ResetModule 删除模块单独设置的级别，之后使用默认级别
+func (l *Levels) ResetModule(module string) {
+	l.update(func(table *levelTable) { delete(table.modules, module) })
+}
+
+// Replace 替换默认级别与所有模块的级别
+func (l *Levels) Replace(base slog.Level, modules map[string]slog.Level) {
+	l.update(func(table *levelTable) {
+		table.base = base
+		table.modules = maps.Clone(modules)
+		if table.modules == nil {
+			table.modules = map[string]slog.Level{}
+		}
+	})
+}
+
+// ParseLevel 解析日志级别名称，不区分大小写，支持 debug、info、warn、error、fatal
+func ParseLevel(name string) (slog.Level, error) {
+	if strings.EqualFold(name, "fatal") {
+		return LevelFatal, nil
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(name)); err != nil {
+		return 0, fmt.Errorf("unknown log level %q", name)
+	}
+	return level, nil
+}
+
+// LevelName 返回日志级别名称
+func LevelName(level slog.Level) string {
+	switch level {
+	case LevelFatal:
+		return "FATAL"
+	case LevelTrace:
+		return "TRACE"
+	}
+	return level.String()
+}
+
+// moduleCache 调用位置到模块名称的缓存
+var moduleCache sync.Map
+
+// moduleOf 返回调用位置所在的模块，即函数所在包的包名
+func moduleOf(pc uintptr) string {
+	if pc == 0 {
+		return ""
+	}
+	if module, ok := moduleCache.Load(pc); ok {
+		return module.(string)
+	}
+	frame, _ := runtime.CallersFrames([]uintptr{pc}).Next()
+	module := frame.Function
+	if index := strings.LastIndexByte(module, '/'); index >= 0 {
+		module = module[index+1:]
+	}
+	if index := strings.IndexByte(module, '.'); index >= 0 {
+		module = module[:index]
+	}
+	moduleCache.Store(pc, module)
+	return module
+}
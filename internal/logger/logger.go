@@ -5,108 +5,90 @@ import (
 	"fmt"
 	"github.com/fatih/color"
 	c "github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/config"
-	"io"
 	"log/slog"
-	"os"
-	"path/filepath"
-	"sync"
+	"maps"
+	"math"
+	"runtime"
+	"strings"
 	"time"
 )
 
 const (
 	LevelFatal slog.Level = 12
+	// LevelTrace 通过管理接口开启的报文跟踪日志，总是输出，不受日志级别限制
+	LevelTrace slog.Level = -8
 )
 
-type AsyncHandler struct {
-	ch          chan []byte
-	writer      io.Writer
-	attrs       []slog.Attr
-	currentDay  int      // 当前日志日期（day of year）
-	currentFile *os.File // 当前日志文件
-	basePath    string   // 日志文件基础路径
-	group       string
-	logLevel    slog.Level
-	wg          sync.WaitGroup
-}
-
-func NewAsyncHandler(basePath string, logLevel slog.Level) *AsyncHandler {
-	h := &AsyncHandler{
-		ch:       make(chan []byte, 1024),
-		logLevel: logLevel,
-		basePath: basePath,
-	}
-	_ = h.rotateIfNeeded()
-	h.wg.Add(1)
-	go h.startWorker()
-	return h
-}
+// 日志输出格式
+const (
+	FormatText = c.LogFormatText // 彩色文本，便于人工阅读
+	FormatJSON = c.LogFormatJSON // 每行一个JSON对象，slog字段保留为JSON字段，便于日志系统解析
+)
 
-// 在rotateIfNeeded中添加
-func (h *AsyncHandler) cleanOldLogs() {
-	files, _ := filepath.Glob(h.basePath + "/*.log")
-	now := time.Now()
+// 常用的日志字段
+const (
+	KeyClientID   = "client_id"
+	KeyConnID     = "conn_id"
+	KeyTopic      = "topic"
+	KeyPacketType = "packet_type"
+	KeyModule     = "module"
+)
 
-	for _, f := range files {
-		fi, _ := os.Stat(f)
-		if now.Sub(fi.ModTime()) > 30*24*time.Hour {
-			_ = os.Remove(f) // 删除30天前的日志
-		}
-	}
+// AsyncHandler 异步写入日志的slog处理器，按调用方所在的模块过滤日志级别
+type AsyncHandler struct {
+	sink   *sink
+	levels *Levels
+	json   slog.Handler // JSON格式的格式化器，为nil时输出彩色文本
+	prefix string       // 文本格式下当前分组的字段前缀
+	attrs  string       // 文本格式下已经格式化的固定字段
 }
 
-// 初始化或轮转日志文件
-func (h *AsyncHandler) rotateIfNeeded() error {
-	now := time.Now()
-	currentDay := now.YearDay()
+// NewAsyncHandler 创建按options输出的日志处理器
+func NewAsyncHandler(options Options, levels *Levels) *AsyncHandler {
+	s := newSink(options, time.Now)
+	s.start()
+	return newAsyncHandler(s, levels)
+}
 
-	// 检查是否需要轮转
-	if currentDay == h.currentDay && h.currentFile != nil {
-		return nil
+// newAsyncHandler 创建写入s的日志处理器
+func newAsyncHandler(s *sink, levels *Levels) *AsyncHandler {
+	h := &AsyncHandler{sink: s, levels: levels}
+	if s.options.Format == FormatJSON {
+		h.json = slog.NewJSONHandler(s, &slog.HandlerOptions{
+			Level:       slog.Level(math.MinInt), // 级别由AsyncHandler过滤
+			ReplaceAttr: replaceLevel,
+		})
 	}
+	return h
+}
 
-	// 关闭旧文件
-	if h.currentFile != nil {
-		if err := h.currentFile.Close(); err != nil {
-			return fmt.Errorf("关闭日志文件失败: %w", err)
+// replaceLevel 使JSON中的致命错误级别显示为 FATAL
+func replaceLevel(groups []string, attr slog.Attr) slog.Attr {
+	if len(groups) == 0 && attr.Key == slog.LevelKey {
+		if level, ok := attr.Value.Any().(slog.Level); ok {
+			attr.Value = slog.StringValue(LevelName(level))
 		}
 	}
-
-	// 创建新文件
-	logPath := h.getLogPath()
-	if err := os.MkdirAll(filepath.Dir(logPath), 0755); err != nil {
-		return fmt.Errorf("创建日志目录失败: %w", err)
-	}
-
-	f, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
-	if err != nil {
-		return fmt.Errorf("创建日志文件失败: %w", err)
-	}
-
-	// 更新状态
-	h.currentFile = f
-	h.currentDay = currentDay
-	h.writer = io.MultiWriter(os.Stdout, h.currentFile)
-	return nil
+	return attr
 }
 
-// 获取当前日志文件路径
-func (h *AsyncHandler) getLogPath() string {
-	now := time.Now()
-	return fmt.Sprintf("%s/%s.log", h.basePath, now.Format("2006-01-02"))
+func (h *AsyncHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.levels.Min()
 }
 
-func (h *AsyncHandler) startWorker() {
-	defer h.wg.Done()
-	for data := range h.ch {
-		_, _ = h.writer.Write(data)
+func (h *AsyncHandler) Handle(ctx context.Context, r slog.Record) error {
+	module := moduleOf(r.PC)
+	if r.Level != LevelTrace && r.Level < h.levels.Level(module) {
+		return nil
+	}
+	if h.json != nil {
+		if module != "" {
+			r = r.Clone()
+			r.AddAttrs(slog.String(KeyModule, module))
+		}
+		return h.json.Handle(ctx, r)
 	}
-}
-
-func (h *AsyncHandler) Enabled(_ context.Context, level slog.Level) bool {
-	return level >= h.logLevel
-}
 
-func (h *AsyncHandler) Handle(_ context.Context, r slog.Record) error {
 	level := r.Level.String()
 
 	switch r.Level {
@@ -120,71 +102,91 @@ func (h *AsyncHandler) Handle(_ context.Context, r slog.Record) error {
 		level = color.RedString(level)
 	case LevelFatal:
 		level = color.HiRedString("FATAL")
+	case LevelTrace:
+		level = color.HiMagentaString("TRACE")
 	}
 
 	// 基础格式：时间 | 级别 | 消息
-	line := fmt.Sprintf(
+	var line strings.Builder
+	line.WriteString(fmt.Sprintf(
 		"%s | %-5s | %s",
 		color.GreenString(r.Time.Format("2006-01-02T15:04:05")),
 		level,
 		color.CyanString(r.Message),
-	)
+	))
 
 	// 处理固定字段
-	for _, attr := range h.attrs {
-		line += color.CyanString(fmt.Sprintf(" %s=%v", attr.Key, attr.Value))
-	}
+	line.WriteString(h.attrs)
 
 	// 处理动态字段
 	r.Attrs(func(attr slog.Attr) bool {
-		line += color.CyanString(fmt.Sprintf(" %s=%v", attr.Key, attr.Value))
+		appendAttr(&line, h.prefix, attr)
 		return true
 	})
 
-	line += "\n"
+	line.WriteString("\n")
 
-	h.Write([]byte(line))
+	_, _ = h.sink.Write([]byte(line.String()))
 	return nil
 }
 
-func (h *AsyncHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
-	// 合并新旧字段
-	newAttrs := make([]slog.Attr, 0, len(h.attrs)+len(attrs))
-	newAttrs = append(newAttrs, h.attrs...)
-	newAttrs = append(newAttrs, attrs...)
+// appendAttr 以 key=value 的形式追加字段，分组中的字段以 分组.字段 为键
+func appendAttr(line *strings.Builder, prefix string, attr slog.Attr) {
+	attr.Value = attr.Value.Resolve()
+	if attr.Equal(slog.Attr{}) {
+		return
+	}
+	if attr.Value.Kind() == slog.KindGroup {
+		if attr.Key != "" {
+			prefix += attr.Key + "."
+		}
+		for _, member := range attr.Value.Group() {
+			appendAttr(line, prefix, member)
+		}
+		return
+	}
+	line.WriteString(color.CyanString(" %s%s=%v", prefix, attr.Key, attr.Value))
+}
 
-	return &AsyncHandler{
-		writer:   h.writer,
-		attrs:    newAttrs,
-		group:    h.group,
-		logLevel: h.logLevel,
+// WithAttrs 返回带固定字段的处理器，与原处理器共享写协程
+func (h *AsyncHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+	handler := *h
+	if h.json != nil {
+		handler.json = h.json.WithAttrs(attrs)
+		return &handler
 	}
+	var line strings.Builder
+	line.WriteString(h.attrs)
+	for _, attr := range attrs {
+		appendAttr(&line, h.prefix, attr)
+	}
+	handler.attrs = line.String()
+	return &handler
 }
 
+// WithGroup 返回之后的字段都位于分组name中的处理器，与原处理器共享写协程
 func (h *AsyncHandler) WithGroup(name string) slog.Handler {
-	// 记录当前分组名称
-	return &AsyncHandler{
-		writer:   h.writer,
-		attrs:    h.attrs,
-		group:    name,
-		logLevel: h.logLevel,
+	if name == "" {
+		return h
+	}
+	handler := *h
+	if h.json != nil {
+		handler.json = h.json.WithGroup(name)
+		return &handler
 	}
+	handler.prefix = h.prefix + name + "."
+	return &handler
 }
 
 func (h *AsyncHandler) Write(p []byte) {
-	// 拷贝数据避免竞态
-	pb := make([]byte, len(p))
-	copy(pb, p)
-	h.ch <- pb
+	_, _ = h.sink.Write(p)
 }
 
 func (h *AsyncHandler) Close() error {
-	close(h.ch)
-	h.wg.Wait()
-	if f, ok := h.writer.(*os.File); ok {
-		_ = f.Sync()
-	}
-	return nil
+	return h.sink.Close()
 }
 
 type ShutdownCallback struct {
@@ -195,56 +197,128 @@ func (lc *ShutdownCallback) Invoke(ctx context.Context) error {
 	return lc.handler.Close()
 }
 
-func Init() *ShutdownCallback {
-	var handler *AsyncHandler
-	config, _ := c.GetConfig()
+// configLevels 返回配置中的默认级别与各模块的级别，debug_mode为true时默认级别为DEBUG
+func configLevels(config c.Config) (slog.Level, map[string]slog.Level) {
+	base, err := ParseLevel(config.Log.Level)
+	if err != nil {
+		base = slog.LevelInfo
+	}
 	if config.DebugMode {
-		handler = NewAsyncHandler("logs", slog.LevelDebug)
-	} else {
-		handler = NewAsyncHandler("logs", slog.LevelInfo)
+		base = slog.LevelDebug
 	}
+	modules := make(map[string]slog.Level, len(config.Log.Modules))
+	for module, name := range config.Log.Modules {
+		if level, err := ParseLevel(name); err == nil {
+			modules[module] = level
+		}
+	}
+	return base, modules
+}
+
+func Init() *ShutdownCallback {
+	config, _ := c.GetConfig()
+	levels.Replace(configLevels(config))
+	handler := NewAsyncHandler(optionsOf(config), levels)
+	// 配置重新加载时同步调整日志级别，通过管理接口调整的级别会被配置文件覆盖
+	c.OnReload(func(old c.Config, new c.Config) {
+		if old.DebugMode != new.DebugMode || old.Log.Level != new.Log.Level || !maps.Equal(old.Log.Modules, new.Log.Modules) {
+			levels.Replace(configLevels(new))
+		}
+	})
 	logger := slog.New(handler)
 	slog.SetDefault(logger)
 	slog.Debug("Logger initialized")
 	return &ShutdownCallback{handler: handler}
 }
 
+// With 返回带固定字段的Logger，如 logger.With(logger.KeyConnID, connID)
+func With(args ...any) *slog.Logger {
+	return slog.Default().With(args...)
+}
+
+// enabled 返回调用位置pc处level级别的日志是否会被输出
+func enabled(ctx context.Context, level slog.Level, pc uintptr) bool {
+	return slog.Default().Enabled(ctx, level) && level >= levels.Level(moduleOf(pc))
+}
+
+// callerPC 返回导出的日志函数的调用位置，使模块级别按调用方所在的包生效
+func callerPC() uintptr {
+	var pcs [1]uintptr
+	runtime.Callers(4, pcs[:]) // 跳过 runtime.Callers、callerPC、log/logf/trace 与导出的日志函数
+	return pcs[0]
+}
+
+// log 以导出的日志函数的调用位置输出一条日志
+func log(level slog.Level, msg string, args ...any) {
+	ctx := context.Background()
+	pc := callerPC()
+	if !enabled(ctx, level, pc) {
+		return
+	}
+	r := slog.NewRecord(time.Now(), level, msg, pc)
+	r.Add(args...)
+	_ = slog.Default().Handler().Handle(ctx, r)
+}
+
+// logf 以导出的日志函数的调用位置输出一条格式化的日志，不会输出时跳过格式化，避免热路径上的内存分配
+func logf(level slog.Level, format string, args ...any) {
+	ctx := context.Background()
+	pc := callerPC()
+	if !enabled(ctx, level, pc) {
+		return
+	}
+	r := slog.NewRecord(time.Now(), level, fmt.Sprintf(format, args...), pc)
+	_ = slog.Default().Handler().Handle(ctx, r)
+}
+
+// Trace 输出一条报文跟踪日志，不受日志级别限制，只应在开启了跟踪的报文上调用
+func Trace(msg string, v ...interface{}) {
+	trace(msg, v...)
+}
+
+// trace 以导出的日志函数的调用位置输出一条报文跟踪日志
+func trace(msg string, args ...any) {
+	r := slog.NewRecord(time.Now(), LevelTrace, msg, callerPC())
+	r.Add(args...)
+	_ = slog.Default().Handler().Handle(context.Background(), r)
+}
+
 func Debug(msg string, v ...interface{}) {
-	slog.Debug(msg, v...)
+	log(slog.LevelDebug, msg, v...)
 }
 
 func DebugF(msg string, v ...interface{}) {
-	slog.Debug(fmt.Sprintf(msg, v...))
+	logf(slog.LevelDebug, msg, v...)
 }
 
 func Info(msg string, v ...interface{}) {
-	slog.Info(msg, v...)
+	log(slog.LevelInfo, msg, v...)
 }
 
 func InfoF(msg string, v ...interface{}) {
-	slog.Info(fmt.Sprintf(msg, v...))
+	logf(slog.LevelInfo, msg, v...)
 }
 
 func Warn(msg string, v ...interface{}) {
-	slog.Warn(msg, v...)
+	log(slog.LevelWarn, msg, v...)
 }
 
 func WarnF(msg string, v ...interface{}) {
-	slog.Warn(fmt.Sprintf(msg, v...))
+	logf(slog.LevelWarn, msg, v...)
 }
 
 func Error(msg string, v ...interface{}) {
-	slog.Error(msg, v...)
+	log(slog.LevelError, msg, v...)
 }
 
 func ErrorF(msg string, v ...interface{}) {
-	slog.Error(fmt.Sprintf(msg, v...))
+	logf(slog.LevelError, msg, v...)
 }
 
 func Fatal(msg string, v ...interface{}) {
-	slog.Log(context.Background(), LevelFatal, msg, v...)
+	log(LevelFatal, msg, v...)
 }
 
 func FatalF(msg string, v ...interface{}) {
-	slog.Log(context.Background(), LevelFatal, fmt.Sprintf(msg, v...))
+	logf(LevelFatal, msg, v...)
 }
@@ -0,0 +1,204 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestHandler 创建写入dir目录且不输出到标准输出的日志处理器
+func newTestHandler(dir string, format string, levels *Levels) *AsyncHandler {
+	s := newSink(Options{Dir: dir, Format: format}, time.Now)
+	s.console = io.Discard
+	s.start()
+	return newAsyncHandler(s, levels)
+}
+
+// readLogs 关闭处理器并返回写入日志文件的所有行
+func readLogs(t *testing.T, handler *AsyncHandler, dir string) []string {
+	t.Helper()
+	if err := handler.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*.log"))
+	var content []byte
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatalf("read log file: %v", err)
+		}
+		content = append(content, data...)
+	}
+	return strings.Split(strings.TrimSpace(string(content)), "\n")
+}
+
+// useDefault 在测试期间把handler设置为默认处理器
+func useDefault(t *testing.T, handler slog.Handler) {
+	previous := slog.Default()
+	slog.SetDefault(slog.New(handler))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+}
+
+func TestJSONKeepsAttributes(t *testing.T) {
+	dir := t.TempDir()
+	handler := newTestHandler(dir, FormatJSON, NewLevels(slog.LevelInfo))
+	log := slog.New(handler).With(KeyConnID, "127.0.0.1:50000").WithGroup("packet")
+	log.Info("Receive packet", KeyPacketType, "PUBLISH", KeyTopic, "a/b")
+	slog.New(handler).Log(nil, LevelFatal, "Fatal error", KeyClientID, "device")
+
+	lines := readLogs(t, handler, dir)
+	if len(lines) != 2 {
+		t.Fatalf("got %d log lines, want 2: %q", len(lines), lines)
+	}
+	var first struct {
+		Level  string `json:"level"`
+		Msg    string `json:"msg"`
+		ConnID string `json:"conn_id"`
+		Packet struct {
+			PacketType string `json:"packet_type"`
+			Topic      string `json:"topic"`
+			Module     string `json:"module"`
+		} `json:"packet"`
+	}
+	if err := json.Unmarshal([]byte(lines[0]), &first); err != nil {
+		t.Fatalf("invalid JSON %q: %v", lines[0], err)
+	}
+	if first.Level != "INFO" || first.Msg != "Receive packet" || first.ConnID != "127.0.0.1:50000" ||
+		first.Packet.PacketType != "PUBLISH" || first.Packet.Topic != "a/b" || first.Packet.Module != "logger" {
+		t.Fatalf("unexpected JSON record %s", lines[0])
+	}
+	var second map[string]any
+	if err := json.Unmarshal([]byte(lines[1]), &second); err != nil {
+		t.Fatalf("invalid JSON %q: %v", lines[1], err)
+	}
+	if second["level"] != "FATAL" || second[KeyClientID] != "device" {
+		t.Fatalf("unexpected JSON record %s", lines[1])
+	}
+}
+
+func TestTextDerivedHandlers(t *testing.T) {
+	dir := t.TempDir()
+	handler := newTestHandler(dir, FormatText, NewLevels(slog.LevelInfo))
+	// 派生的处理器与原处理器共享写协程
+	log := slog.New(handler).With(KeyClientID, "device").WithGroup("queue").With("depth", 3)
+	log.Warn("Slow consumer", "bytes", 1024, slog.Group("policy", "name", "drop_qos0"))
+	slog.New(handler).Info("Plain")
+
+	lines := readLogs(t, handler, dir)
+	if len(lines) != 2 {
+		t.Fatalf("got %d log lines, want 2: %q", len(lines), lines)
+	}
+	for _, want := range []string{"client_id=device", "queue.depth=3", "queue.bytes=1024", "queue.policy.name=drop_qos0"} {
+		if !strings.Contains(lines[0], want) {
+			t.Errorf("log line %q does not contain %q", lines[0], want)
+		}
+	}
+	if strings.Contains(lines[1], "client_id") {
+		t.Errorf("attributes leaked into parent handler: %q", lines[1])
+	}
+}
+
+func TestModuleLevels(t *testing.T) {
+	dir := t.TempDir()
+	moduleLevels := NewLevels(slog.LevelWarn)
+	handler := newTestHandler(dir, FormatJSON, moduleLevels)
+	useDefault(t, handler)
+	previous := levels
+	levels = moduleLevels
+	t.Cleanup(func() { levels = previous })
+
+	InfoF("dropped %d", 1)
+	moduleLevels.SetModule("logger", slog.LevelDebug)
+	DebugF("kept %d", 2)
+	slog.Debug("kept by slog")
+	moduleLevels.SetModule("other", slog.LevelError)
+	if moduleLevels.Min() != slog.LevelDebug {
+		t.Fatalf("Min() = %v, want DEBUG", moduleLevels.Min())
+	}
+	moduleLevels.ResetModule("logger")
+	Info("dropped after reset")
+	Warn("kept at default level")
+
+	lines := readLogs(t, handler, dir)
+	var messages []string
+	for _, line := range lines {
+		var record map[string]any
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("invalid JSON %q: %v", line, err)
+		}
+		messages = append(messages, record["msg"].(string))
+	}
+	want := []string{"kept 2", "kept by slog", "kept at default level"}
+	if strings.Join(messages, "|") != strings.Join(want, "|") {
+		t.Fatalf("logged messages = %q, want %q", messages, want)
+	}
+	if moduleLevels.Min() != slog.LevelWarn {
+		t.Fatalf("Min() after reset = %v, want WARN", moduleLevels.Min())
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		name  string
+		level slog.Level
+		err   bool
+	}{
+		{"debug", slog.LevelDebug, false},
+		{"INFO", slog.LevelInfo, false},
+		{"warn", slog.LevelWarn, false},
+		{"Error", slog.LevelError, false},
+		{"fatal", LevelFatal, false},
+		{"verbose", 0, true},
+		{"", 0, true},
+	}
+	for _, tt := range tests {
+		level, err := ParseLevel(tt.name)
+		if (err != nil) != tt.err || (!tt.err && level != tt.level) {
+			t.Errorf("ParseLevel(%q) = %v, %v", tt.name, level, err)
+		}
+	}
+	if name := LevelName(LevelFatal); name != "FATAL" {
+		t.Errorf("LevelName(LevelFatal) = %q", name)
+	}
+}
+
+func TestWriteIsCopied(t *testing.T) {
+	dir := t.TempDir()
+	handler := newTestHandler(dir, FormatText, NewLevels(slog.LevelInfo))
+	line := []byte("first\n")
+	handler.Write(line)
+	copy(line, "xxxxx")
+	lines := readLogs(t, handler, dir)
+	if !bytes.Equal([]byte(lines[0]), []byte("first")) {
+		t.Fatalf("Write did not copy the buffer, got %q", lines[0])
+	}
+}
+
+func TestTraceIgnoresLevels(t *testing.T) {
+	dir := t.TempDir()
+	moduleLevels := NewLevels(LevelFatal)
+	moduleLevels.SetModule("logger", LevelFatal)
+	handler := newTestHandler(dir, FormatJSON, moduleLevels)
+	useDefault(t, handler)
+
+	Error("dropped")
+	Trace("Packet trace", "direction", "in")
+
+	lines := readLogs(t, handler, dir)
+	if len(lines) != 1 {
+		t.Fatalf("got %d log lines, want 1: %q", len(lines), lines)
+	}
+	var record map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &record); err != nil {
+		t.Fatalf("invalid JSON %q: %v", lines[0], err)
+	}
+	if record["level"] != "TRACE" || record["direction"] != "in" || record[KeyModule] != "logger" {
+		t.Fatalf("unexpected trace record %s", lines[0])
+	}
+}
@@ -0,0 +1,292 @@
+package logger
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	c "github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/config"
+)
+
+const (
+	dateLayout        = "2006-01-02" // 日志文件名中的日期格式
+	defaultBufferSize = 1024         // 写入队列的容量
+)
+
+// droppedLines 因写入队列已满被丢弃的日志行数
+var droppedLines atomic.Uint64
+
+// DroppedLines 返回启动以来因写入队列已满被丢弃的日志行数，overflow_policy为drop时才会丢弃
+func DroppedLines() uint64 {
+	return droppedLines.Load()
+}
+
+// Options 日志处理器的输出设置
+type Options struct {
+	Dir           string // 日志文件目录
+	Format        string // 输出格式：FormatText、FormatJSON
+	MaxSize       int64  // 单个日志文件的最大字节数，0表示只按天轮转
+	RetentionDays int    // 日志文件的保留天数，0表示不删除
+	Compress      bool   // 是否使用gzip压缩轮转后的日志文件
+	Drop          bool   // 写入队列已满时丢弃日志而不是阻塞调用方
+}
+
+// optionsOf 返回配置中的日志输出设置
+func optionsOf(config c.Config) Options {
+	return Options{
+		Dir:           config.Log.Dir,
+		Format:        config.Log.Format,
+		MaxSize:       int64(config.Log.MaxSize) << 20,
+		RetentionDays: config.Log.RetentionDays,
+		Compress:      config.Log.Compress,
+		Drop:          config.Log.OverflowPolicy == c.LogOverflowDrop,
+	}
+}
+
+// entry 一行等待写入的日志，time为写入时间，决定日志所在的文件
+type entry struct {
+	data []byte
+	time time.Time
+}
+
+// sink 异步写入标准输出与日志文件，由同一个根处理器派生的所有处理器共享
+// 日志文件按天命名为 2006-01-02.log，超过大小上限时当前文件被重命名为 2006-01-02.1.log、2006-01-02.2.log……
+// 轮转后在后台压缩并删除过期的文件
+type sink struct {
+	options  Options
+	now      func() time.Time // 时钟，测试时可替换
+	console  io.Writer        // 标准输出
+	ch       chan entry
+	file     *os.File // 当前日志文件
+	day      string   // 当前日志文件的日期
+	size     int64    // 当前日志文件的大小
+	lastErr  string   // 最近一次打开日志文件的错误，相同的错误只输出一次
+	mu       sync.Mutex
+	current  string        // 当前日志文件的日期，由mu保护，供清理协程读取
+	cleanups chan struct{} // 通知清理协程压缩与删除旧文件
+	wg       sync.WaitGroup
+}
+
+// newSink 创建写入options.Dir目录的sink，需要调用start启动写协程
+func newSink(options Options, now func() time.Time) *sink {
+	return &sink{
+		options:  options,
+		now:      now,
+		console:  os.Stdout,
+		ch:       make(chan entry, defaultBufferSize),
+		cleanups: make(chan struct{}, 1),
+	}
+}
+
+// start 打开当前日志文件并启动写协程与清理协程
+func (s *sink) start() {
+	s.rotateIfNeeded(s.now(), 0)
+	s.wg.Add(2)
+	go s.run()
+	go s.runCleanup()
+}
+
+// path 返回day的日志文件路径
+func (s *sink) path(day string) string {
+	return filepath.Join(s.options.Dir, day+".log")
+}
+
+// rotateIfNeeded 在日期变化或写入size字节后超过大小上限时轮转日志文件
+func (s *sink) rotateIfNeeded(now time.Time, size int) {
+	day := now.Format(dateLayout)
+	if s.file != nil && day == s.day &&
+		(s.options.MaxSize <= 0 || s.size == 0 || s.size+int64(size) <= s.options.MaxSize) {
+		return
+	}
+	if err := s.rotate(day); err != nil {
+		if err.Error() != s.lastErr {
+			s.lastErr = err.Error()
+			_, _ = fmt.Fprintf(os.Stderr, "log rotation failed: %v\n", err)
+		}
+		return
+	}
+	s.lastErr = ""
+	s.notifyCleanup()
+}
+
+// rotate 关闭当前日志文件并打开day的日志文件，同一天内轮转时先将当前文件重命名为带序号的文件
+func (s *sink) rotate(day string) error {
+	if s.file != nil {
+		if err := s.file.Close(); err != nil {
+			return fmt.Errorf("error occured while closing log file: %v", err)
+		}
+		s.file = nil
+		if day == s.day {
+			if err := os.Rename(s.path(day), s.nextBackup(day)); err != nil {
+				return fmt.Errorf("error occured while renaming log file: %v", err)
+			}
+		}
+	}
+	if err := os.MkdirAll(s.options.Dir, 0755); err != nil {
+		return fmt.Errorf("error occured while creating log directory: %v", err)
+	}
+	file, err := os.OpenFile(s.path(day), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("error occured while opening log file: %v", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return fmt.Errorf("error occured while reading log file size: %v", err)
+	}
+	s.file, s.day, s.size = file, day, info.Size()
+	s.mu.Lock()
+	s.current = day
+	s.mu.Unlock()
+	return nil
+}
+
+// nextBackup 返回day的下一个带序号的日志文件路径
+func (s *sink) nextBackup(day string) string {
+	next := 1
+	entries, _ := os.ReadDir(s.options.Dir)
+	for _, e := range entries {
+		rest, ok := strings.CutPrefix(e.Name(), day+".")
+		if !ok {
+			continue
+		}
+		rest = strings.TrimSuffix(strings.TrimSuffix(rest, ".gz"), ".log")
+		if index, err := strconv.Atoi(rest); err == nil && index >= next {
+			next = index + 1
+		}
+	}
+	return filepath.Join(s.options.Dir, fmt.Sprintf("%s.%d.log", day, next))
+}
+
+// run 写协程，按写入顺序输出日志，队列关闭后关闭日志文件
+func (s *sink) run() {
+	defer s.wg.Done()
+	for e := range s.ch {
+		s.rotateIfNeeded(e.time, len(e.data))
+		_, _ = s.console.Write(e.data)
+		if s.file != nil {
+			n, _ := s.file.Write(e.data)
+			s.size += int64(n)
+		}
+	}
+	close(s.cleanups)
+	if s.file != nil {
+		_ = s.file.Sync()
+		_ = s.file.Close()
+	}
+}
+
+// notifyCleanup 通知清理协程，已有未处理的通知时直接返回
+func (s *sink) notifyCleanup() {
+	select {
+	case s.cleanups <- struct{}{}:
+	default:
+	}
+}
+
+// runCleanup 清理协程，压缩与删除旧文件不阻塞日志写入
+func (s *sink) runCleanup() {
+	defer s.wg.Done()
+	for range s.cleanups {
+		s.cleanup()
+	}
+}
+
+// cleanup 删除超过保留天数的日志文件，并压缩当前文件以外的日志文件
+// 只处理文件名以日期开头的 .log 与 .log.gz 文件，目录中的其他文件不受影响
+func (s *sink) cleanup() {
+	s.mu.Lock()
+	current := s.current
+	s.mu.Unlock()
+	today, err := time.ParseInLocation(dateLayout, current, time.Local)
+	if err != nil {
+		return
+	}
+	entries, err := os.ReadDir(s.options.Dir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || (!strings.HasSuffix(name, ".log") && !strings.HasSuffix(name, ".log.gz")) || len(name) < len(dateLayout) {
+			continue
+		}
+		day, err := time.ParseInLocation(dateLayout, name[:len(dateLayout)], time.Local)
+		if err != nil {
+			continue
+		}
+		path := filepath.Join(s.options.Dir, name)
+		if s.options.RetentionDays > 0 && day.Before(today.AddDate(0, 0, -s.options.RetentionDays)) {
+			if err := os.Remove(path); err != nil {
+				_, _ = fmt.Fprintf(os.Stderr, "removing expired log file failed: %v\n", err)
+			}
+			continue
+		}
+		// 写协程可能已经轮转到新的一天，不压缩不早于当前日期的按天文件
+		daily := name == name[:len(dateLayout)]+".log"
+		if !s.options.Compress || !strings.HasSuffix(name, ".log") || (daily && !day.Before(today)) {
+			continue
+		}
+		if err := compressFile(path); err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "compressing log file failed: %v\n", err)
+		}
+	}
+}
+
+// compressFile 将path压缩为path.gz并删除原文件，path.gz已存在时追加为新的gzip成员
+func compressFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	if _, err := io.Copy(zw, src); err != nil {
+		_ = dst.Close()
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		_ = dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	_ = src.Close()
+	return os.Remove(path)
+}
+
+// Write 拷贝一行日志并交给写协程，队列已满且设置了丢弃时丢弃日志
+func (s *sink) Write(p []byte) (int, error) {
+	// 拷贝数据避免竞态
+	e := entry{data: make([]byte, len(p)), time: s.now()}
+	copy(e.data, p)
+	if !s.options.Drop {
+		s.ch <- e
+		return len(p), nil
+	}
+	select {
+	case s.ch <- e:
+	default:
+		droppedLines.Add(1)
+	}
+	return len(p), nil
+}
+
+// Close 写入队列中剩余的日志并关闭日志文件
+func (s *sink) Close() error {
+	close(s.ch)
+	s.wg.Wait()
+	return nil
+}
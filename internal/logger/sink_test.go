@@ -0,0 +1,198 @@
+package logger
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// newTestSink 创建使用可控时钟且不输出到标准输出的sink，返回设置时钟的函数
+func newTestSink(t *testing.T, options Options, start time.Time) (*sink, func(time.Time)) {
+	t.Helper()
+	now := start
+	s := newSink(options, func() time.Time { return now })
+	s.console = io.Discard
+	s.start()
+	return s, func(next time.Time) { now = next }
+}
+
+// readLogFile 读取日志文件内容，.gz文件先解压
+func readLogFile(t *testing.T, path string) string {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("open %s: %v", path, err)
+	}
+	defer file.Close()
+	var reader io.Reader = file
+	if filepath.Ext(path) == ".gz" {
+		zr, err := gzip.NewReader(file)
+		if err != nil {
+			t.Fatalf("gzip %s: %v", path, err)
+		}
+		reader = zr
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("read %s: %v", path, err)
+	}
+	return string(data)
+}
+
+// listDir 返回目录中排序后的文件名
+func listDir(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("read dir: %v", err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	slices.Sort(names)
+	return names
+}
+
+func TestRotateAcrossDays(t *testing.T) {
+	dir := t.TempDir()
+	writes := []struct {
+		time time.Time
+		line string
+	}{
+		{time.Date(2026, 1, 1, 12, 0, 0, 0, time.Local), "new year\n"},
+		{time.Date(2026, 3, 1, 23, 59, 59, 999, time.Local), "before midnight\n"},
+		{time.Date(2026, 3, 2, 0, 0, 0, 0, time.Local), "after midnight\n"},
+		{time.Date(2026, 3, 2, 8, 0, 0, 0, time.Local), "same day\n"},
+		// 与第一行位于一年中的同一天，仍然写入新的文件
+		{time.Date(2027, 1, 1, 0, 0, 1, 0, time.Local), "next year\n"},
+	}
+	s, setTime := newTestSink(t, Options{Dir: dir}, writes[0].time)
+	for _, w := range writes {
+		setTime(w.time)
+		_, _ = s.Write([]byte(w.line))
+	}
+	_ = s.Close()
+
+	want := map[string]string{
+		"2026-01-01.log": "new year\n",
+		"2026-03-01.log": "before midnight\n",
+		"2026-03-02.log": "after midnight\nsame day\n",
+		"2027-01-01.log": "next year\n",
+	}
+	if names := listDir(t, dir); len(names) != len(want) {
+		t.Fatalf("log files = %q, want %d files", names, len(want))
+	}
+	for name, content := range want {
+		if got := readLogFile(t, filepath.Join(dir, name)); got != content {
+			t.Errorf("%s = %q, want %q", name, got, content)
+		}
+	}
+}
+
+func TestRotateBySize(t *testing.T) {
+	dir := t.TempDir()
+	day := time.Date(2026, 3, 1, 10, 0, 0, 0, time.Local)
+	s, setTime := newTestSink(t, Options{Dir: dir, MaxSize: 10}, day)
+	for _, line := range []string{"line 1\n", "line 2\n", "line 3\n"} {
+		_, _ = s.Write([]byte(line))
+	}
+	// 新的一天从不带序号的文件开始
+	setTime(day.Add(24 * time.Hour))
+	_, _ = s.Write([]byte("line 4\n"))
+	_ = s.Close()
+
+	want := map[string]string{
+		"2026-03-01.1.log": "line 1\n",
+		"2026-03-01.2.log": "line 2\n",
+		"2026-03-01.log":   "line 3\n",
+		"2026-03-02.log":   "line 4\n",
+	}
+	if names := listDir(t, dir); len(names) != len(want) {
+		t.Fatalf("log files = %q, want %d files", names, len(want))
+	}
+	for name, content := range want {
+		if got := readLogFile(t, filepath.Join(dir, name)); got != content {
+			t.Errorf("%s = %q, want %q", name, got, content)
+		}
+	}
+}
+
+func TestCompressAndRetention(t *testing.T) {
+	dir := t.TempDir()
+	existing := map[string]string{
+		"2026-02-20.log":    "expired\n",
+		"2026-02-21.1.log":  "expired backup\n",
+		"2026-02-28.log":    "kept\n",
+		"2026-03-01.log.gz": "",
+		"app.log":           "unrelated\n",
+		"notes.txt":         "unrelated\n",
+	}
+	for name, content := range existing {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 上次运行时已经压缩过的内容
+	gz, _ := os.Create(filepath.Join(dir, "2026-03-01.log.gz"))
+	zw := gzip.NewWriter(gz)
+	_, _ = zw.Write([]byte("compressed earlier\n"))
+	_ = zw.Close()
+	_ = gz.Close()
+
+	day := time.Date(2026, 3, 1, 23, 0, 0, 0, time.Local)
+	s, setTime := newTestSink(t, Options{Dir: dir, RetentionDays: 2, Compress: true}, day)
+	_, _ = s.Write([]byte("first day\n"))
+	setTime(day.Add(2 * time.Hour))
+	_, _ = s.Write([]byte("second day\n"))
+	_ = s.Close()
+
+	wantNames := []string{"2026-02-28.log.gz", "2026-03-01.log.gz", "2026-03-02.log", "app.log", "notes.txt"}
+	if names := listDir(t, dir); !slices.Equal(names, wantNames) {
+		t.Fatalf("log files = %q, want %q", names, wantNames)
+	}
+	want := map[string]string{
+		"2026-02-28.log.gz": "kept\n",
+		"2026-03-01.log.gz": "compressed earlier\nfirst day\n",
+		"2026-03-02.log":    "second day\n",
+	}
+	for name, content := range want {
+		if got := readLogFile(t, filepath.Join(dir, name)); got != content {
+			t.Errorf("%s = %q, want %q", name, got, content)
+		}
+	}
+}
+
+func TestOverflowPolicy(t *testing.T) {
+	for _, drop := range []bool{false, true} {
+		s := newSink(Options{Dir: t.TempDir(), Drop: drop}, time.Now)
+		s.ch = make(chan entry, 1)
+		before := DroppedLines()
+		_, _ = s.Write([]byte("queued\n"))
+
+		written := make(chan struct{})
+		go func() {
+			_, _ = s.Write([]byte("overflow\n"))
+			close(written)
+		}()
+		select {
+		case <-written:
+			if !drop {
+				t.Fatal("Write did not block on a full queue")
+			}
+			if DroppedLines() != before+1 {
+				t.Fatalf("DroppedLines() = %d, want %d", DroppedLines(), before+1)
+			}
+		case <-time.After(50 * time.Millisecond):
+			if drop {
+				t.Fatal("Write blocked on a full queue with drop policy")
+			}
+			<-s.ch
+			<-written
+		}
+	}
+}
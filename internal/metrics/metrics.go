@@ -0,0 +1,95 @@
+// Package metrics 定义了服务器的Prometheus指标，并提供 /metrics 接口
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/logger"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Namespace 所有指标名称的前缀
+const Namespace = "mqtt"
+
+// Registry 服务器的指标注册表，不使用全局默认注册表，避免依赖库注册的指标混入
+var Registry = prometheus.NewRegistry()
+
+// latencyBuckets 内存操作的耗时分桶，从1微秒到100毫秒
+var latencyBuckets = prometheus.ExponentialBuckets(1e-6, 4, 10)
+
+var (
+	// Connections 各监听地址当前的网络连接数
+	Connections = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: Namespace, Name: "connections",
+		Help: "Current number of network connections per listener.",
+	}, []string{"listener"})
+	// ConnectionsAccepted 各监听地址接受的网络连接总数
+	ConnectionsAccepted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace, Name: "connections_accepted_total",
+		Help: "Total number of network connections accepted per listener.",
+	}, []string{"listener"})
+	// ConnAcks 按返回码统计的CONNACK报文数
+	ConnAcks = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace, Name: "connack_total",
+		Help: "Total number of CONNACK packets sent by return code.",
+	}, []string{"code"})
+	// PacketsReceived 按类型统计的收到的报文数
+	PacketsReceived = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace, Name: "packets_received_total",
+		Help: "Total number of MQTT packets received by type.",
+	}, []string{"type"})
+	// PacketsSent 按类型统计的发送的报文数
+	PacketsSent = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace, Name: "packets_sent_total",
+		Help: "Total number of MQTT packets sent by type.",
+	}, []string{"type"})
+	// FanOut 每条发布消息匹配到的订阅数
+	FanOut = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: Namespace, Name: "publish_fanout_size",
+		Help:    "Number of matching subscriptions per published message.",
+		Buckets: []float64{0, 1, 2, 5, 10, 50, 100, 500, 1000, 5000, 10000},
+	})
+	// MatchDuration 主题匹配耗时
+	MatchDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: Namespace, Name: "match_topic_duration_seconds",
+		Help:    "Time spent matching a published topic against subscriptions.",
+		Buckets: latencyBuckets,
+	})
+	// StorageDuration 存储层操作耗时
+	StorageDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: Namespace, Name: "storage_operation_duration_seconds",
+		Help:    "Time spent in storage operations.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"operation"})
+	// MongoCommandDuration MongoDB命令耗时
+	MongoCommandDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: Namespace, Name: "mongo_command_duration_seconds",
+		Help:    "Duration of MongoDB commands by command name and outcome.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"command", "status"})
+	// MongoPoolEvents MongoDB连接池事件数
+	MongoPoolEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace, Name: "mongo_pool_events_total",
+		Help: "Total number of MongoDB connection pool events by type.",
+	}, []string{"type"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		Connections, ConnectionsAccepted, ConnAcks, PacketsReceived, PacketsSent, FanOut,
+		MatchDuration, StorageDuration, MongoCommandDuration, MongoPoolEvents,
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: Namespace, Name: "log_dropped_lines_total",
+			Help: "Total number of log lines dropped because the log queue was full.",
+		}, func() float64 { return float64(logger.DroppedLines()) }),
+	)
+}
+
+// Handler 返回输出所有指标的HTTP处理器
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
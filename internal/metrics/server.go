@@ -0,0 +1,58 @@
+// Package metrics 定义了服务器的Prometheus指标，并提供 /metrics 接口
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/logger"
+)
+
+// Server 提供 /metrics 等运维接口的HTTP服务器
+type Server struct {
+	mux    *http.ServeMux
+	server *http.Server
+}
+
+// NewServer 创建监听port端口的HTTP服务器，并注册 /metrics 接口
+func NewServer(port int) *Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler())
+	return &Server{
+		mux:    mux,
+		server: &http.Server{Addr: ":" + strconv.Itoa(port), Handler: mux},
+	}
+}
+
+// Handle 注册额外的HTTP接口，需要在Start之前调用
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+// Start 打开监听端口并在后台处理请求
+func (s *Server) Start() error {
+	listener, err := net.Listen("tcp", s.server.Addr)
+	if err != nil {
+		return err
+	}
+	logger.InfoF("HTTP Server Listen On " + listener.Addr().String())
+	go func() {
+		if err := s.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.ErrorF("HTTP Server stopped unexpectedly: %v", err)
+		}
+	}()
+	return nil
+}
+
+// Invoke 优雅关闭HTTP服务器，超时后强制关闭
+func (s *Server) Invoke(ctx context.Context) error {
+	logger.Info("Stopping http server")
+	if err := s.server.Shutdown(ctx); err != nil {
+		_ = s.server.Close()
+		return err
+	}
+	return nil
+}
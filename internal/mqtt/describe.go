@@ -0,0 +1,286 @@
+package mqtt
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// previewSize 报文描述中保留的有效载荷字节数
+const previewSize = 64
+
+// TopicFilter 订阅或取消订阅报文中的一个主题过滤器，取消订阅时QoS无意义
+type TopicFilter struct {
+	Filter string
+	QoS    byte
+}
+
+// PacketInfo 解码后的报文摘要，用于输出便于阅读的调试日志
+// 只解析定位报文所需的字段，CONNECT报文中的密码、遗嘱消息等不会被解析
+type PacketInfo struct {
+	Type           PacketType
+	Flags          byte
+	Size           int           // 报文总字节数，包括固定头部
+	PacketID       uint16        // 报文标识符，HasPacketID为false时无意义
+	HasPacketID    bool          // 报文是否包含报文标识符
+	Topic          string        // PUBLISH报文的主题
+	ClientID       string        // CONNECT报文的客户端ID
+	KeepAlive      uint16        // CONNECT报文的心跳间隔（秒）
+	CleanSession   bool          // CONNECT报文的清理会话标志
+	SessionPresent bool          // CONNACK报文的会话存在标志
+	ReturnCodes    []byte        // CONNACK与SUBACK报文的返回码
+	Filters        []TopicFilter // SUBSCRIBE与UNSUBSCRIBE报文的主题过滤器
+	Payload        []byte        // PUBLISH报文的有效载荷，与原报文共享内存
+	Malformed      bool          // 报文内容不完整，之后的字段未被解析
+}
+
+// Dup 返回PUBLISH报文的重复发送标志
+func (info PacketInfo) Dup() bool {
+	return info.Flags&0x08 != 0
+}
+
+// QoS 返回PUBLISH报文的QoS等级
+func (info PacketInfo) QoS() byte {
+	return info.Flags >> 1 & 0x03
+}
+
+// Retain 返回PUBLISH报文的保留标志
+func (info PacketInfo) Retain() bool {
+	return info.Flags&0x01 != 0
+}
+
+// bodyReader 按顺序读取报文的可变头部与有效载荷，报文可以分段存放
+type bodyReader struct {
+	data []byte   // 当前分段中未读取的部分
+	rest [][]byte // 之后的分段
+	ok   bool
+}
+
+// len 返回未读取的字节数
+func (r *bodyReader) len() int {
+	size := len(r.data)
+	for _, segment := range r.rest {
+		size += len(segment)
+	}
+	return size
+}
+
+// take 读取n个字节，不跨越分段时与报文共享内存
+func (r *bodyReader) take(n int) []byte {
+	if !r.ok || r.len() < n {
+		r.ok = false
+		return nil
+	}
+	for len(r.data) == 0 && len(r.rest) > 0 {
+		r.data, r.rest = r.rest[0], r.rest[1:]
+	}
+	if n <= len(r.data) {
+		value := r.data[:n]
+		r.data = r.data[n:]
+		return value
+	}
+	value := make([]byte, 0, n)
+	for len(value) < n {
+		if len(r.data) == 0 {
+			r.data, r.rest = r.rest[0], r.rest[1:]
+		}
+		count := min(n-len(value), len(r.data))
+		value = append(value, r.data[:count]...)
+		r.data = r.data[count:]
+	}
+	return value
+}
+
+func (r *bodyReader) byte() byte {
+	if value := r.take(1); r.ok {
+		return value[0]
+	}
+	return 0
+}
+
+func (r *bodyReader) uint16() uint16 {
+	if value := r.take(2); r.ok {
+		return binary.BigEndian.Uint16(value)
+	}
+	return 0
+}
+
+func (r *bodyReader) string() string {
+	length := int(r.uint16())
+	return string(r.take(length))
+}
+
+// Describe 解码固定头部为header、可变头部与有效载荷为body的报文
+func Describe(header FixedHeader, body []byte) PacketInfo {
+	return describe(header, &bodyReader{data: body, ok: true})
+}
+
+// describe 从r中解码固定头部为header的报文
+func describe(header FixedHeader, r *bodyReader) PacketInfo {
+	info := PacketInfo{Type: header.Type, Flags: header.Flags, Size: (&Packet{Header: &header}).Size()}
+	switch header.Type {
+	case CONNECT:
+		r.string() // 协议名
+		r.byte()   // 协议级别
+		flags := r.byte()
+		info.CleanSession = flags&0x02 != 0
+		info.KeepAlive = r.uint16()
+		info.ClientID = r.string()
+	case CONNACK:
+		info.SessionPresent = r.byte()&0x01 != 0
+		info.ReturnCodes = []byte{r.byte()}
+	case PUBLISH:
+		info.Topic = r.string()
+		if info.QoS() > 0 {
+			info.PacketID, info.HasPacketID = r.uint16(), true
+		}
+		if r.ok {
+			info.Payload = r.take(r.len())
+		}
+	case PUBACK, PUBREC, PUBREL, PUBCOMP, UNSUBACK:
+		info.PacketID, info.HasPacketID = r.uint16(), true
+	case SUBSCRIBE, UNSUBSCRIBE:
+		info.PacketID, info.HasPacketID = r.uint16(), true
+		for r.ok && r.len() > 0 {
+			filter := TopicFilter{Filter: r.string()}
+			if header.Type == SUBSCRIBE {
+				filter.QoS = r.byte()
+			}
+			if r.ok {
+				info.Filters = append(info.Filters, filter)
+			}
+		}
+	case SUBACK:
+		info.PacketID, info.HasPacketID = r.uint16(), true
+		if r.ok {
+			info.ReturnCodes = append([]byte(nil), r.take(r.len())...)
+		}
+	}
+	info.Malformed = !r.ok
+	return info
+}
+
+// DescribeBytes 解码包含固定头部的完整报文
+func DescribeBytes(data []byte) PacketInfo {
+	return DescribeSegments([][]byte{data})
+}
+
+// DescribeSegments 解码分段存放的完整报文，固定头部需要位于第一个分段中
+// 有效载荷位于单独的分段时与原报文共享内存，不需要把分段合并为连续的报文
+func DescribeSegments(segments [][]byte) PacketInfo {
+	var data []byte
+	if len(segments) > 0 {
+		data = segments[0]
+	}
+	if len(data) < 2 {
+		size := 0
+		for _, segment := range segments {
+			size += len(segment)
+		}
+		return PacketInfo{Size: size, Malformed: true}
+	}
+	header := FixedHeader{Type: PacketType(data[0] >> 4), Flags: data[0] & 0x0F}
+	offset := 1
+	for multiplier := 1; offset < len(data) && offset <= 4; multiplier *= 128 {
+		encodedByte := data[offset]
+		header.RemainingLength += int(encodedByte&127) * multiplier
+		offset++
+		if encodedByte&128 == 0 {
+			break
+		}
+	}
+	body := append([][]byte{data[offset:]}, segments[1:]...)
+	r := &bodyReader{rest: body, ok: true}
+	if r.len() < header.RemainingLength {
+		info := describe(header, r)
+		info.Malformed = true
+		return info
+	}
+	// 忽略剩余长度之后的多余数据
+	remaining := header.RemainingLength
+	for i, segment := range body {
+		body[i] = segment[:min(len(segment), remaining)]
+		remaining -= len(body[i])
+	}
+	return describe(header, r)
+}
+
+// LogValue 以字段分组的形式输出报文摘要，有效载荷只输出前64字节，文本按原样输出，二进制内容按十六进制输出
+func (info PacketInfo) LogValue() slog.Value {
+	attrs := []slog.Attr{
+		slog.String("type", info.Type.String()),
+		slog.String("flags", fmt.Sprintf("%04b", info.Flags)),
+		slog.Int("size", info.Size),
+	}
+	if info.Type == PUBLISH {
+		attrs = append(attrs, slog.Int("qos", int(info.QoS())), slog.Bool("dup", info.Dup()), slog.Bool("retain", info.Retain()))
+	}
+	if info.HasPacketID {
+		attrs = append(attrs, slog.Int("packet_id", int(info.PacketID)))
+	}
+	switch info.Type {
+	case CONNECT:
+		attrs = append(attrs, slog.String("client_id", info.ClientID), slog.Int("keep_alive", int(info.KeepAlive)),
+			slog.Bool("clean_session", info.CleanSession))
+	case CONNACK:
+		attrs = append(attrs, slog.Bool("session_present", info.SessionPresent))
+	case PUBLISH:
+		attrs = append(attrs, slog.String("topic", info.Topic), slog.Int("payload_size", len(info.Payload)),
+			slog.String("payload", PreviewPayload(info.Payload)))
+	case SUBSCRIBE, UNSUBSCRIBE:
+		filters := make([]string, len(info.Filters))
+		for i, filter := range info.Filters {
+			filters[i] = filter.Filter
+			if info.Type == SUBSCRIBE {
+				filters[i] = fmt.Sprintf("%s(qos=%d)", filter.Filter, filter.QoS)
+			}
+		}
+		attrs = append(attrs, slog.String("filters", strings.Join(filters, ",")))
+	}
+	if len(info.ReturnCodes) > 0 {
+		attrs = append(attrs, slog.String("return_codes", hex.EncodeToString(info.ReturnCodes)))
+	}
+	if info.Malformed {
+		attrs = append(attrs, slog.Bool("malformed", true))
+	}
+	return slog.GroupValue(attrs...)
+}
+
+// PreviewPayload 返回有效载荷前64字节的可读形式，可打印的UTF-8文本按原样返回，否则按十六进制返回，截断时以 ... 结尾
+func PreviewPayload(payload []byte) string {
+	preview, suffix := payload, ""
+	if len(payload) > previewSize {
+		preview, suffix = payload[:previewSize], "..."
+	}
+	// 截断位置可能落在多字节字符的中间
+	text := preview
+	for i := 1; i < utf8.UTFMax && suffix != "" && len(text) > 0 && !utf8.Valid(text); i++ {
+		text = text[:len(text)-1]
+	}
+	if printable(text) {
+		return string(text) + suffix
+	}
+	return "0x" + hex.EncodeToString(preview) + suffix
+}
+
+// printable 返回data是否为只包含可打印字符与空白的UTF-8文本
+func printable(data []byte) bool {
+	if !utf8.Valid(data) {
+		return false
+	}
+	for _, r := range string(data) {
+		if !unicode.IsPrint(r) && !unicode.IsSpace(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// Describe 解码报文，返回值中的有效载荷与报文共享内存，报文被释放后不能再访问
+func (p *Packet) Describe() PacketInfo {
+	return Describe(*p.Header, p.Payload.Context[:p.Payload.ContextLen])
+}
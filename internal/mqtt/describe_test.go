@@ -0,0 +1,122 @@
+package mqtt
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// mqttString 编码MQTT字符串
+func mqttString(s string) []byte {
+	return append([]byte{byte(len(s) >> 8), byte(len(s))}, s...)
+}
+
+// fullPacket 编码固定头部为first的报文
+func fullPacket(first byte, body ...[]byte) []byte {
+	joined := bytes.Join(body, nil)
+	return append(append([]byte{first}, EncodeRemainingLength(len(joined))...), joined...)
+}
+
+func TestDescribeBytes(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  []byte
+		check func(info PacketInfo) bool
+	}{
+		{"connect", fullPacket(0x10, mqttString("MQTT"), []byte{0x04, 0xC2, 0x00, 0x3C}, mqttString("sensor"), mqttString("user"), mqttString("secret")),
+			func(info PacketInfo) bool {
+				return info.Type == CONNECT && info.ClientID == "sensor" && info.KeepAlive == 60 && info.CleanSession && !info.Malformed
+			}},
+		{"connack", fullPacket(0x20, []byte{0x01, 0x05}),
+			func(info PacketInfo) bool {
+				return info.SessionPresent && slices.Equal(info.ReturnCodes, []byte{0x05})
+			}},
+		{"publish qos 0", fullPacket(0x31, mqttString("a/b"), []byte("on")),
+			func(info PacketInfo) bool {
+				return info.Topic == "a/b" && info.QoS() == 0 && info.Retain() && !info.HasPacketID && string(info.Payload) == "on"
+			}},
+		{"publish qos 2", fullPacket(0x3C, mqttString("a/b"), []byte{0x00, 0x2A}, []byte("on")),
+			func(info PacketInfo) bool {
+				return info.QoS() == 2 && info.Dup() && info.HasPacketID && info.PacketID == 42 && info.Size == 11
+			}},
+		{"pubrel", fullPacket(0x62, []byte{0x01, 0x00}),
+			func(info PacketInfo) bool { return info.Type == PUBREL && info.PacketID == 256 }},
+		{"subscribe", fullPacket(0x82, []byte{0x00, 0x01}, mqttString("a/+"), []byte{0x01}, mqttString("b/#"), []byte{0x02}),
+			func(info PacketInfo) bool {
+				return slices.Equal(info.Filters, []TopicFilter{{"a/+", 1}, {"b/#", 2}})
+			}},
+		{"unsubscribe", fullPacket(0xA2, []byte{0x00, 0x02}, mqttString("a/+")),
+			func(info PacketInfo) bool {
+				return info.PacketID == 2 && slices.Equal(info.Filters, []TopicFilter{{Filter: "a/+"}})
+			}},
+		{"suback", fullPacket(0x90, []byte{0x00, 0x01}, []byte{0x01, 0x80}),
+			func(info PacketInfo) bool { return slices.Equal(info.ReturnCodes, []byte{0x01, 0x80}) }},
+		{"pingreq", []byte{0xC0, 0x00},
+			func(info PacketInfo) bool { return info.Type == PINGREQ && info.Size == 2 && !info.Malformed }},
+		{"truncated topic", fullPacket(0x30, []byte{0x00, 0x05}, []byte("a/")),
+			func(info PacketInfo) bool { return info.Malformed && info.Payload == nil }},
+		{"truncated packet", fullPacket(0x30, mqttString("a/b"), []byte("on"))[:5],
+			func(info PacketInfo) bool { return info.Type == PUBLISH && info.Malformed }},
+	}
+	for _, tt := range tests {
+		if info := DescribeBytes(tt.data); !tt.check(info) {
+			t.Errorf("%s: unexpected packet info %+v", tt.name, info)
+		}
+	}
+}
+
+func TestDescribeSegments(t *testing.T) {
+	data := fullPacket(0x32, mqttString("a/b"), []byte{0x00, 0x07}, []byte("hello"))
+	want := DescribeBytes(data)
+	// 可变头部可以跨越任意分段
+	for split := 2; split < len(data); split++ {
+		info := DescribeSegments([][]byte{data[:split], data[split:]})
+		if info.Topic != want.Topic || info.PacketID != want.PacketID || info.Size != want.Size ||
+			string(info.Payload) != string(want.Payload) || info.Malformed {
+			t.Fatalf("split at %d: DescribeSegments() = %+v, want %+v", split, info, want)
+		}
+	}
+	// 有效载荷位于单独的分段时与原报文共享内存
+	payload := data[9:]
+	if info := DescribeSegments([][]byte{data[:9], payload}); &info.Payload[0] != &payload[0] {
+		t.Fatal("payload in its own segment was copied")
+	}
+	if info := DescribeSegments([][]byte{data[:9], payload[:2]}); !info.Malformed {
+		t.Fatalf("truncated segments not reported as malformed: %+v", info)
+	}
+}
+
+func TestPacketDescribe(t *testing.T) {
+	data := fullPacket(0x32, mqttString("a/b"), []byte{0x00, 0x07}, []byte("hello"))
+	packet, err := NewPacketReader(bytes.NewReader(data)).ReadPacket()
+	if err != nil {
+		t.Fatalf("ReadPacket: %v", err)
+	}
+	defer packet.Release()
+	info := packet.Describe()
+	if info.Type != PUBLISH || info.Size != len(data) || info.PacketID != 7 || info.Topic != "a/b" || string(info.Payload) != "hello" {
+		t.Fatalf("Describe() = %+v", info)
+	}
+}
+
+func TestPreviewPayload(t *testing.T) {
+	tests := []struct {
+		payload []byte
+		want    string
+	}{
+		{[]byte(`{"state":"on"}`), `{"state":"on"}`},
+		{[]byte("line 1\nline 2"), "line 1\nline 2"},
+		{[]byte{0x00, 0x01, 0xFF}, "0x0001ff"},
+		{[]byte(strings.Repeat("a", 70)), strings.Repeat("a", 64) + "..."},
+		// 截断位置落在多字节字符的中间
+		{[]byte("a" + strings.Repeat("温", 30)), "a" + strings.Repeat("温", 21) + "..."},
+		{bytes.Repeat([]byte{0xAB}, 70), "0x" + strings.Repeat("ab", 64) + "..."},
+		{nil, ""},
+	}
+	for _, tt := range tests {
+		if got := PreviewPayload(tt.payload); got != tt.want {
+			t.Errorf("PreviewPayload(%q) = %q, want %q", tt.payload, got, tt.want)
+		}
+	}
+}
@@ -81,4 +81,16 @@ type Payload struct {
 type Packet struct {
 	Header  *FixedHeader // 固定头部
 	Payload *Payload     // 可变头部和有效载荷
+	buffer  *[]byte      // 负载所在的池化缓冲区
+}
+
+// Size 返回报文在网络上的总字节数，包括固定头部
+func (p *Packet) Size() int {
+	size := 1 + p.Header.RemainingLength
+	for x := p.Header.RemainingLength; ; x /= 128 {
+		size++
+		if x < 128 {
+			return size
+		}
+	}
 }
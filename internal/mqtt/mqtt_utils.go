@@ -5,7 +5,6 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"net"
 )
 
 func UInt16ToByte(number uint16) []byte {
@@ -14,20 +13,33 @@ func UInt16ToByte(number uint16) []byte {
 	return result
 }
 
+// ReadBytes 读取l个字节并返回第一个字节
 func ReadBytes(r io.Reader, l int) (byte, error) {
+	if l == 1 {
+		return ReadByte(r)
+	}
 	bytes := make([]byte, l)
-	_, err := r.Read(bytes)
-	if err != nil {
+	if _, err := io.ReadFull(r, bytes); err != nil {
 		return 0, err
 	}
 	return bytes[0], nil
 }
 
+// ReadByte 读取一个字节，r实现了io.ByteReader时不会产生内存分配
 func ReadByte(r io.Reader) (byte, error) {
-	return ReadBytes(r, 1)
+	if br, ok := r.(io.ByteReader); ok {
+		return br.ReadByte()
+	}
+	var buf [1]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return 0, err
+	}
+	return buf[0], nil
 }
 
-func ReadPacket(conn net.Conn) (*Packet, error) {
+// ReadPacket 以无缓冲的方式从r中读取一个完整报文，每个报文都会分配新的负载内存
+// 连接处理器应使用 PacketReader
+func ReadPacket(conn io.Reader) (*Packet, error) {
 	// 读取固定头
 	typeAndFlags := make([]byte, 1)
 	if _, err := io.ReadFull(conn, typeAndFlags); err != nil {
@@ -0,0 +1,137 @@
+package mqtt
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math/bits"
+	"sync"
+)
+
+const (
+	readerBufferSize = 4096 // 每个连接读缓冲区大小
+	minPooledSize    = 64   // 最小的池化负载大小
+	maxPooledSize    = 1 << 16
+)
+
+// payloadPools 按2的幂划分的负载缓冲区池，第i个池中缓冲区容量为 minPooledSize<<i
+var payloadPools = func() []*sync.Pool {
+	var pools []*sync.Pool
+	for size := minPooledSize; size <= maxPooledSize; size <<= 1 {
+		capacity := size
+		pools = append(pools, &sync.Pool{
+			New: func() any {
+				buf := make([]byte, capacity)
+				return &buf
+			},
+		})
+	}
+	return pools
+}()
+
+// poolIndex 返回能容纳size字节的最小缓冲区池下标，超出池化范围时返回-1
+func poolIndex(size int) int {
+	if size > maxPooledSize {
+		return -1
+	}
+	if size <= minPooledSize {
+		return 0
+	}
+	return bits.Len(uint(size-1)) - bits.Len(uint(minPooledSize-1))
+}
+
+// getPayloadBuffer 获取长度为size的负载缓冲区
+func getPayloadBuffer(size int) *[]byte {
+	index := poolIndex(size)
+	if index < 0 {
+		buf := make([]byte, size)
+		return &buf
+	}
+	buf := payloadPools[index].Get().(*[]byte)
+	*buf = (*buf)[:size]
+	return buf
+}
+
+// putPayloadBuffer 归还负载缓冲区
+func putPayloadBuffer(buf *[]byte) {
+	index := poolIndex(cap(*buf))
+	// 只归还容量恰好等于池中缓冲区容量的内存
+	if index < 0 || minPooledSize<<index != cap(*buf) {
+		return
+	}
+	*buf = (*buf)[:cap(*buf)]
+	payloadPools[index].Put(buf)
+}
+
+// packetBlock 将报文的各个部分放在一起分配
+type packetBlock struct {
+	packet  Packet
+	header  FixedHeader
+	payload Payload
+}
+
+// PacketReader 带缓冲的报文读取器，每个连接持有一个
+// 报文负载来自缓冲区池，处理完成后需调用 Packet.Release 归还
+type PacketReader struct {
+	reader *bufio.Reader
+}
+
+// NewPacketReader 创建新的报文读取器
+func NewPacketReader(r io.Reader) *PacketReader {
+	return &PacketReader{reader: bufio.NewReaderSize(r, readerBufferSize)}
+}
+
+// ReadPacket 读取一个完整报文
+// 返回的报文负载在调用 Packet.Release 之后不能再被访问，需要保留的字段必须先拷贝
+func (pr *PacketReader) ReadPacket() (*Packet, error) {
+	// 读取固定头
+	typeAndFlags, err := pr.reader.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	// 解析剩余长度
+	remaining, err := DecodeRemainingLength(pr.reader)
+	if err != nil {
+		return nil, err
+	}
+
+	// 报文、固定头与负载描述在同一次内存分配中创建
+	block := &packetBlock{
+		header: FixedHeader{
+			Type:            PacketType(typeAndFlags >> 4),
+			Flags:           typeAndFlags & 0x0F,
+			RemainingLength: remaining,
+		},
+		payload: Payload{ContextLen: remaining},
+	}
+	if !ValidateFlags(block.header.Type, block.header.Flags) {
+		return nil, fmt.Errorf("flags %d of %s packet is not valid", block.header.Flags, block.header.Type.String())
+	}
+	packet := &block.packet
+	packet.Header = &block.header
+	packet.Payload = &block.payload
+	if remaining == 0 {
+		return packet, nil
+	}
+
+	// 读取可变头+有效载荷
+	buf := getPayloadBuffer(remaining)
+	if _, err := io.ReadFull(pr.reader, *buf); err != nil {
+		putPayloadBuffer(buf)
+		return nil, err
+	}
+	packet.Payload.Context = *buf
+	packet.buffer = buf
+	return packet, nil
+}
+
+// Release 归还报文负载使用的缓冲区，之后不能再访问报文负载
+func (p *Packet) Release() {
+	if p == nil || p.buffer == nil {
+		return
+	}
+	putPayloadBuffer(p.buffer)
+	p.buffer = nil
+	p.Payload.Context = nil
+}
@@ -0,0 +1,139 @@
+package mqtt
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+)
+
+// connReader 只实现了io.Reader，模拟没有缓冲的网络连接
+type connReader struct {
+	reader io.Reader
+}
+
+func (r *connReader) Read(p []byte) (int, error) {
+	return r.reader.Read(p)
+}
+
+// loopReader 循环读取同一段数据，模拟持续不断的报文流
+type loopReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *loopReader) Read(p []byte) (int, error) {
+	n := copy(p, r.data[r.pos:])
+	r.pos = (r.pos + n) % len(r.data)
+	return n, nil
+}
+
+// newPublishStream 生成包含count个负载为size字节的PUBLISH报文的数据流
+func newPublishStream(count int, size int) []byte {
+	topic := []byte("devices/0b6f0c1e/telemetry")
+	var stream []byte
+	for i := 0; i < count; i++ {
+		body := append(UInt16ToByte(uint16(len(topic))), topic...)
+		body = append(body, bytes.Repeat([]byte{byte(i)}, size)...)
+		stream = append(stream, byte(PUBLISH)<<4)
+		stream = append(stream, EncodeRemainingLength(len(body))...)
+		stream = append(stream, body...)
+	}
+	return stream
+}
+
+func TestPacketReader(t *testing.T) {
+	stream := newPublishStream(3, 200)
+	stream = append(stream, 0xC0, 0x00) // PINGREQ
+	reader := NewPacketReader(&connReader{reader: bytes.NewReader(stream)})
+
+	for i := 0; i < 3; i++ {
+		packet, err := reader.ReadPacket()
+		if err != nil {
+			t.Fatalf("ReadPacket() error: %v", err)
+		}
+		if packet.Header.Type != PUBLISH || packet.Payload.ContextLen != 228 || len(packet.Payload.Context) != 228 {
+			t.Fatalf("unexpected packet %+v", packet.Header)
+		}
+		if packet.Payload.Context[227] != byte(i) {
+			t.Errorf("packet %d payload got %d", i, packet.Payload.Context[227])
+		}
+		packet.Release()
+		if packet.Payload.Context != nil {
+			t.Error("payload should not be accessible after Release()")
+		}
+	}
+
+	packet, err := reader.ReadPacket()
+	if err != nil {
+		t.Fatalf("ReadPacket() error: %v", err)
+	}
+	if packet.Header.Type != PINGREQ || packet.Payload.ContextLen != 0 {
+		t.Errorf("unexpected packet %+v", packet.Header)
+	}
+	packet.Release()
+
+	if _, err := reader.ReadPacket(); err != io.EOF {
+		t.Errorf("ReadPacket() got %v, want EOF", err)
+	}
+}
+
+func TestPoolIndex(t *testing.T) {
+	tests := []struct {
+		size   int
+		expect int
+	}{
+		{1, 0},
+		{64, 0},
+		{65, 1},
+		{128, 1},
+		{129, 2},
+		{1 << 16, 10},
+		{1<<16 + 1, -1},
+	}
+	for _, tt := range tests {
+		if index := poolIndex(tt.size); index != tt.expect {
+			t.Errorf("poolIndex(%d) got %d, want %d", tt.size, index, tt.expect)
+		}
+		if tt.expect >= 0 && minPooledSize<<tt.expect < tt.size {
+			t.Errorf("pool %d can not hold %d bytes", tt.expect, tt.size)
+		}
+	}
+}
+
+func benchmarkPayloadSizes(b *testing.B, read func(b *testing.B, stream []byte)) {
+	for _, size := range []int{16, 256, 4096} {
+		stream := newPublishStream(64, size)
+		b.Run(fmt.Sprintf("payload_%dB", size), func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(stream) / 64))
+			read(b, stream)
+		})
+	}
+}
+
+// BenchmarkReadPacketUnbuffered 无缓冲读取，每个报文分配新的负载
+func BenchmarkReadPacketUnbuffered(b *testing.B) {
+	benchmarkPayloadSizes(b, func(b *testing.B, stream []byte) {
+		reader := &connReader{reader: &loopReader{data: stream}}
+		for i := 0; i < b.N; i++ {
+			if _, err := ReadPacket(reader); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// BenchmarkPacketReader 带缓冲读取，负载来自缓冲区池
+func BenchmarkPacketReader(b *testing.B) {
+	benchmarkPayloadSizes(b, func(b *testing.B, stream []byte) {
+		reader := NewPacketReader(&connReader{reader: &loopReader{data: stream}})
+		for i := 0; i < b.N; i++ {
+			packet, err := reader.ReadPacket()
+			if err != nil {
+				b.Fatal(err)
+			}
+			packet.Release()
+		}
+	})
+}
@@ -0,0 +1,38 @@
+package packet
+
+import (
+	"path"
+	"strings"
+
+	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/config"
+)
+
+// SysTopicPrefix 服务器统计主题的第一层
+const SysTopicPrefix = "$SYS"
+
+// IsSysTopic 返回主题或过滤器是否属于 $SYS 主题
+func IsSysTopic(topic string) bool {
+	return topic == SysTopicPrefix || strings.HasPrefix(topic, SysTopicPrefix+"/")
+}
+
+// canPublish 返回客户端是否可以向主题发布消息，$SYS 主题只能由服务器发布
+func canPublish(clientID string, topicName string) bool {
+	return !IsSysTopic(topicName)
+}
+
+// canSubscribe 返回客户端是否可以订阅过滤器，$SYS 主题只允许 sys.allowed_clients 中的客户端订阅
+func canSubscribe(clientID string, filter string) bool {
+	if !IsSysTopic(filter) {
+		return true
+	}
+	conf, err := config.GetConfig()
+	if err != nil {
+		return false
+	}
+	for _, pattern := range conf.Sys.AllowedClients {
+		if matched, _ := path.Match(pattern, clientID); matched {
+			return true
+		}
+	}
+	return false
+}
@@ -22,6 +22,24 @@ const (
 	NotAuthorized
 )
 
+// connectRespNames 连接返回码的字符串表示
+var connectRespNames = map[ConnectRespType]string{
+	Accepted:             "accepted",
+	UnacceptableProtocol: "unacceptable_protocol",
+	IdentifierRejected:   "identifier_rejected",
+	ServerUnavailable:    "server_unavailable",
+	AuthenticationFailed: "bad_username_or_password",
+	NotAuthorized:        "not_authorized",
+}
+
+// String 返回连接返回码的字符串表示
+func (t ConnectRespType) String() string {
+	if name, ok := connectRespNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown_%d", byte(t))
+}
+
 // ConnectPacketFlag CONNECT控制包连接标志位
 type ConnectPacketFlag struct {
 	UsernameFlag    bool
@@ -162,7 +180,12 @@ func HandlerConnectPacket(payloads *ConnectPacketPayloads) ([]byte, *database.Se
 		databaseStore.DeleteSession(clientId)
 		session = nil
 	} else {
-		session = databaseStore.GetSession(clientId)
+		// 会话加载失败时拒绝连接，不能用空会话覆盖存储中的持久会话
+		var err error
+		session, err = databaseStore.GetSession(clientId)
+		if err != nil {
+			return NewConnectAckPacket(false, ServerUnavailable), nil, fmt.Errorf("unable to load session: %w", err)
+		}
 	}
 	if session == nil {
 		session = database.NewSessionData(clientId)
@@ -170,9 +193,9 @@ func HandlerConnectPacket(payloads *ConnectPacketPayloads) ([]byte, *database.Se
 		if !databaseStore.SaveSession(session) {
 			return NewConnectAckPacket(false, ServerUnavailable), nil, fmt.Errorf("unable to save session")
 		}
-		logger.InfoF("[%s] Session has been created", session.ClientID)
+		logger.Info("Session has been created", logger.KeyClientID, session.ClientID)
 	} else {
-		logger.InfoF("[%s] Session has been found in database", session.ClientID)
+		logger.Info("Session has been found in database", logger.KeyClientID, session.ClientID)
 	}
 	return NewConnectAckPacket(true, Accepted), session, nil
 }
@@ -0,0 +1,93 @@
+package packet
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	c "github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/config"
+	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/database"
+)
+
+// flakyBackend 可以模拟会话读取失败的内存后端
+type flakyBackend struct {
+	*database.MemoryBackend
+	down atomic.Bool
+}
+
+func (b *flakyBackend) LoadSession(ctx context.Context, clientID string) (*database.SessionData, error) {
+	if b.down.Load() {
+		return nil, errors.New("connection refused")
+	}
+	return b.MemoryBackend.LoadSession(ctx, clientID)
+}
+
+func (b *flakyBackend) Ping(ctx context.Context) error {
+	if b.down.Load() {
+		return errors.New("connection refused")
+	}
+	return b.MemoryBackend.Ping(ctx)
+}
+
+// connectPayloads 构造不清除会话的CONNECT报文
+func connectPayloads(clientID string) *ConnectPacketPayloads {
+	return &ConnectPacketPayloads{
+		ClientIdentifier: FieldPayload{PayloadLength: len(clientID), Payload: []byte(clientID)},
+	}
+}
+
+func TestConnectDuringOutageKeepsPersistentSession(t *testing.T) {
+	ctx := context.Background()
+	backend := &flakyBackend{MemoryBackend: database.NewMemoryBackend()}
+	persisted := database.NewSessionData("device")
+	persisted.Subscriptions["sensor/#"] = 0
+	if err := backend.CommitSession(ctx, &database.SessionCommit{
+		ClientID: "device",
+		Session:  persisted,
+		Changes:  []database.SubscriptionChange{{Subscription: database.Subscription{ClientID: "device", TopicName: "sensor/#"}}},
+	}); err != nil {
+		t.Fatalf("CommitSession: %v", err)
+	}
+
+	config := c.Default()
+	config.Storage.BreakerThreshold = 1
+	config.Storage.BreakerCooldown = "10ms"
+	if err := database.UseBackend(backend, config); err != nil {
+		t.Fatalf("UseBackend: %v", err)
+	}
+
+	// 存储不可用时无法加载不在缓存中的持久会话，必须拒绝连接而不是创建空会话
+	backend.down.Store(true)
+	resp, session, err := HandlerConnectPacket(connectPayloads("device"))
+	if err == nil || session != nil {
+		t.Fatalf("HandlerConnectPacket() = %v, %v while storage is down, want an error", session, err)
+	}
+	if resp[3] != byte(ServerUnavailable) {
+		t.Fatalf("CONNACK return code = %d, want %d", resp[3], ServerUnavailable)
+	}
+
+	// 恢复后重放缓冲区也不能覆盖原有会话
+	backend.down.Store(false)
+	deadline := time.Now().Add(5 * time.Second)
+	for database.NewDatabaseStore().Health().Degraded {
+		if time.Now().After(deadline) {
+			t.Fatal("storage did not recover")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	_, session, err = HandlerConnectPacket(connectPayloads("device"))
+	if err != nil {
+		t.Fatalf("HandlerConnectPacket after recovery: %v", err)
+	}
+	if session.SubscriptionCount() != 1 {
+		t.Fatalf("session has %d subscriptions after recovery, want 1", session.SubscriptionCount())
+	}
+	if matched, _ := database.NewDatabaseStore().MatchTopic("sensor/1"); len(matched) != 1 {
+		t.Fatalf("MatchTopic returned %d subscriptions after recovery, want 1", len(matched))
+	}
+	if loaded, err := backend.LoadSession(ctx, "device"); err != nil || loaded.Subscriptions["sensor/#"] != 0 || len(loaded.Subscriptions) != 1 {
+		t.Fatalf("persisted session = %+v, %v", loaded, err)
+	}
+}
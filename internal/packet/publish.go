@@ -1,14 +1,23 @@
 package packet
 
 import (
+	"bytes"
+	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	. "github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/connection"
 	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/database"
 	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/logger"
+	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/metrics"
 	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/mqtt"
+	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/tracing"
+	"net"
 )
 
+// errPublishDenied 客户端没有发布权限
+var errPublishDenied = errors.New("not allowed to publish to this topic")
+
 type PublishPacketFlag struct {
 	RetryFlag bool
 	QoS       byte
@@ -42,6 +51,49 @@ func NewPublishPacket(packetPayloads *PublishPacketPayloads) []byte {
 	return packet
 }
 
+// PublishEncoder 将同一条消息编码为各QoS等级的PUBLISH报文
+// 固定头、主题与负载只编码一次，并在所有订阅者之间共享，每个订阅者只需单独生成报文ID
+type PublishEncoder struct {
+	topic   []byte
+	payload []byte
+	headers [3][]byte // 各QoS等级的固定头、剩余长度与主题
+}
+
+// NewPublishEncoder 创建新的PUBLISH报文编码器
+// 主题与负载会被拷贝一次，调用方之后可以复用原缓冲区
+func NewPublishEncoder(topic []byte, payload []byte) *PublishEncoder {
+	return &PublishEncoder{
+		topic:   bytes.Clone(topic),
+		payload: bytes.Clone(payload),
+	}
+}
+
+// header 返回QoS等级为qos的报文头，首次使用时编码
+func (e *PublishEncoder) header(qos byte) []byte {
+	if e.headers[qos] != nil {
+		return e.headers[qos]
+	}
+	remainLength := 2 + len(e.topic) + len(e.payload)
+	if qos > 0 {
+		remainLength += 2
+	}
+	header := make([]byte, 0, 1+4+2+len(e.topic))
+	header = append(header, byte(mqtt.PUBLISH)<<4|qos<<1)
+	header = append(header, mqtt.EncodeRemainingLength(remainLength)...)
+	header = append(header, mqtt.UInt16ToByte(uint16(len(e.topic)))...)
+	header = append(header, e.topic...)
+	e.headers[qos] = header
+	return header
+}
+
+// Encode 返回QoS等级为qos、报文ID为packetID的PUBLISH报文分段
+func (e *PublishEncoder) Encode(qos byte, packetID uint16) net.Buffers {
+	if qos == 0 {
+		return net.Buffers{e.header(0), e.payload}
+	}
+	return net.Buffers{e.header(qos), mqtt.UInt16ToByte(packetID), e.payload}
+}
+
 func ParsePublishPacket(packet *mqtt.Packet) (*PublishPacketPayloads, error) {
 	result := &PublishPacketPayloads{
 		PacketFlag: PublishPacketFlag{
@@ -65,6 +117,9 @@ func ParsePublishPacket(packet *mqtt.Packet) (*PublishPacketPayloads, error) {
 		return result, fmt.Errorf("error occured when reading topic name, %v", err)
 	}
 	result.TopicName = topicName
+	if err := database.ValidateTopicName(string(topicName.Payload)); err != nil {
+		return result, err
+	}
 
 	if result.PacketFlag.QoS > 0 {
 		packetId, err := readPacketBytes(packet.Payload, 2)
@@ -99,21 +154,44 @@ func HandlePublishPacket(payload *PublishPacketPayloads, session *database.Sessi
 	// 获取主题名称
 	topicName := string(payload.TopicName.Payload)
 
+	ctx, span := tracing.Start(context.Background(), "mqtt.publish",
+		tracing.AttrClientID.String(session.ClientID), tracing.AttrTopic.String(topicName),
+		tracing.AttrQoS.Int(int(payload.PacketFlag.QoS)), tracing.AttrPacketID.Int(payload.PacketID))
+	defer span.End()
+
+	// 没有发布权限的消息直接丢弃，QoS 1/2消息仍然确认，避免客户端重发
+	if !canPublish(session.ClientID, topicName) {
+		logger.Warn("Client is not allowed to publish to topic, message dropped", logger.KeyClientID, session.ClientID, logger.KeyTopic, topicName)
+		span.RecordError(errPublishDenied)
+		switch payload.PacketFlag.QoS {
+		case 1:
+			return NewPubAckPacket(payload.PacketID)
+		case 2:
+			return NewPubRecPacket(payload.PacketID)
+		}
+		return nil
+	}
+
 	// 根据QoS级别处理消息
 	switch payload.PacketFlag.QoS {
 	case 0:
 		// QoS 0: 最多一次，不需要确认
-		handleQoS0Publish(dbStore, topicName, payload)
+		fanOutPublish(ctx, dbStore, topicName, payload)
 		return nil
 
 	case 1:
-		// QoS 1: 至少一次，需要PUBACK确认
-		handleQoS1Publish(dbStore, topicName, payload, session)
+		// QoS 1: 至少一次，需要PUBACK确认，PUBACK在分发后立即回复，不需要保存到待确认队列
+		fanOutPublish(ctx, dbStore, topicName, payload)
 		return NewPubAckPacket(payload.PacketID)
 
 	case 2:
-		// QoS 2: 确保一次，需要完整的确认流程
-		handleQoS2Publish(dbStore, topicName, payload, session)
+		// QoS 2: 确保一次，需要完整的确认流程，收到PUBREL后才从待确认队列中移除
+		// 收到PUBREL之前重发的同一报文ID的消息已经分发过，只重新回复PUBREC
+		if session.HasPendingPublish(uint16(payload.PacketID)) {
+			return NewPubRecPacket(payload.PacketID)
+		}
+		savePendingPublish(ctx, topicName, payload, session)
+		fanOutPublish(ctx, dbStore, topicName, payload)
 		return NewPubRecPacket(payload.PacketID)
 
 	default:
@@ -122,123 +200,113 @@ func HandlePublishPacket(payload *PublishPacketPayloads, session *database.Sessi
 	}
 }
 
-// handleQoS0Publish 处理QoS 0的发布消息
-func handleQoS0Publish(dbStore *database.DBStore, topicName string, payload *PublishPacketPayloads) {
-	// 查找匹配的订阅者
-	subscriptions, err := dbStore.MatchTopic(topicName)
-	if err != nil {
-		logger.ErrorF("Failed to match topic %s: %v", topicName, err)
-		return
-	}
-
-	// 创建消息发送器
-	sender := NewMessageSender()
-
-	// 向所有订阅者发送消息
-	for _, sub := range subscriptions {
-		// 创建新的发布消息
-		publishPacket := &PublishPacketPayloads{
-			PacketFlag: PublishPacketFlag{
-				QoS: sub.QoSLevel,
-			},
-			TopicName: FieldPayload{
-				PayloadLength: len(topicName),
-				Payload:       []byte(topicName),
-			},
-			Payload: payload.Payload,
-		}
-
-		// 发送消息给订阅者
-		if err := sender.SendMessage(sub.ClientID, NewPublishPacket(publishPacket)); err != nil {
-			logger.ErrorF("Failed to send message to client %s: %v", sub.ClientID, err)
-		}
-	}
+// savePendingPublish 保存QoS 2消息到待确认队列，只写入新增的字段而不替换整个会话
+// sync持久化模式下Span包含写入存储的耗时，其余模式只包含合并变更的耗时
+func savePendingPublish(ctx context.Context, topicName string, payload *PublishPacketPayloads, session *database.SessionData) {
+	_, span := tracing.Start(ctx, "storage.add_pending_publish", tracing.AttrClientID.String(session.ClientID))
+	defer span.End()
+	session.AddPendingPublish(uint16(payload.PacketID), topicName)
 }
 
-// handleQoS1Publish 处理QoS 1的发布消息
-func handleQoS1Publish(dbStore *database.DBStore, topicName string, payload *PublishPacketPayloads, session *database.SessionData) {
-	// 保存消息到待确认队列
-	session.PendingPublish[uint16(payload.PacketID)] = topicName
-	session.Save()
+// releasePendingPublish 从待确认队列中移除收到PUBREL的QoS 2消息
+func releasePendingPublish(ctx context.Context, packetID uint16, session *database.SessionData) {
+	_, span := tracing.Start(ctx, "storage.remove_pending_publish", tracing.AttrClientID.String(session.ClientID))
+	defer span.End()
+	session.RemovePendingPublish(packetID)
+}
 
-	// 查找匹配的订阅者
-	subscriptions, err := dbStore.MatchTopic(topicName)
+// ParseAckPacket 解析PUBACK、PUBREC、PUBREL、PUBCOMP报文，返回报文ID
+func ParseAckPacket(packet *mqtt.Packet) (uint16, error) {
+	packetID, err := readPacketBytes(packet.Payload, 2)
 	if err != nil {
-		logger.ErrorF("Failed to match topic %s: %v", topicName, err)
-		return
+		return 0, fmt.Errorf("error occured when reading packet ID, details: %v", err)
 	}
+	return binary.BigEndian.Uint16(packetID), nil
+}
 
-	// 创建消息发送器
-	sender := NewMessageSender()
+// HandlePubRelPacket 处理QoS 2消息的PUBREL报文，从待确认队列中移除消息并返回PUBCOMP
+func HandlePubRelPacket(packetID uint16, session *database.SessionData) []byte {
+	releasePendingPublish(context.Background(), packetID, session)
+	return NewPubCompPacket(int(packetID))
+}
 
-	// 向所有订阅者发送消息
-	for _, sub := range subscriptions {
-		// 创建新的发布消息
-		publishPacket := &PublishPacketPayloads{
-			PacketFlag: PublishPacketFlag{
-				QoS: sub.QoSLevel,
-			},
-			TopicName: FieldPayload{
-				PayloadLength: len(topicName),
-				Payload:       []byte(topicName),
-			},
-			Payload: payload.Payload,
-		}
+// HandlePubAckPacket 处理订阅者对QoS 1消息的PUBACK报文，释放投递时分配的报文ID
+func HandlePubAckPacket(packetID uint16) {
+	database.NewPacketIDManager().ReleaseID(packetID)
+}
 
-		// 为QoS 1/2消息分配新的PacketID
-		if sub.QoSLevel > 0 {
-			publishPacket.PacketID = int(database.NewPacketIDManager().NextID())
-		}
+// HandlePubRecPacket 处理订阅者对QoS 2消息的PUBREC报文，返回PUBREL
+func HandlePubRecPacket(packetID uint16) []byte {
+	return NewPubRelPacket(int(packetID))
+}
 
-		// 发送消息给订阅者
-		if err := sender.SendMessage(sub.ClientID, NewPublishPacket(publishPacket)); err != nil {
-			logger.ErrorF("Failed to send message to client %s: %v", sub.ClientID, err)
-		}
-	}
+// HandlePubCompPacket 处理订阅者对QoS 2消息的PUBCOMP报文，释放投递时分配的报文ID
+func HandlePubCompPacket(packetID uint16) {
+	database.NewPacketIDManager().ReleaseID(packetID)
 }
 
-// handleQoS2Publish 处理QoS 2的发布消息
-func handleQoS2Publish(dbStore *database.DBStore, topicName string, payload *PublishPacketPayloads, session *database.SessionData) {
-	// 保存消息到待确认队列
-	session.PendingPublish[uint16(payload.PacketID)] = topicName
-	session.Save()
+// PublishServerMessage 将服务器产生的QoS 0消息分发给所有匹配的订阅者，用于 $SYS 等服务器主题
+func PublishServerMessage(topicName string, content []byte) {
+	payload := &PublishPacketPayloads{
+		TopicName: FieldPayload{PayloadLength: len(topicName), Payload: []byte(topicName)},
+		Payload:   content,
+	}
+	fanOutPublish(context.Background(), database.NewDatabaseStore(), topicName, payload)
+}
 
+// fanOutPublish 将消息分发给所有匹配的订阅者
+// 每个QoS等级的报文只编码一次，各订阅者之间只有报文ID不同
+func fanOutPublish(ctx context.Context, dbStore *database.DBStore, topicName string, payload *PublishPacketPayloads) {
 	// 查找匹配的订阅者
+	_, span := tracing.Start(ctx, "mqtt.match_topic", tracing.AttrTopic.String(topicName))
 	subscriptions, err := dbStore.MatchTopic(topicName)
+	span.SetAttributes(tracing.AttrFanOut.Int(len(subscriptions)))
+	tracing.End(span, err)
 	if err != nil {
-		logger.ErrorF("Failed to match topic %s: %v", topicName, err)
+		logger.Error("Failed to match topic", logger.KeyTopic, topicName, "error", err)
+		return
+	}
+	metrics.FanOut.Observe(float64(len(subscriptions)))
+	if len(subscriptions) == 0 {
 		return
 	}
 
-	// 创建消息发送器
+	// 创建消息发送器与编码器
 	sender := NewMessageSender()
+	encoder := NewPublishEncoder(payload.TopicName.Payload, payload.Payload)
 
 	// 向所有订阅者发送消息
 	for _, sub := range subscriptions {
-		// 创建新的发布消息
-		publishPacket := &PublishPacketPayloads{
-			PacketFlag: PublishPacketFlag{
-				QoS: sub.QoSLevel,
-			},
-			TopicName: FieldPayload{
-				PayloadLength: len(topicName),
-				Payload:       []byte(topicName),
-			},
-			Payload: payload.Payload,
-		}
+		// 实际投递的QoS等级取发布与订阅中较小的一个
+		qos := min(sub.QoSLevel, payload.PacketFlag.QoS)
 
 		// 为QoS 1/2消息分配新的PacketID
-		if sub.QoSLevel > 0 {
-			publishPacket.PacketID = int(database.NewPacketIDManager().NextID())
+		var packetID uint16
+		if qos > 0 {
+			packetID = database.NewPacketIDManager().NextID()
 		}
 
 		// 发送消息给订阅者
-		if err := sender.SendMessage(sub.ClientID, NewPublishPacket(publishPacket)); err != nil {
-			logger.ErrorF("Failed to send message to client %s: %v", sub.ClientID, err)
+		if err := Deliver(ctx, sender, sub.ClientID, encoder.Encode(qos, packetID), qos, packetID); err != nil {
+			logger.Error("Failed to send message to client", logger.KeyClientID, sub.ClientID, logger.KeyTopic, topicName, "error", err)
 		}
 	}
 }
 
+// Deliver 向客户端投递一条PUBLISH报文，报文进入客户端的出站队列即视为投递完成
+// 客户端不在线时消息被直接丢弃，Span上的 mqtt.client_online 属性记录了这种情况
+func Deliver(ctx context.Context, sender MessageSender, clientID string, data net.Buffers, qos byte, packetID uint16) error {
+	_, span := tracing.Start(ctx, "mqtt.deliver", tracing.AttrClientID.String(clientID),
+		tracing.AttrQoS.Int(int(qos)), tracing.AttrPacketID.Int(int(packetID)))
+	if span.IsRecording() {
+		_, online := GetConnectionManager().GetConnection(clientID)
+		span.SetAttributes(tracing.AttrClientOnline.Bool(online))
+	}
+	err := sender.SendMessage(clientID, data, qos)
+	tracing.End(span, err)
+	return err
+}
+
 // NewPubAckPacket 创建PUBACK响应包
 func NewPubAckPacket(packetID int) []byte {
 	packet := make([]byte, 1)
@@ -264,3 +332,29 @@ func NewPubRecPacket(packetID int) []byte {
 	packet = append(packet, payload...)
 	return packet
 }
+
+// NewPubRelPacket 创建PUBREL响应包，PUBREL报文的固定头标志位必须为0010
+func NewPubRelPacket(packetID int) []byte {
+	packet := make([]byte, 1)
+	packet[0] = byte(mqtt.PUBREL)<<4 | 0x02
+
+	payload := make([]byte, 0)
+	payload = append(payload, mqtt.UInt16ToByte(uint16(packetID))...)
+
+	packet = append(packet, mqtt.EncodeRemainingLength(len(payload))...)
+	packet = append(packet, payload...)
+	return packet
+}
+
+// NewPubCompPacket 创建PUBCOMP响应包
+func NewPubCompPacket(packetID int) []byte {
+	packet := make([]byte, 1)
+	packet[0] = byte(mqtt.PUBCOMP) << 4
+
+	payload := make([]byte, 0)
+	payload = append(payload, mqtt.UInt16ToByte(uint16(packetID))...)
+
+	packet = append(packet, mqtt.EncodeRemainingLength(len(payload))...)
+	packet = append(packet, payload...)
+	return packet
+}
@@ -1,8 +1,17 @@
 package packet
 
 import (
+	"bytes"
+	"net"
 	"reflect"
 	"testing"
+
+	c "github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/config"
+	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/database"
+	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/tracing"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 func TestNewPublishPacket(t *testing.T) {
@@ -50,3 +59,118 @@ func TestNewPublishPacket(t *testing.T) {
 		}
 	}
 }
+
+func TestPublishEncoder(t *testing.T) {
+	topic := []byte("sport/tennis/player1")
+	content := []byte(`{"score": 15}`)
+	encoder := NewPublishEncoder(topic, content)
+
+	for qos := byte(0); qos <= 2; qos++ {
+		for _, packetID := range []uint16{1, 458, 65535} {
+			expect := NewPublishPacket(&PublishPacketPayloads{
+				PacketFlag: PublishPacketFlag{QoS: qos},
+				TopicName:  FieldPayload{PayloadLength: len(topic), Payload: topic},
+				PacketID:   int(packetID),
+				Payload:    content,
+			})
+			buffers := encoder.Encode(qos, packetID)
+			if got := bytes.Join(buffers, nil); !bytes.Equal(got, expect) {
+				t.Errorf("Encode(%d, %d) got: %v want: %v", qos, packetID, got, expect)
+			}
+		}
+	}
+
+	// 编码器持有主题与负载的拷贝，原缓冲区可以被复用
+	topic[0] = 'x'
+	content[0] = 'x'
+	if got := bytes.Join(encoder.Encode(0, 0), nil); got[4] != 's' || got[len(got)-len(content)] != '{' {
+		t.Errorf("encoder should not reference caller buffers, got %q", got)
+	}
+}
+
+// benchmarkSubscribers 模拟一个主题上的订阅者数量
+const benchmarkSubscribers = 10000
+
+// BenchmarkFanOutReencode 为每个订阅者重新构造并编码完整报文
+func BenchmarkFanOutReencode(b *testing.B) {
+	topic := []byte("devices/0b6f0c1e/telemetry")
+	content := bytes.Repeat([]byte{0x7b}, 512)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < benchmarkSubscribers; j++ {
+			packet := NewPublishPacket(&PublishPacketPayloads{
+				PacketFlag: PublishPacketFlag{QoS: 1},
+				TopicName:  FieldPayload{PayloadLength: len(topic), Payload: []byte(string(topic))},
+				PacketID:   j,
+				Payload:    content,
+			})
+			_ = net.Buffers{packet}
+		}
+	}
+}
+
+// BenchmarkFanOutEncodeOnce 报文只编码一次，每个订阅者只生成报文ID
+func BenchmarkFanOutEncodeOnce(b *testing.B) {
+	topic := []byte("devices/0b6f0c1e/telemetry")
+	content := bytes.Repeat([]byte{0x7b}, 512)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		encoder := NewPublishEncoder(topic, content)
+		for j := 0; j < benchmarkSubscribers; j++ {
+			_ = encoder.Encode(1, uint16(j))
+		}
+	}
+}
+
+func TestHandlePublishPacketTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	if err := database.UseBackend(database.NewMemoryBackend(), c.Default()); err != nil {
+		t.Fatalf("UseBackend: %v", err)
+	}
+	subscriber := database.NewSessionData("offline-device")
+	subscriber.AddSubscription(&database.Subscription{TopicName: "control/+", QoSLevel: 1})
+	database.NewDatabaseStore().SaveSession(subscriber)
+
+	topic := []byte("control/switch")
+	HandlePublishPacket(&PublishPacketPayloads{
+		PacketFlag: PublishPacketFlag{QoS: 2},
+		TopicName:  FieldPayload{PayloadLength: len(topic), Payload: topic},
+		PacketID:   7,
+		Payload:    []byte("ON"),
+	}, database.NewSessionData("backend"))
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	publish, ok := spans["mqtt.publish"]
+	if !ok {
+		t.Fatalf("mqtt.publish span not recorded, got %v", spans)
+	}
+	if publish.Parent().IsValid() {
+		t.Fatalf("mqtt.publish parent = %s, want a new trace", publish.Parent().SpanID())
+	}
+	for _, name := range []string{"storage.add_pending_publish", "mqtt.match_topic", "mqtt.deliver"} {
+		span, ok := spans[name]
+		if !ok {
+			t.Fatalf("%s span not recorded", name)
+		}
+		if span.Parent().SpanID() != publish.SpanContext().SpanID() {
+			t.Errorf("%s is not a child of mqtt.publish", name)
+		}
+	}
+	// 订阅者不在线，消息被丢弃的情况记录在Span上
+	online := true
+	for _, attr := range spans["mqtt.deliver"].Attributes() {
+		if attr.Key == tracing.AttrClientOnline {
+			online = attr.Value.AsBool()
+		}
+	}
+	if online {
+		t.Error("mqtt.deliver should record the subscriber as offline")
+	}
+}
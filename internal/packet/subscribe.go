@@ -4,6 +4,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/database"
+	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/logger"
 	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/mqtt"
 )
 
@@ -21,13 +22,16 @@ type SubscribePacketPayloads struct {
 	Subscriptions []*database.Subscription
 }
 
-func NewSubAckPacket(packetId int, state SubscribeState) []byte {
+// NewSubAckPacket 创建SUBACK响应包，states按SUBSCRIBE报文中过滤器的顺序给出每个订阅的结果
+func NewSubAckPacket(packetId int, states ...SubscribeState) []byte {
 	packet := make([]byte, 1)
 	packet[0] += byte(mqtt.SUBACK) << 4
 
-	payload := make([]byte, 0)
+	payload := make([]byte, 0, 2+len(states))
 	payload = append(payload, mqtt.UInt16ToByte(uint16(packetId))...)
-	payload = append(payload, byte(state))
+	for _, state := range states {
+		payload = append(payload, byte(state))
+	}
 
 	packet = append(packet, mqtt.EncodeRemainingLength(len(payload))...)
 	packet = append(packet, payload...)
@@ -56,20 +60,38 @@ func ParseSubscribePacket(packet *mqtt.Packet) (*SubscribePacketPayloads, error)
 		if err != nil {
 			return result, fmt.Errorf("error occured when reading qos level, details: %v", err)
 		}
+		if qos > 2 {
+			return result, fmt.Errorf("invalid requested qos level %d", qos)
+		}
 		subscript.TopicName = string(topicFilter.Payload)
-		subscript.QoSLevel = qos & 0x02
+		subscript.QoSLevel = qos
 		result.Subscriptions = append(result.Subscriptions, subscript)
 	}
 
 	return result, nil
 }
 
+// HandleSubscribePacket 处理订阅请求，无效或没有权限的过滤器返回Failure，其余返回授予的QoS等级
 func HandleSubscribePacket(payload *SubscribePacketPayloads, session *database.SessionData) []byte {
-	for _, subscription := range payload.Subscriptions {
+	states := make([]SubscribeState, len(payload.Subscriptions))
+	for i, subscription := range payload.Subscriptions {
+		if err := database.ValidateTopicFilter(subscription.TopicName); err != nil {
+			logger.Warn("Client subscribe to invalid topic filter", logger.KeyClientID, session.ClientID, logger.KeyTopic, subscription.TopicName, "error", err)
+			states[i] = Failure
+			continue
+		}
+		if !canSubscribe(session.ClientID, subscription.TopicName) {
+			logger.Warn("Client is not allowed to subscribe to topic", logger.KeyClientID, session.ClientID, logger.KeyTopic, subscription.TopicName)
+			states[i] = Failure
+			continue
+		}
 		session.AddSubscription(subscription)
+		states[i] = SubscribeState(subscription.QoSLevel)
 	}
 	if !session.Save() {
-		return NewSubAckPacket(payload.PacketID, Failure)
+		for i := range states {
+			states[i] = Failure
+		}
 	}
-	return NewSubAckPacket(payload.PacketID, SuccessQos0)
+	return NewSubAckPacket(payload.PacketID, states...)
 }
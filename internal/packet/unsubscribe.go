@@ -54,5 +54,9 @@ func HandleUnSubscribePacket(payload *UnSubscribePacketPayloads, session *databa
 	for _, subscription := range payload.Subscriptions {
 		session.RemoveSubscription(subscription)
 	}
+	// 与会话一起提交订阅树的删除
+	if !session.Save() {
+		return nil, fmt.Errorf("unable to save session")
+	}
 	return NewUnSubAckPacket(payload.PacketID, SuccessQos0), nil
 }
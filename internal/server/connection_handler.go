@@ -5,8 +5,10 @@ import (
 	. "github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/connection"
 	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/database"
 	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/logger"
+	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/metrics"
 	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/mqtt"
 	. "github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/packet"
+	"log/slog"
 	"net"
 	"time"
 )
@@ -14,9 +16,26 @@ import (
 // ConnectionHandler 处理MQTT客户端连接
 type ConnectionHandler struct {
 	conn          net.Conn              // 网络连接
+	connection    *Connection           // 带出站队列的客户端连接
+	reader        *mqtt.PacketReader    // 带缓冲的报文读取器
 	connId        string                // 连接ID
 	keepAlive     time.Duration         // 心跳间隔
 	clientSession *database.SessionData // 客户端会话数据
+	log           *slog.Logger          // 带连接ID字段的Logger，完成CONNECT后增加客户端ID字段
+	sessionClosed bool                  // 会话是否已经随DISCONNECT报文处理完毕
+}
+
+// newConnectionHandler 创建新的连接处理器
+func newConnectionHandler(conn net.Conn) *ConnectionHandler {
+	connId := conn.RemoteAddr().String()
+	return &ConnectionHandler{
+		conn:       conn,
+		connection: NewConnection(conn, connId),
+		reader:     mqtt.NewPacketReader(conn),
+		connId:     connId,
+		keepAlive:  60,
+		log:        logger.With(logger.KeyConnID, connId),
+	}
 }
 
 // handleFirstPacket 处理连接建立后的第一个报文（必须是CONNECT）
@@ -25,15 +44,18 @@ func (c *ConnectionHandler) handleFirstPacket() error {
 
 	// 设置读取超时
 	_ = c.conn.SetReadDeadline(time.Now().Add(time.Minute))
-	packet, err := mqtt.ReadPacket(c.conn)
+	packet, err := c.reader.ReadPacket()
 	if err != nil {
-		logger.WarnF("[%s] Fail to read first packet, details: %v", c.connId, err)
+		c.log.Warn("Fail to read first packet", "error", err)
 		return err
 	}
+	defer packet.Release()
+	RecordReceived(packet.Header.Type, packet.Size())
+	c.connection.TraceReceived(packet)
 
 	// 验证第一个报文必须是CONNECT
 	if packet.Header.Type != mqtt.CONNECT {
-		logger.ErrorF("[%s] Invalid first packet type, expected %s packet, but got %s packet", c.connId, mqtt.CONNECT.String(), packet.Header.Type.String())
+		c.log.Error("Invalid first packet type, expected CONNECT packet", logger.KeyPacketType, packet.Header.Type.String())
 		return err
 	}
 
@@ -42,46 +64,57 @@ func (c *ConnectionHandler) handleFirstPacket() error {
 
 	// 发送响应
 	if resp != nil {
-		if err := Send(c.conn, resp, c.connId); err != nil {
+		if err := c.sendConnAck(resp); err != nil {
 			return err
 		}
 	}
 
 	if err != nil {
-		logger.ErrorF("[%s] Fail to parse CONNECT packet, details: %v", c.connId, err)
+		c.log.Error("Fail to parse CONNECT packet", "error", err)
 		return err
 	}
 
-	logger.InfoF("First packet response %v", resp)
+	c.log.Info("First packet response", "response", resp)
 
 	// 处理CONNECT报文
 	resp, c.clientSession, err = HandlerConnectPacket(clientInfo)
 
 	// 发送响应
-	if err := Send(c.conn, resp, c.connId); err != nil {
+	if err := c.sendConnAck(resp); err != nil {
 		return err
 	}
 
 	if err != nil {
-		logger.ErrorF("[%s] Fail to handle CONNECT packet, details: %v", c.connId, err)
+		c.log.Error("Fail to handle CONNECT packet", "error", err)
 		return err
 	}
 
-	connManager.AddConnection(c.clientSession.ClientID, &Connection{
-		Conn:   c.conn,
-		ConnID: c.connId,
-	})
+	c.connection.SetClientID(c.clientSession.ClientID)
+	c.log = c.log.With(logger.KeyClientID, c.clientSession.ClientID)
+	// 同一客户端ID的旧连接被新连接接管后断开
+	if previous := connManager.AddConnection(c.clientSession.ClientID, c.connection); previous != nil {
+		c.log.Info("Client taken over by new connection", logger.KeyConnID, previous.ConnID)
+		previous.Close()
+	}
 
 	// 设置心跳间隔
 	c.keepAlive = time.Duration(clientInfo.KeepAlive) * time.Second
 	if c.keepAlive == 0 {
-		logger.WarnF("[%s] Keep alive set to 0, heartbeat disable", c.connId)
+		c.log.Warn("Keep alive set to 0, heartbeat disable")
 		_ = c.conn.SetReadDeadline(time.Time{})
 	}
 
 	return nil
 }
 
+// sendConnAck 发送CONNACK报文并按返回码计数
+func (c *ConnectionHandler) sendConnAck(resp []byte) error {
+	if len(resp) == 4 {
+		metrics.ConnAcks.WithLabelValues(ConnectRespType(resp[3]).String()).Inc()
+	}
+	return c.connection.Send(resp)
+}
+
 // handlePacket 处理后续的MQTT报文
 func (c *ConnectionHandler) handlePacket() {
 	for {
@@ -91,82 +124,143 @@ func (c *ConnectionHandler) handlePacket() {
 		}
 
 		// 读取报文
-		packet, err := mqtt.ReadPacket(c.conn)
+		packet, err := c.reader.ReadPacket()
 		if err != nil {
 			HandleReadError(c.connId, err)
 			return
 		}
 
 		_ = c.conn.SetReadDeadline(time.Time{})
+		RecordReceived(packet.Header.Type, packet.Size())
+		c.connection.TraceReceived(packet)
 
-		logger.DebugF("[%s] Receive %s package, data %+v", c.connId, packet.Header.Type, packet.Payload)
-
-		// 根据报文类型处理
-		switch packet.Header.Type {
-		case mqtt.CONNECT:
-			logger.ErrorF("[%s] Duplicate CONNECT package", c.connId)
+		// 根据报文类型处理，处理完成后归还报文缓冲区
+		ok := c.dispatch(packet)
+		packet.Release()
+		if !ok {
 			return
-		case mqtt.PUBLISH:
-			result, err := ParsePublishPacket(packet)
-			if err != nil {
-				logger.ErrorF("[%s] Fail to handle publish packet, details: %v", c.connId, err)
-				return
-			}
-			if result.Payload == nil {
-				logger.WarnF("[%s] Receive a zero length payload packet, ", c.connId)
-				break
-			}
-			HandlePublishPacket(result, c.clientSession)
-		case mqtt.SUBSCRIBE:
-			result, err := ParseSubscribePacket(packet)
-			if err != nil {
-				logger.ErrorF("[%s] Fail to handle subscribe packet, details: %v", c.connId, err)
-				return
-			}
-			resp := HandleSubscribePacket(result, c.clientSession)
-			err = Send(c.conn, resp, c.connId)
-			if err != nil {
-				logger.ErrorF("[%s] Fail to send subscribe ack packet, details: %v", c.connId, err)
-				return
-			}
-		case mqtt.UNSUBSCRIBE:
-			result, err := ParseUnSubscribePacket(packet)
-			if err != nil {
-				logger.ErrorF("[%s] Fail to handle unsubscribe packet, details: %v", c.connId, err)
-				return
-			}
-			resp, err := HandleUnSubscribePacket(result, c.clientSession)
-			if err != nil {
-				logger.ErrorF("[%s] Fail to handle unsubscribe packet, details: %v", c.connId, err)
-				return
-			}
-			err = Send(c.conn, resp, c.connId)
-			if err != nil {
-				logger.ErrorF("[%s] Fail to send unsubscribe ack packet, details: %v", c.connId, err)
-				return
+		}
+	}
+}
+
+// dispatch 根据报文类型处理报文，返回false表示需要断开连接
+func (c *ConnectionHandler) dispatch(packet *mqtt.Packet) bool {
+	// 根据报文类型处理
+	switch packet.Header.Type {
+	case mqtt.CONNECT:
+		c.log.Error("Duplicate CONNECT package")
+		return false
+	case mqtt.PUBLISH:
+		result, err := ParsePublishPacket(packet)
+		if err != nil {
+			c.log.Error("Fail to handle publish packet", "error", err)
+			return false
+		}
+		if result.Payload == nil {
+			c.log.Warn("Receive a zero length payload packet", logger.KeyTopic, string(result.TopicName.Payload))
+			break
+		}
+		// QoS 1/2消息需要回复PUBACK或PUBREC
+		if resp := HandlePublishPacket(result, c.clientSession); resp != nil {
+			if err := c.connection.Send(resp); err != nil {
+				c.log.Error("Fail to send publish ack packet", "error", err)
+				return false
 			}
-		case mqtt.PINGREQ:
-			HandlePingReq(c.conn, c.connId)
-		case mqtt.DISCONNECT:
+		}
+	case mqtt.PUBACK:
+		packetID, err := ParseAckPacket(packet)
+		if err != nil {
+			c.log.Error("Fail to handle publish ack packet", "error", err)
+			return false
+		}
+		HandlePubAckPacket(packetID)
+	case mqtt.PUBREC:
+		packetID, err := ParseAckPacket(packet)
+		if err != nil {
+			c.log.Error("Fail to handle publish received packet", "error", err)
+			return false
+		}
+		if err := c.connection.Send(HandlePubRecPacket(packetID)); err != nil {
+			c.log.Error("Fail to send publish release packet", "error", err)
+			return false
+		}
+	case mqtt.PUBREL:
+		packetID, err := ParseAckPacket(packet)
+		if err != nil {
+			c.log.Error("Fail to handle publish release packet", "error", err)
+			return false
+		}
+		if err := c.connection.Send(HandlePubRelPacket(packetID, c.clientSession)); err != nil {
+			c.log.Error("Fail to send publish complete packet", "error", err)
+			return false
+		}
+	case mqtt.PUBCOMP:
+		packetID, err := ParseAckPacket(packet)
+		if err != nil {
+			c.log.Error("Fail to handle publish complete packet", "error", err)
+			return false
+		}
+		HandlePubCompPacket(packetID)
+	case mqtt.SUBSCRIBE:
+		result, err := ParseSubscribePacket(packet)
+		if err != nil {
+			c.log.Error("Fail to handle subscribe packet", "error", err)
+			return false
+		}
+		resp := HandleSubscribePacket(result, c.clientSession)
+		err = c.connection.Send(resp)
+		if err != nil {
+			c.log.Error("Fail to send subscribe ack packet", "error", err)
+			return false
+		}
+	case mqtt.UNSUBSCRIBE:
+		result, err := ParseUnSubscribePacket(packet)
+		if err != nil {
+			c.log.Error("Fail to handle unsubscribe packet", "error", err)
+			return false
+		}
+		resp, err := HandleUnSubscribePacket(result, c.clientSession)
+		if err != nil {
+			c.log.Error("Fail to handle unsubscribe packet", "error", err)
+			return false
+		}
+		err = c.connection.Send(resp)
+		if err != nil {
+			c.log.Error("Fail to send unsubscribe ack packet", "error", err)
+			return false
+		}
+	case mqtt.PINGREQ:
+		HandlePingReq(c.connection)
+	case mqtt.DISCONNECT:
+		// 连接被同一客户端ID的新连接接管后，会话已属于新连接，不能再保存或清理
+		if GetConnectionManager().RemoveConnection(c.clientSession.ClientID, c.connection) {
 			HandleDisconnectPacket(c.clientSession)
-			logger.InfoF("[%s] Client disconnect", c.connId)
-			return
-		default:
-			logger.WarnF("[%s] %s package has not been supported", c.connId, packet.Header.Type.String())
-			return
 		}
+		c.sessionClosed = true
+		c.log.Info("Client disconnect")
+		return false
+	default:
+		c.log.Warn("Packet type has not been supported", logger.KeyPacketType, packet.Header.Type.String())
+		return false
 	}
+	return true
 }
 
 // handleConnection 处理完整的连接生命周期
 func (c *ConnectionHandler) handleConnection() {
 	// 确保连接最终被关闭
 	defer func() {
-		GetConnectionManager().RemoveConnection(c.clientSession.ClientID)
-		logger.DebugF("[%s] Connection closed", c.connId)
-		if err := c.conn.Close(); err != nil && !IsNetClosedError(err) {
-			logger.WarnF("[%s] Error occured while closing connection, details: %v", c.connId, err)
+		// 连接被同一客户端ID的新连接接管时，会话已属于新连接，不能再保存或清理
+		if c.clientSession != nil && GetConnectionManager().RemoveConnection(c.clientSession.ClientID, c.connection) {
+			// 客户端未发送DISCONNECT报文时，同样需要保存或清理会话
+			if !c.sessionClosed {
+				HandleDisconnectPacket(c.clientSession)
+			}
 		}
+		// 写完出站队列中剩余的报文后关闭连接
+		c.connection.Close()
+		<-c.connection.Done()
+		c.log.Debug("Connection closed")
 	}()
 
 	// 处理第一个报文
@@ -177,3 +271,8 @@ func (c *ConnectionHandler) handleConnection() {
 	// 处理后续报文
 	c.handlePacket()
 }
+
+// close 关闭客户端连接，出站队列写完后网络连接被关闭，连接处理器随之退出读取循环
+func (c *ConnectionHandler) close() {
+	c.connection.Close()
+}
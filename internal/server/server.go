@@ -2,55 +2,217 @@
 package server
 
 import (
+	"context"
+	"errors"
+	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/config"
+	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/event"
 	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/logger"
+	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/metrics"
+	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/utils"
 	"net"
 	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	errAcceptLoopStopped = errors.New("accept loop stopped unexpectedly")
+	errNotListening      = errors.New("listener is not bound")
+	errDraining          = errors.New("server is draining connections")
 )
 
 // sem 用于控制并发连接数的信号量
 var sem = make(chan struct{}, 10000)
 
-// StartServer 启动MQTT服务器
+// Server MQTT服务器
+type Server struct {
+	port      int
+	listener  net.Listener
+	draining  atomic.Bool    // 是否正在关闭
+	accepting atomic.Bool    // 接受循环是否正在运行
+	handlers  sync.WaitGroup // 正在运行的连接处理器
+	active    sync.Map       // 正在运行的连接处理器集合
+	accepted  chan struct{}  // 接受循环退出时关闭
+}
+
+// NewServer 创建新的MQTT服务器
 // port: 服务器监听的端口号
-func StartServer(port int) {
+func NewServer(port int) *Server {
+	return &Server{
+		port:     port,
+		accepted: make(chan struct{}),
+	}
+}
+
+// Start 启动MQTT服务器并阻塞，直到监听器被关闭
+func (s *Server) Start() error {
 	// 创建TCP监听器
-	ln, err := net.Listen("tcp", ":"+strconv.Itoa(port))
+	ln, err := net.Listen("tcp", ":"+strconv.Itoa(s.port))
 	if err != nil {
-		logger.FatalF("MQTT Server Start error: %v", err)
+		return err
 	}
+	s.listener = ln
 	logger.InfoF("MQTT Server Listen On " + ln.Addr().String())
 
-	// 确保在函数退出时关闭监听器
-	defer func() {
-		err := ln.Close()
-		if err != nil {
-			logger.ErrorF("Server close error: %v", err)
-		}
-	}()
+	s.registerShutdown()
+
+	s.accepting.Store(true)
+	defer s.accepting.Store(false)
+	defer close(s.accepted)
+
+	listener := ln.Addr().String()
+	connections := metrics.Connections.WithLabelValues(listener)
+	accepted := metrics.ConnectionsAccepted.WithLabelValues(listener)
 
 	// 循环接受新的连接
 	for {
 		conn, err := ln.Accept()
 		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				logger.Info("MQTT Server stop accepting new connections")
+				return nil
+			}
 			logger.ErrorF("Accept connection error: %v", err)
 			continue
 		}
 
+		if s.draining.Load() {
+			_ = conn.Close()
+			continue
+		}
+
 		logger.DebugF("Accepted new connection from %s", conn.RemoteAddr().String())
+		accepted.Inc()
 
 		// 使用信号量控制并发连接数
 		sem <- struct{}{}
+		s.handlers.Add(1)
 		go func(c net.Conn) {
+			defer s.handlers.Done()
+			connections.Inc()
+			defer connections.Dec()
 			// 创建连接处理器
-			connection := &ConnectionHandler{
-				conn:      conn,
-				connId:    conn.RemoteAddr().String(),
-				keepAlive: 60,
-			}
+			connection := newConnectionHandler(c)
+			s.active.Store(connection, struct{}{})
 			// 处理连接
 			connection.handleConnection()
+			s.active.Delete(connection)
 			// 释放信号量
 			<-sem
 		}(conn)
 	}
 }
+
+// Alive 存活检查，接受循环在关闭之前意外退出时返回错误
+func (s *Server) Alive(context.Context) error {
+	select {
+	case <-s.accepted:
+		if !s.draining.Load() {
+			return errAcceptLoopStopped
+		}
+	default:
+	}
+	return nil
+}
+
+// Ready 就绪检查，监听器已经打开并且没有开始关闭时才能接收连接
+func (s *Server) Ready(context.Context) error {
+	if s.draining.Load() {
+		return errDraining
+	}
+	if !s.accepting.Load() {
+		return errNotListening
+	}
+	return nil
+}
+
+// registerShutdown 注册服务器在各关闭阶段的清理回调
+func (s *Server) registerShutdown() {
+	conf, _ := config.GetConfig()
+	cleaner := event.NewCleaner()
+	cleaner.AddPhase(event.PhaseStopAccept, utils.ParseStringTime(conf.Shutdown.StopAcceptTimeout), &stopAcceptCallback{server: s})
+	cleaner.AddPhase(event.PhaseDrainConnections, utils.ParseStringTime(conf.Shutdown.DrainTimeout), &drainCallback{server: s})
+	cleaner.AddPhase(event.PhaseFlushSessions, utils.ParseStringTime(conf.Shutdown.FlushTimeout), &flushCallback{server: s})
+}
+
+// stopAcceptCallback 关闭监听器，停止接受新连接
+type stopAcceptCallback struct {
+	server *Server
+}
+
+func (cb *stopAcceptCallback) Invoke(ctx context.Context) error {
+	cb.server.draining.Store(true)
+	if err := cb.server.listener.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+		return err
+	}
+	select {
+	case <-cb.server.accepted:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// drainCallback 分批断开所有客户端连接
+// 批次大小与间隔在执行时读取，支持通过重新加载配置调整
+type drainCallback struct {
+	server *Server
+}
+
+func (cb *drainCallback) Invoke(ctx context.Context) error {
+	conf, _ := config.GetConfig()
+	batchSize := conf.Shutdown.DrainBatchSize
+	batchInterval := utils.ParseStringTime(conf.Shutdown.DrainBatchInterval)
+
+	var handlers []*ConnectionHandler
+	cb.server.active.Range(func(key, _ any) bool {
+		handlers = append(handlers, key.(*ConnectionHandler))
+		return true
+	})
+	logger.InfoF("Draining %d client connections", len(handlers))
+
+	if batchSize <= 0 {
+		batchSize = len(handlers)
+	}
+	for start := 0; start < len(handlers); start += batchSize {
+		end := min(start+batchSize, len(handlers))
+		for _, handler := range handlers[start:end] {
+			handler.close()
+		}
+		logger.DebugF("Closed client connections %d-%d of %d", start+1, end, len(handlers))
+		if end == len(handlers) || batchInterval <= 0 {
+			continue
+		}
+		select {
+		case <-time.After(batchInterval):
+		case <-ctx.Done():
+			// 超时后直接断开剩余连接
+			for _, handler := range handlers[end:] {
+				handler.close()
+			}
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// flushCallback 等待所有连接处理器退出，确保会话数据已落盘
+type flushCallback struct {
+	server *Server
+}
+
+func (cb *flushCallback) Invoke(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		cb.server.handlers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		logger.Info("All client sessions flushed")
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,468 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	c "github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/config"
+	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/connection"
+	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/database"
+	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/logger"
+	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/metrics"
+	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/mqtt"
+	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/packet"
+)
+
+// startTestBroker 使用内存存储后端启动一个只用于测试的MQTT服务器，返回监听地址
+func startTestBroker(t *testing.T) string {
+	t.Helper()
+	if err := database.UseBackend(database.NewMemoryBackend(), c.Default()); err != nil {
+		t.Fatalf("UseBackend: %v", err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	// 客户端连接的清理先于此处执行，关闭监听后等待所有连接处理协程退出，避免影响下一个测试
+	var handlers sync.WaitGroup
+	t.Cleanup(func() {
+		_ = ln.Close()
+		handlers.Wait()
+	})
+	handlers.Add(1)
+	go func() {
+		defer handlers.Done()
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			handlers.Add(1)
+			go func() {
+				defer handlers.Done()
+				newConnectionHandler(conn).handleConnection()
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// testClient 手工编码报文的测试客户端
+type testClient struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *mqtt.PacketReader
+}
+
+// encodeString 编码带长度前缀的字符串
+func encodeString(value string) []byte {
+	return append(binary.BigEndian.AppendUint16(nil, uint16(len(value))), value...)
+}
+
+// encodePacket 编码固定头与剩余部分
+func encodePacket(typeAndFlags byte, body []byte) []byte {
+	return append(append([]byte{typeAndFlags}, mqtt.EncodeRemainingLength(len(body))...), body...)
+}
+
+// dial 连接测试服务器并完成CONNECT握手
+func dial(t *testing.T, addr string, clientID string, cleanSession bool) *testClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	client := &testClient{t: t, conn: conn, reader: mqtt.NewPacketReader(conn)}
+
+	var flags byte
+	if cleanSession {
+		flags = 0x02
+	}
+	body := append(encodeString("MQTT"), 0x04, flags, 0x00, 0x3C)
+	body = append(body, encodeString(clientID)...)
+	client.write(encodePacket(0x10, body))
+
+	connack := client.read(mqtt.CONNACK)
+	if len(connack.Payload.Context) != 2 || connack.Payload.Context[1] != 0 {
+		t.Fatalf("CONNACK for %s = %v, want accepted", clientID, connack.Payload.Context)
+	}
+	return client
+}
+
+func (c *testClient) write(data []byte) {
+	c.t.Helper()
+	if _, err := c.conn.Write(data); err != nil {
+		c.t.Fatalf("write: %v", err)
+	}
+}
+
+// read 读取一个报文并校验类型
+func (c *testClient) read(expected mqtt.PacketType) *mqtt.Packet {
+	c.t.Helper()
+	_ = c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	packet, err := c.reader.ReadPacket()
+	if err != nil {
+		c.t.Fatalf("read %s: %v", expected, err)
+	}
+	if packet.Header.Type != expected {
+		c.t.Fatalf("got %s packet, want %s", packet.Header.Type, expected)
+	}
+	return packet
+}
+
+func (c *testClient) subscribe(packetID uint16, filter string) {
+	c.t.Helper()
+	body := binary.BigEndian.AppendUint16(nil, packetID)
+	body = append(append(body, encodeString(filter)...), 0x00)
+	c.write(encodePacket(0x82, body))
+	suback := c.read(mqtt.SUBACK)
+	if id := binary.BigEndian.Uint16(suback.Payload.Context); id != packetID {
+		c.t.Fatalf("SUBACK packet id = %d, want %d", id, packetID)
+	}
+}
+
+func (c *testClient) publish(topic string, payload string) {
+	c.t.Helper()
+	c.write(encodePacket(0x30, append(encodeString(topic), payload...)))
+}
+
+// publishQoS 以指定的QoS等级与报文ID发布消息
+func (c *testClient) publishQoS(topic string, payload string, qos byte, packetID uint16) {
+	c.t.Helper()
+	body := binary.BigEndian.AppendUint16(encodeString(topic), packetID)
+	c.write(encodePacket(0x30|qos<<1, append(body, payload...)))
+}
+
+func (c *testClient) disconnect() {
+	c.t.Helper()
+	c.write([]byte{0xE0, 0x00})
+}
+
+func TestConnectSubscribePublish(t *testing.T) {
+	addr := startTestBroker(t)
+
+	subscriber := dial(t, addr, "subscriber", false)
+	subscriber.subscribe(1, "sensor/+/temp")
+	publisher := dial(t, addr, "publisher", true)
+
+	publisher.publish("sensor/1/humidity", "40")
+	publisher.publish("sensor/1/temp", "21.5")
+
+	// 只收到匹配订阅的消息
+	received := subscriber.read(mqtt.PUBLISH)
+	want := append(encodeString("sensor/1/temp"), "21.5"...)
+	if !bytes.Equal(received.Payload.Context, want) {
+		t.Fatalf("PUBLISH body = %q, want %q", received.Payload.Context, want)
+	}
+
+	// PINGREQ 在消息之后返回，说明不匹配的消息没有被投递
+	subscriber.write([]byte{0xC0, 0x00})
+	subscriber.read(mqtt.PINGRESP)
+
+	subscriber.disconnect()
+	publisher.disconnect()
+
+	// 持久会话在断开后保存到存储后端，订阅仍然有效
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		session, _ := database.NewDatabaseStore().GetSession("subscriber")
+		if session != nil && session.SubscriptionCount() == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("subscriber session was not persisted: %+v", session)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if matched, _ := database.NewDatabaseStore().MatchTopic("sensor/2/temp"); len(matched) != 1 {
+		t.Fatalf("persistent subscription lost after disconnect: %v", matched)
+	}
+}
+
+func TestCleanSessionRemovedOnDisconnect(t *testing.T) {
+	addr := startTestBroker(t)
+
+	client := dial(t, addr, "temporary", true)
+	client.subscribe(1, "alerts/#")
+	client.disconnect()
+
+	// 临时会话断开后订阅被清除
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		matched, _ := database.NewDatabaseStore().MatchTopic("alerts/fire")
+		if len(matched) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("clean session subscriptions still present: %v", matched)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// 重新连接时不会恢复任何会话
+	dial(t, addr, "temporary", true).disconnect()
+}
+
+func TestReconnectTakesOverConnection(t *testing.T) {
+	tests := []struct {
+		name         string
+		cleanSession bool
+	}{
+		{"persistent", false},
+		{"clean", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr := startTestBroker(t)
+			store := database.NewDatabaseStore()
+
+			previous := dial(t, addr, "device", tt.cleanSession)
+			previous.subscribe(1, "alerts/#")
+			previousSession, _ := store.GetSession("device")
+			current := dial(t, addr, "device", tt.cleanSession)
+
+			// 旧连接被服务器断开
+			_ = previous.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			if packet, err := previous.reader.ReadPacket(); err == nil {
+				t.Fatalf("taken over connection received %s packet, want it closed", packet.Header.Type)
+			}
+
+			_ = previous.conn.Close()
+
+			// 旧连接处理器退出时不能移除新连接，也不能清理会话
+			for deadline := time.Now().Add(100 * time.Millisecond); time.Now().Before(deadline); {
+				if _, ok := connection.GetConnectionManager().GetConnection("device"); !ok {
+					t.Fatal("new connection was removed by the taken over connection")
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			// 清除会话的新连接不保留旧会话的订阅，订阅索引中也不能留下旧订阅
+			if tt.cleanSession {
+				if count := store.SubscriptionCount(); count != 0 {
+					t.Fatalf("%d subscriptions left in the index after clean session takeover, want 0", count)
+				}
+				current.subscribe(2, "alerts/#")
+			}
+
+			// 旧连接上迟到的DISCONNECT报文不能保存或删除新连接的会话
+			server, client := net.Pipe()
+			defer func() { _ = client.Close() }()
+			stale := &ConnectionHandler{conn: server, connection: connection.NewConnection(server, "stale"), clientSession: previousSession, log: logger.With(logger.KeyConnID, "stale")}
+			stale.dispatch(&mqtt.Packet{Header: &mqtt.FixedHeader{Type: mqtt.DISCONNECT}})
+			stale.connection.Close()
+			if _, ok := connection.GetConnectionManager().GetConnection("device"); !ok {
+				t.Fatal("new connection was removed by a late DISCONNECT on the taken over connection")
+			}
+			if count := store.SubscriptionCount(); count != 1 {
+				t.Fatalf("%d subscriptions in the index after takeover, want 1", count)
+			}
+
+			dial(t, addr, "publisher", true).publish("alerts/fire", "1")
+			received := current.read(mqtt.PUBLISH)
+			if want := append(encodeString("alerts/fire"), '1'); !bytes.Equal(received.Payload.Context, want) {
+				t.Fatalf("PUBLISH body = %q, want %q", received.Payload.Context, want)
+			}
+		})
+	}
+}
+func TestSubscriberAcknowledgements(t *testing.T) {
+	tests := []struct {
+		name string
+		qos  byte
+	}{
+		{"qos1", 1},
+		{"qos2", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr := startTestBroker(t)
+
+			subscriber := dial(t, addr, "subscriber", true)
+			body := append(binary.BigEndian.AppendUint16(nil, 1), encodeString("sensor/#")...)
+			subscriber.write(encodePacket(0x82, append(body, tt.qos)))
+			suback := subscriber.read(mqtt.SUBACK)
+			if granted := suback.Payload.Context[2]; granted != tt.qos {
+				t.Fatalf("granted QoS = %d, want %d", granted, tt.qos)
+			}
+
+			dial(t, addr, "publisher", true).publishQoS("sensor/1", "21.5", tt.qos, 1)
+			received := subscriber.read(mqtt.PUBLISH)
+			if qos := received.Header.Flags >> 1 & 0x03; qos != tt.qos {
+				t.Fatalf("delivered with QoS %d, want %d", qos, tt.qos)
+			}
+			topicLength := int(binary.BigEndian.Uint16(received.Payload.Context))
+			packetID := received.Payload.Context[2+topicLength : 4+topicLength]
+
+			// 订阅者确认消息后连接仍然可用，报文ID被释放后可以再次分配
+			if tt.qos == 1 {
+				subscriber.write(encodePacket(0x40, packetID))
+			} else {
+				subscriber.write(encodePacket(0x50, packetID))
+				pubrel := subscriber.read(mqtt.PUBREL)
+				if !bytes.Equal(pubrel.Payload.Context, packetID) {
+					t.Fatalf("PUBREL packet id = %v, want %v", pubrel.Payload.Context, packetID)
+				}
+				subscriber.write(encodePacket(0x70, packetID))
+			}
+			subscriber.write([]byte{0xC0, 0x00})
+			subscriber.read(mqtt.PINGRESP)
+			if next := database.NewPacketIDManager().NextID(); next != binary.BigEndian.Uint16(packetID) {
+				t.Fatalf("next packet id = %d, want released id %d", next, binary.BigEndian.Uint16(packetID))
+			}
+		})
+	}
+}
+
+func TestPublishAcknowledgements(t *testing.T) {
+	addr := startTestBroker(t)
+
+	subscriber := dial(t, addr, "subscriber", true)
+	subscriber.subscribe(1, "sensor/#")
+	client := dial(t, addr, "device", false)
+	session, _ := database.NewDatabaseStore().GetSession("device")
+	ackID := func(packet *mqtt.Packet) uint16 {
+		return binary.BigEndian.Uint16(packet.Payload.Context)
+	}
+
+	// QoS 1消息分发后直接回复PUBACK，不写入待确认队列
+	client.publishQoS("sensor/1", "21.5", 1, 1)
+	if id := ackID(client.read(mqtt.PUBACK)); id != 1 {
+		t.Fatalf("PUBACK packet id = %d, want 1", id)
+	}
+
+	// QoS 2消息在收到PUBREL之前保留在待确认队列中
+	client.publishQoS("sensor/1", "21.5", 2, 2)
+	if id := ackID(client.read(mqtt.PUBREC)); id != 2 {
+		t.Fatalf("PUBREC packet id = %d, want 2", id)
+	}
+	client.write([]byte{0xC0, 0x00})
+	client.read(mqtt.PINGRESP)
+	if count := session.PendingPublishCount(); count != 1 {
+		t.Fatalf("%d pending publishes before PUBREL, want 1", count)
+	}
+
+	// 收到PUBREL之前重发的消息只重新回复PUBREC，不会再次分发
+	body := binary.BigEndian.AppendUint16(encodeString("sensor/1"), 2)
+	client.write(encodePacket(0x3C, append(body, "21.5"...)))
+	if id := ackID(client.read(mqtt.PUBREC)); id != 2 {
+		t.Fatalf("PUBREC packet id for retransmission = %d, want 2", id)
+	}
+	subscriber.read(mqtt.PUBLISH)
+	subscriber.read(mqtt.PUBLISH)
+	subscriber.write([]byte{0xC0, 0x00})
+	subscriber.read(mqtt.PINGRESP)
+
+	client.write(encodePacket(0x62, []byte{0x00, 0x02}))
+	if id := ackID(client.read(mqtt.PUBCOMP)); id != 2 {
+		t.Fatalf("PUBCOMP packet id = %d, want 2", id)
+	}
+	if count := session.PendingPublishCount(); count != 0 {
+		t.Fatalf("%d pending publishes after PUBCOMP, want 0", count)
+	}
+}
+
+func TestSysTopicsProtected(t *testing.T) {
+	addr := startTestBroker(t)
+
+	client := dial(t, addr, "watcher", true)
+	// 未在 sys.allowed_clients 中的客户端不能订阅 $SYS 主题
+	body := binary.BigEndian.AppendUint16(nil, 1)
+	body = append(append(body, encodeString("$SYS/#")...), 0x00)
+	body = append(append(body, encodeString("#")...), 0x01)
+	client.write(encodePacket(0x82, body))
+	suback := client.read(mqtt.SUBACK)
+	if codes := suback.Payload.Context[2:]; !bytes.Equal(codes, []byte{0x80, 0x01}) {
+		t.Fatalf("SUBACK return codes = %v, want [128 1]", codes)
+	}
+
+	// 客户端发布的 $SYS 消息被丢弃，服务器发布的 $SYS 消息不匹配 #
+	client.publish("$SYS/broker/uptime", "forged")
+	packet.PublishServerMessage("$SYS/broker/uptime", []byte("1 seconds"))
+	client.publish("sensor/1/temp", "21.5")
+	received := client.read(mqtt.PUBLISH)
+	if want := append(encodeString("sensor/1/temp"), "21.5"...); !bytes.Equal(received.Payload.Context, want) {
+		t.Fatalf("PUBLISH body = %q, want %q", received.Payload.Context, want)
+	}
+	client.disconnect()
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	addr := startTestBroker(t)
+
+	subscriber := dial(t, addr, "metrics-subscriber", true)
+	subscriber.subscribe(0, "metrics/#")
+	publisher := dial(t, addr, "metrics-publisher", true)
+	publisher.publish("metrics/test", "1")
+	subscriber.read(mqtt.PUBLISH)
+
+	recorder := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("GET /metrics status = %d", recorder.Code)
+	}
+	body := recorder.Body.String()
+	for _, want := range []string{
+		`mqtt_connack_total{code="accepted"}`,
+		`mqtt_packets_received_total{type="CONNECT"}`,
+		`mqtt_packets_received_total{type="PUBLISH"}`,
+		`mqtt_packets_sent_total{type="PUBLISH"}`,
+		`mqtt_packets_sent_total{type="SUBACK"}`,
+		"mqtt_publish_fanout_size_count",
+		"mqtt_match_topic_duration_seconds_count",
+		"mqtt_outbound_queue_depth",
+		"mqtt_dropped_messages_total",
+		"mqtt_subscriptions 1",
+		"mqtt_storage_degraded 0",
+		"go_goroutines",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics output does not contain %q", want)
+		}
+	}
+	subscriber.disconnect()
+	publisher.disconnect()
+}
+
+func TestServerHealthChecks(t *testing.T) {
+	server := NewServer(0)
+	if err := server.Ready(context.Background()); !errors.Is(err, errNotListening) {
+		t.Fatalf("Ready() before Start = %v, want %v", err, errNotListening)
+	}
+	done := make(chan error, 1)
+	go func() { done <- server.Start() }()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for server.Ready(context.Background()) != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("server did not become ready")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := server.Alive(context.Background()); err != nil {
+		t.Fatalf("Alive() = %v", err)
+	}
+
+	// 关闭监听器后不再就绪，但关闭过程中仍然存活
+	if err := (&stopAcceptCallback{server: server}).Invoke(context.Background()); err != nil {
+		t.Fatalf("stop accept: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("Start() = %v", err)
+	}
+	if err := server.Ready(context.Background()); !errors.Is(err, errDraining) {
+		t.Fatalf("Ready() after stop = %v, want %v", err, errDraining)
+	}
+	if err := server.Alive(context.Background()); err != nil {
+		t.Fatalf("Alive() while draining = %v", err)
+	}
+}
@@ -0,0 +1,187 @@
+// Package sys 实现了 $SYS/broker/... 服务器统计主题的周期性发布
+package sys
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/connection"
+	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/database"
+	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/logger"
+	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/packet"
+)
+
+// Version 服务器版本，构建时通过 -ldflags "-X github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/sys.Version=v1.0.0" 设置
+var Version = "dev"
+
+// topicPrefix 统计主题的公共前缀
+const topicPrefix = packet.SysTopicPrefix + "/broker/"
+
+// loadWindows 负载平均值的时间窗口
+var loadWindows = []struct {
+	name   string
+	window time.Duration
+}{
+	{"1min", time.Minute},
+	{"5min", 5 * time.Minute},
+	{"15min", 15 * time.Minute},
+}
+
+// loadAverage 按各时间窗口计算的每分钟速率的指数移动平均值
+type loadAverage struct {
+	last   uint64    // 上次采样时的累计值
+	values []float64 // 与loadWindows一一对应
+}
+
+// update 使用累计值total更新移动平均值，elapsed为距上次采样的时间
+func (l *loadAverage) update(total uint64, elapsed time.Duration) {
+	if l.values == nil {
+		l.values = make([]float64, len(loadWindows))
+	}
+	rate := float64(total-l.last) / elapsed.Minutes()
+	l.last = total
+	for i, window := range loadWindows {
+		decay := math.Exp(-elapsed.Seconds() / window.window.Seconds())
+		l.values[i] = l.values[i]*decay + rate*(1-decay)
+	}
+}
+
+// snapshot 一次采集的统计数据
+type snapshot struct {
+	connection.Stats
+	Subscriptions int64
+	Retained      int64
+	RetainedErr   error // 存储不可用时无法获取保留消息数，此时不发布该主题
+}
+
+// currentSnapshot 采集连接层与存储层的当前统计数据
+func currentSnapshot() snapshot {
+	result := snapshot{Stats: connection.GetStats()}
+	store := database.NewDatabaseStore()
+	result.Subscriptions = store.SubscriptionCount()
+	result.Retained, result.RetainedErr = store.RetainedMessageCount()
+	return result
+}
+
+// message 一条待发布的统计消息
+type message struct {
+	topic   string
+	payload string
+}
+
+// Publisher 按固定间隔发布服务器统计主题
+type Publisher struct {
+	interval time.Duration
+	started  time.Time
+	lastRun  time.Time
+	loads    map[string]*loadAverage // key=负载主题中load/之后、时间窗口之前的部分
+	collect  func() snapshot
+	publish  func(topic string, payload []byte)
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewPublisher 创建统计主题发布器，需要调用 Start 启动
+func NewPublisher(interval time.Duration) *Publisher {
+	now := time.Now()
+	return &Publisher{
+		interval: interval,
+		started:  now,
+		lastRun:  now,
+		loads:    make(map[string]*loadAverage),
+		collect:  currentSnapshot,
+		publish:  packet.PublishServerMessage,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Start 启动发布协程
+func (p *Publisher) Start() {
+	logger.InfoF("Publishing %s topics every %v", packet.SysTopicPrefix, p.interval)
+	go p.run()
+}
+
+// run 发布协程，每个间隔发布一次所有统计主题
+func (p *Publisher) run() {
+	defer close(p.done)
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case now := <-ticker.C:
+			for _, msg := range p.messages(now) {
+				p.publish(msg.topic, []byte(msg.payload))
+			}
+		}
+	}
+}
+
+// messages 采集统计数据并生成本次需要发布的所有消息
+func (p *Publisher) messages(now time.Time) []message {
+	stats := p.collect()
+	elapsed := now.Sub(p.lastRun)
+	p.lastRun = now
+
+	result := []message{
+		{"version", Version},
+		{"uptime", strconv.FormatInt(int64(now.Sub(p.started).Seconds()), 10) + " seconds"},
+		{"clients/connected", strconv.FormatInt(stats.ConnectedClients, 10)},
+		{"clients/total", strconv.FormatUint(stats.TotalClients, 10)},
+		{"messages/received", strconv.FormatUint(stats.MessagesReceived, 10)},
+		{"messages/sent", strconv.FormatUint(stats.MessagesSent, 10)},
+		{"messages/dropped", strconv.FormatUint(stats.DroppedMessages, 10)},
+		{"bytes/received", strconv.FormatUint(stats.BytesReceived, 10)},
+		{"bytes/sent", strconv.FormatUint(stats.BytesSent, 10)},
+		{"subscriptions/count", strconv.FormatInt(stats.Subscriptions, 10)},
+	}
+	if stats.RetainedErr == nil {
+		result = append(result, message{"retained messages/count", strconv.FormatInt(stats.Retained, 10)})
+	}
+
+	if elapsed > 0 {
+		totals := []struct {
+			name  string
+			total uint64
+		}{
+			{"messages/received", stats.MessagesReceived},
+			{"messages/sent", stats.MessagesSent},
+			{"bytes/received", stats.BytesReceived},
+			{"bytes/sent", stats.BytesSent},
+			{"connections", stats.TotalClients},
+		}
+		for _, metric := range totals {
+			load, ok := p.loads[metric.name]
+			if !ok {
+				load = &loadAverage{}
+				p.loads[metric.name] = load
+			}
+			load.update(metric.total, elapsed)
+			for i, window := range loadWindows {
+				result = append(result, message{"load/" + metric.name + "/" + window.name, strconv.FormatFloat(load.values[i], 'f', 2, 64)})
+			}
+		}
+	}
+
+	for i := range result {
+		result[i].topic = topicPrefix + result[i].topic
+	}
+	return result
+}
+
+// Invoke 停止发布协程
+func (p *Publisher) Invoke(ctx context.Context) error {
+	p.stopOnce.Do(func() { close(p.stop) })
+	select {
+	case <-p.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,83 @@
+package sys
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/connection"
+)
+
+func TestPublisherMessages(t *testing.T) {
+	p := NewPublisher(10 * time.Second)
+	stats := snapshot{
+		Stats: connection.Stats{
+			ConnectedClients: 3,
+			TotalClients:     7,
+			MessagesReceived: 120,
+			MessagesSent:     240,
+			BytesReceived:    4096,
+			BytesSent:        8192,
+		},
+		Subscriptions: 5,
+		Retained:      2,
+	}
+	p.collect = func() snapshot { return stats }
+
+	got := make(map[string]string)
+	for _, msg := range p.messages(p.started.Add(time.Minute)) {
+		got[msg.topic] = msg.payload
+	}
+	want := map[string]string{
+		"$SYS/broker/version":                 Version,
+		"$SYS/broker/uptime":                  "60 seconds",
+		"$SYS/broker/clients/connected":       "3",
+		"$SYS/broker/clients/total":           "7",
+		"$SYS/broker/messages/received":       "120",
+		"$SYS/broker/messages/sent":           "240",
+		"$SYS/broker/bytes/received":          "4096",
+		"$SYS/broker/bytes/sent":              "8192",
+		"$SYS/broker/subscriptions/count":     "5",
+		"$SYS/broker/retained messages/count": "2",
+	}
+	for topic, payload := range want {
+		if got[topic] != payload {
+			t.Errorf("%s = %q, want %q", topic, got[topic], payload)
+		}
+	}
+	if _, ok := got["$SYS/broker/load/messages/received/15min"]; !ok {
+		t.Error("load averages were not published")
+	}
+
+	// 存储不可用时不发布保留消息数
+	stats.RetainedErr = errors.New("storage backend unavailable")
+	for _, msg := range p.messages(p.started.Add(2 * time.Minute)) {
+		if msg.topic == "$SYS/broker/retained messages/count" {
+			t.Fatal("retained count published while storage is unavailable")
+		}
+	}
+}
+
+func TestLoadAverage(t *testing.T) {
+	load := &loadAverage{}
+	// 每10秒增加100，即每分钟600
+	var total uint64
+	for i := 0; i < 15*6*10; i++ {
+		total += 100
+		load.update(total, 10*time.Second)
+	}
+	for i, window := range loadWindows {
+		if math.Abs(load.values[i]-600) > 1 {
+			t.Errorf("%s load = %.2f, want 600", window.name, load.values[i])
+		}
+	}
+
+	// 停止增长后短窗口衰减得更快
+	for i := 0; i < 6; i++ {
+		load.update(total, 10*time.Second)
+	}
+	if !(load.values[0] < load.values[1] && load.values[1] < load.values[2]) {
+		t.Errorf("load after idle minute = %v, want increasing with window length", load.values)
+	}
+}
@@ -0,0 +1,114 @@
+// Package tracing 实现了基于OpenTelemetry的链路追踪
+// 未启用时使用OpenTelemetry默认的空实现，创建Span几乎没有开销
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	c "github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/config"
+	"github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/logger"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName 服务器创建的Span所属的库名称
+const instrumentationName = "github.com/life-stream-dev/life-stream-go-mqtt-broker"
+
+// Span 属性键
+const (
+	AttrClientID     = attribute.Key("mqtt.client_id")
+	AttrClientOnline = attribute.Key("mqtt.client_online")
+	AttrTopic        = attribute.Key("mqtt.topic")
+	AttrQoS          = attribute.Key("mqtt.qos")
+	AttrPacketID     = attribute.Key("mqtt.packet_id")
+	AttrFanOut       = attribute.Key("mqtt.fanout")
+)
+
+func init() {
+	// 传播器与是否启用无关，未启用时仍然透传上游的追踪上下文
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+}
+
+// Provider 已启用的追踪导出器，关闭时导出剩余的Span
+type Provider struct {
+	provider *sdktrace.TracerProvider
+	file     *os.File // file导出方式下写入的文件
+}
+
+// Init 按配置启用追踪并设置为全局TracerProvider，未启用时返回nil
+func Init(config c.Config) (*Provider, error) {
+	conf := config.Tracing
+	if !conf.Enabled {
+		return nil, nil
+	}
+	result := &Provider{}
+	var exporter sdktrace.SpanExporter
+	switch conf.Exporter {
+	case c.TracingExporterFile:
+		if err := os.MkdirAll(filepath.Dir(conf.File), 0755); err != nil {
+			return nil, fmt.Errorf("error occured while creating trace directory: %v", err)
+		}
+		file, err := os.OpenFile(conf.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("error occured while opening trace file: %v", err)
+		}
+		result.file = file
+		if exporter, err = stdouttrace.New(stdouttrace.WithWriter(file)); err != nil {
+			_ = file.Close()
+			return nil, fmt.Errorf("error occured while creating file exporter: %v", err)
+		}
+	default:
+		options := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(conf.Endpoint)}
+		if conf.Insecure {
+			options = append(options, otlptracegrpc.WithInsecure())
+		}
+		var err error
+		// 导出器在后台连接接收端，接收端不可用时不影响启动
+		if exporter, err = otlptracegrpc.New(context.Background(), options...); err != nil {
+			return nil, fmt.Errorf("error occured while creating otlp exporter: %v", err)
+		}
+	}
+
+	result.provider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(conf.SampleRatio))),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(config.AppName))),
+	)
+	otel.SetTracerProvider(result.provider)
+	logger.InfoF("Tracing enabled, exporting spans to %s", conf.Exporter)
+	return result, nil
+}
+
+// Invoke 导出剩余的Span并关闭导出器
+func (p *Provider) Invoke(ctx context.Context) error {
+	err := p.provider.Shutdown(ctx)
+	if p.file != nil {
+		_ = p.file.Close()
+	}
+	return err
+}
+
+// Start 创建一个Span，ctx中有Span时作为其子Span
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End 结束Span，err不为nil时把Span标记为失败
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
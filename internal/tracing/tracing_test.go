@@ -0,0 +1,52 @@
+package tracing
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	c "github.com/life-stream-dev/life-stream-go-mqtt-broker/internal/config"
+	"go.opentelemetry.io/otel"
+)
+
+func TestFileExporter(t *testing.T) {
+	previous := otel.GetTracerProvider()
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	config := c.Config{AppName: "test-broker"}
+	config.Tracing.Enabled = true
+	config.Tracing.Exporter = c.TracingExporterFile
+	config.Tracing.File = filepath.Join(t.TempDir(), "traces", "spans.json")
+	config.Tracing.SampleRatio = 1
+	provider, err := Init(config)
+	if err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+
+	ctx, parent := Start(context.Background(), "mqtt.publish", AttrTopic.String("a/b"))
+	_, child := Start(ctx, "mqtt.deliver", AttrClientID.String("device"))
+	End(child, os.ErrClosed)
+	parent.End()
+	if err := provider.Invoke(context.Background()); err != nil {
+		t.Fatalf("Invoke: %v", err)
+	}
+
+	content, err := os.ReadFile(config.Tracing.File)
+	if err != nil {
+		t.Fatalf("read trace file: %v", err)
+	}
+	for _, want := range []string{`"Name":"mqtt.publish"`, `"Name":"mqtt.deliver"`, `"Value":"test-broker"`, `"Code":"Error"`} {
+		if !strings.Contains(string(content), want) {
+			t.Errorf("trace file does not contain %s", want)
+		}
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	provider, err := Init(c.Config{})
+	if err != nil || provider != nil {
+		t.Fatalf("Init() = %v, %v, want nil provider when disabled", provider, err)
+	}
+}